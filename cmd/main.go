@@ -5,12 +5,28 @@ import (
 	"fmt"
 	"n64emu/pkg/core"
 	"n64emu/pkg/core/cart"
+	"n64emu/pkg/core/sysbus"
+	"n64emu/pkg/debugger"
+	"n64emu/pkg/profiler"
+	"n64emu/pkg/symbols"
+	"n64emu/pkg/testrom"
+	"n64emu/pkg/types"
 	"os"
+	"os/signal"
 )
 
 var (
 	version     string
 	showVersion = flag.Bool("v", false, "show version")
+	debug       = flag.Bool("debug", false, "run with the interactive debugger")
+	gdbAddr     = flag.String("gdb", "", "serve GDB remote protocol on the address e.g. localhost:2345")
+	syms        = flag.String("symbols", "", "symbols of the program (ELF, GNU ld map or text), instead of the ELF's")
+	profile     = flag.String("profile", "", "write pprof profile of the emulated program to the file")
+	interval    = flag.Uint64("profile-interval", 1000, "sampling interval of the profiler in cycles")
+	cycles      = flag.Uint("cycles", 93_750_000, "cycles to run with -profile")
+	testROMs    = flag.String("test", "", "run test ROMs in the JSON manifest headless, and report pass/fail")
+	pak         = flag.Bool("expansion-pak", true, "insert Expansion Pak, that expands RDRAM to 8MB")
+	unmapped    = flag.String("unmapped", "open", "on accesses to unmapped addresses: open (open bus), error (bus error exception) or panic")
 )
 
 const (
@@ -20,6 +36,7 @@ const (
 const (
 	exitCodeOK int = iota
 	exitCodeError
+	exitCodeTestFailed
 )
 
 func main() {
@@ -34,22 +51,139 @@ func Run() int {
 		return exitCodeOK
 	}
 
+	if *testROMs != "" {
+		return runTests(*testROMs)
+	}
+
 	romPath := flag.Arg(0)
 	eepromPath := flag.Arg(1)
 	nvsramPath := flag.Arg(2)
-	c, err := cart.NewCart(romPath, eepromPath, nvsramPath)
+	policy, err := sysbus.ParseUnmappedPolicy(*unmapped)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitCodeError
+	}
+	n, err := newN64(romPath, eepromPath, nvsramPath, core.WithExpansionPak(*pak), core.WithUnmappedPolicy(policy))
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to read ROM data: %s\n", err)
 		return exitCodeError
 	}
+	if *syms != "" {
+		if n.Symbols, err = symbols.Load(*syms); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read symbols: %s\n", err)
+			return exitCodeError
+		}
+	}
+
+	if *gdbAddr != "" {
+		fmt.Printf("waiting for GDB on %s\n", *gdbAddr)
+		if err := debugger.NewGDBServer(newDebugger(n)).ListenAndServe(*gdbAddr); err != nil {
+			fmt.Fprintf(os.Stderr, "gdb: %s\n", err)
+			return exitCodeError
+		}
+		return exitCodeOK
+	}
+
+	if *debug {
+		d := newDebugger(n)
+		// Ctrl+C stops the execution, instead of the debugger
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt)
+		go func() {
+			for range sig {
+				d.Interrupt()
+			}
+		}()
+		if err := d.Run(os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "debugger: %s\n", err)
+			return exitCodeError
+		}
+		return exitCodeOK
+	}
+
+	if *profile != "" {
+		return runProfile(n)
+	}
 
 	// test code
-	fmt.Printf("ROM ImageName='%s'\n", c.ROM.ImageName)
+	if n.ROM != nil {
+		fmt.Printf("ROM ImageName='%s'\n", n.ROM.ImageName)
+	} else {
+		fmt.Printf("ELF Entry=%016x\n", n.ELF.Entry)
+	}
 	core.Hello()
 
 	return exitCodeOK
 }
 
+// load ROM or ELF executable, and create the machine
+func newN64(romPath, eepromPath, nvsramPath string, opts ...core.Option) (*core.N64, error) {
+	if cart.IsELF(romPath) {
+		e, err := cart.NewELF(romPath)
+		if err != nil {
+			return nil, err
+		}
+		return core.NewN64WithELF(e, opts...)
+	}
+	c, err := cart.NewCart(romPath, eepromPath, nvsramPath)
+	if err != nil {
+		return nil, err
+	}
+	return core.NewN64(c.ROM, opts...), nil
+}
+
+func newDebugger(n *core.N64) *debugger.Debugger {
+	d := debugger.New(n.CPU, n.Bus())
+	d.SetSymbols(n.Symbols)
+	d.SetBusWatcher(n.WatchBus())
+	return d
+}
+
+// run the machine with the profiler, and write the profile even if it crashed
+func runProfile(n *core.N64) int {
+	p := profiler.New(n.CPU, *interval)
+	n.OnCycle(p.Tick)
+	code := exitCodeOK
+	if err := n.Run(types.Word(*cycles)); err != nil {
+		if c, ok := err.(*core.Crash); ok {
+			c.Report(os.Stderr)
+		} else {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		code = exitCodeError
+	}
+
+	f, err := os.Create(*profile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write profile: %s\n", err)
+		return exitCodeError
+	}
+	defer f.Close()
+	if err := p.WritePprof(f, n.Symbols); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write profile: %s\n", err)
+		return exitCodeError
+	}
+	fmt.Printf("%d cycles profiled, written to %s\n", p.Cycles(), *profile)
+	return code
+}
+
+// run the test ROMs, and print the results as a table
+func runTests(manifest string) int {
+	specs, err := testrom.LoadSpecs(manifest)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read test ROMs: %s\n", err)
+		return exitCodeError
+	}
+	results := make([]*testrom.Result, len(specs))
+	for i := range specs {
+		results[i] = testrom.RunFile(&specs[i])
+	}
+	if testrom.WriteTable(os.Stdout, results) > 0 {
+		return exitCodeTestFailed
+	}
+	return exitCodeOK
+}
+
 func getVersion() string {
 	if version == "" {
 		return "Develop"
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"n64emu/pkg/core"
+	"n64emu/pkg/core/mips/r4300i/cpu"
+	"n64emu/pkg/core/mips/r4300i/disasm"
+	"n64emu/pkg/core/mips/r4300i/trace"
+	"n64emu/pkg/types"
+	"sort"
+	"strings"
+)
+
+const (
+	// max cycles to retire an instruction, the CPU is regarded as stalled beyond this
+	maxStallCycles = 1000
+)
+
+// Divergence is the first instruction where the emulator differs from the reference trace
+type Divergence struct {
+	Index  uint64 // number of instructions matched before
+	Reason string
+	Ours   *cpu.TraceEvent // nil if the emulator could not execute the instruction
+	Ref    *Record
+	Before []cpu.TraceEvent // instructions retired before, oldest first
+	// register values, by index of trace events
+	OurRegs map[types.Byte]types.DoubleWord
+	RefRegs map[types.Byte]types.DoubleWord
+}
+
+// Comparer runs the machine in lockstep with the reference trace.
+type Comparer struct {
+	n64     *core.N64
+	parser  Parser
+	context int
+
+	retired []*cpu.TraceEvent // events of the current cycle
+	history []cpu.TraceEvent  // ring buffer of retired instructions
+	refRegs map[types.Byte]types.DoubleWord
+	// registers as of the last retired instruction, updated from trace events.
+	// The CPU itself is ahead of it, e.g. HI and LO are written in EX stage of later instructions.
+	ourRegs [cpu.TraceRegLO + 1]types.DoubleWord
+}
+
+// NewComparer returns Comparer, keeping context instructions for the report.
+func NewComparer(n64 *core.N64, parser Parser, context int) *Comparer {
+	c := &Comparer{
+		n64:     n64,
+		parser:  parser,
+		context: context,
+		refRegs: map[types.Byte]types.DoubleWord{},
+	}
+	state := n64.CPU.Save()
+	copy(c.ourRegs[:], state.GPR[:])
+	c.ourRegs[cpu.TraceRegHI] = state.HI
+	c.ourRegs[cpu.TraceRegLO] = state.LO
+	n64.CPU.SetTraceHook(func(ev *cpu.TraceEvent) {
+		if !ev.Exception {
+			c.retired = append(c.retired, ev)
+		}
+	})
+	return c
+}
+
+// Run compares at most max instructions (no limit if zero).
+// It returns nil divergence if the emulator follows the trace to the end.
+func (c *Comparer) Run(max uint64) (*Divergence, uint64, error) {
+	var index uint64
+	for max == 0 || index < max {
+		ref, err := c.nextRecord()
+		if err == io.EOF {
+			return nil, index, nil
+		}
+		if err != nil {
+			return nil, index, err
+		}
+
+		ours, reason := c.nextEvent()
+		if ours != nil && reason == "" {
+			reason = c.compare(ours, ref)
+		}
+		if reason != "" {
+			return c.divergence(index, reason, ours, ref), index, nil
+		}
+		c.record(ours)
+		index++
+	}
+	return nil, index, nil
+}
+
+// next record, which is not canceled
+func (c *Comparer) nextRecord() (*Record, error) {
+	for {
+		rec, err := c.parser.Next()
+		if err != nil {
+			return nil, err
+		}
+		if !rec.Exception {
+			return rec, nil
+		}
+	}
+}
+
+// step the CPU until an instruction retires
+func (c *Comparer) nextEvent() (ev *cpu.TraceEvent, reason string) {
+	defer func() {
+		if r := recover(); r != nil {
+			ev = nil
+			reason = fmt.Sprint(r)
+		}
+	}()
+	for cycles := 0; len(c.retired) == 0; cycles++ {
+		if cycles >= maxStallCycles {
+			return nil, fmt.Sprintf("no instruction retired in %d cycles", maxStallCycles)
+		}
+		c.n64.CPU.Step()
+	}
+	ev, c.retired = c.retired[0], c.retired[1:]
+	return ev, ""
+}
+
+// compare the instruction, and returns the reason of mismatch or ""
+func (c *Comparer) compare(ours *cpu.TraceEvent, ref *Record) string {
+	for _, r := range ours.Registers {
+		c.ourRegs[r.Register] = r.Value
+	}
+	if ours.PC != ref.PC {
+		return "pc mismatch"
+	}
+	if ours.Opcode != ref.Opcode {
+		return "opcode mismatch"
+	}
+	mismatch := []string{}
+	for _, r := range ref.Registers {
+		c.refRegs[r.Register] = r.Value
+		if c.ourRegs[r.Register] != r.Value {
+			mismatch = append(mismatch, trace.RegisterName(r.Register))
+		}
+	}
+	if len(mismatch) > 0 {
+		return "register mismatch: " + strings.Join(mismatch, ", ")
+	}
+	return ""
+}
+
+func (c *Comparer) record(ev *cpu.TraceEvent) {
+	if c.context == 0 {
+		return
+	}
+	if len(c.history) == c.context {
+		c.history = c.history[1:]
+	}
+	c.history = append(c.history, *ev)
+}
+
+func (c *Comparer) divergence(index uint64, reason string, ours *cpu.TraceEvent, ref *Record) *Divergence {
+	d := &Divergence{
+		Index:   index,
+		Reason:  reason,
+		Ours:    ours,
+		Ref:     ref,
+		Before:  c.history,
+		OurRegs: map[types.Byte]types.DoubleWord{},
+		RefRegs: map[types.Byte]types.DoubleWord{},
+	}
+	for _, r := range ref.Registers {
+		c.refRegs[r.Register] = r.Value
+	}
+	for r, v := range c.refRegs {
+		d.RefRegs[r] = v
+		d.OurRegs[r] = c.ourRegs[r]
+	}
+	return d
+}
+
+// instruction at vaddr, or false if it is not in the unmapped segments
+func (c *Comparer) fetch(vaddr types.DoubleWord) (types.Word, bool) {
+	if vaddr < 0xFFFF_FFFF_8000_0000 || vaddr >= 0xFFFF_FFFF_C000_0000 {
+		return 0, false
+	}
+	return c.n64.Bus().ReadWord(types.Big, types.Word(vaddr)&0x1FFF_FFFF), true
+}
+
+// Report writes the divergence with the surrounding instructions and register diff.
+func (c *Comparer) Report(w io.Writer, d *Divergence) {
+	fmt.Fprintf(w, "diverged after %d instructions (trace line %d): %s\n", d.Index, d.Ref.Line, d.Reason)
+
+	fmt.Fprintln(w, "\ninstructions:")
+	for _, ev := range d.Before {
+		fmt.Fprintf(w, "    %s\n", c.formatInstruction(ev.PC, ev.Opcode))
+	}
+	pc := d.Ref.PC
+	if d.Ours != nil {
+		pc = d.Ours.PC
+		fmt.Fprintf(w, "ours> %s\n", c.formatInstruction(d.Ours.PC, d.Ours.Opcode))
+	}
+	fmt.Fprintf(w, "ref > %s\n", c.formatInstruction(d.Ref.PC, d.Ref.Opcode))
+	for i := 1; i <= c.context; i++ {
+		addr := pc + types.DoubleWord(i*4)
+		opcode, ok := c.fetch(addr)
+		if !ok {
+			break
+		}
+		fmt.Fprintf(w, "    %s\n", c.formatInstruction(addr, opcode))
+	}
+
+	fmt.Fprintln(w, "\nregisters:")
+	fmt.Fprintf(w, "  %-4s %-16s %s\n", "", "ours", "ref")
+	indices := []int{}
+	for r := range d.RefRegs {
+		indices = append(indices, int(r))
+	}
+	sort.Ints(indices)
+	for _, i := range indices {
+		r := types.Byte(i)
+		mark := " "
+		if d.OurRegs[r] != d.RefRegs[r] {
+			mark = "*"
+		}
+		fmt.Fprintf(w, "%s %-4s %016x %016x\n", mark, trace.RegisterName(r), d.OurRegs[r], d.RefRegs[r])
+	}
+}
+
+func (c *Comparer) formatInstruction(pc types.DoubleWord, opcode types.Word) string {
+	line := fmt.Sprintf("%016x %08x %s", pc, opcode, disasm.Disassemble(pc, opcode))
+	if name := c.n64.Symbols.Symbolize(pc); name != "" {
+		line += " <" + name + ">"
+	}
+	return line
+}
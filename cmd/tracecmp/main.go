@@ -0,0 +1,85 @@
+/*
+
+tracecmp runs a ROM in lockstep with a reference trace, and stops at the first divergence.
+
+Usage:
+	tracecmp [-format text|binary] [-context N] [-n max] [-symbols path] <rom> <trace>
+
+The report shows the instructions around the divergence and the registers listed in the trace so far.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"n64emu/pkg/core"
+	"n64emu/pkg/core/cart"
+	"n64emu/pkg/symbols"
+	"os"
+	"strings"
+)
+
+var (
+	format  = flag.String("format", "text", "format of the reference trace ("+strings.Join(Formats(), "|")+")")
+	context = flag.Int("context", 8, "number of instructions shown before and after the divergence")
+	max     = flag.Uint64("n", 0, "max number of instructions compared (0: no limit)")
+	syms    = flag.String("symbols", "", "symbols of the program (ELF, GNU ld map or text)")
+)
+
+const (
+	exitCodeOK int = iota
+	exitCodeError
+	exitCodeDiverged
+)
+
+func main() {
+	os.Exit(Run())
+}
+
+func Run() int {
+	flag.Parse()
+
+	if flag.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "usage: tracecmp [options] <rom> <trace>")
+		flag.PrintDefaults()
+		return exitCodeError
+	}
+
+	rom, err := cart.NewRom(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read ROM data: %s\n", err)
+		return exitCodeError
+	}
+	f, err := os.Open(flag.Arg(1))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open trace: %s\n", err)
+		return exitCodeError
+	}
+	defer f.Close()
+	parser, err := NewParser(*format, f)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read trace: %s\n", err)
+		return exitCodeError
+	}
+
+	n := core.NewN64(rom)
+	if *syms != "" {
+		if n.Symbols, err = symbols.Load(*syms); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read symbols: %s\n", err)
+			return exitCodeError
+		}
+	}
+	c := NewComparer(n, parser, *context)
+	d, matched, err := c.Run(*max)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read trace: %s\n", err)
+		return exitCodeError
+	}
+	if d != nil {
+		c.Report(os.Stdout, d)
+		return exitCodeDiverged
+	}
+	fmt.Printf("%d instructions matched\n", matched)
+	return exitCodeOK
+}
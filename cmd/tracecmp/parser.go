@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"n64emu/pkg/core/mips/r4300i/cpu"
+	"n64emu/pkg/core/mips/r4300i/trace"
+	"n64emu/pkg/types"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Record is an instruction in the reference trace
+type Record struct {
+	Line      int // line number, or record number for binary traces
+	PC        types.DoubleWord
+	Opcode    types.Word
+	Registers []cpu.RegisterWrite // register values after the instruction, only listed ones are compared
+	Exception bool                // the instruction was canceled by an exception
+}
+
+// Parser reads records from a reference trace.
+// Next returns io.EOF at the end of the trace.
+type Parser interface {
+	Next() (*Record, error)
+}
+
+// parsers is the trace dialects, by the name of -format
+var parsers = map[string]func(r io.Reader) (Parser, error){
+	"text":   newTextParser,
+	"binary": newBinaryParser,
+}
+
+// Formats returns names of the supported trace dialects.
+func Formats() []string {
+	names := []string{}
+	for name := range parsers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewParser returns parser of the trace dialect.
+func NewParser(format string, r io.Reader) (Parser, error) {
+	newParser, ok := parsers[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown trace format '%s'", format)
+	}
+	return newParser(r)
+}
+
+// textParser reads plain text traces, one instruction per line:
+//
+//	<pc> <opcode> [<register>=<value> ...]
+//
+// pc, opcode and values are hex with or without "0x", and 8-digit pc is sign-extended.
+// register is ABI name with or without '$', "rN", "hi" or "lo".
+// Other tokens (e.g. memory accesses) are ignored, except "exception".
+// Blank lines and lines starting with '#' are skipped.
+// The text format of the trace package is also read by this parser.
+type textParser struct {
+	s    *bufio.Scanner
+	line int
+}
+
+func newTextParser(r io.Reader) (Parser, error) {
+	return &textParser{s: bufio.NewScanner(r)}, nil
+}
+
+func (p *textParser) Next() (*Record, error) {
+	for p.s.Scan() {
+		p.line++
+		text := strings.TrimSpace(p.s.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		rec, err := parseTextLine(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", p.line, err)
+		}
+		rec.Line = p.line
+		return rec, nil
+	}
+	if err := p.s.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+func parseTextLine(text string) (*Record, error) {
+	fields := strings.Fields(text)
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("pc and opcode are required")
+	}
+	pc, err := parseHex(fields[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid pc '%s'", fields[0])
+	}
+	if len(strings.TrimPrefix(fields[0], "0x")) <= 8 {
+		pc = types.DoubleWord(types.SWord(pc))
+	}
+	opcode, err := parseHex(fields[1])
+	if err != nil || opcode > 0xFFFF_FFFF {
+		return nil, fmt.Errorf("invalid opcode '%s'", fields[1])
+	}
+
+	rec := &Record{PC: pc, Opcode: types.Word(opcode)}
+	for _, f := range fields[2:] {
+		if f == "exception" {
+			rec.Exception = true
+			continue
+		}
+		index := strings.Index(f, "=")
+		if index < 0 {
+			continue
+		}
+		r, ok := parseRegister(f[:index])
+		if !ok {
+			continue
+		}
+		v, err := parseHex(f[index+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid value '%s'", f)
+		}
+		rec.Registers = append(rec.Registers, cpu.RegisterWrite{Register: r, Value: v})
+	}
+	return rec, nil
+}
+
+func parseHex(s string) (types.DoubleWord, error) {
+	return strconv.ParseUint(strings.TrimPrefix(strings.ToLower(s), "0x"), 16, 64)
+}
+
+func parseRegister(name string) (types.Byte, bool) {
+	name = strings.ToLower(strings.TrimPrefix(name, "$"))
+	if name == "fp" {
+		name = "s8"
+	}
+	if index, ok := trace.RegisterIndex(name); ok {
+		return index, true
+	}
+	n := strings.TrimPrefix(name, "r")
+	if i, err := strconv.Atoi(n); err == nil && 0 <= i && i < 32 {
+		return types.Byte(i), true
+	}
+	return 0, false
+}
+
+// binaryParser reads binary traces of the trace package.
+// Register writes of the events are compared.
+type binaryParser struct {
+	r     *trace.BinaryReader
+	index int
+}
+
+func newBinaryParser(r io.Reader) (Parser, error) {
+	br, err := trace.NewBinaryReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return &binaryParser{r: br}, nil
+}
+
+func (p *binaryParser) Next() (*Record, error) {
+	ev, err := p.r.Read()
+	if err != nil {
+		return nil, err
+	}
+	p.index++
+	for _, r := range ev.Registers {
+		if r.Register > cpu.TraceRegLO {
+			return nil, fmt.Errorf("event %d: invalid register %d", p.index, r.Register)
+		}
+	}
+	return &Record{
+		Line:      p.index,
+		PC:        ev.PC,
+		Opcode:    ev.Opcode,
+		Registers: ev.Registers,
+		Exception: ev.Exception,
+	}, nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"n64emu/pkg/core"
+	"n64emu/pkg/core/cart"
+	"n64emu/pkg/core/mips/r4300i/asm"
+	"n64emu/pkg/core/mips/r4300i/cpu"
+	"n64emu/pkg/core/mips/r4300i/trace"
+	"n64emu/pkg/types"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTextParser(t *testing.T) {
+	src := `
+# comment
+80001000 27bdffe0 sp=ffffffff801fffe0
+0xFFFFFFFF80001004 0x8fa80010 $t0=1234 R4@001fffe0=00001234
+80001008 00850018 lo=6 r2=0x10 $29=20 fp=1
+8000100c 0000000c exception
+`
+	p, err := NewParser("text", strings.NewReader(src))
+	assert.NoError(t, err)
+
+	want := []Record{
+		{Line: 3, PC: 0xFFFFFFFF80001000, Opcode: 0x27bdffe0, Registers: []cpu.RegisterWrite{{Register: 29, Value: 0xffffffff801fffe0}}},
+		{Line: 4, PC: 0xFFFFFFFF80001004, Opcode: 0x8fa80010, Registers: []cpu.RegisterWrite{{Register: 8, Value: 0x1234}}},
+		{Line: 5, PC: 0xFFFFFFFF80001008, Opcode: 0x00850018, Registers: []cpu.RegisterWrite{
+			{Register: cpu.TraceRegLO, Value: 6}, {Register: 2, Value: 0x10}, {Register: 29, Value: 0x20}, {Register: 30, Value: 1},
+		}},
+		{Line: 6, PC: 0xFFFFFFFF8000100c, Opcode: 0x0000000c, Exception: true},
+	}
+	for _, w := range want {
+		got, err := p.Next()
+		assert.NoError(t, err)
+		assert.Equal(t, w, *got)
+	}
+	_, err = p.Next()
+	assert.Equal(t, io.EOF, err)
+
+	p, _ = NewParser("text", strings.NewReader("80001000 zzzz"))
+	_, err = p.Next()
+	assert.Error(t, err)
+
+	_, err = NewParser("unknown", strings.NewReader(""))
+	assert.Error(t, err)
+}
+
+const testProgram = `
+	addu  v0, s4, s6
+	addu  v1, v0, v0
+	multu v0, v1
+	mflo  a0
+	subu  a1, a0, v0
+`
+
+func newTestN64(src string) *core.N64 {
+	code := asm.MustAssemble(0xFFFFFFFF80000400, src)
+	image := make([]types.Byte, cart.RomHeaderSize+len(code))
+	binary.BigEndian.PutUint32(image[0:4], cart.RomHeaderBigEndian)
+	copy(image[cart.RomHeaderSize:], code)
+	return core.NewN64(&cart.ROM{ProgramCounter: 0x80000400, Image: image})
+}
+
+// reference trace written by the emulator itself
+func newTestTrace(src string, binary bool) []byte {
+	buf := &bytes.Buffer{}
+	var w *trace.Writer
+	if binary {
+		w = trace.NewBinaryWriter(buf, trace.Filter{Count: 10})
+	} else {
+		w = trace.NewTextWriter(buf, trace.Filter{Count: 10})
+	}
+	n := newTestN64(src)
+	n.CPU.SetTraceHook(w.Hook)
+	for !w.Done() {
+		n.CPU.Step()
+	}
+	w.Flush()
+	return buf.Bytes()
+}
+
+func TestComparer_Match(t *testing.T) {
+	for _, format := range Formats() {
+		t.Run(format, func(t *testing.T) {
+			p, err := NewParser(format, bytes.NewReader(newTestTrace(testProgram, format == "binary")))
+			assert.NoError(t, err)
+			d, n, err := NewComparer(newTestN64(testProgram), p, 4).Run(0)
+			assert.NoError(t, err)
+			assert.Nil(t, d)
+			assert.Equal(t, uint64(10), n)
+		})
+	}
+}
+
+func TestComparer_Diverged(t *testing.T) {
+	tests := []struct {
+		name   string
+		line   int
+		edit   func(string) string
+		reason string
+	}{
+		{"register", 4, func(l string) string { return strings.Replace(l, "a0=0", "a0=f", 1) }, "register mismatch: a0"},
+		{"pc", 2, func(l string) string { return "80000408" + l[8:] }, "pc mismatch"},
+		{"opcode", 3, func(l string) string { return l[:9] + "00000000" + l[17:] }, "opcode mismatch"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := strings.Split(string(newTestTrace(testProgram, false)), "\n")
+			lines[tt.line-1] = tt.edit(lines[tt.line-1])
+			p, _ := NewParser("text", strings.NewReader(strings.Join(lines, "\n")))
+
+			c := NewComparer(newTestN64(testProgram), p, 2)
+			d, n, err := c.Run(0)
+			assert.NoError(t, err)
+			if assert.NotNil(t, d) {
+				assert.Equal(t, tt.reason, d.Reason)
+				assert.Equal(t, tt.line, d.Ref.Line)
+				assert.Equal(t, uint64(tt.line-1), n)
+
+				out := &bytes.Buffer{}
+				c.Report(out, d)
+				assert.Contains(t, out.String(), tt.reason)
+			}
+		})
+	}
+}
+
+// HI and LO are compared as written by the instruction, though a later instruction writes them in EX stage
+func TestComparer_HILO(t *testing.T) {
+	src := `
+		multu s4, s4
+		nop
+		mtlo  zero
+	`
+	p, err := NewParser("text", bytes.NewReader(newTestTrace(src, false)))
+	assert.NoError(t, err)
+	d, n, err := NewComparer(newTestN64(src), p, 2).Run(0)
+	assert.NoError(t, err)
+	assert.Nil(t, d)
+	assert.Equal(t, uint64(10), n)
+}
+
+func TestComparer_Unimplemented(t *testing.T) {
+	ref := "80000400 3c088000\n" // lui t0, 0x8000
+	image := make([]types.Byte, cart.RomHeaderSize+4)
+	binary.BigEndian.PutUint32(image[cart.RomHeaderSize:], 0x3c088000)
+	n := core.NewN64(&cart.ROM{ProgramCounter: 0x80000400, Image: image})
+
+	p, _ := NewParser("text", strings.NewReader(ref))
+	d, _, err := NewComparer(n, p, 2).Run(0)
+	assert.NoError(t, err)
+	if assert.NotNil(t, d) {
+		assert.Nil(t, d.Ours)
+		assert.Contains(t, d.Reason, "TODO: LUI")
+	}
+}
@@ -13,3 +13,16 @@ type Bus interface {
 	ReadWord(e types.Endianness, addr types.Word) types.Word
 	ReadDoubleWord(e types.Endianness, addr types.Word) types.DoubleWord
 }
+
+// Fetcher is a Bus that tells instruction fetches from data reads.
+type Fetcher interface {
+	FetchWord(e types.Endianness, addr types.Word) types.Word
+}
+
+// FetchWord fetches an instruction through the bus. It is a word read, unless the bus is a Fetcher.
+func FetchWord(b Bus, e types.Endianness, addr types.Word) types.Word {
+	if f, ok := b.(Fetcher); ok {
+		return f.FetchWord(e, addr)
+	}
+	return b.ReadWord(e, addr)
+}
@@ -0,0 +1,192 @@
+package bus
+
+import (
+	"fmt"
+	"io"
+	"n64emu/pkg/types"
+)
+
+// AccessKind is kind of bus access
+type AccessKind types.Byte
+
+const (
+	AccessRead AccessKind = 1 << iota
+	AccessWrite
+	AccessFetch // instruction fetch
+)
+
+// Access is an access through the bus
+type Access struct {
+	Kind AccessKind
+	// physical address
+	Addr types.Word
+	// width in bytes, 1, 2, 4 or 8
+	Size types.Word
+	// value read or written
+	Value types.DoubleWord
+	// name of the register, or empty if it is not a known register
+	Name string
+}
+
+// String returns the access e.g. "W4 VI_ORIGIN(04400004)=00100000"
+func (a *Access) String() string {
+	kind := "R"
+	switch a.Kind {
+	case AccessWrite:
+		kind = "W"
+	case AccessFetch:
+		kind = "F"
+	}
+	addr := fmt.Sprintf("%08x", a.Addr)
+	if a.Name != "" {
+		addr = a.Name + "(" + addr + ")"
+	}
+	return fmt.Sprintf("%s%d %s=%0*x", kind, a.Size, addr, 2*a.Size, a.Value)
+}
+
+// Filter selects accesses to watch
+type Filter struct {
+	// physical address range, the whole space if Size is 0
+	Addr types.Word
+	Size types.Word
+	// kinds of accesses, reads and writes if 0. Fetches are watched only if AccessFetch is set.
+	Kind AccessKind
+	// widths of accesses, as bits of the sizes e.g. 4|8 for words and doublewords. All if 0.
+	Widths types.Byte
+}
+
+func (f *Filter) match(a *Access) bool {
+	kind := f.Kind
+	if kind == 0 {
+		kind = AccessRead | AccessWrite
+	}
+	if (kind & a.Kind) == 0 {
+		return false
+	}
+	if f.Widths != 0 && (f.Widths&types.Byte(a.Size)) == 0 {
+		return false
+	}
+	// ranges overlap, compared by the last bytes not to wrap at the top of the address space
+	return f.Size == 0 || (a.Addr <= f.Addr+f.Size-1 && f.Addr <= a.Addr+a.Size-1)
+}
+
+type watch struct {
+	id     int
+	filter Filter
+	fn     func(a *Access)
+}
+
+// Watcher is a Bus that calls functions on the accesses to the wrapped Bus, e.g. to debug MMIO drivers.
+type Watcher struct {
+	Bus
+	names   func(addr types.Word) string
+	watches []watch // in order of the ID
+	nextID  int
+}
+
+// NewWatcher returns Watcher wrapping the bus. names returns the register name of the address, and may be nil.
+func NewWatcher(b Bus, names func(addr types.Word) string) *Watcher {
+	return &Watcher{
+		Bus:   b,
+		names: names,
+	}
+}
+
+// Watch adds the function called after every access that passes the filter, and returns its ID.
+// The functions are called in order of the ID.
+func (w *Watcher) Watch(f Filter, fn func(a *Access)) int {
+	id := w.nextID
+	w.nextID++
+	w.watches = append(w.watches, watch{id: id, filter: f, fn: fn})
+	return id
+}
+
+// Unwatch removes the function. Returns false if it does not exist.
+func (w *Watcher) Unwatch(id int) bool {
+	for i, wt := range w.watches {
+		if wt.id == id {
+			w.watches = append(w.watches[:i:i], w.watches[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Watching reports whether any function is watching the accesses.
+func (w *Watcher) Watching() bool {
+	return len(w.watches) > 0
+}
+
+// Log returns a function for Watch, that writes accesses as lines.
+func Log(out io.Writer) func(a *Access) {
+	return func(a *Access) {
+		fmt.Fprintln(out, a.String())
+	}
+}
+
+func (w *Watcher) notify(kind AccessKind, addr types.Word, size types.Word, value types.DoubleWord) {
+	if len(w.watches) == 0 {
+		return
+	}
+	a := &Access{Kind: kind, Addr: addr, Size: size, Value: value}
+	named := false
+	for _, wt := range w.watches {
+		if !wt.filter.match(a) {
+			continue
+		}
+		if !named && w.names != nil {
+			a.Name, named = w.names(addr), true
+		}
+		wt.fn(a)
+	}
+}
+
+func (w *Watcher) WriteByte(e types.Endianness, addr types.Word, data types.Byte) {
+	w.Bus.WriteByte(e, addr, data)
+	w.notify(AccessWrite, addr, 1, types.DoubleWord(data))
+}
+
+func (w *Watcher) WriteHalfWord(e types.Endianness, addr types.Word, data types.HalfWord) {
+	w.Bus.WriteHalfWord(e, addr, data)
+	w.notify(AccessWrite, addr, 2, types.DoubleWord(data))
+}
+
+func (w *Watcher) WriteWord(e types.Endianness, addr types.Word, data types.Word) {
+	w.Bus.WriteWord(e, addr, data)
+	w.notify(AccessWrite, addr, 4, types.DoubleWord(data))
+}
+
+func (w *Watcher) WriteDoubleWord(e types.Endianness, addr types.Word, data types.DoubleWord) {
+	w.Bus.WriteDoubleWord(e, addr, data)
+	w.notify(AccessWrite, addr, 8, data)
+}
+
+func (w *Watcher) ReadByte(e types.Endianness, addr types.Word) types.Byte {
+	data := w.Bus.ReadByte(e, addr)
+	w.notify(AccessRead, addr, 1, types.DoubleWord(data))
+	return data
+}
+
+func (w *Watcher) ReadHalfWord(e types.Endianness, addr types.Word) types.HalfWord {
+	data := w.Bus.ReadHalfWord(e, addr)
+	w.notify(AccessRead, addr, 2, types.DoubleWord(data))
+	return data
+}
+
+func (w *Watcher) ReadWord(e types.Endianness, addr types.Word) types.Word {
+	data := w.Bus.ReadWord(e, addr)
+	w.notify(AccessRead, addr, 4, types.DoubleWord(data))
+	return data
+}
+
+func (w *Watcher) FetchWord(e types.Endianness, addr types.Word) types.Word {
+	data := FetchWord(w.Bus, e, addr)
+	w.notify(AccessFetch, addr, 4, types.DoubleWord(data))
+	return data
+}
+
+func (w *Watcher) ReadDoubleWord(e types.Endianness, addr types.Word) types.DoubleWord {
+	data := w.Bus.ReadDoubleWord(e, addr)
+	w.notify(AccessRead, addr, 8, data)
+	return data
+}
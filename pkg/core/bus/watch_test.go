@@ -0,0 +1,113 @@
+package bus
+
+import (
+	"bytes"
+	"n64emu/pkg/types"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// memory of 16 bytes, that ignores the endianness
+type testBus struct {
+	mem [16]types.Byte
+}
+
+func (b *testBus) WriteByte(e types.Endianness, addr types.Word, data types.Byte) { b.mem[addr] = data }
+func (b *testBus) WriteHalfWord(e types.Endianness, addr types.Word, data types.HalfWord) {
+	b.WriteByte(e, addr, types.Byte(data>>8))
+	b.WriteByte(e, addr+1, types.Byte(data))
+}
+func (b *testBus) WriteWord(e types.Endianness, addr types.Word, data types.Word) {
+	b.WriteHalfWord(e, addr, types.HalfWord(data>>16))
+	b.WriteHalfWord(e, addr+2, types.HalfWord(data))
+}
+func (b *testBus) WriteDoubleWord(e types.Endianness, addr types.Word, data types.DoubleWord) {
+	b.WriteWord(e, addr, types.Word(data>>32))
+	b.WriteWord(e, addr+4, types.Word(data))
+}
+func (b *testBus) ReadByte(e types.Endianness, addr types.Word) types.Byte { return b.mem[addr] }
+func (b *testBus) ReadHalfWord(e types.Endianness, addr types.Word) types.HalfWord {
+	return types.HalfWord(b.ReadByte(e, addr))<<8 | types.HalfWord(b.ReadByte(e, addr+1))
+}
+func (b *testBus) ReadWord(e types.Endianness, addr types.Word) types.Word {
+	return types.Word(b.ReadHalfWord(e, addr))<<16 | types.Word(b.ReadHalfWord(e, addr+2))
+}
+func (b *testBus) ReadDoubleWord(e types.Endianness, addr types.Word) types.DoubleWord {
+	return types.DoubleWord(b.ReadWord(e, addr))<<32 | types.DoubleWord(b.ReadWord(e, addr+4))
+}
+
+func TestWatcher(t *testing.T) {
+	assert := assert.New(t)
+	names := func(addr types.Word) string {
+		if addr&^3 == 4 {
+			return "TEST_REG"
+		}
+		return ""
+	}
+	w := NewWatcher(&testBus{}, names)
+
+	all := []Access{}
+	id := w.Watch(Filter{}, func(a *Access) { all = append(all, *a) })
+	log := &bytes.Buffer{}
+	w.Watch(Filter{Addr: 4, Size: 4, Kind: AccessWrite, Widths: 4 | 8}, Log(log))
+
+	w.WriteDoubleWord(types.Big, 0, 0xAABB)
+	w.WriteWord(types.Big, 4, 0x1234_5678)
+	w.WriteByte(types.Big, 5, 0xFF)
+	w.WriteDoubleWord(types.Big, 8, 1)
+	assert.Equal(types.HalfWord(0x12FF), w.ReadHalfWord(types.Big, 4))
+
+	assert.Equal([]Access{
+		{Kind: AccessWrite, Addr: 0, Size: 8, Value: 0xAABB},
+		{Kind: AccessWrite, Addr: 4, Size: 4, Value: 0x1234_5678, Name: "TEST_REG"},
+		{Kind: AccessWrite, Addr: 5, Size: 1, Value: 0xFF, Name: "TEST_REG"},
+		{Kind: AccessWrite, Addr: 8, Size: 8, Value: 1},
+		{Kind: AccessRead, Addr: 4, Size: 2, Value: 0x12FF, Name: "TEST_REG"},
+	}, all)
+	assert.Equal("W8 00000000=000000000000aabb\nW4 TEST_REG(00000004)=12345678\n", log.String())
+
+	assert.True(w.Unwatch(id))
+	assert.False(w.Unwatch(id))
+	w.ReadWord(types.Big, 0)
+	assert.Len(all, 5)
+}
+
+func TestWatcher_Fetch(t *testing.T) {
+	assert := assert.New(t)
+	w := NewWatcher(&testBus{}, nil)
+	w.WriteWord(types.Big, 8, 0x1234_5678)
+
+	order := []int{}
+	data := []Access{}
+	fetches := []Access{}
+	for i := 0; i < 3; i++ {
+		i := i
+		w.Watch(Filter{}, func(a *Access) { order = append(order, i) })
+	}
+	w.Watch(Filter{}, func(a *Access) { data = append(data, *a) })
+	w.Watch(Filter{Kind: AccessFetch}, func(a *Access) { fetches = append(fetches, *a) })
+
+	assert.Equal(types.Word(0x1234_5678), FetchWord(w, types.Big, 8))
+	assert.Equal(types.Word(0x1234_5678), w.ReadWord(types.Big, 8))
+	assert.Equal([]Access{{Kind: AccessRead, Addr: 8, Size: 4, Value: 0x1234_5678}}, data, "should fetches not watched by default")
+	assert.Equal([]Access{{Kind: AccessFetch, Addr: 8, Size: 4, Value: 0x1234_5678}}, fetches)
+	assert.Equal("F4 00000008=12345678", fetches[0].String())
+	assert.Equal([]int{0, 1, 2}, order, "should functions called in order of the ID")
+
+	assert.True(w.Watching())
+	for id := 0; id < 5; id++ {
+		w.Unwatch(id)
+	}
+	assert.False(w.Watching())
+}
+
+func TestFilter_Match(t *testing.T) {
+	assert := assert.New(t)
+	f := Filter{Addr: 0xFFFF_FFFC, Size: 4}
+	assert.True(f.match(&Access{Kind: AccessRead, Addr: 0xFFFF_FFFC, Size: 4}), "should match at the top of the address space")
+	assert.True(f.match(&Access{Kind: AccessWrite, Addr: 0xFFFF_FFF8, Size: 8}))
+	assert.False(f.match(&Access{Kind: AccessRead, Addr: 0xFFFF_FFF8, Size: 4}))
+	assert.False(f.match(&Access{Kind: AccessRead, Addr: 0x0000_0000, Size: 4}), "should not wrap to the bottom")
+	assert.True((&Filter{}).match(&Access{Kind: AccessRead, Addr: 0xFFFF_FFFC, Size: 4}), "should empty filter match all")
+}
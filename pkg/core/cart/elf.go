@@ -0,0 +1,112 @@
+/*
+ELF Loader
+
+Homebrew executables (e.g. libdragon programs before makemask and n64tool) are MIPS ELF files in big-endian.
+Both ELF32 and ELF64 are accepted. Loadable segments are placed at their virtual addresses,
+and 32-bit addresses are sign-extended.
+*/
+
+package cart
+
+import (
+	"debug/elf"
+	"errors"
+	"io"
+	"n64emu/pkg/symbols"
+	"n64emu/pkg/types"
+	"os"
+)
+
+// ELF is an executable loaded from ELF file
+type ELF struct {
+	ELFPath string
+	// entry point
+	Entry types.DoubleWord
+	// loadable segments
+	Segments []Segment
+	// symbols in .symtab
+	Symbols *symbols.Table
+}
+
+// Segment is a loadable segment
+type Segment struct {
+	VAddr types.DoubleWord
+	// contents in file, the rest of MemSize is zero-filled (e.g. .bss)
+	Data    []types.Byte
+	MemSize types.DoubleWord
+}
+
+// IsELF reports whether the file starts with ELF magic number.
+func IsELF(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	magic := make([]byte, len(elf.ELFMAG))
+	if _, err := io.ReadFull(f, magic); err != nil {
+		return false
+	}
+	return string(magic) == elf.ELFMAG
+}
+
+// Read from ELF file
+func NewELF(elfPath string) (*ELF, error) {
+	f, err := os.Open(elfPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	dst, err := ParseELF(f)
+	if err != nil {
+		return nil, err
+	}
+	dst.ELFPath = elfPath
+	return dst, nil
+}
+
+// ParseELF parses ELF image
+func ParseELF(r io.ReaderAt) (*ELF, error) {
+	f, err := elf.NewFile(r)
+	if err != nil {
+		return nil, err
+	}
+	if f.Machine != elf.EM_MIPS {
+		return nil, errors.New("Not a MIPS executable")
+	}
+	if f.Data != elf.ELFDATA2MSB {
+		return nil, errors.New("Not a big-endian executable")
+	}
+
+	is32 := f.Class == elf.ELFCLASS32
+	addr := func(v uint64) types.DoubleWord {
+		if is32 {
+			return types.DoubleWord(types.SWord(v))
+		}
+		return v
+	}
+
+	dst := ELF{Entry: addr(f.Entry)}
+	for _, p := range f.Progs {
+		if p.Type != elf.PT_LOAD || p.Memsz == 0 {
+			continue
+		}
+		data := make([]types.Byte, p.Filesz)
+		if _, err := p.ReadAt(data, 0); err != nil {
+			return nil, err
+		}
+		dst.Segments = append(dst.Segments, Segment{
+			VAddr:   addr(p.Vaddr),
+			Data:    data,
+			MemSize: p.Memsz,
+		})
+	}
+	if len(dst.Segments) == 0 {
+		return nil, errors.New("No loadable segment")
+	}
+
+	if dst.Symbols, err = symbols.FromELF(f); err != nil {
+		return nil, err
+	}
+	return &dst, nil
+}
@@ -0,0 +1,133 @@
+package cart
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"io/ioutil"
+	"n64emu/pkg/symbols"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testSymbol struct {
+	name  string
+	value uint32
+	size  uint32
+	info  byte
+}
+
+// build ELF32 big-endian executable with a loadable segment and .symtab
+func buildELF(machine elf.Machine, entry uint32, vaddr uint32, data []byte, memsz uint32, symbols []testSymbol) []byte {
+	be := binary.BigEndian
+	const (
+		ehsize    = 52
+		phentsize = 32
+		shentsize = 40
+	)
+
+	// .strtab and .shstrtab
+	strtab := []byte{0}
+	syms := &bytes.Buffer{}
+	syms.Write(make([]byte, 16)) // null symbol
+	for _, s := range symbols {
+		name := uint32(len(strtab))
+		strtab = append(append(strtab, s.name...), 0)
+		binary.Write(syms, be, struct {
+			Name  uint32
+			Value uint32
+			Size  uint32
+			Info  byte
+			Other byte
+			Shndx uint16
+		}{name, s.value, s.size, s.info, 0, 1})
+	}
+	shstrtab := []byte("\x00.text\x00.symtab\x00.strtab\x00.shstrtab\x00")
+
+	// layout: header | program header | data | symtab | strtab | shstrtab | section headers
+	dataOff := uint32(ehsize + phentsize)
+	symOff := dataOff + uint32(len(data))
+	strOff := symOff + uint32(syms.Len())
+	shstrOff := strOff + uint32(len(strtab))
+	shOff := shstrOff + uint32(len(shstrtab))
+
+	buf := &bytes.Buffer{}
+	ident := [16]byte{0x7f, 'E', 'L', 'F', byte(elf.ELFCLASS32), byte(elf.ELFDATA2MSB), byte(elf.EV_CURRENT)}
+	buf.Write(ident[:])
+	binary.Write(buf, be, struct {
+		Type, Machine                                        uint16
+		Version, Entry, Phoff, Shoff, Flags                  uint32
+		Ehsize, Phentsize, Phnum, Shentsize, Shnum, Shstrndx uint16
+	}{uint16(elf.ET_EXEC), uint16(machine), 1, entry, ehsize, shOff, 0, ehsize, phentsize, 1, shentsize, 5, 4})
+	binary.Write(buf, be, struct {
+		Type, Off, Vaddr, Paddr, Filesz, Memsz, Flags, Align uint32
+	}{uint32(elf.PT_LOAD), dataOff, vaddr, vaddr, uint32(len(data)), memsz, uint32(elf.PF_R | elf.PF_X), 4})
+	buf.Write(data)
+	buf.Write(syms.Bytes())
+	buf.Write(strtab)
+	buf.Write(shstrtab)
+
+	type section struct {
+		Name, Type, Flags, Addr, Off, Size, Link, Info, Addralign, Entsize uint32
+	}
+	for _, sh := range []section{
+		{},
+		{1, uint32(elf.SHT_PROGBITS), uint32(elf.SHF_ALLOC | elf.SHF_EXECINSTR), vaddr, dataOff, uint32(len(data)), 0, 0, 4, 0},
+		{7, uint32(elf.SHT_SYMTAB), 0, 0, symOff, uint32(syms.Len()), 3, 1, 4, 16},
+		{15, uint32(elf.SHT_STRTAB), 0, 0, strOff, uint32(len(strtab)), 0, 0, 1, 0},
+		{23, uint32(elf.SHT_STRTAB), 0, 0, shstrOff, uint32(len(shstrtab)), 0, 0, 1, 0},
+	} {
+		binary.Write(buf, be, sh)
+	}
+	return buf.Bytes()
+}
+
+func TestNewELF(t *testing.T) {
+	assert := assert.New(t)
+	src := buildELF(elf.EM_MIPS, 0x80000400, 0x80000400, []byte{0x27, 0xbd, 0xff, 0xe0}, 0x10, []testSymbol{
+		{"main", 0x80000404, 8, byte(elf.STT_FUNC)},
+		{"_start", 0x80000400, 4, byte(elf.STT_FUNC)},
+		{"counter", 0x80000408, 4, byte(elf.STT_OBJECT)},
+		{"crt0.S", 0, 0, byte(elf.STT_FILE)},
+	})
+	path := filepath.Join(t.TempDir(), "test.elf")
+	assert.NoError(ioutil.WriteFile(path, src, 0644))
+
+	assert.True(IsELF(path))
+	e, err := NewELF(path)
+	assert.NoError(err)
+	assert.Equal(path, e.ELFPath)
+	assert.Equal(uint64(0xFFFFFFFF80000400), e.Entry, "should 32-bit entry sign-extended")
+	assert.Equal([]Segment{{VAddr: 0xFFFFFFFF80000400, Data: []byte{0x27, 0xbd, 0xff, 0xe0}, MemSize: 0x10}}, e.Segments)
+	assert.Equal([]symbols.Symbol{
+		{Name: "_start", Addr: 0xFFFFFFFF80000400, Size: 4},
+		{Name: "main", Addr: 0xFFFFFFFF80000404, Size: 8},
+		{Name: "counter", Addr: 0xFFFFFFFF80000408, Size: 4},
+	}, e.Symbols.Symbols(), "should symbols sorted by address, without file symbols")
+}
+
+func TestNewELF_Error(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name string
+		src  []byte
+	}{
+		{"not ELF", bytes.Repeat([]byte{0x80, 0x37, 0x12, 0x40}, 0x400)},
+		{"not MIPS", buildELF(elf.EM_ARM, 0x80000400, 0x80000400, []byte{0, 0, 0, 0}, 4, nil)},
+		{"no segment", buildELF(elf.EM_MIPS, 0x80000400, 0x80000400, nil, 0, nil)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name)
+			assert.NoError(t, ioutil.WriteFile(path, tt.src, 0644))
+			_, err := NewELF(path)
+			assert.Error(t, err)
+		})
+	}
+	_, err := NewELF(filepath.Join(dir, "not found"))
+	assert.True(t, os.IsNotExist(err))
+	assert.False(t, IsELF(filepath.Join(dir, "not found")))
+}
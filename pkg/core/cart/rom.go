@@ -118,6 +118,8 @@ type ROM struct {
 	BootCode []types.Byte
 	// 0x1000 ~ File End
 	Data []types.Byte
+	// whole image in big-endian, including the header
+	Image []types.Byte
 }
 
 // Swap the values of A and B.
@@ -237,6 +239,7 @@ func NewRom(romPath string) (*ROM, error) {
 	dst.Version = types.Byte(src[0x3f])
 	dst.BootCode = src[0x40 : 0x40+BootCodeSize] // 0x40 ~ 0x1000
 	dst.Data = src[RomHeaderSize:]               // 0x1000 ~ File End
+	dst.Image = src
 
 	// done.
 	return &dst, nil
@@ -0,0 +1,65 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"n64emu/pkg/core/mips/r4300i/cpu"
+	"n64emu/pkg/core/mips/r4300i/disasm"
+	"n64emu/pkg/core/mips/r4300i/reg"
+	"n64emu/pkg/symbols"
+	"n64emu/pkg/types"
+	"runtime/debug"
+)
+
+// Crash is a panic in the emulation (e.g. util.TODO or assert), with the state of the CPU at that time
+type Crash struct {
+	Value  interface{}      // value passed to panic
+	PC     types.DoubleWord // address of the instruction in EX stage
+	Opcode types.Word
+	State  cpu.State
+	Stack  []byte // stack trace of the emulator
+	// symbols of the program, may be nil
+	Symbols *symbols.Table
+}
+
+func (c *Crash) Error() string {
+	return fmt.Sprintf("panic at %s: %v", c.Symbols.Format(c.PC), c.Value)
+}
+
+// Report writes the crash report with symbolized addresses.
+func (c *Crash) Report(w io.Writer) {
+	fmt.Fprintf(w, "panic: %v\n\n", c.Value)
+	fmt.Fprintf(w, "pc: %s\n", c.Symbols.Format(c.PC))
+	fmt.Fprintf(w, "    %08x  %s\n", c.Opcode, disasm.Disassemble(c.PC, c.Opcode))
+	fmt.Fprintf(w, "ra: %s\n", c.Symbols.Format(c.State.GPR[31]))
+	fmt.Fprintf(w, "epc: %s\n\n", c.Symbols.Format(c.State.CP0[reg.CP0EPC]))
+
+	for i := 0; i < reg.NumOfRegsInGpr; i += 4 {
+		for j := i; j < i+4; j++ {
+			fmt.Fprintf(w, "%4s %016x", reg.GPRNames[j], c.State.GPR[j])
+			if j < i+3 {
+				fmt.Fprint(w, "  ")
+			}
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "%4s %016x  %4s %016x\n", "hi", c.State.HI, "lo", c.State.LO)
+	fmt.Fprintf(w, "%8s %08x  %8s %08x\n", "Status", c.State.CP0[reg.CP0Status], "Cause", c.State.CP0[reg.CP0Cause])
+
+	fmt.Fprintf(w, "\nemulator stack:\n%s", c.Stack)
+}
+
+func (n *N64) crash(value interface{}) *Crash {
+	s := n.CPU.Save()
+	c := &Crash{
+		Value:   value,
+		PC:      s.Pipeline.ExecutionPC,
+		State:   s,
+		Stack:   debug.Stack(),
+		Symbols: n.Symbols,
+	}
+	if paddr, ok := n.CPU.Translate(c.PC); ok {
+		c.Opcode = n.bus.ReadWord(types.Big, paddr)
+	}
+	return c
+}
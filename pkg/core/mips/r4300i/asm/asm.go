@@ -0,0 +1,313 @@
+/*
+
+MIPS III(VR4300) Assembler
+
+Source format:
+	label:                      # labels end with ':', and may be followed by an instruction
+	    addiu  sp, sp, -32      # registers are ABI names, with or without '$' ($sp, sp, $29)
+	    lw     t0, 16(sp)       # offset(base)
+	    beq    t0, zero, label  # branch and jump targets are labels or addresses
+	    li     t1, 0x80001000   # pseudo instructions
+	    .word  0x12345678       # raw data
+
+	Comments start with '#', ';' or '//'.
+
+Pseudo instructions:
+	nop                 -> sll zero, zero, 0
+	move rd, rs         -> or rd, rs, zero
+	li   rt, imm        -> addiu rt, zero, imm / ori rt, zero, imm / lui rt, hi + ori rt, rt, lo
+	la   rt, label      -> lui rt, hi + ori rt, rt, lo
+	b    label          -> beq zero, zero, label
+	beqz rs, label      -> beq rs, zero, label
+	bnez rs, label      -> bne rs, zero, label
+
+Instructions and their operands are taken from the opcode table of the isa package.
+The output is big-endian byte sequence.
+*/
+
+package asm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"n64emu/pkg/core/mips/r4300i/isa"
+	"n64emu/pkg/types"
+	"strconv"
+	"strings"
+)
+
+// Assemble assembles source placed at base address.
+func Assemble(base types.DoubleWord, src string) ([]types.Byte, error) {
+	lines, err := parse(src)
+	if err != nil {
+		return nil, err
+	}
+
+	// pass 1: resolve label addresses
+	labels := map[string]types.DoubleWord{}
+	addr := base
+	for _, l := range lines {
+		for _, label := range l.labels {
+			if _, ok := labels[label]; ok {
+				return nil, fmt.Errorf("line %d: duplicate label '%s'", l.number, label)
+			}
+			labels[label] = addr
+		}
+		if l.mnemonic == "" {
+			continue
+		}
+		n, err := size(l)
+		if err != nil {
+			return nil, err
+		}
+		addr += types.DoubleWord(n * 4)
+	}
+
+	// pass 2: encode
+	dst := []types.Byte{}
+	addr = base
+	for _, l := range lines {
+		if l.mnemonic == "" {
+			continue
+		}
+		words, err := encodeLine(addr, l, labels)
+		if err != nil {
+			return nil, err
+		}
+		for _, w := range words {
+			bytes := make([]types.Byte, 4)
+			binary.BigEndian.PutUint32(bytes, w)
+			dst = append(dst, bytes...)
+		}
+		addr += types.DoubleWord(len(words) * 4)
+	}
+	return dst, nil
+}
+
+// MustAssemble is like Assemble but panics if the source cannot be assembled.
+// It is intended for tests.
+func MustAssemble(base types.DoubleWord, src string) []types.Byte {
+	dst, err := Assemble(base, src)
+	if err != nil {
+		panic(err)
+	}
+	return dst
+}
+
+// a line of source
+type line struct {
+	number   int
+	labels   []string
+	mnemonic string
+	operands []string
+}
+
+func (l *line) errorf(format string, a ...interface{}) error {
+	return fmt.Errorf("line %d: %s: %s", l.number, l.mnemonic, fmt.Sprintf(format, a...))
+}
+
+// parse source into lines
+func parse(src string) ([]line, error) {
+	lines := []line{}
+	for i, text := range strings.Split(src, "\n") {
+		l := line{number: i + 1}
+		// strip comments
+		for _, c := range []string{"#", ";", "//"} {
+			if index := strings.Index(text, c); index >= 0 {
+				text = text[:index]
+			}
+		}
+		text = strings.TrimSpace(text)
+
+		// labels
+		for {
+			index := strings.Index(text, ":")
+			if index < 0 {
+				break
+			}
+			label := strings.TrimSpace(text[:index])
+			if !isIdentifier(label) {
+				return nil, fmt.Errorf("line %d: invalid label '%s'", l.number, label)
+			}
+			l.labels = append(l.labels, label)
+			text = strings.TrimSpace(text[index+1:])
+		}
+
+		// mnemonic and operands
+		if text != "" {
+			fields := []string{text}
+			if index := strings.IndexAny(text, " \t"); index >= 0 {
+				fields = []string{text[:index], text[index+1:]}
+			}
+			l.mnemonic = strings.ToLower(fields[0])
+			if len(fields) == 2 && strings.TrimSpace(fields[1]) != "" {
+				for _, o := range strings.Split(fields[1], ",") {
+					l.operands = append(l.operands, strings.TrimSpace(o))
+				}
+			}
+		}
+		lines = append(lines, l)
+	}
+	return lines, nil
+}
+
+func isIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, c := range s {
+		isAlpha := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '_' || c == '.'
+		isDigit := c >= '0' && c <= '9'
+		if !isAlpha && !(isDigit && i > 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// number of words the line is assembled into
+func size(l line) (int, error) {
+	switch l.mnemonic {
+	case "la":
+		return 2, nil
+	case "li":
+		if len(l.operands) != 2 {
+			return 0, l.errorf("2 operands are required")
+		}
+		imm, err := parseInt(l.operands[1])
+		if err != nil {
+			return 0, l.errorf("%s", err)
+		}
+		if isInt16(imm) || isUint16(imm) {
+			return 1, nil
+		}
+		return 2, nil
+	}
+	return 1, nil
+}
+
+// encode a line at addr
+func encodeLine(addr types.DoubleWord, l line, labels map[string]types.DoubleWord) ([]types.Word, error) {
+	// data and pseudo instructions
+	switch l.mnemonic {
+	case ".word":
+		words := []types.Word{}
+		for _, o := range l.operands {
+			v, err := parseInt(o)
+			if err != nil {
+				return nil, l.errorf("%s", err)
+			}
+			words = append(words, types.Word(v))
+		}
+		if len(words) != 1 {
+			return nil, l.errorf("1 operand is required")
+		}
+		return words, nil
+	case "nop":
+		return encodeAs(addr, l, "sll", []string{"zero", "zero", "0"}, labels)
+	case "move":
+		if len(l.operands) != 2 {
+			return nil, l.errorf("2 operands are required")
+		}
+		return encodeAs(addr, l, "or", []string{l.operands[0], l.operands[1], "zero"}, labels)
+	case "b":
+		if len(l.operands) != 1 {
+			return nil, l.errorf("1 operand is required")
+		}
+		return encodeAs(addr, l, "beq", []string{"zero", "zero", l.operands[0]}, labels)
+	case "beqz", "bnez":
+		if len(l.operands) != 2 {
+			return nil, l.errorf("2 operands are required")
+		}
+		return encodeAs(addr, l, l.mnemonic[:3], []string{l.operands[0], "zero", l.operands[1]}, labels)
+	case "li", "la":
+		if len(l.operands) != 2 {
+			return nil, l.errorf("2 operands are required")
+		}
+		var imm int64
+		if l.mnemonic == "la" {
+			target, err := parseTarget(l.operands[1], labels)
+			if err != nil {
+				return nil, l.errorf("%s", err)
+			}
+			imm = int64(target)
+		} else {
+			v, err := parseInt(l.operands[1])
+			if err != nil {
+				return nil, l.errorf("%s", err)
+			}
+			imm = v
+			if isInt16(imm) {
+				return encodeAs(addr, l, "addiu", []string{l.operands[0], "zero", fmt.Sprint(imm)}, labels)
+			}
+			if isUint16(imm) {
+				return encodeAs(addr, l, "ori", []string{l.operands[0], "zero", fmt.Sprint(imm)}, labels)
+			}
+		}
+		if !isInt32(imm) && !isUint32(imm) {
+			return nil, l.errorf("immediate %d is out of range", imm)
+		}
+		hi, err := encodeAs(addr, l, "lui", []string{l.operands[0], fmt.Sprint((imm >> 16) & 0xffff)}, labels)
+		if err != nil {
+			return nil, err
+		}
+		lo, err := encodeAs(addr+4, l, "ori", []string{l.operands[0], l.operands[0], fmt.Sprint(imm & 0xffff)}, labels)
+		if err != nil {
+			return nil, err
+		}
+		return append(hi, lo...), nil
+	}
+
+	inst, ok := isa.LookupMnemonic(l.mnemonic)
+	if !ok {
+		return nil, fmt.Errorf("line %d: unknown instruction '%s'", l.number, l.mnemonic)
+	}
+	word, err := encode(inst, addr, l.operands, labels)
+	if err != nil {
+		return nil, l.errorf("%s", err)
+	}
+	return []types.Word{word}, nil
+}
+
+// encode pseudo instruction as another instruction
+func encodeAs(addr types.DoubleWord, l line, mnemonic string, operands []string, labels map[string]types.DoubleWord) ([]types.Word, error) {
+	return encodeLine(addr, line{number: l.number, labels: l.labels, mnemonic: mnemonic, operands: operands}, labels)
+}
+
+func isInt16(v int64) bool  { return -0x8000 <= v && v <= 0x7fff }
+func isUint16(v int64) bool { return 0 <= v && v <= 0xffff }
+func isInt32(v int64) bool  { return -0x8000_0000 <= v && v <= 0x7fff_ffff }
+func isUint32(v int64) bool { return 0 <= v && v <= 0xffff_ffff }
+
+// parse integer literal (decimal, 0x hex, 0b binary, with optional sign)
+func parseInt(s string) (int64, error) {
+	v, err := strconv.ParseInt(strings.ReplaceAll(s, "_", ""), 0, 64)
+	if err != nil {
+		// allow 64-bit unsigned literals e.g. 0xFFFFFFFF80000000
+		u, uerr := strconv.ParseUint(strings.ReplaceAll(s, "_", ""), 0, 64)
+		if uerr != nil {
+			return 0, fmt.Errorf("invalid number '%s'", s)
+		}
+		return int64(u), nil
+	}
+	return v, nil
+}
+
+// parse label or address
+// 32-bit addresses are sign-extended e.g. 0x80001000 -> 0xFFFFFFFF80001000
+func parseTarget(s string, labels map[string]types.DoubleWord) (types.DoubleWord, error) {
+	if addr, ok := labels[s]; ok {
+		return addr, nil
+	}
+	if isIdentifier(s) {
+		return 0, fmt.Errorf("undefined label '%s'", s)
+	}
+	v, err := parseInt(s)
+	if err != nil {
+		return 0, err
+	}
+	if isUint32(v) {
+		return types.DoubleWord(types.SWord(v)), nil
+	}
+	return types.DoubleWord(v), nil
+}
@@ -0,0 +1,204 @@
+package asm
+
+import (
+	"encoding/binary"
+	"n64emu/pkg/core/mips/r4300i/cpu"
+	"n64emu/pkg/core/mips/r4300i/disasm"
+	"n64emu/pkg/core/mips/r4300i/isa"
+	"n64emu/pkg/types"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const pc = types.DoubleWord(0xFFFFFFFF80001000)
+
+// split assembled bytes into instructions
+func words(src []types.Byte) []types.Word {
+	dst := []types.Word{}
+	for i := 0; i+4 <= len(src); i += 4 {
+		dst = append(dst, binary.BigEndian.Uint32(src[i:i+4]))
+	}
+	return dst
+}
+
+func TestAssemble_R(t *testing.T) {
+	tests := []struct {
+		src  string
+		want cpu.InstR
+	}{
+		{"sll v1, v0, 3", cpu.InstR{Opcode: 0x00, Rs: 0, Rt: 2, Rd: 3, Sa: 3, Funct: 0x00}},
+		{"sllv $v1, $v0, $at", cpu.InstR{Opcode: 0x00, Rs: 1, Rt: 2, Rd: 3, Sa: 0, Funct: 0x04}},
+		{"add t0, t1, t2", cpu.InstR{Opcode: 0x00, Rs: 9, Rt: 10, Rd: 8, Sa: 0, Funct: 0x20}},
+		{"dsubu $8, $9, $10", cpu.InstR{Opcode: 0x00, Rs: 9, Rt: 10, Rd: 8, Sa: 0, Funct: 0x2F}},
+		{"mult a0, a1", cpu.InstR{Opcode: 0x00, Rs: 4, Rt: 5, Funct: 0x18}},
+		{"mflo v0", cpu.InstR{Opcode: 0x00, Rd: 2, Funct: 0x12}},
+		{"jr ra", cpu.InstR{Opcode: 0x00, Rs: 31, Funct: 0x08}},
+		{"jalr t9", cpu.InstR{Opcode: 0x00, Rs: 25, Rd: 31, Funct: 0x09}},
+		{"jalr s7, t9", cpu.InstR{Opcode: 0x00, Rs: 25, Rd: 23, Funct: 0x09}},
+		{"move fp, sp", cpu.InstR{Opcode: 0x00, Rs: 29, Rt: 0, Rd: 30, Funct: 0x25}},
+		{"nop", cpu.InstR{}},
+		{"mtc0 t0, Status", cpu.InstR{Opcode: 0x10, Rs: 0x04, Rt: 8, Rd: 12}},
+		{"mfc0 k0, $13", cpu.InstR{Opcode: 0x10, Rs: 0x00, Rt: 26, Rd: 13}},
+		{"eret", cpu.InstR{Opcode: 0x10, Rs: 0x10, Funct: 0x18}},
+		{"add.d f0, f2, f4", cpu.InstR{Opcode: 0x11, Rs: 0x11, Rt: 4, Rd: 2, Sa: 0, Funct: 0x00}},
+		{"cfc1 t0, fcr31", cpu.InstR{Opcode: 0x11, Rs: 0x02, Rt: 8, Rd: 31}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			got, err := Assemble(pc, tt.src)
+			assert.NoError(t, err)
+			assert.Equal(t, []types.Word{words(got)[0]}, words(got))
+			assert.Equal(t, tt.want, cpu.DecodeR(words(got)[0]))
+		})
+	}
+}
+
+func TestAssemble_I(t *testing.T) {
+	tests := []struct {
+		src  string
+		want cpu.InstI
+	}{
+		{"addiu sp, sp, -32", cpu.InstI{Opcode: 0x09, Rs: 29, Rt: 29, Immediate: 0xFFE0}},
+		{"ori t0, t0, 0xffff", cpu.InstI{Opcode: 0x0D, Rs: 8, Rt: 8, Immediate: 0xFFFF}},
+		{"lui t0, 0x8000", cpu.InstI{Opcode: 0x0F, Rs: 0, Rt: 8, Immediate: 0x8000}},
+		{"lw t0, 16(sp)", cpu.InstI{Opcode: 0x23, Rs: 29, Rt: 8, Immediate: 16}},
+		{"sw ra, -4(sp)", cpu.InstI{Opcode: 0x2B, Rs: 29, Rt: 31, Immediate: 0xFFFC}},
+		{"ld a0, (a1)", cpu.InstI{Opcode: 0x37, Rs: 5, Rt: 4, Immediate: 0}},
+		{"lwc1 f4, 8(a0)", cpu.InstI{Opcode: 0x31, Rs: 4, Rt: 4, Immediate: 8}},
+		{"cache 0x19, 0(a0)", cpu.InstI{Opcode: 0x2F, Rs: 4, Rt: 0x19, Immediate: 0}},
+		{"beq v0, zero, 0x80001014", cpu.InstI{Opcode: 0x04, Rs: 2, Rt: 0, Immediate: 4}},
+		{"bnel v0, zero, 0x80001000", cpu.InstI{Opcode: 0x15, Rs: 2, Rt: 0, Immediate: 0xFFFF}},
+		{"bgez a0, 0x80001000", cpu.InstI{Opcode: 0x01, Rs: 4, Rt: 0x01, Immediate: 0xFFFF}},
+		{"teqi a0, 16", cpu.InstI{Opcode: 0x01, Rs: 4, Rt: 0x0C, Immediate: 16}},
+		{"li a0, -1", cpu.InstI{Opcode: 0x09, Rs: 0, Rt: 4, Immediate: 0xFFFF}},
+		{"li a0, 0x8000", cpu.InstI{Opcode: 0x0D, Rs: 0, Rt: 4, Immediate: 0x8000}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			got, err := Assemble(pc, tt.src)
+			assert.NoError(t, err)
+			assert.Equal(t, 1, len(words(got)))
+			assert.Equal(t, tt.want, cpu.DecodeI(words(got)[0]))
+		})
+	}
+}
+
+func TestAssemble_J(t *testing.T) {
+	tests := []struct {
+		src  string
+		want cpu.InstJ
+	}{
+		{"j 0x80001000", cpu.InstJ{Opcode: 0x02, Address: 0x400}},
+		{"jal 0x80001020", cpu.InstJ{Opcode: 0x03, Address: 0x408}},
+		{"jal 0xFFFFFFFF80400000", cpu.InstJ{Opcode: 0x03, Address: 0x100000}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			got, err := Assemble(pc, tt.src)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, cpu.DecodeJ(words(got)[0]))
+		})
+	}
+}
+
+func TestAssemble_Program(t *testing.T) {
+	src := `
+	# copy 4 words from a0 to a1
+	start:
+		li    t0, 4
+		la    a2, data        ; 2 instructions
+	loop: lw  t1, 0(a0)
+		sw    t1, 0(a1)
+		addiu a0, a0, 4
+		addiu a1, a1, 4
+		addiu t0, t0, -1
+		bnez  t0, loop
+		nop                   // delay slot
+		j     start
+		nop
+	data:
+		.word 0xdeadbeef
+	`
+	got, err := Assemble(pc, src)
+	assert.NoError(t, err)
+	ws := words(got)
+	assert.Equal(t, 13, len(ws))
+
+	// li t0, 4
+	assert.Equal(t, cpu.InstI{Opcode: 0x09, Rs: 0, Rt: 8, Immediate: 4}, cpu.DecodeI(ws[0]))
+	// la a2, data (0x80001030)
+	assert.Equal(t, cpu.InstI{Opcode: 0x0F, Rs: 0, Rt: 6, Immediate: 0x8000}, cpu.DecodeI(ws[1]))
+	assert.Equal(t, cpu.InstI{Opcode: 0x0D, Rs: 6, Rt: 6, Immediate: 0x1030}, cpu.DecodeI(ws[2]))
+	// bnez t0, loop: 0x80001020 -> 0x8000100C
+	assert.Equal(t, cpu.InstI{Opcode: 0x05, Rs: 8, Rt: 0, Immediate: 0xFFFA}, cpu.DecodeI(ws[8]))
+	assert.Equal(t, disasm.BranchTarget(pc+8*4, ws[8]), pc+3*4)
+	// j start
+	assert.Equal(t, cpu.InstJ{Opcode: 0x02, Address: 0x400}, cpu.DecodeJ(ws[10]))
+	assert.Equal(t, types.Word(0), ws[11])
+	assert.Equal(t, types.Word(0xDEADBEEF), ws[12])
+}
+
+func TestAssemble_LI(t *testing.T) {
+	got, err := Assemble(0, "li v0, 0x12345678")
+	assert.NoError(t, err)
+	ws := words(got)
+	assert.Equal(t, 2, len(ws))
+	assert.Equal(t, cpu.InstI{Opcode: 0x0F, Rs: 0, Rt: 2, Immediate: 0x1234}, cpu.DecodeI(ws[0]))
+	assert.Equal(t, cpu.InstI{Opcode: 0x0D, Rs: 2, Rt: 2, Immediate: 0x5678}, cpu.DecodeI(ws[1]))
+}
+
+// assembly of the disassembler output gives the original instruction
+func TestAssemble_Disassemble(t *testing.T) {
+	opcodes := []types.Word{
+		0x000218C0, 0x00221814, 0x0008403C, 0x00221825, 0x03E00008, 0x0320F809, 0x0320B809,
+		0x0000000D, 0x0000000F, 0x27BDFFE0, 0x3108FFFF, 0x35080123, 0x3C088000, 0x8FA80010,
+		0xAFBFFFFC, 0xBC800000, 0x0C000408, 0x08000400, 0x10400004, 0x5440FFFF, 0x0481FFFF,
+		0x04110002, 0x048C0010, 0x401A6800, 0x40886000, 0x40A87000, 0x42000006, 0x42000018,
+		0x44886000, 0x4448F800, 0x45010002, 0x46041000, 0x46201004, 0x4624103C, 0x46801020,
+		0xC4840008,
+	}
+	for _, opcode := range opcodes {
+		text := disasm.Disassemble(pc, opcode)
+		t.Run(text, func(t *testing.T) {
+			got, err := Assemble(pc, text)
+			assert.NoError(t, err)
+			assert.Equal(t, []types.Word{opcode}, words(got))
+		})
+	}
+}
+
+func TestAssemble_Error(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+	}{
+		{"unknown instruction", "foo t0, t1"},
+		{"invalid register", "addu t0, t1, x9"},
+		{"number of operands", "addu t0, t1"},
+		{"immediate out of range", "addiu t0, t1, 0x8000"},
+		{"undefined label", "b nowhere"},
+		{"duplicate label", "a: nop\na: nop"},
+		{"branch out of range", "b 0x80100000"},
+		{"jump out of segment", "j 0x90000000"},
+		{"invalid offset(base)", "lw t0, 16"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Assemble(pc, tt.src)
+			assert.Error(t, err)
+		})
+	}
+	assert.Panics(t, func() { MustAssemble(pc, "foo") })
+}
+
+// the assembler knows every instruction of the opcode table
+func TestAssemble_OpcodeTable(t *testing.T) {
+	for _, inst := range isa.Instructions() {
+		src := disasm.Disassemble(pc, inst.Match)
+		got, err := Assemble(pc, src)
+		if assert.NoError(t, err, "%s: should be assembled", src) {
+			assert.Equal(t, inst.Match, binary.BigEndian.Uint32(got), "%s: should have same encoding", src)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package asm
+
+import (
+	"fmt"
+	"n64emu/pkg/core/mips/r4300i/isa"
+	"n64emu/pkg/core/mips/r4300i/reg"
+	"n64emu/pkg/types"
+	"strings"
+)
+
+// fields of an instruction, set by the operands
+type fields struct {
+	rs, rt, rd, sa types.Byte
+	imm            types.HalfWord
+	target         types.Word
+}
+
+// encode the instruction at addr
+func encode(inst *isa.Instruction, addr types.DoubleWord, operands []string, labels map[string]types.DoubleWord) (types.Word, error) {
+	ops := inst.Operands
+	n := len(operands)
+	switch {
+	case inst.Mnemonic == "jalr":
+		// rd is ra if omitted
+		if n != 1 && n != 2 {
+			return 0, fmt.Errorf("1 or 2 operands are required")
+		}
+		if n == 1 {
+			operands = []string{"ra", operands[0]}
+		}
+	case len(ops) == 1 && ops[0] == isa.OperandCode:
+		// code is 0 if omitted
+		if n > 1 {
+			return 0, fmt.Errorf("at most 1 operand is allowed")
+		}
+		if n == 0 {
+			operands = []string{"0"}
+		}
+	default:
+		if n != len(ops) {
+			return 0, fmt.Errorf("%d operands are required", len(ops))
+		}
+	}
+
+	// parse operands in order, stopping at the first error
+	var f fields
+	for i, o := range ops {
+		if err := f.parse(o, operands[i], addr, labels); err != nil {
+			return 0, err
+		}
+	}
+	return inst.Match |
+		types.Word(f.rs)<<21 |
+		types.Word(f.rt)<<16 |
+		types.Word(f.rd)<<11 |
+		types.Word(f.sa)<<6 |
+		types.Word(f.imm) |
+		f.target, nil
+}
+
+// parse an operand of the role into the fields
+func (f *fields) parse(o isa.Operand, s string, addr types.DoubleWord, labels map[string]types.DoubleWord) (err error) {
+	switch o {
+	case isa.OperandRs:
+		f.rs, err = parseGPR(s)
+	case isa.OperandRt:
+		f.rt, err = parseGPR(s)
+	case isa.OperandRd:
+		f.rd, err = parseGPR(s)
+	case isa.OperandSa:
+		f.sa, err = parseField(s, "", 31)
+	case isa.OperandImmediate, isa.OperandImmediateU:
+		var v int64
+		if v, err = parseInt(s); err != nil {
+			return err
+		}
+		signed := o == isa.OperandImmediate
+		if (signed && !isInt16(v)) || (!signed && !isUint16(v)) {
+			return fmt.Errorf("immediate %d is out of range", v)
+		}
+		f.imm = types.HalfWord(v)
+	case isa.OperandOffsetBase:
+		f.imm, f.rs, err = parseOffsetBase(s)
+	case isa.OperandBranch:
+		f.imm, err = branchOffset(addr, s, labels)
+	case isa.OperandJump:
+		f.target, err = jumpTarget(addr, s, labels)
+	case isa.OperandCode:
+		var v int64
+		if v, err = parseInt(s); err != nil {
+			return err
+		}
+		if v < 0 || v > 0xf_ffff {
+			return fmt.Errorf("code %d is out of range", v)
+		}
+		f.target = types.Word(v) << 6
+	case isa.OperandCacheOp, isa.OperandCop2Rt:
+		f.rt, err = parseField(s, "$", 31)
+	case isa.OperandCP0:
+		f.rd, err = parseCP0(s)
+	case isa.OperandFCR:
+		f.rd, err = parseField(s, "fcr", 31)
+	case isa.OperandFs:
+		f.rd, err = parseFPR(s)
+	case isa.OperandFt:
+		f.rt, err = parseFPR(s)
+	case isa.OperandFd:
+		f.sa, err = parseFPR(s)
+	default:
+		return fmt.Errorf("operand '%s' is not supported", s)
+	}
+	return err
+}
+
+// parse a numbered field e.g. sa, "$3" (CACHE, COP2), "fcr31"
+func parseField(s string, prefix string, max int64) (types.Byte, error) {
+	v, err := parseInt(strings.TrimPrefix(strings.ToLower(s), prefix))
+	if err != nil {
+		return 0, err
+	}
+	if v < 0 || v > max {
+		return 0, fmt.Errorf("'%s' is out of range", s)
+	}
+	return types.Byte(v), nil
+}
+
+// instruction index field of a jump at addr to the target
+func jumpTarget(addr types.DoubleWord, s string, labels map[string]types.DoubleWord) (types.Word, error) {
+	dst, err := parseTarget(s, labels)
+	if err != nil {
+		return 0, err
+	}
+	// The upper bits are taken from the address of the delay slot.
+	if ((addr + 4) &^ 0x0fff_ffff) != (dst &^ 0x0fff_ffff) {
+		return 0, fmt.Errorf("target 0x%x is out of the 256MB segment", dst)
+	}
+	if (dst & 0x3) != 0 {
+		return 0, fmt.Errorf("target 0x%x is not aligned", dst)
+	}
+	return types.Word(dst>>2) & 0x03ff_ffff, nil
+}
+
+// parse general purpose register e.g. "sp", "$sp", "$29"
+func parseGPR(s string) (types.Byte, error) {
+	name := strings.TrimPrefix(strings.ToLower(s), "$")
+	for i, n := range reg.GPRNames {
+		if n == name {
+			return types.Byte(i), nil
+		}
+	}
+	// alias of s8
+	if name == "fp" {
+		return 30, nil
+	}
+	if strings.HasPrefix(s, "$") {
+		if v, err := parseInt(name); err == nil && 0 <= v && v < 32 {
+			return types.Byte(v), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid register '%s'", s)
+}
+
+// parse floating-point register e.g. "f0", "$f0"
+func parseFPR(s string) (types.Byte, error) {
+	name := strings.TrimPrefix(strings.ToLower(s), "$")
+	if strings.HasPrefix(name, "f") {
+		if v, err := parseInt(name[1:]); err == nil && 0 <= v && v < 32 {
+			return types.Byte(v), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid floating-point register '%s'", s)
+}
+
+// parse CP0 register e.g. "Status", "$12"
+func parseCP0(s string) (types.Byte, error) {
+	for i, n := range reg.CP0Names {
+		if strings.EqualFold(n, s) {
+			return types.Byte(i), nil
+		}
+	}
+	if v, err := parseInt(strings.TrimPrefix(s, "$")); err == nil && 0 <= v && v < 32 {
+		return types.Byte(v), nil
+	}
+	return 0, fmt.Errorf("invalid CP0 register '%s'", s)
+}
+
+// parse "offset(base)"; offset may be omitted
+func parseOffsetBase(s string) (types.HalfWord, types.Byte, error) {
+	open := strings.Index(s, "(")
+	if open < 0 || !strings.HasSuffix(s, ")") {
+		return 0, 0, fmt.Errorf("'%s' is not offset(base)", s)
+	}
+	var offset int64
+	if text := strings.TrimSpace(s[:open]); text != "" {
+		v, err := parseInt(text)
+		if err != nil {
+			return 0, 0, err
+		}
+		if !isInt16(v) {
+			return 0, 0, fmt.Errorf("offset %d is out of range", v)
+		}
+		offset = v
+	}
+	base, err := parseGPR(strings.TrimSpace(s[open+1 : len(s)-1]))
+	if err != nil {
+		return 0, 0, err
+	}
+	return types.HalfWord(offset), base, nil
+}
+
+// offset field of a branch at addr to the target
+func branchOffset(addr types.DoubleWord, s string, labels map[string]types.DoubleWord) (types.HalfWord, error) {
+	dst, err := parseTarget(s, labels)
+	if err != nil {
+		return 0, err
+	}
+	if (dst & 0x3) != 0 {
+		return 0, fmt.Errorf("target 0x%x is not aligned", dst)
+	}
+	offset := int64(dst-(addr+4)) >> 2
+	if !isInt16(offset) {
+		return 0, fmt.Errorf("target 0x%x is out of range", dst)
+	}
+	return types.HalfWord(offset), nil
+}
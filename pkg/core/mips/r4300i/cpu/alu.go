@@ -141,6 +141,15 @@ const (
 	SDC1
 	SDC2
 	SD
+	MFC0
+	DMFC0
+	MTC0
+	DMTC0
+	TLBR
+	TLBWI
+	TLBWR
+	TLBP
+	ERET
 )
 
 // SLL rd, rt, sa
@@ -159,7 +168,7 @@ func srl(gpr *reg.GPR, inst *InstR) *aluOutput {
 	return &aluOutput{
 		op:     SRL,
 		dest:   inst.Rd,
-		result: types.DoubleWord((gpr.Read(inst.Rt)) >> inst.Sa),
+		result: types.DoubleWord(types.SWord(types.Word(gpr.Read(inst.Rt)) >> inst.Sa)),
 	}
 }
 
@@ -179,7 +188,7 @@ func sllv(gpr *reg.GPR, inst *InstR) *aluOutput {
 	return &aluOutput{
 		op:     SLLV,
 		dest:   inst.Rd,
-		result: types.DoubleWord(int32(gpr.Read(inst.Rt)) << (inst.Rs & 0x1F)),
+		result: types.DoubleWord(int32(gpr.Read(inst.Rt)) << (gpr.Read(inst.Rs) & 0x1F)),
 	}
 }
 
@@ -189,7 +198,7 @@ func srlv(gpr *reg.GPR, inst *InstR) *aluOutput {
 	return &aluOutput{
 		op:     SRLV,
 		dest:   inst.Rd,
-		result: types.DoubleWord(int32(gpr.Read(inst.Rt)) >> (inst.Rs & 0x1F)),
+		result: types.DoubleWord(types.SWord(types.Word(gpr.Read(inst.Rt)) >> (gpr.Read(inst.Rs) & 0x1F))),
 	}
 }
 
@@ -199,7 +208,7 @@ func srav(gpr *reg.GPR, inst *InstR) *aluOutput {
 	return &aluOutput{
 		op:     SRAV,
 		dest:   inst.Rd,
-		result: types.DoubleWord(int32(gpr.Read(inst.Rt)) >> (inst.Rs & 0x1F)),
+		result: types.DoubleWord(int32(gpr.Read(inst.Rt)) >> (gpr.Read(inst.Rs) & 0x1F)),
 	}
 }
 
@@ -266,7 +275,7 @@ func dsllv(gpr *reg.GPR, inst *InstR) *aluOutput {
 	return &aluOutput{
 		op:     DSLLV,
 		dest:   inst.Rd,
-		result: types.DoubleWord((gpr.Read(inst.Rt)) << (inst.Rs & 0x3F)),
+		result: types.DoubleWord((gpr.Read(inst.Rt)) << (gpr.Read(inst.Rs) & 0x3F)),
 	}
 }
 
@@ -276,7 +285,7 @@ func dsrlv(gpr *reg.GPR, inst *InstR) *aluOutput {
 	return &aluOutput{
 		op:     DSRLV,
 		dest:   inst.Rd,
-		result: types.DoubleWord((gpr.Read(inst.Rt)) >> (inst.Rs & 0x3F)),
+		result: types.DoubleWord((gpr.Read(inst.Rt)) >> (gpr.Read(inst.Rs) & 0x3F)),
 	}
 }
 
@@ -286,7 +295,7 @@ func dsrav(gpr *reg.GPR, inst *InstR) *aluOutput {
 	return &aluOutput{
 		op:     DSRAV,
 		dest:   inst.Rd,
-		result: types.DoubleWord(int64(gpr.Read(inst.Rt)) >> (inst.Rs & 0x3F)),
+		result: types.DoubleWord(int64(gpr.Read(inst.Rt)) >> (gpr.Read(inst.Rs) & 0x3F)),
 	}
 }
 
@@ -294,13 +303,13 @@ func dsrav(gpr *reg.GPR, inst *InstR) *aluOutput {
 // Multiplies the contents of register rs by the contents of register rt as a 32-bit signed integer.
 // Number of required cycles 5
 func mult(gpr *reg.GPR, hi *types.DoubleWord, lo *types.DoubleWord, inst *InstR) *aluOutput {
-	result := types.DoubleWord(int64(gpr.Read(inst.Rt)) * int64(gpr.Read(inst.Rs)))
+	result := types.DoubleWord(int64(types.SWord(gpr.Read(inst.Rt))) * int64(types.SWord(gpr.Read(inst.Rs))))
 	// TODO: We need to do some investigation about write back timing
 	// .     Should we add 20 cycle delay for 64bit mode?
 	//       ref. https://en.wikipedia.org/wiki/R4000#Integer_execution
 	// .     See also, https://github.com/ofuton-dev/n64emu/pull/18
-	*hi = result >> 32
-	*lo = result & 0xFFFFFFFF
+	*hi = signExtendWord(types.Word(result >> 32))
+	*lo = signExtendWord(types.Word(result))
 	return nil
 }
 
@@ -309,37 +318,60 @@ func mult(gpr *reg.GPR, hi *types.DoubleWord, lo *types.DoubleWord, inst *InstR)
 // register rt are multiplied, treating both operands as 32-bit unsigned values.
 // Number of required cycles 5
 func multu(gpr *reg.GPR, hi *types.DoubleWord, lo *types.DoubleWord, inst *InstR) *aluOutput {
-	result := types.DoubleWord(gpr.Read(inst.Rt) * gpr.Read(inst.Rs))
+	result := types.DoubleWord(types.Word(gpr.Read(inst.Rt))) * types.DoubleWord(types.Word(gpr.Read(inst.Rs)))
 	// TODO: We need to do some investigation about write back timing
 	// .     Should we add 20 cycle delay for 64bit mode?
 	//       ref. https://en.wikipedia.org/wiki/R4000#Integer_execution
 	// .     See also, https://github.com/ofuton-dev/n64emu/pull/18
-	*hi = result >> 32
-	*lo = result & 0xFFFFFFFF
+	*hi = signExtendWord(types.Word(result >> 32))
+	*lo = signExtendWord(types.Word(result))
 	return nil
 }
 
 // DIV rs, rt
 // Divides the contents of register rs by the contents of register rt. The operand
 // is treated as a 32-bit signed integer.
+// Stores the 32-bit quotient to special register LO, and the 32-bit remainder to
+// special register HI.
+// Division by zero does not trap. LO is -1 if rs is positive, 1 otherwise, and HI is rs.
 // Number of required cycles 37
 func div(gpr *reg.GPR, hi *types.DoubleWord, lo *types.DoubleWord, inst *InstR) *aluOutput {
-	rs := int32(gpr.Read(inst.Rs))
-	rt := int32(gpr.Read(inst.Rt))
-	*hi = types.DoubleWord(rs / rt)
-	*lo = types.DoubleWord(rs % rt)
+	rs := types.SWord(gpr.Read(inst.Rs))
+	rt := types.SWord(gpr.Read(inst.Rt))
+	switch {
+	case rt == 0:
+		*lo = 1
+		if rs >= 0 {
+			*lo = math.MaxUint64
+		}
+		*hi = types.DoubleWord(rs)
+	case rs == math.MinInt32 && rt == -1:
+		*lo = types.DoubleWord(rs)
+		*hi = 0
+	default:
+		*lo = types.DoubleWord(rs / rt)
+		*hi = types.DoubleWord(rs % rt)
+	}
 	return nil
 }
 
 // DIVU rs, rt
 // The contents of general purpose register rs are divided by the contents of general
 // purpose register rt, treating both operands as unsigned integers.
+// Stores the sign-extended 32-bit quotient to special register LO, and the remainder to
+// special register HI.
+// Division by zero does not trap. LO is all ones, and HI is rs.
 // Number of required cycles 37
 func divu(gpr *reg.GPR, hi *types.DoubleWord, lo *types.DoubleWord, inst *InstR) *aluOutput {
 	rs := types.Word(gpr.Read(inst.Rs))
 	rt := types.Word(gpr.Read(inst.Rt))
-	*hi = types.DoubleWord(rs / rt)
-	*lo = types.DoubleWord(rs % rt)
+	if rt == 0 {
+		*lo = math.MaxUint64
+		*hi = signExtendWord(rs)
+		return nil
+	}
+	*lo = signExtendWord(rs / rt)
+	*hi = signExtendWord(rs % rt)
 	return nil
 }
 
@@ -350,8 +382,7 @@ func dmult(gpr *reg.GPR, hi *types.DoubleWord, lo *types.DoubleWord, inst *InstR
 	rt := big.NewInt(types.SDoubleWord(gpr.Read(inst.Rt)))
 	rs := big.NewInt(types.SDoubleWord(gpr.Read(inst.Rs)))
 	result := new(big.Int).Mul(rt, rs)
-	*hi = result.Rsh(result, 64).Uint64()
-	*lo = result.Uint64()
+	*hi, *lo = split128(result)
 	return nil
 }
 
@@ -362,8 +393,7 @@ func dmultu(gpr *reg.GPR, hi *types.DoubleWord, lo *types.DoubleWord, inst *Inst
 	rt := new(big.Int).SetUint64(gpr.Read(inst.Rt))
 	rs := new(big.Int).SetUint64(gpr.Read(inst.Rs))
 	result := new(big.Int).Mul(rt, rs)
-	*hi = result.Rsh(result, 64).Uint64()
-	*lo = result.Uint64()
+	*hi, *lo = split128(result)
 	return nil
 }
 
@@ -372,9 +402,19 @@ func dmultu(gpr *reg.GPR, hi *types.DoubleWord, lo *types.DoubleWord, inst *Inst
 // The operand is treated as a signed integer.
 // Stores the 64-bit quotient to special register LO, and the 64-bit remainder to
 // special register HI.
+// Division by zero does not trap, as DIV.
 func ddiv(gpr *reg.GPR, hi *types.DoubleWord, lo *types.DoubleWord, inst *InstR) *aluOutput {
 	rt := types.SDoubleWord(gpr.Read(inst.Rt))
 	rs := types.SDoubleWord(gpr.Read(inst.Rs))
+	if rt == 0 {
+		*lo = 1
+		if rs >= 0 {
+			*lo = math.MaxUint64
+		}
+		*hi = types.DoubleWord(rs)
+		return nil
+	}
+	// MinInt64 / -1 is MinInt64, and the remainder is 0 in Go, as the hardware
 	*lo = types.DoubleWord(rs / rt)
 	*hi = types.DoubleWord(rs % rt)
 	return nil
@@ -385,9 +425,15 @@ func ddiv(gpr *reg.GPR, hi *types.DoubleWord, lo *types.DoubleWord, inst *InstR)
 // The operand is treated as an unsigned integer.
 // Stores the 64-bit quotient to special register LO, and the 64-bit remainder to
 // special register HI.
+// Division by zero does not trap, as DIVU.
 func ddivu(gpr *reg.GPR, hi *types.DoubleWord, lo *types.DoubleWord, inst *InstR) *aluOutput {
 	rt := gpr.Read(inst.Rt)
 	rs := gpr.Read(inst.Rs)
+	if rt == 0 {
+		*lo = math.MaxUint64
+		*hi = rs
+		return nil
+	}
 	*lo = rs / rt
 	*hi = rs % rt
 	return nil
@@ -397,6 +443,7 @@ func ddivu(gpr *reg.GPR, hi *types.DoubleWord, lo *types.DoubleWord, inst *InstR
 // The contents of general purpose register rs and the contents of general purpose
 // register rt are added to store the result in general purpose register rd. In 64-bit
 // mode, the operands must be sign-extended, 32-bit values.
+// Returns nil if an integer overflow occurs, to raise the exception.
 func add(gpr *reg.GPR, inst *InstR) *aluOutput {
 	rt := types.SWord(gpr.Read(inst.Rt))
 	rs := types.SWord(gpr.Read(inst.Rs))
@@ -428,13 +475,13 @@ func addu(gpr *reg.GPR, inst *InstR) *aluOutput {
 }
 
 // SUB rd, rs, rt
-// Subtracts the contents of register rs from register rt, and stores (sign-extends
+// Subtracts the contents of register rt from register rs, and stores (sign-extends
 // in the 64-bit mode) the result to register rd.
-// Generates an exception if an integer overflow occurs.
+// Returns nil if an integer overflow occurs, to raise the exception.
 func sub(gpr *reg.GPR, inst *InstR) *aluOutput {
 	rt := types.SWord(gpr.Read(inst.Rt))
 	rs := types.SWord(gpr.Read(inst.Rs))
-	if isI32AddOverflow(rs, -rt) {
+	if isI32SubOverflow(rs, rt) {
 		return nil
 	}
 	result := types.SDoubleWord(rs - rt)
@@ -509,8 +556,8 @@ func nor(gpr *reg.GPR, inst *InstR) *aluOutput {
 // otherwise, stores 0 to rd.
 func slt(gpr *reg.GPR, inst *InstR) *aluOutput {
 	var result types.DoubleWord
-	rt := types.SWord(gpr.Read(inst.Rt))
-	rs := types.SWord(gpr.Read(inst.Rs))
+	rt := types.SDoubleWord(gpr.Read(inst.Rt))
+	rs := types.SDoubleWord(gpr.Read(inst.Rs))
 	if rs < rt {
 		result = 1
 	}
@@ -527,8 +574,8 @@ func slt(gpr *reg.GPR, inst *InstR) *aluOutput {
 // otherwise, stores 0 to rd.
 func sltu(gpr *reg.GPR, inst *InstR) *aluOutput {
 	var result types.DoubleWord
-	rt := types.Word(gpr.Read(inst.Rt))
-	rs := types.Word(gpr.Read(inst.Rs))
+	rt := gpr.Read(inst.Rt)
+	rs := gpr.Read(inst.Rs)
 	if rs < rt {
 		result = 1
 	}
@@ -576,10 +623,28 @@ func lw(gpr *reg.GPR, inst *InstI) *aluOutput {
 }
 
 func isI32AddOverflow(l, r types.SWord) bool {
-	if r > 0 && l > math.MaxInt32-r {
-		return true
-	} else if l < math.MinInt32-r {
-		return true
+	if r > 0 {
+		return l > math.MaxInt32-r
 	}
-	return false
+	return l < math.MinInt32-r
+}
+
+func isI32SubOverflow(l, r types.SWord) bool {
+	if r < 0 {
+		return l > math.MaxInt32+r
+	}
+	return l < math.MinInt32+r
+}
+
+// split 128-bit product into high and low 64 bits, in two's complement
+func split128(v *big.Int) (types.DoubleWord, types.DoubleWord) {
+	mask64 := new(big.Int).SetUint64(math.MaxUint64)
+	lo := new(big.Int).And(v, mask64).Uint64()
+	hi := new(big.Int).And(new(big.Int).Rsh(v, 64), mask64).Uint64()
+	return hi, lo
+}
+
+// sign-extend 32-bit result to 64 bits
+func signExtendWord(v types.Word) types.DoubleWord {
+	return types.DoubleWord(types.SWord(v))
 }
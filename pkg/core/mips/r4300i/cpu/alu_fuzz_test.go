@@ -0,0 +1,230 @@
+//go:build go1.18
+// +build go1.18
+
+package cpu
+
+import (
+	"fmt"
+	"math/bits"
+	"n64emu/pkg/core/mips/r4300i/reg"
+	"n64emu/pkg/types"
+	"strings"
+	"testing"
+)
+
+// refState is the state observed by the reference model
+type refState struct {
+	gpr      [reg.NumOfRegsInGpr]uint64
+	hi, lo   uint64
+	overflow bool
+}
+
+func sext32(v uint32) uint64 { return uint64(int64(int32(v))) }
+
+// refExecute is a straightforward model of MIPS III SPECIAL instructions in 64-bit mode, independent of alu.go.
+// 32-bit operations use the low 32 bits of the operands, the results of not sign-extended operands are undefined.
+// Returns false if the instruction is not modeled.
+func refExecute(s *refState, opcode uint32) bool {
+	rs, rt, rd, sa := (opcode>>21)&0x1f, (opcode>>16)&0x1f, (opcode>>11)&0x1f, (opcode>>6)&0x1f
+	a, b := s.gpr[rs], s.gpr[rt]
+	a32, b32 := uint32(a), uint32(b)
+	write := func(v uint64) {
+		if rd != 0 {
+			s.gpr[rd] = v
+		}
+	}
+	switch opcode & 0x3f {
+	case 0x00: // sll
+		write(sext32(b32 << sa))
+	case 0x02: // srl
+		write(sext32(b32 >> sa))
+	case 0x03: // sra
+		write(sext32(uint32(int32(b32) >> sa)))
+	case 0x04: // sllv
+		write(sext32(b32 << (a & 31)))
+	case 0x06: // srlv
+		write(sext32(b32 >> (a & 31)))
+	case 0x07: // srav
+		write(sext32(uint32(int32(b32) >> (a & 31))))
+	case 0x10: // mfhi
+		write(s.hi)
+	case 0x11: // mthi
+		s.hi = a
+	case 0x12: // mflo
+		write(s.lo)
+	case 0x13: // mtlo
+		s.lo = a
+	case 0x14: // dsllv
+		write(b << (a & 63))
+	case 0x16: // dsrlv
+		write(b >> (a & 63))
+	case 0x17: // dsrav
+		write(uint64(int64(b) >> (a & 63)))
+	case 0x18: // mult
+		p := uint64(int64(int32(a32)) * int64(int32(b32)))
+		s.hi, s.lo = sext32(uint32(p>>32)), sext32(uint32(p))
+	case 0x19: // multu
+		p := uint64(a32) * uint64(b32)
+		s.hi, s.lo = sext32(uint32(p>>32)), sext32(uint32(p))
+	case 0x1A: // div
+		n, d := int32(a32), int32(b32)
+		switch {
+		case d == 0 && n >= 0:
+			s.lo, s.hi = ^uint64(0), sext32(a32)
+		case d == 0:
+			s.lo, s.hi = 1, sext32(a32)
+		case n == -1<<31 && d == -1:
+			s.lo, s.hi = sext32(a32), 0
+		default:
+			s.lo, s.hi = sext32(uint32(n/d)), sext32(uint32(n%d))
+		}
+	case 0x1B: // divu
+		if b32 == 0 {
+			s.lo, s.hi = ^uint64(0), sext32(a32)
+		} else {
+			s.lo, s.hi = sext32(a32/b32), sext32(a32%b32)
+		}
+	case 0x1C: // dmult
+		hi, lo := bits.Mul64(a, b)
+		// signed product from the unsigned one
+		if int64(a) < 0 {
+			hi -= b
+		}
+		if int64(b) < 0 {
+			hi -= a
+		}
+		s.hi, s.lo = hi, lo
+	case 0x1D: // dmultu
+		s.hi, s.lo = bits.Mul64(a, b)
+	case 0x1E: // ddiv
+		n, d := int64(a), int64(b)
+		switch {
+		case d == 0 && n >= 0:
+			s.lo, s.hi = ^uint64(0), a
+		case d == 0:
+			s.lo, s.hi = 1, a
+		case n == -1<<63 && d == -1:
+			s.lo, s.hi = a, 0
+		default:
+			s.lo, s.hi = uint64(n/d), uint64(n%d)
+		}
+	case 0x1F: // ddivu
+		if b == 0 {
+			s.lo, s.hi = ^uint64(0), a
+		} else {
+			s.lo, s.hi = a/b, a%b
+		}
+	case 0x20, 0x22: // add, sub
+		sum := int64(int32(a32)) + int64(int32(b32))
+		if opcode&0x3f == 0x22 {
+			sum = int64(int32(a32)) - int64(int32(b32))
+		}
+		if sum != int64(int32(sum)) {
+			s.overflow = true
+			return true
+		}
+		write(uint64(sum))
+	case 0x21: // addu
+		write(sext32(a32 + b32))
+	case 0x23: // subu
+		write(sext32(a32 - b32))
+	case 0x24: // and
+		write(a & b)
+	case 0x25: // or
+		write(a | b)
+	case 0x26: // xor
+		write(a ^ b)
+	case 0x27: // nor
+		write(^(a | b))
+	case 0x2A: // slt
+		if int64(a) < int64(b) {
+			write(1)
+		} else {
+			write(0)
+		}
+	case 0x2B: // sltu
+		if a < b {
+			write(1)
+		} else {
+			write(0)
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// run an instruction in EX stage, and write back the result
+func executeALU(c *CPU, opcode types.Word) refState {
+	c.pipeline.executionPC = kseg0
+	output := c.execute(opcode)
+	s := refState{hi: c.hi, lo: c.lo}
+	if c.pipeline.exception {
+		s.overflow = ExcCode((c.cp0.Read(reg.CP0Cause)&causeExcCodeMask)>>causeExcShift) == ExcOv
+		output = nil
+	}
+	if output != nil {
+		c.gpr.Write(output.dest, output.result)
+	}
+	for i := range s.gpr {
+		s.gpr[i] = c.gpr.Read(types.Byte(i))
+	}
+	return s
+}
+
+// reproducer of the divergence, as an entry of the seed corpus
+func reproducer(opcode uint32, rs, rt, hi, lo uint64) string {
+	return strings.Join([]string{
+		"go test fuzz v1",
+		fmt.Sprintf("uint32(%#x)", opcode),
+		fmt.Sprintf("uint64(%#x)", rs),
+		fmt.Sprintf("uint64(%#x)", rt),
+		fmt.Sprintf("uint64(%#x)", hi),
+		fmt.Sprintf("uint64(%#x)", lo),
+	}, "\n")
+}
+
+// FuzzALU executes a SPECIAL instruction with random operands, and compares the result with the reference model.
+// A failing input is a single instruction with the values of rs, rt, HI and LO.
+// Run `make fuzz`, and add the reproducer to testdata/fuzz/FuzzALU after fixing the divergence.
+func FuzzALU(f *testing.F) {
+	f.Add(uint32(0x00221821), uint64(1), uint64(2), uint64(0), uint64(0))                                   // addu v1, at, v0
+	f.Add(uint32(0x0022001A), uint64(0xFFFFFFFF80000000), uint64(0xFFFFFFFFFFFFFFFF), uint64(0), uint64(0)) // div at, v0
+	f.Add(uint32(0x00221820), uint64(0x7FFFFFFF), uint64(1), uint64(0), uint64(0))                          // add v1, at, v0
+	f.Add(uint32(0x0022001C), uint64(0x8000000000000000), uint64(3), uint64(0), uint64(0))                  // dmult at, v0
+
+	f.Fuzz(func(t *testing.T, opcode uint32, rs, rt, hi, lo uint64) {
+		// SPECIAL
+		opcode &= 0x03FF_FFFF
+		inst, ok := LookupInstruction(opcode)
+		if !ok || !inst.Implemented() {
+			return
+		}
+		r := DecodeR(opcode)
+
+		want := refState{hi: hi, lo: lo}
+		want.gpr[r.Rs] = rs
+		want.gpr[r.Rt] = rt
+		want.gpr[0] = 0
+		if !refExecute(&want, opcode) {
+			return
+		}
+
+		c := NewCPU(nil)
+		c.gpr.Write(r.Rs, rs)
+		c.gpr.Write(r.Rt, rt)
+		c.hi, c.lo = hi, lo
+		got := executeALU(c, opcode)
+
+		if got != want {
+			t.Errorf("%s diverged: rs=%016x rt=%016x hi=%016x lo=%016x\n"+
+				"want: rd=%016x hi=%016x lo=%016x overflow=%v\n"+
+				"got : rd=%016x hi=%016x lo=%016x overflow=%v\n"+
+				"reproducer:\n%s",
+				inst.Mnemonic, rs, rt, hi, lo,
+				want.gpr[r.Rd], want.hi, want.lo, want.overflow,
+				got.gpr[r.Rd], got.hi, got.lo, got.overflow,
+				reproducer(opcode, rs, rt, hi, lo))
+		}
+	})
+}
@@ -0,0 +1,105 @@
+package cpu
+
+import (
+	"n64emu/pkg/core/mips/r4300i/reg"
+	"n64emu/pkg/types"
+)
+
+const (
+	// Index register, P(probe failure) bit
+	indexProbeFailure = 0x8000_0000
+	// Index register, index field
+	indexMask = 0x3f
+)
+
+// MFC0 rt, rd
+// The contents of coprocessor register rd of the CP0 are loaded into general purpose register rt.
+func mfc0(cp0 *reg.CP0, inst *InstR) *aluOutput {
+	return &aluOutput{
+		op:     MFC0,
+		dest:   inst.Rt,
+		result: types.DoubleWord(types.SWord(cp0.Read(int(inst.Rd)))),
+	}
+}
+
+// DMFC0 rt, rd
+// The contents of coprocessor register rd of the CP0 are loaded into general purpose register rt.
+// All 64 bits of the coprocessor register are transferred.
+func dmfc0(cp0 *reg.CP0, inst *InstR) *aluOutput {
+	return &aluOutput{
+		op:     DMFC0,
+		dest:   inst.Rt,
+		result: cp0.ReadDoubleWord(int(inst.Rd)),
+	}
+}
+
+// MTC0 rt, rd
+// The contents of general purpose register rt are loaded into coprocessor register rd of the CP0.
+func mtc0(gpr *reg.GPR, cp0 *reg.CP0, inst *InstR) *aluOutput {
+	// TODO: We need to do some investigation about write back timing
+	cp0.Write(int(inst.Rd), types.Word(gpr.Read(inst.Rt)))
+	return nil
+}
+
+// DMTC0 rt, rd
+// The contents of general purpose register rt are loaded into coprocessor register rd of the CP0.
+// All 64 bits of the general purpose register are transferred.
+func dmtc0(gpr *reg.GPR, cp0 *reg.CP0, inst *InstR) *aluOutput {
+	// TODO: We need to do some investigation about write back timing
+	cp0.WriteDoubleWord(int(inst.Rd), gpr.Read(inst.Rt))
+	return nil
+}
+
+// make TLB entry from PageMask, EntryHi, EntryLo0 and EntryLo1 registers
+func tlbEntryFromCP0(cp0 *reg.CP0) reg.TLBEntry {
+	return reg.TLBEntry{
+		PageMask: cp0.ReadDoubleWord(reg.CP0PageMask),
+		EntryHi:  cp0.ReadDoubleWord(reg.CP0EntryHi),
+		EntryLo0: cp0.ReadDoubleWord(reg.CP0EntryLo0),
+		EntryLo1: cp0.ReadDoubleWord(reg.CP0EntryLo1),
+	}
+}
+
+// TLBR
+// The PageMask, EntryHi, EntryLo0 and EntryLo1 registers are loaded with the contents of
+// the TLB entry pointed at by the contents of the Index register.
+func tlbr(cp0 *reg.CP0, tlb *reg.TLB) *aluOutput {
+	index := int(cp0.Read(reg.CP0Index) & (reg.NumOfTLBEntries - 1))
+	entry := tlb.Read(index)
+	cp0.WriteDoubleWord(reg.CP0PageMask, entry.PageMask)
+	cp0.WriteDoubleWord(reg.CP0EntryHi, entry.EntryHi)
+	cp0.WriteDoubleWord(reg.CP0EntryLo0, entry.EntryLo0)
+	cp0.WriteDoubleWord(reg.CP0EntryLo1, entry.EntryLo1)
+	return nil
+}
+
+// TLBWI
+// The TLB entry pointed at by the contents of the Index register is loaded with the contents of
+// the PageMask, EntryHi, EntryLo0 and EntryLo1 registers.
+func tlbwi(cp0 *reg.CP0, tlb *reg.TLB) *aluOutput {
+	index := int(cp0.Read(reg.CP0Index) & (reg.NumOfTLBEntries - 1))
+	tlb.Write(index, tlbEntryFromCP0(cp0))
+	return nil
+}
+
+// TLBWR
+// The TLB entry pointed at by the contents of the Random register is loaded with the contents of
+// the PageMask, EntryHi, EntryLo0 and EntryLo1 registers.
+// Entries below the Wired register are never selected by Random, so they are never replaced.
+func tlbwr(cp0 *reg.CP0, tlb *reg.TLB) *aluOutput {
+	index := int(cp0.Read(reg.CP0Random) & (reg.NumOfTLBEntries - 1))
+	tlb.Write(index, tlbEntryFromCP0(cp0))
+	return nil
+}
+
+// TLBP
+// Searches the TLB for an entry that matches the EntryHi register, and loads its address to the Index register.
+// If no TLB entry matches, the high-order bit of the Index register is set.
+func tlbp(cp0 *reg.CP0, tlb *reg.TLB) *aluOutput {
+	if index, ok := tlb.Probe(cp0.ReadDoubleWord(reg.CP0EntryHi)); ok {
+		cp0.Write(reg.CP0Index, types.Word(index)&indexMask)
+	} else {
+		cp0.Write(reg.CP0Index, indexProbeFailure)
+	}
+	return nil
+}
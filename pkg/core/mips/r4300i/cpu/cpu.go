@@ -5,12 +5,15 @@ import (
 	"n64emu/pkg/core/mips/r4300i/reg"
 	"n64emu/pkg/types"
 	"n64emu/pkg/util"
+	"strings"
 )
 
 // CPU is cpu registers and bus accessor
 type CPU struct {
 	gpr      reg.GPR          // 32 64-bit general purpose registers, GPRs
 	fpr      reg.FPR          // 32 64-bit floating-point operation registers, FPRs
+	cp0      reg.CP0          // System Control Coprocessor(CP0) registers
+	tlb      reg.TLB          // Translation Lookaside Buffer
 	pc       types.DoubleWord // Program Counter, the PC register
 	hi       types.DoubleWord // HI register, containing the integer multiply and divide highorder doubleword result
 	lo       types.DoubleWord // LO register, containing the integer multiply and divide loworder doubleword result
@@ -19,6 +22,14 @@ type CPU struct {
 	fcr31    types.Word       // 32-bit floating-point Control/Status register, FCR31
 	bus      bus.Bus          // Bus accessor
 	pipeline *Pipeline
+
+	watchpoints       []hostWatchpoint // host-side watchpoints, in order of the ID
+	nextWatchpointID  int
+	watchpointHandler func(hit WatchHit)
+
+	idle idleDetector
+
+	busError bool // set by BusError during a bus access
 }
 
 // NewCPU is CPU constructor
@@ -27,6 +38,8 @@ func NewCPU(bus bus.Bus) *CPU {
 	cpu := &CPU{
 		gpr:      reg.NewGPR(),
 		fpr:      reg.NewFGR(),
+		cp0:      reg.NewCP0(),
+		tlb:      reg.TLB{},
 		pc:       0,
 		hi:       0,
 		lo:       0,
@@ -39,21 +52,62 @@ func NewCPU(bus bus.Bus) *CPU {
 	return cpu
 }
 
+// SetBus replaces the bus, e.g. with a wrapper of the bus to watch accesses.
+func (c *CPU) SetBus(b bus.Bus) {
+	c.bus = b
+	c.pipeline.bus = b
+}
+
 func (c *CPU) endian() types.Endianness {
 	// TODO: For now, return only `BIG`.
 	return types.Big
 }
 
 func (c *CPU) fetch(addr types.DoubleWord) types.Word {
-	data := c.bus.ReadWord(c.endian(), types.Word(addr))
+	paddr, ok := c.instructionAddress(addr)
+	if !ok {
+		return 0
+	}
+	c.busError = false
+	data := bus.FetchWord(c.bus, c.endian(), paddr)
+	if c.busError {
+		c.busError = false
+		c.raiseException(ExcIBE, addr, c.pipeline.branchTaken)
+		return 0
+	}
 	return data
 }
 
+// BusError reports that the bus access in progress failed, e.g. to an unmapped address.
+// The CPU raises Bus Error exception for the instruction fetch or the data access.
+func (c *CPU) BusError() {
+	c.busError = true
+}
+
+// raise Bus Error exception if the data access of the instruction in DC stage failed.
+// Returns true if the exception is raised and the access must be canceled.
+func (c *CPU) dataBusError() bool {
+	if !c.busError {
+		return false
+	}
+	c.busError = false
+	c.raiseException(ExcDBE, c.pipeline.dataCachePC, c.pipeline.dataCacheInDelaySlot)
+	return true
+}
+
 // Step runs 1 pclk cycle CPU
 func (c *CPU) Step() {
 	// TODO: We need to consider about `pipline`.
 	//       Implement later here.
-	c.pipeline.step(c.endian(), &c.pc, &c.gpr, c.execute, c.fetch)
+	execute := c.execute
+	if c.pipeline.traceHook != nil {
+		execute = c.executeTraced
+	}
+	// the data access in DC stage reports bus errors from here
+	c.busError = false
+	c.pipeline.step(c.endian(), &c.pc, &c.gpr, execute, c.fetch, c.dataAddress, c.dataBusError)
+	c.cp0.DecrementRandom()
+	c.observeIdle()
 }
 
 // RunUntil runs CPU until specified cycles
@@ -65,263 +119,44 @@ func (c *CPU) RunUntil(cycle types.Word) {
 }
 
 func (c *CPU) trapIntegerOverflow() {
-	util.TODO("Please implement integer overflow excrption.")
+	c.raiseException(ExcOv, c.pipeline.executionPC, c.pipeline.executionInDelaySlot)
 }
 
-func (c *CPU) execute(opcode types.Word) *aluOutput {
-	op := GetOp(opcode)
-
-	instI := DecodeI(opcode)
+// checkPrivilege checks whether the instruction can be executed in current mode.
+// If not, raises the exception and returns false.
+func (c *CPU) checkPrivilege(inst *Instruction, opcode types.Word) bool {
+	pc, inDelaySlot := c.pipeline.executionPC, c.pipeline.executionInDelaySlot
 
-	switch op {
-	// R type instructions
-	// SPECIAL
-	case 0x00:
-		instR := DecodeR(opcode)
-		switch instR.Funct {
-		case 0x00: // SLL
-			return sll(&c.gpr, &instR)
-		case 0x02: // SRL
-			return srl(&c.gpr, &instR)
-		case 0x03: // SRA
-			return sra(&c.gpr, &instR)
-		case 0x04: // SLLV
-			return sllv(&c.gpr, &instR)
-		case 0x06: // SRLV
-			return srlv(&c.gpr, &instR)
-		case 0x07: // SRAV
-			return srav(&c.gpr, &instR)
-		case 0x08: // JR
-			return jr(&c.pc, &c.gpr, &instR)
-		case 0x09: // JALR
-			return jalr(&c.pc, &c.gpr, &instR)
-		case 0x0D:
-			util.TODO("BREAK")
-		case 0x0F:
-			util.TODO("SYNC")
-		case 0x10: /// MFHI
-			return mfhi(c.hi, &instR)
-		case 0x11: // MTHI
-			return mthi(&c.gpr, &c.hi, &instR)
-		case 0x12: // MFLO
-			return mflo(c.lo, &instR)
-		case 0x13: // MTLO
-			return mtlo(&c.gpr, &c.lo, &instR)
-		case 0x14: // DSLLV
-			return dsllv(&c.gpr, &instR)
-		case 0x16: // DSRLV
-			return dsrlv(&c.gpr, &instR)
-		case 0x17: // DSRAV
-			return dsrav(&c.gpr, &instR)
-		case 0x18: // MULT
-			return mult(&c.gpr, &c.hi, &c.lo, &instR)
-		case 0x19: // MULTU
-			return multu(&c.gpr, &c.hi, &c.lo, &instR)
-		case 0x1A: // DIV
-			return div(&c.gpr, &c.hi, &c.lo, &instR)
-		case 0x1B: // DIVU
-			return divu(&c.gpr, &c.hi, &c.lo, &instR)
-		case 0x1C: // DMULT
-			return dmult(&c.gpr, &c.hi, &c.lo, &instR)
-		case 0x1D: // DMULTU
-			return dmultu(&c.gpr, &c.hi, &c.lo, &instR)
-		case 0x1E: // DDIV
-			return ddiv(&c.gpr, &c.hi, &c.lo, &instR)
-		case 0x1F: // DDIVU
-			return ddivu(&c.gpr, &c.hi, &c.lo, &instR)
-		case 0x20: // ADD
-			output := add(&c.gpr, &instR)
-			if output != nil {
-				c.trapIntegerOverflow()
-			}
-			return output
-		case 0x21: // ADDU
-			return addu(&c.gpr, &instR)
-		case 0x22:
-			output := sub(&c.gpr, &instR)
-			if output != nil {
-				c.trapIntegerOverflow()
-			}
-			return output
-		case 0x23: // SUBU
-			return subu(&c.gpr, &instR)
-		case 0x24: // AND
-			return and(&c.gpr, &instR)
-		case 0x25: // OR
-			return or(&c.gpr, &instR)
-		case 0x26: // XOR
-			return xor(&c.gpr, &instR)
-		case 0x27: // NOR
-			return nor(&c.gpr, &instR)
-		case 0x2A: // SLT
-			return slt(&c.gpr, &instR)
-		case 0x2B: // SLTU
-			return sltu(&c.gpr, &instR)
-		case 0x2C:
-			util.TODO("DADD")
-		case 0x2D:
-			util.TODO("DADDU")
-		case 0x2E:
-			util.TODO("DSUB")
-		case 0x2F:
-			util.TODO("DSUBU")
-		case 0x34:
-			util.TODO("TEQ")
-		case 0x38:
-			util.TODO("DSLL")
-		case 0x3A:
-			util.TODO("DSRL")
-		case 0x3B:
-			util.TODO("DSRA")
-		case 0x3C:
-			util.TODO("DSLL32")
-		case 0x3E:
-			util.TODO("DSRL32")
-		case 0x3F:
-			util.TODO("DSRA32")
+	// CP0 instructions and CACHE
+	switch GetOp(opcode) {
+	case 0x10, 0x2F:
+		if !c.isCop0Usable() {
+			c.raiseExceptionWithOffset(ExcCpU, 0, pc, inDelaySlot, exceptionVectorGeneral)
+			return false
 		}
-		// TODO: map other instructions
-	case 0x01:
-		switch instI.Rt {
-		case 0x00:
-			util.TODO("BLTZ")
-		case 0x01:
-			util.TODO("BGEZ")
-		case 0x02:
-			util.TODO("BLTZL")
-		case 0x03:
-			util.TODO("BGEZL")
-		case 0x08:
-			util.TODO("TGEI")
-		case 0x09:
-			util.TODO("TGEIU")
-		case 0x0A:
-			util.TODO("TLTI")
-		case 0x0B:
-			util.TODO("TLTIU")
-		case 0x0C:
-			util.TODO("TEQI")
-		case 0x0E:
-			util.TODO("TNEI")
-		case 0x10:
-			util.TODO("BLTZAL")
-		case 0x11:
-			util.TODO("BGEZAL")
-		case 0x12:
-			util.TODO("BLTZALL")
-		case 0x13:
-			util.TODO("BGEZALL")
-		}
-	case 0x02:
-		util.TODO("J")
-	case 0x03:
-		util.TODO("JAL")
-	case 0x04:
-		util.TODO("BEQ")
-	case 0x05:
-		util.TODO("BNE")
-	case 0x06:
-		util.TODO("BLEZ")
-	case 0x07:
-		util.TODO("BGTZ")
-	case 0x08:
-		util.TODO("ADDI")
-	case 0x09:
-		util.TODO("ADDIU")
-	case 0x0A:
-		util.TODO("SLTI")
-	case 0x0B:
-		util.TODO("SLTIU")
-	case 0x0C:
-		util.TODO("ANDI")
-	case 0x0D:
-		util.TODO("ORI")
-	case 0x0E:
-		util.TODO("XORI")
-	case 0x0F:
-		util.TODO("LUI")
-	case 0x10:
-		util.TODO("COP0")
-	case 0x11:
-		util.TODO("COP1")
-	case 0x12:
-		util.TODO("COP2")
-	case 0x14:
-		util.TODO("BEQL")
-	case 0x15:
-		util.TODO("BNEL")
-	case 0x16:
-		util.TODO("BLEZL")
-	case 0x17:
-		util.TODO("BGTZL")
-	case 0x18:
-		util.TODO("DADDI")
-	case 0x19:
-		util.TODO("DADDIU")
-	case 0x1A:
-		util.TODO("LDL")
-	case 0x1B:
-		util.TODO("LDR")
-	case 0x20: // LB
-		return lb(&c.gpr, &instI)
-	case 0x21: // LH
-		return lh(&c.gpr, &instI)
-	case 0x22:
-		util.TODO("LWL")
-	case 0x23: // LW
-		return lw(&c.gpr, &instI)
-	case 0x24:
-		util.TODO("LBU")
-	case 0x25:
-		util.TODO("LHU")
-	case 0x26:
-		util.TODO("LWR")
-	case 0x27:
-		util.TODO("LWU")
-	case 0x28:
-		util.TODO("SB")
-	case 0x29:
-		util.TODO("SH")
-	case 0x2A:
-		util.TODO("SWL")
-	case 0x2B:
-		util.TODO("SW")
-	case 0x2C:
-		util.TODO("SDL")
-	case 0x2D:
-		util.TODO("SDR")
-	case 0x2E:
-		util.TODO("SWR")
-	case 0x2F:
-		util.TODO("CACHE")
-	case 0x30:
-		util.TODO("LL")
-	case 0x31:
-		util.TODO("LWC1")
-	case 0x32:
-		util.TODO("LWC2")
-	case 0x34:
-		util.TODO("LLD")
-	case 0x35:
-		util.TODO("LDC1")
-	case 0x36:
-		util.TODO("LDC2")
-	case 0x37:
-		util.TODO("LD")
-	case 0x38:
-		util.TODO("SC")
-	case 0x39:
-		util.TODO("SWC1")
-	case 0x3A:
-		util.TODO("SWC2")
-	case 0x3C:
-		util.TODO("SCD")
-	case 0x3D:
-		util.TODO("SDC1")
-	case 0x3E:
-		util.TODO("SDC2")
-	case 0x3F:
-		util.TODO("SD")
 	}
-	return nil
+	// 64-bit operations in 32-bit supervisor/user mode
+	if !c.is64BitOpEnabled() && inst.Is64Bit {
+		c.raiseException(ExcRI, pc, inDelaySlot)
+		return false
+	}
+	return true
+}
+
+func (c *CPU) execute(opcode types.Word) *aluOutput {
+	inst, ok := LookupInstruction(opcode)
+	if !ok {
+		c.raiseException(ExcRI, c.pipeline.executionPC, c.pipeline.executionInDelaySlot)
+		return nil
+	}
+	if !c.checkPrivilege(inst, opcode) {
+		return nil
+	}
+	if !inst.Implemented() {
+		util.TODO(strings.ToUpper(inst.Mnemonic))
+	}
+	if inst.hasSideEffect(opcode) {
+		c.idle.sideEffect = true
+	}
+	return inst.handler(c, opcode)
 }
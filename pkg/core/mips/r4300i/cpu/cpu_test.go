@@ -2,7 +2,10 @@ package cpu
 
 import (
 	"encoding/binary"
+	"n64emu/pkg/core/mips/r4300i/asm"
+	"n64emu/pkg/core/mips/r4300i/reg"
 	"n64emu/pkg/types"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -39,6 +42,8 @@ func (b *MockBus) ReadHalfWord(e types.Endianness, addr types.Word) types.HalfWo
 
 func (b *MockBus) ReadWord(e types.Endianness, addr types.Word) types.Word {
 	// TODO:  For now, fixed by BIG endian
+	// mirror out of range address (e.g. exception vector)
+	addr %= types.Word(len(b.MockMemory))
 	return binary.BigEndian.Uint32(b.MockMemory[addr : addr+4])
 }
 
@@ -52,15 +57,8 @@ func (b *MockBus) SetMemory(offset types.Word, data []types.Byte) {
 	}
 }
 
-func beOpcodes2bytes(opecodes ...types.Word) []types.Byte {
-	res := []types.Byte{}
-	for _, o := range opecodes {
-		bytes := make([]byte, 4)
-		binary.BigEndian.PutUint32(bytes, o)
-		res = append(res, bytes...)
-	}
-	return res
-}
+// base address of kseg0, unmapped segment mirroring physical address 0
+const kseg0 = types.DoubleWord(0xFFFFFFFF80000000)
 
 func setupCPU(offset types.Word, data []types.Byte) (*CPU, *MockBus) {
 	b := MockBus{}
@@ -70,13 +68,12 @@ func setupCPU(offset types.Word, data []types.Byte) (*CPU, *MockBus) {
 
 func TestSLL(t *testing.T) {
 	assert := assert.New(t)
-	// SLL rd=3, rt=2, sa=3
-	cpu, _ := setupCPU(0, beOpcodes2bytes(0x000218C0))
+	cpu, _ := setupCPU(0, asm.MustAssemble(0, "sll v1, v0, 3"))
 	cpu.gpr.Write(2, 0x2)
 	cpu.RunUntil(5)
 	assert.Equal(types.DoubleWord(0x10), cpu.gpr.Read(3), "should shifted value stored")
-	// SLL rd=3, rt=2, sa=0 with sign extended
-	cpu, _ = setupCPU(0, beOpcodes2bytes(0x00021800))
+	// with sign extended
+	cpu, _ = setupCPU(0, asm.MustAssemble(0, "sll v1, v0, 0"))
 	cpu.gpr.Write(2, 0x00000000FFFFFFFF)
 	cpu.RunUntil(5)
 	assert.Equal(types.DoubleWord(0xFFFFFFFFFFFFFFFF), cpu.gpr.Read(3), "should shifted value stored")
@@ -84,8 +81,7 @@ func TestSLL(t *testing.T) {
 
 func TestSRL(t *testing.T) {
 	assert := assert.New(t)
-	// SLL rd=3, rt=2, sa=3
-	cpu, _ := setupCPU(0, beOpcodes2bytes(0x000218C2))
+	cpu, _ := setupCPU(0, asm.MustAssemble(0, "srl v1, v0, 3"))
 	cpu.gpr.Write(2, 0x10)
 	cpu.RunUntil(5)
 	assert.Equal(types.DoubleWord(0x2), cpu.gpr.Read(3), "should shifted value stored")
@@ -93,8 +89,7 @@ func TestSRL(t *testing.T) {
 
 func TestSRA(t *testing.T) {
 	assert := assert.New(t)
-	// SRA rd=3, rt=2, sa=3
-	cpu, _ := setupCPU(0, beOpcodes2bytes(0x000218C3))
+	cpu, _ := setupCPU(0, asm.MustAssemble(0, "sra v1, v0, 3"))
 	cpu.gpr.Write(2, 0x00000000FFFFFFFF)
 	cpu.RunUntil(5)
 	assert.Equal(types.DoubleWord(0xFFFFFFFFFFFFFFFF), cpu.gpr.Read(3), "should shifted value stored")
@@ -102,9 +97,7 @@ func TestSRA(t *testing.T) {
 
 func TestJR(t *testing.T) {
 	assert := assert.New(t)
-	// JR rs=4
-	// SRA rd=3, rt=2, sa=3
-	cpu, _ := setupCPU(0, beOpcodes2bytes(0x00800008, 0x000218C3))
+	cpu, _ := setupCPU(0, asm.MustAssemble(0, "jr a0\nsra v1, v0, 3"))
 	cpu.gpr.Write(2, 0x00000000FFFFFFFF)
 	cpu.gpr.Write(4, 0x0000000000000100)
 	cpu.RunUntil(6)
@@ -115,8 +108,7 @@ func TestJR(t *testing.T) {
 
 func TestMTHI(t *testing.T) {
 	assert := assert.New(t)
-	// MTHI rs=1
-	cpu, _ := setupCPU(0, beOpcodes2bytes(0x00200011))
+	cpu, _ := setupCPU(0, asm.MustAssemble(0, "mthi at"))
 	cpu.gpr.Write(1, 0x5555AAAA5555AAAA)
 	cpu.RunUntil(5)
 	assert.Equal(types.DoubleWord(0x5555AAAA5555AAAA), cpu.hi, "should 0x5555AAAA5555AAAA in hi register")
@@ -124,8 +116,7 @@ func TestMTHI(t *testing.T) {
 
 func TestDSLLV(t *testing.T) {
 	assert := assert.New(t)
-	// DSLLV rd=3, rt=2, rs=1
-	cpu, _ := setupCPU(0, beOpcodes2bytes(0x00221814))
+	cpu, _ := setupCPU(0, asm.MustAssemble(0, "dsllv v1, v0, at"))
 	cpu.gpr.Write(2, 0x5555AAAA5555AAAA)
 	cpu.gpr.Write(1, 0x1)
 	cpu.RunUntil(5)
@@ -134,8 +125,7 @@ func TestDSLLV(t *testing.T) {
 
 func TestOR(t *testing.T) {
 	assert := assert.New(t)
-	// OR rd=3, rs=1, rt=2
-	cpu, _ := setupCPU(0, beOpcodes2bytes(0x00221825))
+	cpu, _ := setupCPU(0, asm.MustAssemble(0, "or v1, at, v0"))
 	cpu.gpr.Write(1, 0x00000000AAAAAAAA)
 	cpu.gpr.Write(2, 0x5555555500000000)
 	cpu.RunUntil(5)
@@ -144,10 +134,197 @@ func TestOR(t *testing.T) {
 
 func TestLW(t *testing.T) {
 	assert := assert.New(t)
-	// LW base=1, rt=3, offset=0x0100
-	cpu, bus := setupCPU(0, beOpcodes2bytes(0x8C230100))
+	cpu, bus := setupCPU(0, asm.MustAssemble(0, "lw v1, 0x100(at)"))
 	cpu.gpr.Write(1, 0x0000000000000004)
 	bus.WriteWord(types.Big, 0x00000104, 0x5555AAAA)
 	cpu.RunUntil(5)
 	assert.Equal(types.DoubleWord(0x000000005555AAAA), cpu.gpr.Read(3), "should specified word data loaded")
 }
+
+func TestMFC0(t *testing.T) {
+	assert := assert.New(t)
+	cpu, _ := setupCPU(0, asm.MustAssemble(0, "mfc0 v1, Status"))
+	cpu.cp0.Write(reg.CP0Status, 0x8040_0004)
+	cpu.RunUntil(5)
+	assert.Equal(types.DoubleWord(0xFFFFFFFF80400004), cpu.gpr.Read(3), "should sign-extended CP0 value loaded")
+}
+
+func TestMTC0(t *testing.T) {
+	assert := assert.New(t)
+	cpu, _ := setupCPU(0, asm.MustAssemble(0, "mtc0 at, Compare"))
+	cpu.gpr.Write(1, 0x0000_1234)
+	cpu.RunUntil(5)
+	assert.Equal(uint32(0x1234), cpu.cp0.Read(reg.CP0Compare), "should GPR value stored in CP0")
+}
+
+func TestTLBWR(t *testing.T) {
+	assert := assert.New(t)
+	program := "mtc0 at, Wired\n" + strings.Repeat("tlbwr\n", reg.NumOfTLBEntries)
+	cpu, _ := setupCPU(0, asm.MustAssemble(0, program))
+	cpu.gpr.Write(1, 8)
+	cpu.cp0.WriteDoubleWord(reg.CP0EntryHi, 0x0040_0000)
+	cpu.cp0.WriteDoubleWord(reg.CP0EntryLo0, 0x1)
+	cpu.cp0.WriteDoubleWord(reg.CP0EntryLo1, 0x1)
+	cpu.RunUntil(types.Word(1 + reg.NumOfTLBEntries + 4))
+
+	for i := 0; i < reg.NumOfTLBEntries; i++ {
+		entry := cpu.tlb.Read(i)
+		if i < 8 {
+			assert.Equal(reg.TLBEntry{}, entry, "wired entries should never be replaced")
+		} else {
+			assert.Equal(types.DoubleWord(0x0040_0000), entry.EntryHi, "entries selected by Random should be written")
+		}
+	}
+}
+
+func TestTLBP(t *testing.T) {
+	assert := assert.New(t)
+	cpu, _ := setupCPU(0, asm.MustAssemble(0, "tlbp"))
+	cpu.tlb.Write(5, reg.TLBEntry{EntryHi: 0x0040_0000, EntryLo0: 0x1, EntryLo1: 0x1})
+	cpu.cp0.WriteDoubleWord(reg.CP0EntryHi, 0x0040_0000)
+	cpu.RunUntil(5)
+	assert.Equal(uint32(5), cpu.cp0.Read(reg.CP0Index), "should matched index loaded")
+
+	cpu, _ = setupCPU(0, asm.MustAssemble(0, "tlbp"))
+	cpu.cp0.WriteDoubleWord(reg.CP0EntryHi, 0x0040_0000)
+	cpu.RunUntil(5)
+	assert.Equal(uint32(0x8000_0000), cpu.cp0.Read(reg.CP0Index), "should probe failure bit set")
+}
+
+func TestWatchLo(t *testing.T) {
+	assert := assert.New(t)
+	cpu, _ := setupCPU(0, asm.MustAssemble(0, "lw v1, 0x100(at)"))
+	cpu.pc = kseg0
+	cpu.gpr.Write(1, kseg0+0x4)
+	cpu.cp0.Write(reg.CP0Status, 0)
+	cpu.cp0.Write(reg.CP0WatchLo, 0x0000_0100|0x2)
+	cpu.RunUntil(5)
+	assert.Equal(uint32(ExcWATCH)<<2, cpu.cp0.Read(reg.CP0Cause)&0x7c, "should Watch exception raised")
+	assert.Equal(kseg0, cpu.cp0.ReadDoubleWord(reg.CP0EPC), "should address of LW stored in EPC")
+	assert.Equal(types.DoubleWord(0), cpu.gpr.Read(3), "should load canceled")
+
+	// W bit only, load is not watched
+	cpu, _ = setupCPU(0, asm.MustAssemble(0, "lw v1, 0x100(at)"))
+	cpu.pc = kseg0
+	cpu.gpr.Write(1, kseg0+0x4)
+	cpu.cp0.Write(reg.CP0Status, 0)
+	cpu.cp0.Write(reg.CP0WatchLo, 0x0000_0100|0x1)
+	cpu.RunUntil(5)
+	assert.Equal(uint32(0), cpu.cp0.Read(reg.CP0Cause)&0x7c, "should not Watch exception raised")
+}
+
+func TestWatchLoInDelaySlot(t *testing.T) {
+	assert := assert.New(t)
+	cpu, _ := setupCPU(0, asm.MustAssemble(0, "jr a0\nlw v1, 0x100(at)"))
+	cpu.pc = kseg0
+	cpu.gpr.Write(1, kseg0+0x4)
+	cpu.gpr.Write(4, kseg0+0x100)
+	cpu.cp0.Write(reg.CP0Status, 0)
+	cpu.cp0.Write(reg.CP0WatchLo, 0x0000_0100|0x2)
+	cpu.RunUntil(6)
+	assert.Equal(uint32(0x8000_0000|uint32(ExcWATCH)<<2), cpu.cp0.Read(reg.CP0Cause)&0x8000_007c, "should BD bit set")
+	assert.Equal(kseg0, cpu.cp0.ReadDoubleWord(reg.CP0EPC), "should address of JR stored in EPC")
+}
+
+func TestWatchpoint_Order(t *testing.T) {
+	assert := assert.New(t)
+	cpu, _ := setupCPU(0, asm.MustAssemble(0, "lw v1, 0x100(at)"))
+	cpu.gpr.Write(1, 0x0000000000000004)
+
+	ids := []int{}
+	cpu.SetWatchpointHandler(func(hit WatchHit) {
+		ids = append(ids, hit.ID)
+	})
+	for i := 0; i < 8; i++ {
+		cpu.AddWatchpoint(Watchpoint{Addr: 0x100, Size: 8, Kind: WatchRead})
+	}
+	cpu.RemoveWatchpoint(3)
+	cpu.RunUntil(5)
+	assert.Equal([]int{0, 1, 2, 4, 5, 6, 7}, ids, "should overlapping watchpoints notified in order of the ID")
+}
+
+// errorBus reports bus error on reads of the address
+type errorBus struct {
+	*MockBus
+	cpu  *CPU
+	addr types.Word
+}
+
+func (b *errorBus) ReadWord(e types.Endianness, addr types.Word) types.Word {
+	if addr == b.addr {
+		b.cpu.BusError()
+	}
+	return b.MockBus.ReadWord(e, addr)
+}
+
+func TestBusError(t *testing.T) {
+	assert := assert.New(t)
+	cpu, b := setupCPU(0, asm.MustAssemble(0, "lw v1, 0x100(at)"))
+	cpu.SetBus(&errorBus{MockBus: b, cpu: cpu, addr: 0x104})
+	cpu.pc = kseg0
+	cpu.gpr.Write(1, kseg0+0x4)
+	cpu.gpr.Write(3, 0x1)
+	cpu.cp0.Write(reg.CP0Status, 0)
+	cpu.RunUntil(5)
+	assert.Equal(uint32(ExcDBE)<<2, cpu.cp0.Read(reg.CP0Cause)&0x7c, "should Bus Error exception on data raised")
+	assert.Equal(kseg0, cpu.cp0.ReadDoubleWord(reg.CP0EPC), "should address of LW stored in EPC")
+	assert.Equal(types.DoubleWord(0x1), cpu.gpr.Read(3), "should load canceled")
+
+	// instruction fetch
+	cpu, b = setupCPU(0, asm.MustAssemble(0, "nop\nnop"))
+	cpu.SetBus(&errorBus{MockBus: b, cpu: cpu, addr: 0x4})
+	cpu.pc = kseg0
+	cpu.cp0.Write(reg.CP0Status, 0)
+	cpu.RunUntil(3)
+	assert.Equal(uint32(ExcIBE)<<2, cpu.cp0.Read(reg.CP0Cause)&0x7c, "should Bus Error exception on instruction raised")
+	assert.Equal(kseg0+0x4, cpu.cp0.ReadDoubleWord(reg.CP0EPC), "should address of the instruction stored in EPC")
+}
+
+func TestERET(t *testing.T) {
+	assert := assert.New(t)
+	cpu, _ := setupCPU(0, asm.MustAssemble(0, "eret\nsll v1, v0, 3"))
+	cpu.pc = kseg0
+	cpu.gpr.Write(2, 0x2)
+	cpu.cp0.Write(reg.CP0Status, 0x2)
+	cpu.cp0.WriteDoubleWord(reg.CP0EPC, kseg0+0x100)
+	cpu.RunUntil(3)
+	assert.Equal(uint32(0), cpu.cp0.Read(reg.CP0Status), "should EXL cleared")
+	assert.Equal(kseg0+0x100, cpu.pc, "should return to EPC")
+	cpu.RunUntil(4)
+	assert.Equal(types.DoubleWord(0), cpu.gpr.Read(3), "should next instruction not executed")
+}
+
+func TestWatchpoint(t *testing.T) {
+	assert := assert.New(t)
+	cpu, bus := setupCPU(0, asm.MustAssemble(0, "lw v1, 0x100(at)"))
+	cpu.gpr.Write(1, 0x0000000000000004)
+	bus.WriteWord(types.Big, 0x00000104, 0x5555AAAA)
+
+	hits := []WatchHit{}
+	cpu.SetWatchpointHandler(func(hit WatchHit) {
+		hits = append(hits, hit)
+	})
+	id := cpu.AddWatchpoint(Watchpoint{Addr: 0x106, Size: 1, Kind: WatchRead})
+	cpu.AddWatchpoint(Watchpoint{Addr: 0x104, Size: 4, Kind: WatchWrite})
+	cpu.RunUntil(5)
+
+	assert.Equal([]WatchHit{{ID: id, PC: 0, Addr: 0x104, Size: 4, Kind: WatchRead}}, hits, "should read watchpoint hit")
+	assert.Equal(types.DoubleWord(0x000000005555AAAA), cpu.gpr.Read(3), "should load not canceled")
+
+	cpu.RemoveWatchpoint(id)
+	hits = hits[:0]
+	cpu.pc = 0
+	cpu.RunUntil(5)
+	assert.Empty(hits, "should removed watchpoint not hit")
+}
+
+func TestWatchpoint_Match(t *testing.T) {
+	assert := assert.New(t)
+	wp := Watchpoint{Addr: 0xFFFF_FFFC, Size: 4, Kind: WatchRead}
+	assert.True(wp.match(0xFFFF_FFFC, 4, WatchRead), "should match at the top of the address space")
+	assert.True(wp.match(0xFFFF_FFF8, 8, WatchRead))
+	assert.True(wp.match(0xFFFF_FFFF, 1, WatchRead))
+	assert.False(wp.match(0xFFFF_FFF8, 4, WatchRead))
+	assert.False(wp.match(0x0000_0000, 4, WatchRead), "should not wrap to the bottom")
+	assert.False(wp.match(0xFFFF_FFFC, 4, WatchWrite))
+}
@@ -0,0 +1,124 @@
+/*
+
+Exception Processing
+
+Status register:
+	| CU [31:28] | RP [27] | FR [26] | RE [25] | DS [24:16] | IM [15:8] | KX [7] | SX [6] | UX [5] | KSU [4:3] | ERL [2] | EXL [1] | IE [0] |
+	DS: | ITS [24] | 0 [23] | BEV [22] | TS [21] | SR [20] | 0 [19] | CH [18] | CE [17] | DE [16] |
+
+Cause register:
+	| BD [31] | 0 [30] | CE [29:28] | 0 [27:16] | IP [15:8] | 0 [7] | ExcCode [6:2] | 0 [1:0] |
+
+Exception vector:
+	| Exception        | BEV=0              | BEV=1              |
+	| ---------------- | ------------------ | ------------------ |
+	| TLB refill(32)   | 0x8000_0000        | 0xBFC0_0200        |
+	| TLB refill(64)   | 0x8000_0080        | 0xBFC0_0280        |
+	| Others           | 0x8000_0180        | 0xBFC0_0380        |
+
+Reference:
+	- VR4300 User's Manual, Chapter 6 Exception Processing
+*/
+
+package cpu
+
+import (
+	"n64emu/pkg/core/mips/r4300i/reg"
+	"n64emu/pkg/types"
+)
+
+// ExcCode is exception code in Cause register
+type ExcCode types.Byte
+
+const (
+	ExcInt   ExcCode = 0  // Interrupt
+	ExcMod   ExcCode = 1  // TLB Modification exception
+	ExcTLBL  ExcCode = 2  // TLB Miss exception (load or instruction fetch)
+	ExcTLBS  ExcCode = 3  // TLB Miss exception (store)
+	ExcAdEL  ExcCode = 4  // Address Error exception (load or instruction fetch)
+	ExcAdES  ExcCode = 5  // Address Error exception (store)
+	ExcIBE   ExcCode = 6  // Bus Error exception (instruction fetch)
+	ExcDBE   ExcCode = 7  // Bus Error exception (data reference: load or store)
+	ExcSys   ExcCode = 8  // System Call exception
+	ExcBp    ExcCode = 9  // Breakpoint exception
+	ExcRI    ExcCode = 10 // Reserved Instruction exception
+	ExcCpU   ExcCode = 11 // Coprocessor Unusable exception
+	ExcOv    ExcCode = 12 // Arithmetic Overflow exception
+	ExcTr    ExcCode = 13 // Trap exception
+	ExcFPE   ExcCode = 15 // Floating-Point exception
+	ExcWATCH ExcCode = 23 // Watch exception
+)
+
+const (
+	statusIE  = 0x0000_0001
+	statusEXL = 0x0000_0002
+	statusERL = 0x0000_0004
+	statusBEV = 0x0040_0000
+)
+
+const (
+	causeBD          = 0x8000_0000
+	causeCEMask      = 0x3000_0000
+	causeCEShift     = 28
+	causeExcCodeMask = 0x0000_007c
+	causeExcShift    = 2
+)
+
+const (
+	exceptionVectorBase    = 0xffff_ffff_8000_0000
+	exceptionVectorBaseBEV = 0xffff_ffff_bfc0_0200
+	exceptionVectorGeneral = 0x180
+)
+
+// raise exception
+// pc is the address of the instruction which caused the exception, and inDelaySlot reports whether
+// the instruction is in a branch delay slot.
+func (c *CPU) raiseException(code ExcCode, pc types.DoubleWord, inDelaySlot bool) {
+	c.raiseExceptionWithOffset(code, 0, pc, inDelaySlot, exceptionVectorGeneral)
+}
+
+func (c *CPU) raiseExceptionWithOffset(code ExcCode, ce types.Byte, pc types.DoubleWord, inDelaySlot bool, offset types.DoubleWord) {
+	status := c.cp0.Read(reg.CP0Status)
+	cause := c.cp0.Read(reg.CP0Cause) &^ (causeBD | causeCEMask | causeExcCodeMask)
+	cause |= (types.Word(code) << causeExcShift) & causeExcCodeMask
+	cause |= (types.Word(ce) << causeCEShift) & causeCEMask
+
+	// EPC and BD are not updated while processing another exception
+	if (status & statusEXL) == 0 {
+		if inDelaySlot {
+			cause |= causeBD
+			c.cp0.WriteDoubleWord(reg.CP0EPC, pc-4)
+		} else {
+			c.cp0.WriteDoubleWord(reg.CP0EPC, pc)
+		}
+		status |= statusEXL
+	}
+	c.cp0.Write(reg.CP0Cause, cause)
+	c.cp0.Write(reg.CP0Status, status)
+
+	if (status & statusBEV) != 0 {
+		c.pc = exceptionVectorBaseBEV + offset
+	} else {
+		c.pc = exceptionVectorBase + offset
+	}
+	c.pipeline.flush()
+	c.pipeline.exception = true
+}
+
+// ERET
+// Returns from an exception, interrupt or error trap.
+// Unlike a branch or jump instruction, ERET does not execute the next instruction.
+func (c *CPU) eret() *aluOutput {
+	status := c.cp0.Read(reg.CP0Status)
+	if (status & statusERL) != 0 {
+		c.pc = c.cp0.ReadDoubleWord(reg.CP0ErrorEPC)
+		status &^= statusERL
+	} else {
+		c.pc = c.cp0.ReadDoubleWord(reg.CP0EPC)
+		status &^= statusEXL
+	}
+	c.cp0.Write(reg.CP0Status, status)
+	c.llBit = false
+	c.pipeline.flush()
+	return nil
+}
@@ -0,0 +1,149 @@
+/*
+
+Idle Loop Detection
+
+Games spin in short loops waiting for interrupts, e.g. polling a flag in memory set by the handler.
+Such a loop is detected at the backward jump or branch closing it:
+	- the loop is short, the jump is at most maxIdleLoopSize bytes after the target
+	- no instruction in the loop has side effects, i.e. stores, CP0 operations, exceptions
+	  and unmapped accesses, which are counted by the bus
+	- the CPU state at the jump is identical to the one at the jump in the previous iteration
+Then every following iteration is identical until memory is changed from outside of the CPU,
+so the iterations can be skipped until the next event, without changing the results.
+Random register is excluded from the state, since it counts cycles. It is advanced on skips instead.
+
+Detection is disabled while the trace hook or host-side watchpoints are set, since they observe every iteration.
+*/
+
+package cpu
+
+import (
+	"n64emu/pkg/core/mips/r4300i/isa"
+	"n64emu/pkg/core/mips/r4300i/reg"
+	"n64emu/pkg/types"
+)
+
+const (
+	// max distance from the target to the jump closing an idle loop
+	maxIdleLoopSize = 0x20
+)
+
+// IdleStats is statistics of idle loop skips
+type IdleStats struct {
+	// number of idle loops detected, a loop is counted once until it exits or memory is changed
+	Detected uint64
+	// number of skips, and cycles skipped in total
+	Skips         uint64
+	SkippedCycles uint64
+}
+
+type idleDetector struct {
+	cycles types.DoubleWord // cycles since reset, to measure iterations
+
+	// the jump closing the loop being observed, and the state at the jump
+	observing  bool
+	jumpPC     types.DoubleWord
+	state      State
+	stateCycle types.DoubleWord
+	sideEffect bool // an instruction with side effects is executed since the state
+	idle       bool // the loop is detected as idle
+
+	// cycles of an iteration, non-zero only in the cycle the idle loop is detected
+	period types.DoubleWord
+	stats  IdleStats
+}
+
+// reset forgets the loop being observed
+func (d *idleDetector) reset() {
+	d.observing = false
+	d.sideEffect = false
+	d.idle = false
+}
+
+// hasSideEffect reports whether the instruction changes state other than GPR, HI and LO
+func (i *Instruction) hasSideEffect(opcode types.Word) bool {
+	switch GetOp(opcode) {
+	case 0x10, 0x2F: // COP0, CACHE
+		return true
+	}
+	for _, w := range i.Writes {
+		if w == isa.OperandMemory || w == isa.OperandCP0 {
+			return true
+		}
+	}
+	return false
+}
+
+// observe the cycle just executed
+func (c *CPU) observeIdle() {
+	d := &c.idle
+	d.cycles++
+	d.period = 0
+	p := c.pipeline
+	if p.exception || p.flushed {
+		d.reset()
+		return
+	}
+	// the jump or branch is taken in EX stage of this cycle, and the delay slot is fetched
+	if !p.registerFetchInDelaySlot || p.registerFetchLatch == nil || p.registerFetchPC != p.executionPC+4 {
+		return
+	}
+	jumpPC, target := p.executionPC, p.instructionCacheFetchLatch
+	if target > jumpPC || jumpPC-target > maxIdleLoopSize || c.pipeline.traceHook != nil || len(c.watchpoints) > 0 {
+		d.reset()
+		return
+	}
+
+	state := c.Save()
+	state.CP0[reg.CP0Random] = 0
+	if d.observing && d.jumpPC == jumpPC && !d.sideEffect && d.state == state {
+		d.period = d.cycles - d.stateCycle
+		if !d.idle {
+			d.stats.Detected++
+		}
+		d.idle = true
+	} else {
+		d.idle = false
+	}
+	d.observing = true
+	d.jumpPC = jumpPC
+	d.state = state
+	d.stateCycle = d.cycles
+	d.sideEffect = false
+}
+
+// IdleLoop returns cycles of an iteration, if an idle loop is detected in the last cycle.
+func (c *CPU) IdleLoop() (types.DoubleWord, bool) {
+	return c.idle.period, c.idle.period != 0
+}
+
+// SkipIdle skips the cycles of the idle loop detected in the last cycle.
+// The cycles must be a multiple of the iteration, so that the CPU state is the same as running them.
+func (c *CPU) SkipIdle(cycles types.DoubleWord) {
+	d := &c.idle
+	if d.period == 0 || cycles%d.period != 0 {
+		panic("SkipIdle: not a multiple of the idle loop")
+	}
+	c.cp0.AdvanceRandom(cycles)
+	d.cycles += cycles
+	d.stateCycle += cycles
+	d.stats.Skips++
+	d.stats.SkippedCycles += cycles
+}
+
+// UnmappedAccess reports that the bus access in progress is to an unmapped address.
+// The access is counted by the bus, so the loop making it is not skipped.
+func (c *CPU) UnmappedAccess() {
+	c.idle.sideEffect = true
+}
+
+// ResetIdle forgets the loop being observed. Call it when memory is changed from outside of the CPU,
+// since the iterations observed may have read the old values.
+func (c *CPU) ResetIdle() {
+	c.idle.reset()
+}
+
+// IdleStats returns statistics of idle loop skips.
+func (c *CPU) IdleStats() IdleStats {
+	return c.idle.stats
+}
@@ -0,0 +1,333 @@
+/*
+
+Operating Modes and Virtual Address Translation
+
+Operating mode:
+	| Mode       | Condition                      | 64-bit addressing |
+	| ---------- | ------------------------------ | ----------------- |
+	| Kernel     | KSU=00 or EXL=1 or ERL=1       | KX=1              |
+	| Supervisor | KSU=01 and EXL=0 and ERL=0     | SX=1              |
+	| User       | KSU=10 and EXL=0 and ERL=0     | UX=1              |
+
+32-bit address space (address must be a sign-extended 32-bit value):
+	| Virtual address         | Kernel          | Supervisor   | User         |
+	| ----------------------- | --------------- | ------------ | ------------ |
+	| 0x00000000 - 0x7FFFFFFF | kuseg  mapped   | suseg mapped | useg  mapped |
+	| 0x80000000 - 0x9FFFFFFF | kseg0  unmapped | -            | -            |
+	| 0xA0000000 - 0xBFFFFFFF | kseg1  unmapped | -            | -            |
+	| 0xC0000000 - 0xDFFFFFFF | ksseg  mapped   | sseg  mapped | -            |
+	| 0xE0000000 - 0xFFFFFFFF | kseg3  mapped   | -            | -            |
+
+64-bit address space:
+	| Virtual address                         | Kernel          | Supervisor    | User          |
+	| --------------------------------------- | --------------- | ------------- | ------------- |
+	| 0x0000000000000000 - 0x000000FFFFFFFFFF | xkuseg mapped   | xsuseg mapped | xuseg mapped  |
+	| 0x4000000000000000 - 0x400000FFFFFFFFFF | xksseg mapped   | xsseg  mapped | -             |
+	| 0x8000000000000000 - 0xBFFFFFFFFFFFFFFF | xkphys unmapped | -             | -             |
+	| 0xC000000000000000 - 0xC00000FF7FFFFFFF | xkseg  mapped   | -             | -             |
+	| 0xFFFFFFFF80000000 - 0xFFFFFFFF9FFFFFFF | ckseg0 unmapped | -             | -             |
+	| 0xFFFFFFFFA0000000 - 0xFFFFFFFFBFFFFFFF | ckseg1 unmapped | -             | -             |
+	| 0xFFFFFFFFC0000000 - 0xFFFFFFFFDFFFFFFF | cksseg mapped   | csseg mapped  | -             |
+	| 0xFFFFFFFFE0000000 - 0xFFFFFFFFFFFFFFFF | ckseg3 mapped   | -             | -             |
+
+While ERL=1, kuseg(xkuseg) is unmapped and uncached.
+Accesses outside of the segments of the current mode raise Address Error exception.
+
+Reference:
+	- VR4300 User's Manual, Chapter 5 Memory Management System
+*/
+
+package cpu
+
+import (
+	"n64emu/pkg/core/mips/r4300i/reg"
+	"n64emu/pkg/types"
+)
+
+// Mode is operating mode of the CPU
+type Mode types.Byte
+
+const (
+	KernelMode Mode = iota
+	SupervisorMode
+	UserMode
+)
+
+const (
+	statusKSUMask  = 0x0000_0018
+	statusKSUShift = 3
+	statusUX       = 0x0000_0020
+	statusSX       = 0x0000_0040
+	statusKX       = 0x0000_0080
+	statusCU0      = 0x1000_0000
+)
+
+const (
+	entryHiASIDMask   = 0x0000_0000_0000_00ff
+	entryHiVPN2Mask   = 0xc000_00ff_ffff_e000
+	contextPTEMask    = 0xffff_ffff_ff80_0000
+	contextVPN2Mask   = 0x0000_0000_007f_fff0
+	xcontextPTEMask   = 0xffff_fffe_0000_0000
+	xcontextVPN2Mask  = 0x0000_0000_7fff_fff0
+	xkphysPAddrMask   = 0x0000_0000_ffff_ffff
+	xkphysInvalidMask = 0x07ff_ffff_0000_0000
+	kseg0Base         = 0xffff_ffff_8000_0000
+	kseg1Base         = 0xffff_ffff_a000_0000
+)
+
+const (
+	exceptionVectorTLBRefill  = 0x000
+	exceptionVectorXTLBRefill = 0x080
+)
+
+// accessKind is kind of memory access for address translation
+type accessKind types.Byte
+
+const (
+	accessFetch accessKind = iota
+	accessLoad
+	accessStore
+)
+
+// Mode returns current operating mode.
+func (c *CPU) Mode() Mode {
+	status := c.cp0.Read(reg.CP0Status)
+	if (status & (statusEXL | statusERL)) != 0 {
+		return KernelMode
+	}
+	switch (status & statusKSUMask) >> statusKSUShift {
+	case 0:
+		return KernelMode
+	case 1:
+		return SupervisorMode
+	default:
+		// KSU=11 is undefined, treat as user mode
+		return UserMode
+	}
+}
+
+// is64BitAddressing reports whether 64-bit addressing is enabled in current mode.
+func (c *CPU) is64BitAddressing() bool {
+	status := c.cp0.Read(reg.CP0Status)
+	switch c.Mode() {
+	case KernelMode:
+		return (status & statusKX) != 0
+	case SupervisorMode:
+		return (status & statusSX) != 0
+	default:
+		return (status & statusUX) != 0
+	}
+}
+
+// is64BitOpEnabled reports whether 64-bit operations can be executed.
+// 64-bit operations are always valid in kernel mode, and valid in supervisor/user mode only
+// if 64-bit addressing is enabled.
+func (c *CPU) is64BitOpEnabled() bool {
+	return c.Mode() == KernelMode || c.is64BitAddressing()
+}
+
+// isCop0Usable reports whether CP0 instructions can be executed.
+// CP0 is always usable in kernel mode, and usable in supervisor/user mode only if CU0 is set.
+func (c *CPU) isCop0Usable() bool {
+	return c.Mode() == KernelMode || (c.cp0.Read(reg.CP0Status)&statusCU0) != 0
+}
+
+// segment kind of virtual address
+type segment types.Byte
+
+const (
+	segmentInvalid segment = iota
+	segmentMapped
+	segmentUnmapped
+)
+
+// lookup segment of vaddr in current mode.
+// For unmapped segments, physical address is also returned.
+func (c *CPU) segmentOf(vaddr types.DoubleWord) (segment, types.DoubleWord) {
+	mode := c.Mode()
+	erl := (c.cp0.Read(reg.CP0Status) & statusERL) != 0
+
+	if !c.is64BitAddressing() {
+		// address must be a sign-extended 32-bit value
+		if vaddr != types.DoubleWord(types.SWord(vaddr)) {
+			return segmentInvalid, 0
+		}
+		vaddr32 := types.Word(vaddr)
+		switch {
+		case vaddr32 < 0x8000_0000:
+			// kuseg, suseg, useg
+			if mode == KernelMode && erl {
+				return segmentUnmapped, types.DoubleWord(vaddr32)
+			}
+			return segmentMapped, 0
+		case vaddr32 < 0xa000_0000:
+			// kseg0
+			if mode == KernelMode {
+				return segmentUnmapped, vaddr - kseg0Base
+			}
+		case vaddr32 < 0xc000_0000:
+			// kseg1
+			if mode == KernelMode {
+				return segmentUnmapped, vaddr - kseg1Base
+			}
+		case vaddr32 < 0xe000_0000:
+			// ksseg, sseg
+			if mode != UserMode {
+				return segmentMapped, 0
+			}
+		default:
+			// kseg3
+			if mode == KernelMode {
+				return segmentMapped, 0
+			}
+		}
+		return segmentInvalid, 0
+	}
+
+	switch {
+	case vaddr <= 0x0000_00ff_ffff_ffff:
+		// xkuseg, xsuseg, xuseg
+		if mode == KernelMode && erl {
+			return segmentUnmapped, vaddr
+		}
+		return segmentMapped, 0
+	case 0x4000_0000_0000_0000 <= vaddr && vaddr <= 0x4000_00ff_ffff_ffff:
+		// xksseg, xsseg
+		if mode != UserMode {
+			return segmentMapped, 0
+		}
+	case 0x8000_0000_0000_0000 <= vaddr && vaddr <= 0xbfff_ffff_ffff_ffff:
+		// xkphys, bits [61:59] are cache algorithm
+		if mode == KernelMode && (vaddr&xkphysInvalidMask) == 0 {
+			return segmentUnmapped, vaddr & xkphysPAddrMask
+		}
+	case 0xc000_0000_0000_0000 <= vaddr && vaddr <= 0xc000_00ff_7fff_ffff:
+		// xkseg
+		if mode == KernelMode {
+			return segmentMapped, 0
+		}
+	case 0xffff_ffff_8000_0000 <= vaddr && vaddr <= 0xffff_ffff_9fff_ffff:
+		// ckseg0
+		if mode == KernelMode {
+			return segmentUnmapped, vaddr - kseg0Base
+		}
+	case 0xffff_ffff_a000_0000 <= vaddr && vaddr <= 0xffff_ffff_bfff_ffff:
+		// ckseg1
+		if mode == KernelMode {
+			return segmentUnmapped, vaddr - kseg1Base
+		}
+	case 0xffff_ffff_c000_0000 <= vaddr && vaddr <= 0xffff_ffff_dfff_ffff:
+		// cksseg, csseg
+		if mode != UserMode {
+			return segmentMapped, 0
+		}
+	case 0xffff_ffff_e000_0000 <= vaddr:
+		// ckseg3
+		if mode == KernelMode {
+			return segmentMapped, 0
+		}
+	}
+	return segmentInvalid, 0
+}
+
+// translate virtual address to physical address.
+// size is the number of bytes to be accessed, and the address must be aligned to it.
+// If the translation fails, the exception is raised for the instruction at pc and false is returned.
+func (c *CPU) translate(vaddr types.DoubleWord, size types.Word, kind accessKind, pc types.DoubleWord, inDelaySlot bool) (types.Word, bool) {
+	seg, paddr := c.segmentOf(vaddr)
+	if seg == segmentInvalid || (vaddr&types.DoubleWord(size-1)) != 0 {
+		code := ExcAdEL
+		if kind == accessStore {
+			code = ExcAdES
+		}
+		c.cp0.WriteDoubleWord(reg.CP0BadVAddr, vaddr)
+		c.raiseException(code, pc, inDelaySlot)
+		return 0, false
+	}
+	if seg == segmentUnmapped {
+		return types.Word(paddr), true
+	}
+
+	asid := types.Byte(c.cp0.Read(reg.CP0EntryHi) & entryHiASIDMask)
+	result, found := c.tlb.Lookup(vaddr, asid)
+	if found && result.Valid && (kind != accessStore || result.Dirty) {
+		return types.Word(result.PAddr), true
+	}
+
+	// TLB exceptions
+	code := ExcTLBL
+	if kind == accessStore {
+		code = ExcTLBS
+		if found && result.Valid {
+			code = ExcMod
+		}
+	}
+	c.setTLBExceptionRegs(vaddr)
+	offset := types.DoubleWord(exceptionVectorGeneral)
+	// TLB refill vector is used for TLB miss, unless processing another exception
+	if !found && (c.cp0.Read(reg.CP0Status)&statusEXL) == 0 {
+		offset = exceptionVectorTLBRefill
+		if c.is64BitAddressing() {
+			offset = exceptionVectorXTLBRefill
+		}
+	}
+	c.raiseExceptionWithOffset(code, 0, pc, inDelaySlot, offset)
+	return 0, false
+}
+
+// Translate translates virtual address to physical address in current mode, without raising exceptions.
+// It is intended for debuggers. Returns false if the address is invalid or not mapped by TLB.
+func (c *CPU) Translate(vaddr types.DoubleWord) (types.Word, bool) {
+	seg, paddr := c.segmentOf(vaddr)
+	switch seg {
+	case segmentUnmapped:
+		return types.Word(paddr), true
+	case segmentMapped:
+		asid := types.Byte(c.cp0.Read(reg.CP0EntryHi) & entryHiASIDMask)
+		if result, found := c.tlb.Lookup(vaddr, asid); found && result.Valid {
+			return types.Word(result.PAddr), true
+		}
+	}
+	return 0, false
+}
+
+// set BadVAddr, Context, XContext and EntryHi on TLB exceptions
+func (c *CPU) setTLBExceptionRegs(vaddr types.DoubleWord) {
+	c.cp0.WriteDoubleWord(reg.CP0BadVAddr, vaddr)
+
+	context := c.cp0.ReadDoubleWord(reg.CP0Context) & contextPTEMask
+	context |= (vaddr >> 9) & contextVPN2Mask
+	c.cp0.WriteDoubleWord(reg.CP0Context, context)
+
+	// XContext: | PTEBase [63:33] | R [32:31] | BadVPN2 [30:4] | 0 [3:0] |
+	xcontext := c.cp0.ReadDoubleWord(reg.CP0XContext) & xcontextPTEMask
+	xcontext |= ((vaddr >> 62) & 0x3) << 31
+	xcontext |= (vaddr >> 9) & xcontextVPN2Mask
+	c.cp0.WriteDoubleWord(reg.CP0XContext, xcontext)
+
+	entryHi := c.cp0.ReadDoubleWord(reg.CP0EntryHi) & entryHiASIDMask
+	entryHi |= vaddr & entryHiVPN2Mask
+	c.cp0.WriteDoubleWord(reg.CP0EntryHi, entryHi)
+}
+
+// translate address of the instruction fetch in RF stage
+func (c *CPU) instructionAddress(vaddr types.DoubleWord) (types.Word, bool) {
+	return c.translate(vaddr, 4, accessFetch, vaddr, c.pipeline.branchTaken)
+}
+
+// translate address of the data access in DC stage, and check watchpoints.
+// Returns false if the access raised an exception and must be canceled.
+func (c *CPU) dataAddress(vaddr types.DoubleWord, size types.Word, kind WatchKind) (types.Word, bool) {
+	access := accessLoad
+	if kind == WatchWrite {
+		access = accessStore
+	}
+	paddr, ok := c.translate(vaddr, size, access, c.pipeline.dataCachePC, c.pipeline.dataCacheInDelaySlot)
+	if !ok {
+		return 0, false
+	}
+	if c.watch(paddr, size, kind) {
+		return 0, false
+	}
+	return paddr, true
+}
@@ -0,0 +1,225 @@
+package cpu
+
+import (
+	"fmt"
+	"n64emu/pkg/core/mips/r4300i/asm"
+	"n64emu/pkg/core/mips/r4300i/reg"
+	"n64emu/pkg/types"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// map virtual page 0 and 1 (4KB pages) to physical address 0, for user mode tests
+func mapFirstPages(cpu *CPU) {
+	cpu.tlb.Write(0, reg.TLBEntry{
+		EntryHi:  0x0000_0000,
+		EntryLo0: (0x0 << 6) | 0x7, // PFN=0, D, V, G
+		EntryLo1: (0x1 << 6) | 0x7, // PFN=1, D, V, G
+	})
+}
+
+func TestMode(t *testing.T) {
+	tests := []struct {
+		status types.Word
+		want   Mode
+	}{
+		{status: 0x0000_0000, want: KernelMode},
+		{status: 0x0000_0008, want: SupervisorMode},
+		{status: 0x0000_0010, want: UserMode},
+		{status: 0x0000_0012, want: KernelMode}, // EXL
+		{status: 0x0000_0014, want: KernelMode}, // ERL
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%08x", tt.status), func(t *testing.T) {
+			cpu, _ := setupCPU(0, []types.Byte{})
+			cpu.cp0.Write(reg.CP0Status, tt.status)
+			assert.Equal(t, tt.want, cpu.Mode())
+		})
+	}
+}
+
+func TestSegmentOf(t *testing.T) {
+	const (
+		kernel32 = 0x0000_0000
+		kernel64 = 0x0000_0080 // KX
+		erl      = 0x0000_0004
+		super32  = 0x0000_0008
+		super64  = 0x0000_0048 // SX
+		user32   = 0x0000_0010
+		user64   = 0x0000_0030 // UX
+	)
+	tests := []struct {
+		status    types.Word
+		vaddr     types.DoubleWord
+		wantSeg   segment
+		wantPAddr types.DoubleWord
+	}{
+		// 32-bit kernel
+		{status: kernel32, vaddr: 0x0000_0000_0000_1000, wantSeg: segmentMapped},
+		{status: kernel32 | erl, vaddr: 0x0000_0000_0000_1000, wantSeg: segmentUnmapped, wantPAddr: 0x1000},
+		{status: kernel32, vaddr: 0xffff_ffff_8000_1000, wantSeg: segmentUnmapped, wantPAddr: 0x1000},
+		{status: kernel32, vaddr: 0xffff_ffff_a400_0040, wantSeg: segmentUnmapped, wantPAddr: 0x0400_0040},
+		{status: kernel32, vaddr: 0xffff_ffff_c000_0000, wantSeg: segmentMapped},
+		{status: kernel32, vaddr: 0xffff_ffff_e000_0000, wantSeg: segmentMapped},
+		{status: kernel32, vaddr: 0x0000_0000_8000_0000, wantSeg: segmentInvalid}, // not sign-extended
+		// 32-bit supervisor
+		{status: super32, vaddr: 0x0000_0000_0000_1000, wantSeg: segmentMapped},
+		{status: super32, vaddr: 0xffff_ffff_8000_1000, wantSeg: segmentInvalid},
+		{status: super32, vaddr: 0xffff_ffff_c000_0000, wantSeg: segmentMapped},
+		{status: super32, vaddr: 0xffff_ffff_e000_0000, wantSeg: segmentInvalid},
+		// 32-bit user
+		{status: user32, vaddr: 0x0000_0000_7fff_fffc, wantSeg: segmentMapped},
+		{status: user32, vaddr: 0xffff_ffff_8000_0000, wantSeg: segmentInvalid},
+		{status: user32, vaddr: 0xffff_ffff_c000_0000, wantSeg: segmentInvalid},
+		// 64-bit kernel
+		{status: kernel64, vaddr: 0x0000_00ff_ffff_f000, wantSeg: segmentMapped},
+		{status: kernel64, vaddr: 0x0000_0100_0000_0000, wantSeg: segmentInvalid},
+		{status: kernel64, vaddr: 0x4000_0000_0000_0000, wantSeg: segmentMapped},
+		{status: kernel64, vaddr: 0x9800_0000_0400_0040, wantSeg: segmentUnmapped, wantPAddr: 0x0400_0040},
+		{status: kernel64, vaddr: 0x9800_0001_0000_0000, wantSeg: segmentInvalid},
+		{status: kernel64, vaddr: 0xc000_0000_0000_0000, wantSeg: segmentMapped},
+		{status: kernel64, vaddr: 0xffff_ffff_8000_1000, wantSeg: segmentUnmapped, wantPAddr: 0x1000},
+		{status: kernel64, vaddr: 0xffff_ffff_b000_0000, wantSeg: segmentUnmapped, wantPAddr: 0x1000_0000},
+		// 64-bit supervisor
+		{status: super64, vaddr: 0x4000_0000_0000_0000, wantSeg: segmentMapped},
+		{status: super64, vaddr: 0x9800_0000_0000_0000, wantSeg: segmentInvalid},
+		{status: super64, vaddr: 0xffff_ffff_c000_0000, wantSeg: segmentMapped},
+		// 64-bit user
+		{status: user64, vaddr: 0x0000_00ff_ffff_f000, wantSeg: segmentMapped},
+		{status: user64, vaddr: 0x4000_0000_0000_0000, wantSeg: segmentInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%08x/%016x", tt.status, tt.vaddr), func(t *testing.T) {
+			cpu, _ := setupCPU(0, []types.Byte{})
+			cpu.cp0.Write(reg.CP0Status, tt.status)
+			seg, paddr := cpu.segmentOf(tt.vaddr)
+			assert.Equal(t, tt.wantSeg, seg)
+			assert.Equal(t, tt.wantPAddr, paddr)
+		})
+	}
+}
+
+func TestCoprocessorUnusable(t *testing.T) {
+	assert := assert.New(t)
+	cpu, _ := setupCPU(0, asm.MustAssemble(0, "mfc0 v1, Status"))
+	mapFirstPages(cpu)
+	cpu.cp0.Write(reg.CP0Status, 0x0000_0010) // user mode
+	cpu.RunUntil(5)
+	assert.Equal(uint32(ExcCpU)<<2, cpu.cp0.Read(reg.CP0Cause)&0x3000_007c, "should Coprocessor Unusable exception raised with CE=0")
+	assert.Equal(types.DoubleWord(0), cpu.gpr.Read(3), "should MFC0 canceled")
+
+	// CU0 is set
+	cpu, _ = setupCPU(0, asm.MustAssemble(0, "mfc0 v1, Status"))
+	mapFirstPages(cpu)
+	cpu.cp0.Write(reg.CP0Status, 0x1000_0010)
+	cpu.RunUntil(5)
+	assert.Equal(uint32(0), cpu.cp0.Read(reg.CP0Cause)&0x7c, "should no exception raised")
+	assert.Equal(types.DoubleWord(0x1000_0010), cpu.gpr.Read(3), "should CP0 value loaded")
+}
+
+func TestReservedInstruction64(t *testing.T) {
+	assert := assert.New(t)
+	cpu, _ := setupCPU(0, asm.MustAssemble(0, "dsllv v1, v0, at"))
+	mapFirstPages(cpu)
+	cpu.cp0.Write(reg.CP0Status, 0x0000_0010) // 32-bit user mode
+	cpu.gpr.Write(2, 0x5555AAAA5555AAAA)
+	cpu.gpr.Write(1, 0x1)
+	cpu.RunUntil(5)
+	assert.Equal(uint32(ExcRI)<<2, cpu.cp0.Read(reg.CP0Cause)&0x7c, "should Reserved Instruction exception raised")
+	assert.Equal(types.DoubleWord(0), cpu.gpr.Read(3), "should DSLLV canceled")
+
+	// 64-bit user mode
+	cpu, _ = setupCPU(0, asm.MustAssemble(0, "dsllv v1, v0, at"))
+	mapFirstPages(cpu)
+	cpu.cp0.Write(reg.CP0Status, 0x0000_0030)
+	cpu.gpr.Write(2, 0x5555AAAA5555AAAA)
+	cpu.gpr.Write(1, 0x1)
+	cpu.RunUntil(5)
+	assert.Equal(uint32(0), cpu.cp0.Read(reg.CP0Cause)&0x7c, "should no exception raised")
+	assert.Equal(types.DoubleWord(0xAAAB5554AAAB5554), cpu.gpr.Read(3), "should shifted value stored")
+}
+
+func TestReservedInstruction(t *testing.T) {
+	assert := assert.New(t)
+	cpu, _ := setupCPU(0, asm.MustAssemble(0, "nop\n.word 0x00000001\nsll v1, v0, 3"))
+	cpu.pc = kseg0
+	cpu.gpr.Write(2, 0x2)
+	cpu.cp0.Write(reg.CP0Status, 0)
+	cpu.RunUntil(6)
+	assert.Equal(uint32(ExcRI)<<2, cpu.cp0.Read(reg.CP0Cause)&0x7c, "should Reserved Instruction exception raised")
+	assert.Equal(kseg0+0x4, cpu.cp0.ReadDoubleWord(reg.CP0EPC), "should address of the undefined instruction stored in EPC")
+	assert.Equal(types.DoubleWord(0), cpu.gpr.Read(3), "should next instruction not executed")
+}
+
+func TestAddressError(t *testing.T) {
+	assert := assert.New(t)
+	cpu, _ := setupCPU(0, asm.MustAssemble(0, "lw v1, 0(at)"))
+	mapFirstPages(cpu)
+	cpu.cp0.Write(reg.CP0Status, 0x0000_0010) // user mode
+	cpu.gpr.Write(1, kseg0+0x104)
+	cpu.RunUntil(5)
+	assert.Equal(uint32(ExcAdEL)<<2, cpu.cp0.Read(reg.CP0Cause)&0x7c, "should Address Error exception raised")
+	assert.Equal(kseg0+0x104, cpu.cp0.ReadDoubleWord(reg.CP0BadVAddr), "should address stored in BadVAddr")
+
+	// unaligned
+	cpu, _ = setupCPU(0, asm.MustAssemble(0, "lw v1, 0(at)"))
+	cpu.gpr.Write(1, 0x102)
+	cpu.RunUntil(5)
+	assert.Equal(uint32(ExcAdEL)<<2, cpu.cp0.Read(reg.CP0Cause)&0x7c, "should Address Error exception raised")
+	assert.Equal(types.DoubleWord(0x102), cpu.cp0.ReadDoubleWord(reg.CP0BadVAddr), "should address stored in BadVAddr")
+}
+
+func TestTLBMiss(t *testing.T) {
+	assert := assert.New(t)
+	cpu, _ := setupCPU(0, asm.MustAssemble(0, "lw v1, 0(at)"))
+	cpu.pc = kseg0
+	cpu.cp0.Write(reg.CP0Status, 0)
+	cpu.cp0.Write(reg.CP0EntryHi, 0x12)
+	cpu.gpr.Write(1, 0x0040_2104)
+	cpu.RunUntil(4)
+	assert.Equal(uint32(ExcTLBL)<<2, cpu.cp0.Read(reg.CP0Cause)&0x7c, "should TLB Miss exception raised")
+	assert.Equal(types.DoubleWord(0x0040_2104), cpu.cp0.ReadDoubleWord(reg.CP0BadVAddr), "should address stored in BadVAddr")
+	assert.Equal(types.DoubleWord(0x0040_2012), cpu.cp0.ReadDoubleWord(reg.CP0EntryHi), "should VPN2 stored in EntryHi with ASID")
+	assert.Equal(types.DoubleWord(0x0000_2010), cpu.cp0.ReadDoubleWord(reg.CP0Context), "should BadVPN2 stored in Context")
+	assert.Equal(types.DoubleWord(0xFFFFFFFF80000000), cpu.pc, "should jump to TLB refill vector")
+
+	// mapped
+	cpu, bus := setupCPU(0, asm.MustAssemble(0, "lw v1, 0(at)"))
+	cpu.pc = kseg0
+	cpu.cp0.Write(reg.CP0Status, 0)
+	cpu.tlb.Write(0, reg.TLBEntry{
+		EntryHi:  0x0040_2000,
+		EntryLo0: (0x0 << 6) | 0x7,
+		EntryLo1: (0x1 << 6) | 0x7, // 0x00403000 -> 0x00001000
+	})
+	bus.WriteWord(types.Big, 0x1104, 0x5555AAAA)
+	cpu.gpr.Write(1, 0x0040_3104)
+	cpu.RunUntil(5)
+	assert.Equal(uint32(0), cpu.cp0.Read(reg.CP0Cause)&0x7c, "should no exception raised")
+	assert.Equal(types.DoubleWord(0x5555AAAA), cpu.gpr.Read(3), "should mapped word data loaded")
+}
+
+func TestTranslate(t *testing.T) {
+	assert := assert.New(t)
+	cpu, _ := setupCPU(0, []types.Byte{})
+	cpu.cp0.Write(reg.CP0Status, 0)
+	cpu.tlb.Write(0, reg.TLBEntry{
+		EntryHi:  0x0040_2000,
+		EntryLo0: (0x0 << 6) | 0x7,
+		EntryLo1: (0x1 << 6) | 0x7, // 0x00403000 -> 0x00001000
+	})
+
+	paddr, ok := cpu.Translate(kseg0 + 0x104)
+	assert.True(ok)
+	assert.Equal(types.Word(0x104), paddr, "should unmapped segment translated")
+	paddr, ok = cpu.Translate(0x0040_3104)
+	assert.True(ok)
+	assert.Equal(types.Word(0x1104), paddr, "should mapped address translated")
+	_, ok = cpu.Translate(0x0050_0000)
+	assert.False(ok, "should TLB miss fail")
+	assert.Equal(uint32(0), cpu.cp0.Read(reg.CP0Cause)&0x7c, "should no exception raised")
+	assert.Equal(types.DoubleWord(0), cpu.cp0.ReadDoubleWord(reg.CP0BadVAddr), "should BadVAddr not changed")
+}
@@ -0,0 +1,207 @@
+/*
+
+Interpreter Table
+
+Every entry of the opcode table (see package isa) is bound to its handler by the mnemonic.
+The handler interprets the instruction in EX stage, and is nil if not implemented yet.
+*/
+
+package cpu
+
+import (
+	"n64emu/pkg/core/mips/r4300i/isa"
+	"n64emu/pkg/core/mips/r4300i/reg"
+	"n64emu/pkg/types"
+	"sort"
+)
+
+// handler interprets the instruction in EX stage
+type handler func(c *CPU, opcode types.Word) *aluOutput
+
+// Instruction is an entry of the opcode table with its interpreter
+type Instruction struct {
+	isa.Instruction
+
+	handler handler
+}
+
+// Implemented reports whether the interpreter of the instruction is implemented.
+func (i *Instruction) Implemented() bool {
+	return i.handler != nil
+}
+
+// handler of R-type instruction operating on GPRs
+func gprR(f func(*reg.GPR, *InstR) *aluOutput) handler {
+	return func(c *CPU, opcode types.Word) *aluOutput {
+		inst := DecodeR(opcode)
+		return f(&c.gpr, &inst)
+	}
+}
+
+// handler of R-type instruction operating on GPRs, HI and LO
+func hiloR(f func(*reg.GPR, *types.DoubleWord, *types.DoubleWord, *InstR) *aluOutput) handler {
+	return func(c *CPU, opcode types.Word) *aluOutput {
+		inst := DecodeR(opcode)
+		return f(&c.gpr, &c.hi, &c.lo, &inst)
+	}
+}
+
+// handler of I-type instruction operating on GPRs
+func gprI(f func(*reg.GPR, *InstI) *aluOutput) handler {
+	return func(c *CPU, opcode types.Word) *aluOutput {
+		inst := DecodeI(opcode)
+		return f(&c.gpr, &inst)
+	}
+}
+
+// handler of TLB instruction
+func tlbOp(f func(*reg.CP0, *reg.TLB) *aluOutput) handler {
+	return func(c *CPU, opcode types.Word) *aluOutput {
+		return f(&c.cp0, &c.tlb)
+	}
+}
+
+// handler of jump register instruction
+func jumpR(f func(*types.DoubleWord, *reg.GPR, *InstR) *aluOutput) handler {
+	return func(c *CPU, opcode types.Word) *aluOutput {
+		inst := DecodeR(opcode)
+		c.pipeline.branchTaken = true
+		return f(&c.pc, &c.gpr, &inst)
+	}
+}
+
+// handler of instruction which traps on integer overflow, f returns nil on overflow
+func trapR(f func(*reg.GPR, *InstR) *aluOutput) handler {
+	return func(c *CPU, opcode types.Word) *aluOutput {
+		inst := DecodeR(opcode)
+		output := f(&c.gpr, &inst)
+		if output == nil {
+			c.trapIntegerOverflow()
+		}
+		return output
+	}
+}
+
+// handlers by mnemonic
+var handlers = map[string]handler{
+	"lb": gprI(lb),
+	"lh": gprI(lh),
+	"lw": gprI(lw),
+
+	"sll":  gprR(sll),
+	"srl":  gprR(srl),
+	"sra":  gprR(sra),
+	"sllv": gprR(sllv),
+	"srlv": gprR(srlv),
+	"srav": gprR(srav),
+	"jr":   jumpR(jr),
+	"jalr": jumpR(jalr),
+	"mfhi": func(c *CPU, opcode types.Word) *aluOutput {
+		inst := DecodeR(opcode)
+		return mfhi(c.hi, &inst)
+	},
+	"mthi": func(c *CPU, opcode types.Word) *aluOutput {
+		inst := DecodeR(opcode)
+		return mthi(&c.gpr, &c.hi, &inst)
+	},
+	"mflo": func(c *CPU, opcode types.Word) *aluOutput {
+		inst := DecodeR(opcode)
+		return mflo(c.lo, &inst)
+	},
+	"mtlo": func(c *CPU, opcode types.Word) *aluOutput {
+		inst := DecodeR(opcode)
+		return mtlo(&c.gpr, &c.lo, &inst)
+	},
+	"dsllv":  gprR(dsllv),
+	"dsrlv":  gprR(dsrlv),
+	"dsrav":  gprR(dsrav),
+	"mult":   hiloR(mult),
+	"multu":  hiloR(multu),
+	"div":    hiloR(div),
+	"divu":   hiloR(divu),
+	"dmult":  hiloR(dmult),
+	"dmultu": hiloR(dmultu),
+	"ddiv":   hiloR(ddiv),
+	"ddivu":  hiloR(ddivu),
+	"add":    trapR(add),
+	"addu":   gprR(addu),
+	"sub":    trapR(sub),
+	"subu":   gprR(subu),
+	"and":    gprR(and),
+	"or":     gprR(or),
+	"xor":    gprR(xor),
+	"nor":    gprR(nor),
+	"slt":    gprR(slt),
+	"sltu":   gprR(sltu),
+
+	"mfc0": func(c *CPU, opcode types.Word) *aluOutput {
+		inst := DecodeR(opcode)
+		return mfc0(&c.cp0, &inst)
+	},
+	"dmfc0": func(c *CPU, opcode types.Word) *aluOutput {
+		inst := DecodeR(opcode)
+		return dmfc0(&c.cp0, &inst)
+	},
+	"mtc0": func(c *CPU, opcode types.Word) *aluOutput {
+		inst := DecodeR(opcode)
+		return mtc0(&c.gpr, &c.cp0, &inst)
+	},
+	"dmtc0": func(c *CPU, opcode types.Word) *aluOutput {
+		inst := DecodeR(opcode)
+		return dmtc0(&c.gpr, &c.cp0, &inst)
+	},
+	"tlbr":  tlbOp(tlbr),
+	"tlbwi": tlbOp(tlbwi),
+	"tlbwr": tlbOp(tlbwr),
+	"tlbp":  tlbOp(tlbp),
+	"eret": func(c *CPU, opcode types.Word) *aluOutput {
+		return c.eret()
+	},
+}
+
+// opcode table with the handlers
+var instructionTable []Instruction
+
+// instructions indexed by primary opcode
+var instructionsByOp [64][]*Instruction
+
+func init() {
+	for _, inst := range isa.Instructions() {
+		instructionTable = append(instructionTable, Instruction{inst, handlers[inst.Mnemonic]})
+	}
+	for i := range instructionTable {
+		inst := &instructionTable[i]
+		op := inst.Match >> 26
+		instructionsByOp[op] = append(instructionsByOp[op], inst)
+	}
+}
+
+// LookupInstruction returns the entry of the opcode table that matches the opcode,
+// or false if the opcode is not a valid instruction.
+func LookupInstruction(opcode types.Word) (*Instruction, bool) {
+	for _, inst := range instructionsByOp[GetOp(opcode)] {
+		if (opcode & inst.Mask) == inst.Match {
+			return inst, true
+		}
+	}
+	return nil, false
+}
+
+// Instructions returns all entries of the opcode table.
+func Instructions() []Instruction {
+	dst := make([]Instruction, len(instructionTable))
+	copy(dst, instructionTable)
+	return dst
+}
+
+// Unimplemented returns sorted mnemonics of the instructions whose interpreter is not implemented yet.
+func Unimplemented() []string {
+	dst := []string{}
+	for i := range instructionTable {
+		if !instructionTable[i].Implemented() {
+			dst = append(dst, instructionTable[i].Mnemonic)
+		}
+	}
+	sort.Strings(dst)
+	return dst
+}
@@ -0,0 +1,36 @@
+package cpu
+
+import (
+	"n64emu/pkg/core/mips/r4300i/isa"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstructions(t *testing.T) {
+	assert := assert.New(t)
+	assert.Len(Instructions(), len(isa.Instructions()), "should every entry of the opcode table be bound")
+	for mnemonic := range handlers {
+		_, ok := isa.LookupMnemonic(mnemonic)
+		assert.True(ok, "%s: handler should be bound to an entry of the opcode table", mnemonic)
+	}
+
+	inst, ok := LookupInstruction(0x0008403C)
+	if assert.True(ok) {
+		assert.Equal("dsll32", inst.Mnemonic)
+		assert.True(inst.Is64Bit)
+	}
+	_, ok = LookupInstruction(0x4C000000)
+	assert.False(ok, "should COP3 not be found")
+}
+
+func TestUnimplemented(t *testing.T) {
+	assert := assert.New(t)
+	got := Unimplemented()
+	assert.True(sort.StringsAreSorted(got), "should be sorted")
+	assert.Contains(got, "j")
+	assert.Contains(got, "add.s")
+	assert.NotContains(got, "sll")
+	assert.NotContains(got, "tlbwr")
+}
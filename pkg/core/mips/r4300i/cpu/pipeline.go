@@ -12,8 +12,21 @@ type Pipeline struct {
 	instructionCacheFetchLatch types.DoubleWord
 	registerFetchReady         bool
 	registerFetchLatch         *types.Word
+	registerFetchPC            types.DoubleWord
+	registerFetchInDelaySlot   bool
 	executionLatch             *aluOutput
+	executionPC                types.DoubleWord
+	executionInDelaySlot       bool
 	dataCacheLatch             *dataCacheOutput
+	dataCachePC                types.DoubleWord
+	dataCacheInDelaySlot       bool
+	branchTaken                bool // set when a jump or branch is executed, the next instruction is in the delay slot
+	flushed                    bool // set when an exception occurred or ERET is executed, the rest of the stages are canceled
+	exception                  bool // set when an exception occurred in the current cycle
+
+	traceHook      func(ev *TraceEvent)
+	executionTrace *TraceEvent // trace event of the instruction in EX latch
+	dataCacheTrace *TraceEvent // trace event of the instruction in DC latch
 }
 
 type dataCacheOutput struct {
@@ -40,53 +53,152 @@ func NewPipeline(bus bus.Bus) *Pipeline {
 }
 
 // TODO: Refactor later.
-func (p *Pipeline) step(endian types.Endianness, pc *types.DoubleWord, gpr *reg.GPR, execute func(types.Word) *aluOutput, fetch func(addr types.DoubleWord) types.Word) {
-	// TODO: We need to consider about pipeline exception, branch delay, load delay and etc...
+func (p *Pipeline) step(endian types.Endianness, pc *types.DoubleWord, gpr *reg.GPR, execute func(types.Word) *aluOutput, fetch func(addr types.DoubleWord) types.Word, access func(vaddr types.DoubleWord, size types.Word, kind WatchKind) (types.Word, bool), busFault func() bool) {
+	// TODO: We need to consider about branch delay, load delay and etc...
+	p.flushed = false
+	p.exception = false
+
 	p.writeBackStage(gpr)
 
-	p.dataCacheStage(endian)
+	p.dataCacheStage(endian, access, busFault)
+	if p.flushed {
+		return
+	}
 
 	p.executionStage(execute)
+	if p.flushed {
+		return
+	}
 
 	p.registerFetchStage(fetch)
+	if p.flushed {
+		return
+	}
 
 	p.instructionCacheFetchStage(pc)
 }
 
+// flush cancels the instruction that caused an exception and all the following instructions.
+// The stages that have not run in the current cycle are skipped.
+func (p *Pipeline) flush() {
+	p.registerFetchReady = false
+	p.registerFetchLatch = nil
+	p.registerFetchInDelaySlot = false
+	p.branchTaken = false
+	p.flushed = true
+}
+
 // WB - Write Back
 func (p *Pipeline) writeBackStage(gpr *reg.GPR) {
 	if p.dataCacheLatch != nil {
 		gpr.Write(p.dataCacheLatch.dest, p.dataCacheLatch.result)
 	}
+	if p.dataCacheTrace != nil {
+		if p.dataCacheLatch != nil && p.dataCacheLatch.dest != 0 {
+			p.dataCacheTrace.Registers = append(p.dataCacheTrace.Registers, RegisterWrite{
+				Register: p.dataCacheLatch.dest,
+				Value:    p.dataCacheLatch.result,
+			})
+		}
+		p.traceHook(p.dataCacheTrace)
+		p.dataCacheTrace = nil
+	}
+}
+
+// record memory access of the instruction in DC stage
+func (p *Pipeline) traceMemory(kind WatchKind, vaddr types.DoubleWord, paddr types.Word, size types.Word, value types.DoubleWord) {
+	if p.dataCacheTrace != nil {
+		p.dataCacheTrace.Memory = append(p.dataCacheTrace.Memory, MemoryAccess{
+			Kind:  kind,
+			VAddr: vaddr,
+			PAddr: paddr,
+			Size:  size,
+			Value: value,
+		})
+	}
+}
+
+// mark the instruction in DC stage canceled by an exception
+func (p *Pipeline) traceCancel() {
+	if p.dataCacheTrace != nil {
+		p.dataCacheTrace.Exception = true
+	}
 }
 
 // DC - Data Cache Fetch
-func (p *Pipeline) dataCacheStage(endian types.Endianness) {
-	if p.executionLatch != nil {
-		switch p.executionLatch.op {
-		case LB:
-			data := p.bus.ReadWord(endian, types.Word(p.executionLatch.result))
-			result := types.DoubleWord(types.SByte(data))
-			p.dataCacheLatch = newDataChacheOutput(p.executionLatch.op, p.executionLatch.dest, result)
-		case LH:
-			data := p.bus.ReadWord(endian, types.Word(p.executionLatch.result))
-			result := types.DoubleWord(types.SHalfWord(data))
-			p.dataCacheLatch = newDataChacheOutput(p.executionLatch.op, p.executionLatch.dest, result)
-		case LW:
-			data := p.bus.ReadWord(endian, types.Word(p.executionLatch.result))
-			// In 64-bit mode, the loaded word is sign-extended to 64 bits.
-			result := types.DoubleWord(types.SWord(data))
-			p.dataCacheLatch = newDataChacheOutput(p.executionLatch.op, p.executionLatch.dest, result)
-		default:
-			p.dataCacheLatch = p.executionLatch.toDataChacheOutput()
+// busFault reports whether the bus access raised Bus Error exception, and it must be canceled.
+func (p *Pipeline) dataCacheStage(endian types.Endianness, access func(vaddr types.DoubleWord, size types.Word, kind WatchKind) (types.Word, bool), busFault func() bool) {
+	p.dataCacheLatch = nil
+	p.dataCacheTrace = p.executionTrace
+	p.executionTrace = nil
+	latch := p.executionLatch
+	p.executionLatch = nil
+	if latch == nil {
+		return
+	}
+	p.dataCachePC = p.executionPC
+	p.dataCacheInDelaySlot = p.executionInDelaySlot
+
+	switch latch.op {
+	case LB:
+		addr, ok := access(latch.result, 1, WatchRead)
+		if !ok {
+			p.traceCancel()
+			return
+		}
+		data := p.bus.ReadWord(endian, addr)
+		if busFault() {
+			p.traceCancel()
+			return
+		}
+		// the byte loaded, truncated as the result
+		p.traceMemory(WatchRead, latch.result, addr, 1, types.DoubleWord(types.Byte(data)))
+		result := types.DoubleWord(types.SByte(data))
+		p.dataCacheLatch = newDataChacheOutput(latch.op, latch.dest, result)
+	case LH:
+		addr, ok := access(latch.result, 2, WatchRead)
+		if !ok {
+			p.traceCancel()
+			return
 		}
+		data := p.bus.ReadWord(endian, addr)
+		if busFault() {
+			p.traceCancel()
+			return
+		}
+		p.traceMemory(WatchRead, latch.result, addr, 2, types.DoubleWord(types.HalfWord(data)))
+		result := types.DoubleWord(types.SHalfWord(data))
+		p.dataCacheLatch = newDataChacheOutput(latch.op, latch.dest, result)
+	case LW:
+		addr, ok := access(latch.result, 4, WatchRead)
+		if !ok {
+			p.traceCancel()
+			return
+		}
+		data := p.bus.ReadWord(endian, addr)
+		if busFault() {
+			p.traceCancel()
+			return
+		}
+		p.traceMemory(WatchRead, latch.result, addr, 4, types.DoubleWord(data))
+		// In 64-bit mode, the loaded word is sign-extended to 64 bits.
+		result := types.DoubleWord(types.SWord(data))
+		p.dataCacheLatch = newDataChacheOutput(latch.op, latch.dest, result)
+	default:
+		p.dataCacheLatch = latch.toDataChacheOutput()
 	}
 }
 
 // EX - Execution
 func (p *Pipeline) executionStage(execute func(types.Word) *aluOutput) {
 	if p.registerFetchLatch != nil {
-		p.executionLatch = execute(*p.registerFetchLatch)
+		p.executionPC = p.registerFetchPC
+		p.executionInDelaySlot = p.registerFetchInDelaySlot
+		output := execute(*p.registerFetchLatch)
+		if p.flushed {
+			return
+		}
+		p.executionLatch = output
 	}
 }
 
@@ -94,7 +206,13 @@ func (p *Pipeline) executionStage(execute func(types.Word) *aluOutput) {
 func (p *Pipeline) registerFetchStage(fetch func(addr types.DoubleWord) types.Word) {
 	if p.registerFetchReady {
 		opcode := fetch(p.instructionCacheFetchLatch)
+		if p.flushed {
+			return
+		}
 		p.registerFetchLatch = &opcode
+		p.registerFetchPC = p.instructionCacheFetchLatch
+		p.registerFetchInDelaySlot = p.branchTaken
+		p.branchTaken = false
 	}
 
 }
@@ -0,0 +1,189 @@
+package cpu
+
+import (
+	"math"
+	"n64emu/pkg/core/mips/r4300i/reg"
+	"n64emu/pkg/types"
+)
+
+// State is a snapshot of CPU, containing every architectural register, CP0, TLB and the instructions in the pipeline.
+// All the fields are exported, so that it can be serialized by encoding/gob, encoding/json, etc.
+type State struct {
+	GPR   [reg.NumOfRegsInGpr]types.DoubleWord
+	FPR   [reg.NumOfRegsInFpr]types.DoubleWord // raw bits of the registers
+	PC    types.DoubleWord
+	HI    types.DoubleWord
+	LO    types.DoubleWord
+	LLBit bool
+	FCR0  types.Word
+	FCR31 types.Word
+	CP0   [reg.NumOfRegsInCp0]types.DoubleWord
+	TLB   [reg.NumOfTLBEntries]reg.TLBEntry
+
+	Pipeline PipelineState
+}
+
+// PipelineState is a snapshot of the pipeline latches
+type PipelineState struct {
+	// IC
+	InstructionCacheFetchPC types.DoubleWord
+	// RF
+	RegisterFetchReady       bool
+	RegisterFetchValid       bool // an instruction is fetched
+	RegisterFetchOpcode      types.Word
+	RegisterFetchPC          types.DoubleWord
+	RegisterFetchInDelaySlot bool
+	// EX
+	Execution            LatchState
+	ExecutionPC          types.DoubleWord
+	ExecutionInDelaySlot bool
+	// DC
+	DataCache            LatchState
+	DataCachePC          types.DoubleWord
+	DataCacheInDelaySlot bool
+
+	BranchTaken bool
+}
+
+// LatchState is output of EX or DC stage
+type LatchState struct {
+	Valid  bool // the stage has output
+	Op     Op
+	Dest   types.Byte
+	Result types.DoubleWord
+}
+
+// Save returns a snapshot of the CPU.
+func (c *CPU) Save() State {
+	s := State{
+		PC:    c.pc,
+		HI:    c.hi,
+		LO:    c.lo,
+		LLBit: c.llBit,
+		FCR0:  c.fcr0,
+		FCR31: c.fcr31,
+		CP0:   c.cp0.Registers(),
+	}
+	for i := range s.GPR {
+		s.GPR[i] = c.gpr.Read(types.Byte(i))
+	}
+	for i := range s.FPR {
+		s.FPR[i] = math.Float64bits(c.fpr.Read(i))
+	}
+	for i := range s.TLB {
+		s.TLB[i] = c.tlb.Read(i)
+	}
+
+	p := c.pipeline
+	s.Pipeline = PipelineState{
+		InstructionCacheFetchPC:  p.instructionCacheFetchLatch,
+		RegisterFetchReady:       p.registerFetchReady,
+		RegisterFetchPC:          p.registerFetchPC,
+		RegisterFetchInDelaySlot: p.registerFetchInDelaySlot,
+		ExecutionPC:              p.executionPC,
+		ExecutionInDelaySlot:     p.executionInDelaySlot,
+		DataCachePC:              p.dataCachePC,
+		DataCacheInDelaySlot:     p.dataCacheInDelaySlot,
+		BranchTaken:              p.branchTaken,
+	}
+	if p.registerFetchLatch != nil {
+		s.Pipeline.RegisterFetchValid = true
+		s.Pipeline.RegisterFetchOpcode = *p.registerFetchLatch
+	}
+	if l := p.executionLatch; l != nil {
+		s.Pipeline.Execution = LatchState{Valid: true, Op: l.op, Dest: l.dest, Result: l.result}
+	}
+	if l := p.dataCacheLatch; l != nil {
+		s.Pipeline.DataCache = LatchState{Valid: true, Op: l.op, Dest: l.dest, Result: l.result}
+	}
+	return s
+}
+
+// Load restores the CPU from a snapshot.
+// Memory and host-side watchpoints are not part of the snapshot.
+func (c *CPU) Load(s *State) {
+	c.pc = s.PC
+	c.hi = s.HI
+	c.lo = s.LO
+	c.llBit = s.LLBit
+	c.fcr0 = s.FCR0
+	c.fcr31 = s.FCR31
+	c.cp0.SetRegisters(s.CP0)
+	for i, v := range s.GPR {
+		c.gpr.Write(types.Byte(i), v)
+	}
+	for i, v := range s.FPR {
+		c.fpr.Write(i, math.Float64frombits(v))
+	}
+	for i, e := range s.TLB {
+		c.tlb.Write(i, e)
+	}
+
+	p := c.pipeline
+	p.instructionCacheFetchLatch = s.Pipeline.InstructionCacheFetchPC
+	p.registerFetchReady = s.Pipeline.RegisterFetchReady
+	p.registerFetchLatch = nil
+	if s.Pipeline.RegisterFetchValid {
+		opcode := s.Pipeline.RegisterFetchOpcode
+		p.registerFetchLatch = &opcode
+	}
+	p.registerFetchPC = s.Pipeline.RegisterFetchPC
+	p.registerFetchInDelaySlot = s.Pipeline.RegisterFetchInDelaySlot
+	p.executionLatch = nil
+	if l := s.Pipeline.Execution; l.Valid {
+		p.executionLatch = &aluOutput{op: l.Op, dest: l.Dest, result: l.Result}
+	}
+	p.executionPC = s.Pipeline.ExecutionPC
+	p.executionInDelaySlot = s.Pipeline.ExecutionInDelaySlot
+	p.dataCacheLatch = nil
+	if l := s.Pipeline.DataCache; l.Valid {
+		p.dataCacheLatch = newDataChacheOutput(l.Op, l.Dest, l.Result)
+	}
+	p.dataCachePC = s.Pipeline.DataCachePC
+	p.dataCacheInDelaySlot = s.Pipeline.DataCacheInDelaySlot
+	p.branchTaken = s.Pipeline.BranchTaken
+	p.flushed = false
+	p.exception = false
+	// trace events of the instructions in flight are not restored
+	p.executionTrace = nil
+	p.dataCacheTrace = nil
+}
+
+// GPR returns current value of the general purpose register, without taking a whole snapshot.
+func (c *CPU) GPR(index types.Byte) types.DoubleWord {
+	return c.gpr.Read(index)
+}
+
+// NextInstruction returns the instruction fetched in RF stage, which is executed in the next cycle.
+// Returns false if the stage is empty, e.g. just after an exception.
+func (c *CPU) NextInstruction() (types.DoubleWord, types.Word, bool) {
+	p := c.pipeline
+	if p.registerFetchLatch == nil {
+		return 0, 0, false
+	}
+	return p.registerFetchPC, *p.registerFetchLatch, true
+}
+
+// NextPC returns address of the instruction to be executed next, without running the pipeline.
+// It is the instruction in RF stage, or the one to be fetched if the stage is empty.
+func (c *CPU) NextPC() types.DoubleWord {
+	p := c.pipeline
+	switch {
+	case p.registerFetchLatch != nil:
+		return p.registerFetchPC
+	case p.registerFetchReady:
+		return p.instructionCacheFetchLatch
+	}
+	return c.pc
+}
+
+// SetPC redirects the execution to pc, e.g. for debuggers.
+// The instructions fetched but not executed yet are discarded, and the executed ones complete in the following cycles.
+func (c *CPU) SetPC(pc types.DoubleWord) {
+	c.pc = pc
+	p := c.pipeline
+	p.registerFetchReady = false
+	p.registerFetchLatch = nil
+	p.registerFetchInDelaySlot = false
+	p.branchTaken = false
+}
@@ -0,0 +1,110 @@
+package cpu
+
+import (
+	"bytes"
+	"encoding/gob"
+	"n64emu/pkg/core/mips/r4300i/asm"
+	"n64emu/pkg/core/mips/r4300i/reg"
+	"n64emu/pkg/types"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSaveLoad(t *testing.T) {
+	assert := assert.New(t)
+	cpu, _ := setupCPU(0, asm.MustAssemble(0, "nop"))
+	cpu.gpr.Write(3, 0x1234)
+	cpu.fpr.Write(2, 1.5)
+	cpu.hi = 0x5555
+	cpu.lo = 0xAAAA
+	cpu.llBit = true
+	cpu.fcr31 = 0x0100_0000
+	cpu.cp0.WriteDoubleWord(reg.CP0EntryHi, 0x0040_0000)
+	cpu.tlb.Write(3, reg.TLBEntry{EntryHi: 0x0040_0000, EntryLo0: 0x41, EntryLo1: 0x43})
+	cpu.RunUntil(2)
+	want := cpu.Save()
+
+	restored, _ := setupCPU(0, nil)
+	restored.Load(&want)
+	assert.Equal(want, restored.Save(), "should all the registers restored")
+	assert.Equal(1.5, restored.fpr.Read(2))
+	assert.Equal(cpu.tlb, restored.tlb)
+	assert.Equal(cpu.pipeline, restored.pipeline)
+}
+
+func TestSaveLoad_Run(t *testing.T) {
+	assert := assert.New(t)
+	program := asm.MustAssemble(0, `
+	start:
+		addu  v0, a0, a1
+		sll   v1, v0, 2
+		lw    t0, 0x200(zero)
+		mult  v0, v1
+		mfc0  t1, Random
+		jr    a2             # a2 = next
+		or    t2, t0, v1     # delay slot
+		sllv  t5, t5, t5     # skipped
+	next:
+		mflo  t3
+		dsllv t4, v1, a0
+		addu  a0, a0, t3
+		jr    zero
+		nop
+	`)
+	setup := func() *CPU {
+		cpu, bus := setupCPU(0, program)
+		bus.WriteWord(types.Big, 0x200, 0x0000_0010)
+		cpu.gpr.Write(4, 1)
+		cpu.gpr.Write(5, 2)
+		cpu.gpr.Write(6, 0x20)
+		return cpu
+	}
+
+	// uninterrupted run
+	cpu := setup()
+	cpu.RunUntil(9)
+	snapshot := cpu.Save()
+	assert.True(snapshot.Pipeline.RegisterFetchValid, "should instructions be in flight")
+	assert.True(snapshot.Pipeline.Execution.Valid, "should instructions be in flight")
+	cpu.RunUntil(50)
+	want := cpu.Save()
+
+	// the snapshot survives serialization
+	buf := bytes.Buffer{}
+	assert.NoError(gob.NewEncoder(&buf).Encode(snapshot))
+	decoded := State{}
+	assert.NoError(gob.NewDecoder(&buf).Decode(&decoded))
+
+	restored := setup()
+	restored.Load(&decoded)
+	restored.RunUntil(50)
+	assert.Equal(want, restored.Save(), "should restored run match uninterrupted run")
+}
+
+func TestSetPC(t *testing.T) {
+	assert := assert.New(t)
+	cpu, _ := setupCPU(0, asm.MustAssemble(0, `
+		addu v0, a0, a1
+		addu v1, a0, a0   # skipped
+		nop
+		nop
+		subu t0, a1, a0   # 0x10
+	`))
+	cpu.gpr.Write(4, 1)
+	cpu.gpr.Write(5, 3)
+	cpu.RunUntil(3)
+	pc, opcode, ok := cpu.NextInstruction()
+	assert.True(ok)
+	assert.Equal(types.DoubleWord(4), pc, "should the second instruction be fetched")
+	assert.Equal(types.Word(0x00841821), opcode)
+
+	cpu.SetPC(0x10)
+	_, _, ok = cpu.NextInstruction()
+	assert.False(ok, "should fetched instruction discarded")
+	assert.Equal(types.DoubleWord(0x10), cpu.NextPC(), "should next instruction be at the new pc")
+	cpu.RunUntil(6)
+	assert.Equal(types.DoubleWord(4), cpu.gpr.Read(2), "should executed instruction completed")
+	assert.Equal(types.DoubleWord(0), cpu.gpr.Read(3), "should discarded instruction not executed")
+	assert.Equal(types.DoubleWord(2), cpu.gpr.Read(8), "should execution redirected")
+}
@@ -0,0 +1,77 @@
+/*
+
+Instruction Trace
+
+A trace event is reported for each instruction that reaches WB stage, in program order.
+It carries the address and the opcode of the instruction from EX stage, the memory accesses
+of DC stage and the register writes of EX and WB stage.
+
+An instruction that raised an exception in EX or DC stage is reported with Exception set.
+The instruction is canceled, so its results are not reported.
+
+The events are built only while a hook is set, so tracing costs nothing when it is disabled.
+*/
+
+package cpu
+
+import "n64emu/pkg/types"
+
+const (
+	// TraceRegHI is index of HI register in RegisterWrite
+	TraceRegHI = 32
+	// TraceRegLO is index of LO register in RegisterWrite
+	TraceRegLO = 33
+)
+
+// RegisterWrite is a write to GPR, HI or LO register
+type RegisterWrite struct {
+	// 0-31: GPR, TraceRegHI: HI, TraceRegLO: LO
+	Register types.Byte
+	Value    types.DoubleWord
+}
+
+// MemoryAccess is a data access of load/store instruction
+type MemoryAccess struct {
+	Kind  WatchKind
+	VAddr types.DoubleWord
+	PAddr types.Word
+	// size of the access in bytes
+	Size  types.Word
+	Value types.DoubleWord
+}
+
+// TraceEvent is an executed instruction
+type TraceEvent struct {
+	PC        types.DoubleWord
+	Opcode    types.Word
+	Exception bool
+	Registers []RegisterWrite
+	Memory    []MemoryAccess
+}
+
+// SetTraceHook sets the function called for each executed instruction.
+// The event must not be retained after the hook returns. Set nil to disable tracing.
+func (c *CPU) SetTraceHook(hook func(ev *TraceEvent)) {
+	c.pipeline.traceHook = hook
+	c.pipeline.executionTrace = nil
+	c.pipeline.dataCacheTrace = nil
+}
+
+// execute the instruction, and build trace event of it
+func (c *CPU) executeTraced(opcode types.Word) *aluOutput {
+	hi, lo := c.hi, c.lo
+	output := c.execute(opcode)
+	ev := &TraceEvent{
+		PC:        c.pipeline.executionPC,
+		Opcode:    opcode,
+		Exception: c.pipeline.exception,
+	}
+	if c.hi != hi {
+		ev.Registers = append(ev.Registers, RegisterWrite{Register: TraceRegHI, Value: c.hi})
+	}
+	if c.lo != lo {
+		ev.Registers = append(ev.Registers, RegisterWrite{Register: TraceRegLO, Value: c.lo})
+	}
+	c.pipeline.executionTrace = ev
+	return output
+}
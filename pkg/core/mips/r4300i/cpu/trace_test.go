@@ -0,0 +1,127 @@
+package cpu
+
+import (
+	"n64emu/pkg/core/mips/r4300i/asm"
+	"n64emu/pkg/core/mips/r4300i/reg"
+	"n64emu/pkg/types"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTraceHook(t *testing.T) {
+	assert := assert.New(t)
+	cpu, bus := setupCPU(0, asm.MustAssemble(0, `
+		addu v0, a0, a1
+		lw   v1, 0x100(zero)
+		mult a0, a1
+		nop
+	`))
+	bus.WriteWord(types.Big, 0x100, 0x5555AAAA)
+	cpu.gpr.Write(4, 2)
+	cpu.gpr.Write(5, 3)
+
+	events := []TraceEvent{}
+	cpu.SetTraceHook(func(ev *TraceEvent) {
+		events = append(events, *ev)
+	})
+	cpu.RunUntil(8)
+
+	assert.Equal([]TraceEvent{
+		{PC: 0x0, Opcode: 0x00851021, Registers: []RegisterWrite{{Register: 2, Value: 5}}},
+		{
+			PC:        0x4,
+			Opcode:    0x8C030100,
+			Registers: []RegisterWrite{{Register: 3, Value: 0x5555AAAA}},
+			Memory:    []MemoryAccess{{Kind: WatchRead, VAddr: 0x100, PAddr: 0x100, Size: 4, Value: 0x5555AAAA}},
+		},
+		{PC: 0x8, Opcode: 0x00850018, Registers: []RegisterWrite{{Register: TraceRegLO, Value: 6}}},
+		{PC: 0xC, Opcode: 0x00000000},
+	}, events[:4])
+
+	// disabled
+	cpu.SetTraceHook(nil)
+	n := len(events)
+	cpu.RunUntil(4)
+	assert.Equal(n, len(events), "should not be traced")
+}
+
+func TestTraceHook_ByteHalfWord(t *testing.T) {
+	assert := assert.New(t)
+	cpu, bus := setupCPU(0, asm.MustAssemble(0, `
+		lb   v1, 0x100(zero)
+		lh   v0, 0x100(zero)
+	`))
+	bus.WriteWord(types.Big, 0x100, 0x5555AAAA)
+
+	events := []TraceEvent{}
+	cpu.SetTraceHook(func(ev *TraceEvent) {
+		events = append(events, *ev)
+	})
+	cpu.RunUntil(6)
+
+	assert.Equal([]TraceEvent{
+		{
+			PC:        0x0,
+			Opcode:    0x80030100,
+			Registers: []RegisterWrite{{Register: 3, Value: 0xFFFFFFFFFFFFFFAA}},
+			Memory:    []MemoryAccess{{Kind: WatchRead, VAddr: 0x100, PAddr: 0x100, Size: 1, Value: 0xAA}},
+		},
+		{
+			PC:        0x4,
+			Opcode:    0x84020100,
+			Registers: []RegisterWrite{{Register: 2, Value: 0xFFFFFFFFFFFFAAAA}},
+			Memory:    []MemoryAccess{{Kind: WatchRead, VAddr: 0x100, PAddr: 0x100, Size: 2, Value: 0xAAAA}},
+		},
+	}, events, "should the value loaded match the size")
+}
+
+func TestTraceHook_Exception(t *testing.T) {
+	assert := assert.New(t)
+	cpu, _ := setupCPU(0, asm.MustAssemble(0, "lw v1, 0x100(at)"))
+	cpu.pc = kseg0
+	cpu.gpr.Write(1, kseg0)
+	cpu.cp0.Write(reg.CP0Status, 0)
+	cpu.cp0.Write(reg.CP0WatchLo, 0x0000_0100|0x2)
+
+	events := []TraceEvent{}
+	cpu.SetTraceHook(func(ev *TraceEvent) {
+		events = append(events, *ev)
+	})
+	cpu.RunUntil(5)
+
+	assert.Equal([]TraceEvent{{PC: kseg0, Opcode: 0x8C230100, Exception: true}}, events, "should canceled load be reported")
+}
+
+func benchmarkStep(b *testing.B, hook func(ev *TraceEvent)) {
+	cpu, _ := setupCPU(0, asm.MustAssemble(0, "addu v0, a0, a1\njr zero\nsll v1, v0, 2"))
+	cpu.SetTraceHook(hook)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cpu.Step()
+	}
+}
+
+func BenchmarkStep(b *testing.B) {
+	benchmarkStep(b, nil)
+}
+
+func BenchmarkStep_Traced(b *testing.B) {
+	benchmarkStep(b, func(ev *TraceEvent) {})
+}
+
+func TestTraceHook_ERET(t *testing.T) {
+	assert := assert.New(t)
+	cpu, _ := setupCPU(0, asm.MustAssemble(0, "eret"))
+	cpu.pc = kseg0
+	cpu.cp0.Write(reg.CP0Status, 0x2)
+	cpu.cp0.WriteDoubleWord(reg.CP0EPC, kseg0+0x100)
+
+	events := []TraceEvent{}
+	cpu.SetTraceHook(func(ev *TraceEvent) {
+		events = append(events, *ev)
+	})
+	cpu.RunUntil(5)
+
+	assert.Equal(TraceEvent{PC: kseg0, Opcode: 0x42000018}, events[0], "should ERET not be reported as exception")
+}
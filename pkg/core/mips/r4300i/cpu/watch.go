@@ -0,0 +1,148 @@
+/*
+
+Memory Watchpoints
+
+WatchLo register:
+	| PAddr0 [31:3] | 0 [2] | R [1] | W [0] |
+
+WatchHi register:
+	| 0 [31:4] | PAddr1 [3:0] |
+
+PAddr0 and PAddr1 are bits [31:3] and [35:32] of the physical address to be watched.
+A data access to the doubleword pointed at by them raises the Watch exception, if R(load) or W(store) is set.
+
+The same matching is used for host-side watchpoints, which notify the debugger instead of raising the exception.
+*/
+
+package cpu
+
+import (
+	"n64emu/pkg/core/mips/r4300i/reg"
+	"n64emu/pkg/types"
+)
+
+// WatchKind is kind of memory access
+type WatchKind types.Byte
+
+const (
+	WatchRead WatchKind = 1 << iota
+	WatchWrite
+)
+
+const (
+	watchLoPAddrMask = 0xffff_fff8
+	watchLoRead      = 0x2
+	watchLoWrite     = 0x1
+	watchHiPAddrMask = 0xf
+)
+
+// Watchpoint is a memory range to be watched
+type Watchpoint struct {
+	// physical address
+	Addr types.Word
+	// size of the range in bytes
+	Size types.Word
+	// kind of accesses to be watched
+	Kind WatchKind
+}
+
+// host-side watchpoint with the ID
+type hostWatchpoint struct {
+	id int
+	Watchpoint
+}
+
+// WatchHit is a memory access that hit a host-side watchpoint
+type WatchHit struct {
+	// ID of the watchpoint
+	ID int
+	// address of the instruction that accessed memory
+	PC types.DoubleWord
+	// physical address of the access
+	Addr types.Word
+	// size of the access in bytes
+	Size types.Word
+	// kind of the access
+	Kind WatchKind
+}
+
+// match reports whether an access to [addr, addr+size) of the kind hits the watchpoint.
+func (w *Watchpoint) match(addr types.Word, size types.Word, kind WatchKind) bool {
+	if (w.Kind&kind) == 0 || w.Size == 0 || size == 0 {
+		return false
+	}
+	// ranges overlap, compared by the last bytes not to wrap at the top of the address space
+	return (addr <= w.Addr+w.Size-1) && (w.Addr <= addr+size-1)
+}
+
+// make watchpoint from WatchLo and WatchHi registers
+func watchpointFromCP0(cp0 *reg.CP0) Watchpoint {
+	var kind WatchKind
+	lo := cp0.Read(reg.CP0WatchLo)
+	if (lo & watchLoRead) != 0 {
+		kind |= WatchRead
+	}
+	if (lo & watchLoWrite) != 0 {
+		kind |= WatchWrite
+	}
+	// Only 32-bit physical address is accessed, so PAddr1 must be zero to match.
+	if (cp0.Read(reg.CP0WatchHi) & watchHiPAddrMask) != 0 {
+		kind = 0
+	}
+	return Watchpoint{
+		Addr: lo & watchLoPAddrMask,
+		Size: 8,
+		Kind: kind,
+	}
+}
+
+// AddWatchpoint adds a host-side watchpoint, and returns its ID.
+// Accesses that hit the watchpoint are notified to the handler set by SetWatchpointHandler.
+func (c *CPU) AddWatchpoint(wp Watchpoint) int {
+	id := c.nextWatchpointID
+	c.nextWatchpointID++
+	c.watchpoints = append(c.watchpoints, hostWatchpoint{id: id, Watchpoint: wp})
+	return id
+}
+
+// RemoveWatchpoint removes a host-side watchpoint.
+func (c *CPU) RemoveWatchpoint(id int) {
+	for i, wp := range c.watchpoints {
+		if wp.id == id {
+			c.watchpoints = append(c.watchpoints[:i:i], c.watchpoints[i+1:]...)
+			return
+		}
+	}
+}
+
+// SetWatchpointHandler sets the function called when a host-side watchpoint is hit.
+func (c *CPU) SetWatchpointHandler(handler func(hit WatchHit)) {
+	c.watchpointHandler = handler
+}
+
+// check a data access of the instruction in DC stage. Host-side watchpoints are notified in order of the ID.
+// Returns true if the access raised the Watch exception and must be canceled.
+func (c *CPU) watch(addr types.Word, size types.Word, kind WatchKind) bool {
+	for _, wp := range c.watchpoints {
+		if wp.match(addr, size, kind) && c.watchpointHandler != nil {
+			c.watchpointHandler(WatchHit{
+				ID:   wp.id,
+				PC:   c.pipeline.dataCachePC,
+				Addr: addr,
+				Size: size,
+				Kind: kind,
+			})
+		}
+	}
+
+	// The Watch exception does not occur while processing another exception.
+	if (c.cp0.Read(reg.CP0Status) & (statusEXL | statusERL)) != 0 {
+		return false
+	}
+	wp := watchpointFromCP0(&c.cp0)
+	if !wp.match(addr, size, kind) {
+		return false
+	}
+	c.raiseException(ExcWATCH, c.pipeline.dataCachePC, c.pipeline.dataCacheInDelaySlot)
+	return true
+}
@@ -0,0 +1,137 @@
+/*
+
+MIPS III(VR4300) Disassembler
+
+Output format:
+	| mnemonic (padded to 8 chars) | operands separated by ", " |
+
+	e.g. "addiu   sp, sp, -32"
+	     "lw      t0, 16(sp)"
+	     "beq     v0, zero, 0x80001020"
+
+Signed immediates and offsets are printed in decimal, unsigned immediates in hex.
+Branch and jump targets are resolved from the address of the instruction.
+Mnemonics and operands are taken from the opcode table of the isa package.
+Unknown instructions are printed as ".word 0x????????".
+*/
+
+package disasm
+
+import (
+	"fmt"
+	"n64emu/pkg/core/mips/r4300i/cpu"
+	"n64emu/pkg/core/mips/r4300i/isa"
+	"n64emu/pkg/core/mips/r4300i/reg"
+	"n64emu/pkg/types"
+	"strings"
+)
+
+// Disassemble returns assembly text of the instruction at pc.
+func Disassemble(pc types.DoubleWord, opcode types.Word) string {
+	// canonical nop
+	if opcode == 0 {
+		return "nop"
+	}
+	inst, ok := isa.Lookup(opcode)
+	if !ok {
+		return fmt.Sprintf(".word 0x%08x", opcode)
+	}
+	operands := formatOperands(pc, opcode, inst)
+	if operands == "" {
+		return inst.Mnemonic
+	}
+	return fmt.Sprintf("%-7s %s", inst.Mnemonic, operands)
+}
+
+// Mnemonic returns mnemonic of the instruction, or false if the instruction is unknown.
+func Mnemonic(opcode types.Word) (string, bool) {
+	inst, ok := isa.Lookup(opcode)
+	if !ok {
+		return "", false
+	}
+	return inst.Mnemonic, true
+}
+
+// name of general purpose register
+func gpr(index types.Byte) string {
+	return reg.GPRNames[index]
+}
+
+// name of floating-point register
+func fpr(index types.Byte) string {
+	return fmt.Sprintf("f%d", index)
+}
+
+// format branch or jump target
+func formatAddress(addr types.DoubleWord) string {
+	// sign-extended 32-bit address
+	if addr == types.DoubleWord(types.SWord(addr)) {
+		return fmt.Sprintf("0x%08x", types.Word(addr))
+	}
+	return fmt.Sprintf("0x%016x", addr)
+}
+
+// BranchTarget returns the target address of a branch instruction at pc.
+func BranchTarget(pc types.DoubleWord, opcode types.Word) types.DoubleWord {
+	offset := types.SDoubleWord(types.SHalfWord(cpu.DecodeI(opcode).Immediate)) << 2
+	return types.DoubleWord(types.SDoubleWord(pc+4) + offset)
+}
+
+// JumpTarget returns the target address of a jump instruction(J, JAL) at pc.
+// The upper bits are taken from the address of the delay slot.
+func JumpTarget(pc types.DoubleWord, opcode types.Word) types.DoubleWord {
+	return ((pc + 4) &^ 0x0fff_ffff) | (types.DoubleWord(cpu.DecodeJ(opcode).Address) << 2)
+}
+
+func formatOperands(pc types.DoubleWord, opcode types.Word, inst *isa.Instruction) string {
+	instR := cpu.DecodeR(opcode)
+	instI := cpu.DecodeI(opcode)
+	imm := types.SHalfWord(instI.Immediate)
+
+	operands := []string{}
+	for _, o := range inst.Operands {
+		switch o {
+		case isa.OperandRs:
+			operands = append(operands, gpr(instR.Rs))
+		case isa.OperandRt:
+			operands = append(operands, gpr(instR.Rt))
+		case isa.OperandRd:
+			// rd of JALR is omitted if it is ra
+			if inst.Mnemonic == "jalr" && instR.Rd == 31 {
+				continue
+			}
+			operands = append(operands, gpr(instR.Rd))
+		case isa.OperandSa:
+			operands = append(operands, fmt.Sprint(instR.Sa))
+		case isa.OperandImmediate:
+			operands = append(operands, fmt.Sprint(imm))
+		case isa.OperandImmediateU:
+			operands = append(operands, fmt.Sprintf("0x%x", instI.Immediate))
+		case isa.OperandOffsetBase:
+			operands = append(operands, fmt.Sprintf("%d(%s)", imm, gpr(instI.Rs)))
+		case isa.OperandBranch:
+			operands = append(operands, formatAddress(BranchTarget(pc, opcode)))
+		case isa.OperandJump:
+			operands = append(operands, formatAddress(JumpTarget(pc, opcode)))
+		case isa.OperandCode:
+			if code := (opcode >> 6) & 0xf_ffff; code != 0 {
+				operands = append(operands, fmt.Sprintf("0x%x", code))
+			}
+		case isa.OperandCacheOp:
+			operands = append(operands, fmt.Sprintf("0x%x", instI.Rt))
+		case isa.OperandCop2Rt:
+			operands = append(operands, fmt.Sprintf("$%d", instI.Rt))
+		case isa.OperandCP0:
+			operands = append(operands, reg.CP0Names[instR.Rd])
+		case isa.OperandFCR:
+			operands = append(operands, fmt.Sprintf("fcr%d", instR.Rd))
+		case isa.OperandFs:
+			operands = append(operands, fpr(instR.Rd))
+		case isa.OperandFt:
+			operands = append(operands, fpr(instR.Rt))
+		case isa.OperandFd:
+			operands = append(operands, fpr(instR.Sa))
+		}
+	}
+	return strings.Join(operands, ", ")
+}
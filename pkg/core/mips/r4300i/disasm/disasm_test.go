@@ -0,0 +1,84 @@
+package disasm
+
+import (
+	"fmt"
+	"n64emu/pkg/types"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDisassemble(t *testing.T) {
+	const pc = types.DoubleWord(0xFFFFFFFF80001000)
+	tests := []struct {
+		opcode types.Word
+		want   string
+	}{
+		// CPU
+		{opcode: 0x00000000, want: "nop"},
+		{opcode: 0x000218C0, want: "sll     v1, v0, 3"},
+		{opcode: 0x00221814, want: "dsllv   v1, v0, at"},
+		{opcode: 0x0008403C, want: "dsll32  t0, t0, 0"},
+		{opcode: 0x00221825, want: "or      v1, at, v0"},
+		{opcode: 0x03E00008, want: "jr      ra"},
+		{opcode: 0x0320F809, want: "jalr    t9"},
+		{opcode: 0x0320B809, want: "jalr    s7, t9"},
+		{opcode: 0x0000000D, want: "break"},
+		{opcode: 0x0000000F, want: "sync"},
+		{opcode: 0x27BDFFE0, want: "addiu   sp, sp, -32"},
+		{opcode: 0x3108FFFF, want: "andi    t0, t0, 0xffff"},
+		{opcode: 0x35080123, want: "ori     t0, t0, 0x123"},
+		{opcode: 0x3C088000, want: "lui     t0, 0x8000"},
+		{opcode: 0x8FA80010, want: "lw      t0, 16(sp)"},
+		{opcode: 0xAFBFFFFC, want: "sw      ra, -4(sp)"},
+		{opcode: 0xBC800000, want: "cache   0x0, 0(a0)"},
+		{opcode: 0x0C000408, want: "jal     0x80001020"},
+		{opcode: 0x08000400, want: "j       0x80001000"},
+		{opcode: 0x10400004, want: "beq     v0, zero, 0x80001014"},
+		{opcode: 0x5440FFFF, want: "bnel    v0, zero, 0x80001000"},
+		// REGIMM
+		{opcode: 0x0481FFFF, want: "bgez    a0, 0x80001000"},
+		{opcode: 0x04110002, want: "bgezal  zero, 0x8000100c"},
+		{opcode: 0x048C0010, want: "teqi    a0, 16"},
+		// COP0
+		{opcode: 0x401A6800, want: "mfc0    k0, Cause"},
+		{opcode: 0x40886000, want: "mtc0    t0, Status"},
+		{opcode: 0x40A87000, want: "dmtc0   t0, EPC"},
+		{opcode: 0x42000006, want: "tlbwr"},
+		{opcode: 0x42000018, want: "eret"},
+		// COP1
+		{opcode: 0x44886000, want: "mtc1    t0, f12"},
+		{opcode: 0x4448F800, want: "cfc1    t0, fcr31"},
+		{opcode: 0x45010002, want: "bc1t    0x8000100c"},
+		{opcode: 0x46041000, want: "add.s   f0, f2, f4"},
+		{opcode: 0x46201004, want: "sqrt.d  f0, f2"},
+		{opcode: 0x4624103C, want: "c.lt.d  f2, f4"},
+		{opcode: 0x46801020, want: "cvt.s.w f0, f2"},
+		{opcode: 0xC4840008, want: "lwc1    f4, 8(a0)"},
+		// unknown
+		{opcode: 0x4C000000, want: ".word 0x4c000000"},
+		{opcode: 0x00000001, want: ".word 0x00000001"},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%08x", tt.opcode), func(t *testing.T) {
+			assert.Equal(t, tt.want, Disassemble(pc, tt.opcode))
+		})
+	}
+}
+
+func TestDisassemble64BitTarget(t *testing.T) {
+	// beq zero, zero, -1
+	assert.Equal(t, "beq     zero, zero, 0x0000000100001000", Disassemble(0x0000000100001000, 0x1000FFFF))
+	// j
+	assert.Equal(t, "j       0x0000000100000010", Disassemble(0x0000000100001000, 0x08000004))
+}
+
+func TestMnemonic(t *testing.T) {
+	got, ok := Mnemonic(0x27BDFFE0)
+	assert.True(t, ok)
+	assert.Equal(t, "addiu", got)
+
+	_, ok = Mnemonic(0x4C000000)
+	assert.False(t, ok)
+}
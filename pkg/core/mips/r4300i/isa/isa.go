@@ -0,0 +1,408 @@
+/*
+
+Opcode Table of the VR4300
+
+The table is shared by the interpreter, the assembler and the disassembler.
+Every instruction of the VR4300 is described by an entry of the table:
+	- encoding   : an opcode matches the entry if (opcode & Mask) == Match
+	- mnemonic   : lower-case assembly mnemonic e.g. "addiu", "add.s", "c.eq.d"
+	- format     : I, J or R type (see cpu/decode.go)
+	- operands   : roles of the fields in assembly syntax order, and the registers written
+	- cycles     : number of PCycles spent in EX stage
+	- 64-bit     : the instruction is a 64-bit operation (RI in 32-bit User/Supervisor mode)
+
+Encoding groups:
+	| group        | mask       | match                        |
+	| ------------ | ---------- | ---------------------------- |
+	| primary      | 0xFC000000 | opcode << 26                 |
+	| SPECIAL      | 0xFC00003F | funct                        |
+	| REGIMM       | 0xFC1F0000 | 0x01 << 26 | rt << 16        |
+	| COPz, rs     | 0xFFE00000 | op << 26 | rs << 21          |
+	| COPz, CO     | 0xFFE0003F | op << 26 | 0x10 << 21 | fn |
+	| COP1, BC     | 0xFFFF0000 | 0x11 << 26 | 0x08 << 21 | rt |
+	| COP1, fmt    | 0xFFE0003F | 0x11 << 26 | fmt << 21 | fn |
+
+Reference:
+	- VR4300 User's Manual, Chapter 16 CPU Instruction Set Details
+	- VR4300 User's Manual, Chapter 17 FPU Instruction Set Details
+*/
+
+package isa
+
+import "n64emu/pkg/types"
+
+// Format is format of instruction
+type Format types.Byte
+
+const (
+	FormatI Format = iota
+	FormatJ
+	FormatR
+)
+
+// Operand is role of a field of instruction
+type Operand types.Byte
+
+const (
+	OperandRs         Operand = iota // general purpose register rs
+	OperandRt                        // general purpose register rt
+	OperandRd                        // general purpose register rd
+	OperandSa                        // shift amount
+	OperandImmediate                 // signed 16-bit immediate
+	OperandImmediateU                // unsigned 16-bit immediate
+	OperandOffsetBase                // offset(base), base is rs
+	OperandBranch                    // branch target, PC-relative offset
+	OperandJump                      // jump target, 26-bit instruction index
+	OperandCode                      // code of SYSCALL/BREAK
+	OperandCacheOp                   // operation of CACHE, in rt
+	OperandCP0                       // CP0 register, in rd
+	OperandFCR                       // FPU control register, in rd
+	OperandFs                        // floating-point register fs, in rd
+	OperandFt                        // floating-point register ft, in rt
+	OperandFd                        // floating-point register fd, in sa
+	OperandCop2Rt                    // COP2 register, in rt
+	OperandHI                        // HI register (implicit)
+	OperandLO                        // LO register (implicit)
+	OperandRA                        // general purpose register ra (implicit)
+	OperandMemory                    // memory (implicit)
+)
+
+// Instruction is an entry of the opcode table
+type Instruction struct {
+	Mnemonic string
+	Mask     types.Word
+	Match    types.Word
+	Format   Format
+	// operands in assembly syntax order
+	Operands []Operand
+	// registers (or memory) written by the instruction
+	Writes []Operand
+	// number of PCycles spent in EX stage
+	Cycles int
+	// 64-bit operation
+	Is64Bit bool
+}
+
+func (i Instruction) withCycles(cycles int) Instruction {
+	i.Cycles = cycles
+	return i
+}
+
+func (i Instruction) with64Bit() Instruction {
+	i.Is64Bit = true
+	return i
+}
+
+func operands(o ...Operand) []Operand { return o }
+
+func primaryInst(op types.Word, mnemonic string, ops []Operand, writes []Operand) Instruction {
+	format := FormatI
+	if op == 0x02 || op == 0x03 {
+		format = FormatJ
+	}
+	return Instruction{mnemonic, 0xFC00_0000, op << 26, format, ops, writes, 1, false}
+}
+
+func specialInst(funct types.Word, mnemonic string, ops []Operand, writes []Operand) Instruction {
+	return Instruction{mnemonic, 0xFC00_003F, funct, FormatR, ops, writes, 1, false}
+}
+
+func regimmInst(rt types.Word, mnemonic string, ops []Operand, writes []Operand) Instruction {
+	return Instruction{mnemonic, 0xFC1F_0000, 0x01<<26 | rt<<16, FormatI, ops, writes, 1, false}
+}
+
+func copInst(op types.Word, rs types.Word, mnemonic string, ops []Operand, writes []Operand) Instruction {
+	return Instruction{mnemonic, 0xFFE0_0000, op<<26 | rs<<21, FormatR, ops, writes, 1, false}
+}
+
+func copFunctInst(op types.Word, rs types.Word, funct types.Word, mnemonic string, ops []Operand, writes []Operand) Instruction {
+	return Instruction{mnemonic, 0xFFE0_003F, op<<26 | rs<<21 | funct, FormatR, ops, writes, 1, false}
+}
+
+func cop1BCInst(rt types.Word, mnemonic string) Instruction {
+	return Instruction{mnemonic, 0xFFFF_0000, 0x11<<26 | 0x08<<21 | rt<<16, FormatI, operands(OperandBranch), nil, 1, false}
+}
+
+var (
+	opsRdRtSa      = operands(OperandRd, OperandRt, OperandSa)
+	opsRdRtRs      = operands(OperandRd, OperandRt, OperandRs)
+	opsRdRsRt      = operands(OperandRd, OperandRs, OperandRt)
+	opsRsRt        = operands(OperandRs, OperandRt)
+	opsRsRtBranch  = operands(OperandRs, OperandRt, OperandBranch)
+	opsRsBranch    = operands(OperandRs, OperandBranch)
+	opsRsImm       = operands(OperandRs, OperandImmediate)
+	opsRtRsImm     = operands(OperandRt, OperandRs, OperandImmediate)
+	opsRtRsImmU    = operands(OperandRt, OperandRs, OperandImmediateU)
+	opsRtOffset    = operands(OperandRt, OperandOffsetBase)
+	opsFtOffset    = operands(OperandFt, OperandOffsetBase)
+	opsCop2Offset  = operands(OperandCop2Rt, OperandOffsetBase)
+	opsRtCP0       = operands(OperandRt, OperandCP0)
+	opsRtFs        = operands(OperandRt, OperandFs)
+	opsRtFCR       = operands(OperandRt, OperandFCR)
+	writesRd       = operands(OperandRd)
+	writesRt       = operands(OperandRt)
+	writesHILO     = operands(OperandHI, OperandLO)
+	writesMemory   = operands(OperandMemory)
+	writesRA       = operands(OperandRA)
+	writesCP0      = operands(OperandCP0)
+	writesFt       = operands(OperandFt)
+	writesFs       = operands(OperandFs)
+	writesRtMemory = operands(OperandRt, OperandMemory)
+)
+
+var instructionTable = []Instruction{
+	// primary opcode
+	primaryInst(0x02, "j", operands(OperandJump), nil),
+	primaryInst(0x03, "jal", operands(OperandJump), writesRA),
+	primaryInst(0x04, "beq", opsRsRtBranch, nil),
+	primaryInst(0x05, "bne", opsRsRtBranch, nil),
+	primaryInst(0x06, "blez", opsRsBranch, nil),
+	primaryInst(0x07, "bgtz", opsRsBranch, nil),
+	primaryInst(0x08, "addi", opsRtRsImm, writesRt),
+	primaryInst(0x09, "addiu", opsRtRsImm, writesRt),
+	primaryInst(0x0A, "slti", opsRtRsImm, writesRt),
+	primaryInst(0x0B, "sltiu", opsRtRsImm, writesRt),
+	primaryInst(0x0C, "andi", opsRtRsImmU, writesRt),
+	primaryInst(0x0D, "ori", opsRtRsImmU, writesRt),
+	primaryInst(0x0E, "xori", opsRtRsImmU, writesRt),
+	primaryInst(0x0F, "lui", operands(OperandRt, OperandImmediateU), writesRt),
+	primaryInst(0x14, "beql", opsRsRtBranch, nil),
+	primaryInst(0x15, "bnel", opsRsRtBranch, nil),
+	primaryInst(0x16, "blezl", opsRsBranch, nil),
+	primaryInst(0x17, "bgtzl", opsRsBranch, nil),
+	primaryInst(0x18, "daddi", opsRtRsImm, writesRt).with64Bit(),
+	primaryInst(0x19, "daddiu", opsRtRsImm, writesRt).with64Bit(),
+	primaryInst(0x1A, "ldl", opsRtOffset, writesRt).with64Bit(),
+	primaryInst(0x1B, "ldr", opsRtOffset, writesRt).with64Bit(),
+	primaryInst(0x20, "lb", opsRtOffset, writesRt),
+	primaryInst(0x21, "lh", opsRtOffset, writesRt),
+	primaryInst(0x22, "lwl", opsRtOffset, writesRt),
+	primaryInst(0x23, "lw", opsRtOffset, writesRt),
+	primaryInst(0x24, "lbu", opsRtOffset, writesRt),
+	primaryInst(0x25, "lhu", opsRtOffset, writesRt),
+	primaryInst(0x26, "lwr", opsRtOffset, writesRt),
+	primaryInst(0x27, "lwu", opsRtOffset, writesRt).with64Bit(),
+	primaryInst(0x28, "sb", opsRtOffset, writesMemory),
+	primaryInst(0x29, "sh", opsRtOffset, writesMemory),
+	primaryInst(0x2A, "swl", opsRtOffset, writesMemory),
+	primaryInst(0x2B, "sw", opsRtOffset, writesMemory),
+	primaryInst(0x2C, "sdl", opsRtOffset, writesMemory).with64Bit(),
+	primaryInst(0x2D, "sdr", opsRtOffset, writesMemory).with64Bit(),
+	primaryInst(0x2E, "swr", opsRtOffset, writesMemory),
+	primaryInst(0x2F, "cache", operands(OperandCacheOp, OperandOffsetBase), nil),
+	primaryInst(0x30, "ll", opsRtOffset, writesRt),
+	primaryInst(0x31, "lwc1", opsFtOffset, writesFt),
+	primaryInst(0x32, "lwc2", opsCop2Offset, nil),
+	primaryInst(0x34, "lld", opsRtOffset, writesRt).with64Bit(),
+	primaryInst(0x35, "ldc1", opsFtOffset, writesFt),
+	primaryInst(0x36, "ldc2", opsCop2Offset, nil),
+	primaryInst(0x37, "ld", opsRtOffset, writesRt).with64Bit(),
+	primaryInst(0x38, "sc", opsRtOffset, writesRtMemory),
+	primaryInst(0x39, "swc1", opsFtOffset, writesMemory),
+	primaryInst(0x3A, "swc2", opsCop2Offset, writesMemory),
+	primaryInst(0x3C, "scd", opsRtOffset, writesRtMemory).with64Bit(),
+	primaryInst(0x3D, "sdc1", opsFtOffset, writesMemory),
+	primaryInst(0x3E, "sdc2", opsCop2Offset, writesMemory),
+	primaryInst(0x3F, "sd", opsRtOffset, writesMemory).with64Bit(),
+
+	// SPECIAL, funct
+	specialInst(0x00, "sll", opsRdRtSa, writesRd),
+	specialInst(0x02, "srl", opsRdRtSa, writesRd),
+	specialInst(0x03, "sra", opsRdRtSa, writesRd),
+	specialInst(0x04, "sllv", opsRdRtRs, writesRd),
+	specialInst(0x06, "srlv", opsRdRtRs, writesRd),
+	specialInst(0x07, "srav", opsRdRtRs, writesRd),
+	specialInst(0x08, "jr", operands(OperandRs), nil),
+	specialInst(0x09, "jalr", operands(OperandRd, OperandRs), writesRd),
+	specialInst(0x0C, "syscall", operands(OperandCode), nil),
+	specialInst(0x0D, "break", operands(OperandCode), nil),
+	specialInst(0x0F, "sync", nil, nil),
+	specialInst(0x10, "mfhi", operands(OperandRd), writesRd),
+	specialInst(0x11, "mthi", operands(OperandRs), operands(OperandHI)),
+	specialInst(0x12, "mflo", operands(OperandRd), writesRd),
+	specialInst(0x13, "mtlo", operands(OperandRs), operands(OperandLO)),
+	specialInst(0x14, "dsllv", opsRdRtRs, writesRd).with64Bit(),
+	specialInst(0x16, "dsrlv", opsRdRtRs, writesRd).with64Bit(),
+	specialInst(0x17, "dsrav", opsRdRtRs, writesRd).with64Bit(),
+	specialInst(0x18, "mult", opsRsRt, writesHILO).withCycles(5),
+	specialInst(0x19, "multu", opsRsRt, writesHILO).withCycles(5),
+	specialInst(0x1A, "div", opsRsRt, writesHILO).withCycles(37),
+	specialInst(0x1B, "divu", opsRsRt, writesHILO).withCycles(37),
+	specialInst(0x1C, "dmult", opsRsRt, writesHILO).withCycles(8).with64Bit(),
+	specialInst(0x1D, "dmultu", opsRsRt, writesHILO).withCycles(8).with64Bit(),
+	specialInst(0x1E, "ddiv", opsRsRt, writesHILO).withCycles(69).with64Bit(),
+	specialInst(0x1F, "ddivu", opsRsRt, writesHILO).withCycles(69).with64Bit(),
+	specialInst(0x20, "add", opsRdRsRt, writesRd),
+	specialInst(0x21, "addu", opsRdRsRt, writesRd),
+	specialInst(0x22, "sub", opsRdRsRt, writesRd),
+	specialInst(0x23, "subu", opsRdRsRt, writesRd),
+	specialInst(0x24, "and", opsRdRsRt, writesRd),
+	specialInst(0x25, "or", opsRdRsRt, writesRd),
+	specialInst(0x26, "xor", opsRdRsRt, writesRd),
+	specialInst(0x27, "nor", opsRdRsRt, writesRd),
+	specialInst(0x2A, "slt", opsRdRsRt, writesRd),
+	specialInst(0x2B, "sltu", opsRdRsRt, writesRd),
+	specialInst(0x2C, "dadd", opsRdRsRt, writesRd).with64Bit(),
+	specialInst(0x2D, "daddu", opsRdRsRt, writesRd).with64Bit(),
+	specialInst(0x2E, "dsub", opsRdRsRt, writesRd).with64Bit(),
+	specialInst(0x2F, "dsubu", opsRdRsRt, writesRd).with64Bit(),
+	specialInst(0x30, "tge", opsRsRt, nil),
+	specialInst(0x31, "tgeu", opsRsRt, nil),
+	specialInst(0x32, "tlt", opsRsRt, nil),
+	specialInst(0x33, "tltu", opsRsRt, nil),
+	specialInst(0x34, "teq", opsRsRt, nil),
+	specialInst(0x36, "tne", opsRsRt, nil),
+	specialInst(0x38, "dsll", opsRdRtSa, writesRd).with64Bit(),
+	specialInst(0x3A, "dsrl", opsRdRtSa, writesRd).with64Bit(),
+	specialInst(0x3B, "dsra", opsRdRtSa, writesRd).with64Bit(),
+	specialInst(0x3C, "dsll32", opsRdRtSa, writesRd).with64Bit(),
+	specialInst(0x3E, "dsrl32", opsRdRtSa, writesRd).with64Bit(),
+	specialInst(0x3F, "dsra32", opsRdRtSa, writesRd).with64Bit(),
+
+	// REGIMM, rt
+	regimmInst(0x00, "bltz", opsRsBranch, nil),
+	regimmInst(0x01, "bgez", opsRsBranch, nil),
+	regimmInst(0x02, "bltzl", opsRsBranch, nil),
+	regimmInst(0x03, "bgezl", opsRsBranch, nil),
+	regimmInst(0x08, "tgei", opsRsImm, nil),
+	regimmInst(0x09, "tgeiu", opsRsImm, nil),
+	regimmInst(0x0A, "tlti", opsRsImm, nil),
+	regimmInst(0x0B, "tltiu", opsRsImm, nil),
+	regimmInst(0x0C, "teqi", opsRsImm, nil),
+	regimmInst(0x0E, "tnei", opsRsImm, nil),
+	regimmInst(0x10, "bltzal", opsRsBranch, writesRA),
+	regimmInst(0x11, "bgezal", opsRsBranch, writesRA),
+	regimmInst(0x12, "bltzall", opsRsBranch, writesRA),
+	regimmInst(0x13, "bgezall", opsRsBranch, writesRA),
+
+	// COP0, rs
+	copInst(0x10, 0x00, "mfc0", opsRtCP0, writesRt),
+	copInst(0x10, 0x01, "dmfc0", opsRtCP0, writesRt).with64Bit(),
+	copInst(0x10, 0x04, "mtc0", opsRtCP0, writesCP0),
+	copInst(0x10, 0x05, "dmtc0", opsRtCP0, writesCP0).with64Bit(),
+	// COP0, CO, funct
+	copFunctInst(0x10, 0x10, 0x01, "tlbr", nil, nil),
+	copFunctInst(0x10, 0x10, 0x02, "tlbwi", nil, nil),
+	copFunctInst(0x10, 0x10, 0x06, "tlbwr", nil, nil),
+	copFunctInst(0x10, 0x10, 0x08, "tlbp", nil, nil),
+	copFunctInst(0x10, 0x10, 0x18, "eret", nil, nil),
+
+	// COP1, rs
+	copInst(0x11, 0x00, "mfc1", opsRtFs, writesRt),
+	copInst(0x11, 0x01, "dmfc1", opsRtFs, writesRt).with64Bit(),
+	copInst(0x11, 0x02, "cfc1", opsRtFCR, writesRt),
+	copInst(0x11, 0x04, "mtc1", opsRtFs, writesFs),
+	copInst(0x11, 0x05, "dmtc1", opsRtFs, writesFs).with64Bit(),
+	copInst(0x11, 0x06, "ctc1", opsRtFCR, operands(OperandFCR)),
+	// COP1, BC, rt
+	cop1BCInst(0x00, "bc1f"),
+	cop1BCInst(0x01, "bc1t"),
+	cop1BCInst(0x02, "bc1fl"),
+	cop1BCInst(0x03, "bc1tl"),
+}
+
+// COP1, fmt
+var cop1Formats = []struct {
+	suffix string
+	fmt    types.Word
+}{
+	{"s", 0x10},
+	{"d", 0x11},
+	{"w", 0x14},
+	{"l", 0x15},
+}
+
+// COP1, fmt, funct
+var cop1FmtInstructions = []struct {
+	funct    types.Word
+	mnemonic string
+	binary   bool // fd, fs, ft
+	cycles   [2]int
+}{
+	{0x00, "add", true, [2]int{3, 3}},
+	{0x01, "sub", true, [2]int{3, 3}},
+	{0x02, "mul", true, [2]int{5, 8}},
+	{0x03, "div", true, [2]int{29, 58}},
+	{0x04, "sqrt", false, [2]int{29, 58}},
+	{0x05, "abs", false, [2]int{1, 1}},
+	{0x06, "mov", false, [2]int{1, 1}},
+	{0x07, "neg", false, [2]int{1, 1}},
+	{0x08, "round.l", false, [2]int{5, 5}},
+	{0x09, "trunc.l", false, [2]int{5, 5}},
+	{0x0A, "ceil.l", false, [2]int{5, 5}},
+	{0x0B, "floor.l", false, [2]int{5, 5}},
+	{0x0C, "round.w", false, [2]int{5, 5}},
+	{0x0D, "trunc.w", false, [2]int{5, 5}},
+	{0x0E, "ceil.w", false, [2]int{5, 5}},
+	{0x0F, "floor.w", false, [2]int{5, 5}},
+	{0x20, "cvt.s", false, [2]int{5, 2}},
+	{0x21, "cvt.d", false, [2]int{1, 1}},
+	{0x24, "cvt.w", false, [2]int{5, 5}},
+	{0x25, "cvt.l", false, [2]int{5, 5}},
+}
+
+// COP1, fmt, C.cond (funct = 0x30 | cond)
+var cop1Conditions = [16]string{
+	"f", "un", "eq", "ueq", "olt", "ult", "ole", "ule",
+	"sf", "ngle", "seq", "ngl", "lt", "nge", "le", "ngt",
+}
+
+// instructions indexed by primary opcode
+var instructionsByOp [64][]*Instruction
+
+// instructions indexed by mnemonic
+var instructionsByMnemonic = map[string]*Instruction{}
+
+func init() {
+	// COP1 arithmetic instructions with fmt suffix e.g. "add.s", "c.eq.d"
+	for _, f := range cop1Formats {
+		for _, inst := range cop1FmtInstructions {
+			ops := operands(OperandFd, OperandFs)
+			if inst.binary {
+				ops = operands(OperandFd, OperandFs, OperandFt)
+			}
+			// fixed-point formats take the cycles of single precision
+			cycles := inst.cycles[0]
+			if f.suffix == "d" || f.suffix == "l" {
+				cycles = inst.cycles[1]
+			}
+			instructionTable = append(instructionTable,
+				copFunctInst(0x11, f.fmt, inst.funct, inst.mnemonic+"."+f.suffix, ops, operands(OperandFd)).withCycles(cycles))
+		}
+		for cond, name := range cop1Conditions {
+			instructionTable = append(instructionTable,
+				copFunctInst(0x11, f.fmt, 0x30|types.Word(cond), "c."+name+"."+f.suffix, operands(OperandFs, OperandFt), nil))
+		}
+	}
+
+	for i := range instructionTable {
+		inst := &instructionTable[i]
+		op := inst.Match >> 26
+		instructionsByOp[op] = append(instructionsByOp[op], inst)
+		instructionsByMnemonic[inst.Mnemonic] = inst
+	}
+}
+
+// Lookup returns the entry of the opcode table that matches the opcode,
+// or false if the opcode is not a valid instruction.
+func Lookup(opcode types.Word) (*Instruction, bool) {
+	for _, inst := range instructionsByOp[opcode>>26] {
+		if (opcode & inst.Mask) == inst.Match {
+			return inst, true
+		}
+	}
+	return nil, false
+}
+
+// LookupMnemonic returns the entry of the opcode table with the mnemonic, or false if there is no such instruction.
+func LookupMnemonic(mnemonic string) (*Instruction, bool) {
+	inst, ok := instructionsByMnemonic[mnemonic]
+	return inst, ok
+}
+
+// Instructions returns all entries of the opcode table.
+func Instructions() []Instruction {
+	dst := make([]Instruction, len(instructionTable))
+	copy(dst, instructionTable)
+	return dst
+}
@@ -0,0 +1,71 @@
+package isa
+
+import (
+	"n64emu/pkg/types"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstructions(t *testing.T) {
+	assert := assert.New(t)
+	mnemonics := map[string]bool{}
+	for _, inst := range Instructions() {
+		assert.Equal(inst.Match, inst.Match&inst.Mask, "%s: match should be inside mask", inst.Mnemonic)
+		assert.False(mnemonics[inst.Mnemonic], "%s: mnemonic should be unique", inst.Mnemonic)
+		mnemonics[inst.Mnemonic] = true
+
+		got, ok := Lookup(inst.Match)
+		if assert.True(ok, "%s: should be found", inst.Mnemonic) {
+			assert.Equal(inst.Mnemonic, got.Mnemonic, "%s: should not be shadowed by another entry", inst.Mnemonic)
+		}
+		got, ok = LookupMnemonic(inst.Mnemonic)
+		if assert.True(ok, "%s: should be found by mnemonic", inst.Mnemonic) {
+			assert.Equal(inst.Match, got.Match, inst.Mnemonic)
+		}
+		assert.GreaterOrEqual(inst.Cycles, 1, "%s: should take at least 1 cycle", inst.Mnemonic)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	assert := assert.New(t)
+	tests := []struct {
+		opcode   types.Word
+		mnemonic string
+		format   Format
+		is64Bit  bool
+	}{
+		{0x000218C0, "sll", FormatR, false},
+		{0x0008403C, "dsll32", FormatR, true},
+		{0x27BDFFE0, "addiu", FormatI, false},
+		{0x0C000408, "jal", FormatJ, false},
+		{0x0481FFFF, "bgez", FormatI, false},
+		{0x40A87000, "dmtc0", FormatR, true},
+		{0x42000018, "eret", FormatR, false},
+		{0x4624103C, "c.lt.d", FormatR, false},
+		{0x46801020, "cvt.s.w", FormatR, false},
+	}
+	for _, tt := range tests {
+		inst, ok := Lookup(tt.opcode)
+		if assert.True(ok, tt.mnemonic) {
+			assert.Equal(tt.mnemonic, inst.Mnemonic)
+			assert.Equal(tt.format, inst.Format, tt.mnemonic)
+			assert.Equal(tt.is64Bit, inst.Is64Bit, tt.mnemonic)
+		}
+	}
+
+	_, ok := Lookup(0x4C000000)
+	assert.False(ok, "should COP3 not be found")
+	_, ok = Lookup(0x00000001)
+	assert.False(ok, "should undefined SPECIAL funct not be found")
+}
+
+func TestLookupMnemonic(t *testing.T) {
+	assert := assert.New(t)
+	inst, ok := LookupMnemonic("c.eq.s")
+	if assert.True(ok) {
+		assert.Equal(types.Word(0x46000032), inst.Match)
+	}
+	_, ok = LookupMnemonic("foo")
+	assert.False(ok, "should unknown mnemonic not be found")
+}
@@ -36,20 +36,152 @@ Register map:
 
 package reg
 
+import "n64emu/pkg/types"
+
 const (
 	NumOfRegsInCp0 = 32
 )
 
+// CP0 register indexes
+const (
+	CP0Index    = 0
+	CP0Random   = 1
+	CP0EntryLo0 = 2
+	CP0EntryLo1 = 3
+	CP0Context  = 4
+	CP0PageMask = 5
+	CP0Wired    = 6
+	CP0BadVAddr = 8
+	CP0Count    = 9
+	CP0EntryHi  = 10
+	CP0Compare  = 11
+	CP0Status   = 12
+	CP0Cause    = 13
+	CP0EPC      = 14
+	CP0PRId     = 15
+	CP0Config   = 16
+	CP0LLAddr   = 17
+	CP0WatchLo  = 18
+	CP0WatchHi  = 19
+	CP0XContext = 20
+	CP0Parity   = 26
+	CP0Cache    = 27
+	CP0TagLo    = 28
+	CP0TagHi    = 29
+	CP0ErrorEPC = 30
+)
+
+// CP0Names is names of the CP0 registers
+var CP0Names = [NumOfRegsInCp0]string{
+	"Index", "Random", "EntryLo0", "EntryLo1", "Context", "PageMask", "Wired", "$7",
+	"BadVAddr", "Count", "EntryHi", "Compare", "Status", "Cause", "EPC", "PRId",
+	"Config", "LLAddr", "WatchLo", "WatchHi", "XContext", "$21", "$22", "$23",
+	"$24", "$25", "Parity", "Cache", "TagLo", "TagHi", "ErrorEPC", "$31",
+}
+
+const (
+	// Upper bound of the Random register. Random counts down from here to Wired.
+	RandomUpperBound = NumOfTLBEntries - 1
+	// Random and Wired are 6-bit fields
+	randomMask = 0x3f
+)
+
+// Power-on reset values
+const (
+	// ERL=1, BEV=1
+	StatusResetValue = 0x0040_0004
+	// EP=0(D), BE=1(Big endian), CU=0, K0=3(cached)
+	ConfigResetValue = 0x7006_e463
+	// Imp=0x0B, Rev=0x22
+	PRIdResetValue = 0x0000_0b22
+)
+
+// CP0 is System Control Coprocessor registers.
+// Each register is held as 64-bit value, because some of them (EntryHi, EPC, ...) are 64-bit in 64-bit mode.
 type CP0 struct {
-	cp0 [NumOfRegsInCp0]uint32
+	cp0 [NumOfRegsInCp0]types.DoubleWord
+}
+
+// NewCP0 is CP0 constructor
+func NewCP0() CP0 {
+	cp0 := CP0{}
+	cp0.cp0[CP0Random] = RandomUpperBound
+	cp0.cp0[CP0Status] = StatusResetValue
+	cp0.cp0[CP0Config] = ConfigResetValue
+	cp0.cp0[CP0PRId] = PRIdResetValue
+	return cp0
 }
 
 // Read value of the register.
 func (cp0 *CP0) Read(index int) uint32 {
-	return cp0.cp0[index]
+	return uint32(cp0.cp0[index])
 }
 
 // Write value in register
+// The value is sign-extended to 64 bits, as MTC0 does.
 func (cp0 *CP0) Write(index int, value uint32) {
-	cp0.cp0[index] = value
+	cp0.WriteDoubleWord(index, types.DoubleWord(types.SWord(value)))
+}
+
+// ReadDoubleWord reads 64-bit value of the register.
+func (cp0 *CP0) ReadDoubleWord(index int) types.DoubleWord {
+	return cp0.cp0[index]
+}
+
+// WriteDoubleWord writes 64-bit value in register
+//
+// Random is read only, so writes to it are ignored.
+// Writing Wired resets Random to its upper bound.
+func (cp0 *CP0) WriteDoubleWord(index int, value types.DoubleWord) {
+	switch index {
+	case CP0Random:
+		// read only
+	case CP0Wired:
+		cp0.cp0[CP0Wired] = value & randomMask
+		cp0.cp0[CP0Random] = RandomUpperBound
+	default:
+		cp0.cp0[index] = value
+	}
+}
+
+// DecrementRandom decrements Random register.
+// Random counts down by one for each instruction, and wraps to the upper bound when it reaches Wired.
+func (cp0 *CP0) DecrementRandom() {
+	if cp0.cp0[CP0Random] == cp0.cp0[CP0Wired] {
+		cp0.cp0[CP0Random] = RandomUpperBound
+		return
+	}
+	cp0.cp0[CP0Random] = (cp0.cp0[CP0Random] - 1) & randomMask
+}
+
+// AdvanceRandom decrements Random register n times, as n cycles passed.
+func (cp0 *CP0) AdvanceRandom(n uint64) {
+	// Random may start out of the cycle, e.g. after Wired is written
+	for ; n > 0 && cp0.cp0[CP0Random] != RandomUpperBound; n-- {
+		cp0.DecrementRandom()
+	}
+	if n == 0 {
+		return
+	}
+	period := uint64(0)
+	for {
+		cp0.DecrementRandom()
+		period++
+		if cp0.cp0[CP0Random] == RandomUpperBound {
+			break
+		}
+	}
+	for n %= period; n > 0; n-- {
+		cp0.DecrementRandom()
+	}
+}
+
+// Registers returns raw values of all the registers, for snapshots.
+func (cp0 *CP0) Registers() [NumOfRegsInCp0]types.DoubleWord {
+	return cp0.cp0
+}
+
+// SetRegisters restores raw values of all the registers, bypassing the side effects of writes.
+func (cp0 *CP0) SetRegisters(registers [NumOfRegsInCp0]types.DoubleWord) {
+	cp0.cp0 = registers
 }
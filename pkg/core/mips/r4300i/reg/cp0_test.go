@@ -12,13 +12,109 @@ func TestCP0_WriteRead(t *testing.T) {
 
 	for i := 0; i < NumOfRegsInCp0; i++ {
 		t.Run(fmt.Sprint(i), func(t *testing.T) {
-			cp0 := CP0{}
+			cp0 := NewCP0()
 
 			// write testData and read verify
 			cp0.Write(i, testData)
 			got := cp0.Read(i)
 
-			assert.Equal(t, testData, got)
+			switch i {
+			case CP0Random:
+				// read only
+				assert.Equal(t, uint32(RandomUpperBound), got)
+			case CP0Wired:
+				assert.Equal(t, testData&0x3f, got)
+			default:
+				assert.Equal(t, testData, got)
+			}
 		})
 	}
 }
+
+func TestCP0_WriteReadDoubleWord(t *testing.T) {
+	cp0 := CP0{}
+
+	// 32-bit write is sign-extended
+	cp0.Write(CP0EPC, 0x8000_0180)
+	assert.Equal(t, uint64(0xffff_ffff_8000_0180), cp0.ReadDoubleWord(CP0EPC))
+
+	cp0.WriteDoubleWord(CP0EntryHi, 0xc000_0012_3456_e0ff)
+	assert.Equal(t, uint64(0xc000_0012_3456_e0ff), cp0.ReadDoubleWord(CP0EntryHi))
+	assert.Equal(t, uint32(0x3456_e0ff), cp0.Read(CP0EntryHi))
+}
+
+func TestCP0_ResetValue(t *testing.T) {
+	cp0 := NewCP0()
+	assert.Equal(t, uint32(0x0040_0004), cp0.Read(CP0Status), "should BEV and ERL set")
+	assert.Equal(t, uint32(0x7006_e463), cp0.Read(CP0Config))
+	assert.Equal(t, uint32(0x0000_0b22), cp0.Read(CP0PRId))
+}
+
+func TestCP0_Random(t *testing.T) {
+	t.Run("Initial", func(t *testing.T) {
+		cp0 := NewCP0()
+		assert.Equal(t, uint32(31), cp0.Read(CP0Random))
+	})
+	t.Run("Decrement", func(t *testing.T) {
+		cp0 := NewCP0()
+		// 31, 30, ..., 1, 0, 31, ...
+		for want := 30; want >= 0; want-- {
+			cp0.DecrementRandom()
+			assert.Equal(t, uint32(want), cp0.Read(CP0Random))
+		}
+		cp0.DecrementRandom()
+		assert.Equal(t, uint32(31), cp0.Read(CP0Random))
+	})
+	t.Run("WrapAtWired", func(t *testing.T) {
+		cp0 := NewCP0()
+		cp0.Write(CP0Wired, 29)
+		// 31, 30, 29, 31, 30, 29, ...
+		want := []uint32{30, 29, 31, 30, 29, 31}
+		for _, w := range want {
+			cp0.DecrementRandom()
+			assert.Equal(t, w, cp0.Read(CP0Random))
+		}
+	})
+	t.Run("WriteWiredResetsRandom", func(t *testing.T) {
+		cp0 := NewCP0()
+		for i := 0; i < 10; i++ {
+			cp0.DecrementRandom()
+		}
+		assert.Equal(t, uint32(21), cp0.Read(CP0Random))
+		cp0.Write(CP0Wired, 4)
+		assert.Equal(t, uint32(31), cp0.Read(CP0Random))
+	})
+	t.Run("Advance", func(t *testing.T) {
+		for _, wired := range []uint32{0, 29, 40} {
+			for _, n := range []uint64{0, 1, 31, 32, 100, 12345} {
+				want := NewCP0()
+				want.Write(CP0Wired, wired)
+				got := want
+				for i := uint64(0); i < n; i++ {
+					want.DecrementRandom()
+				}
+				got.AdvanceRandom(n)
+				assert.Equal(t, want.Read(CP0Random), got.Read(CP0Random), "wired=%d n=%d", wired, n)
+			}
+		}
+	})
+	t.Run("ReadOnly", func(t *testing.T) {
+		cp0 := NewCP0()
+		cp0.Write(CP0Random, 5)
+		assert.Equal(t, uint32(31), cp0.Read(CP0Random))
+	})
+}
+
+func TestCP0_SetRegisters(t *testing.T) {
+	cp0 := NewCP0()
+	cp0.Write(CP0Wired, 4)
+	for i := 0; i < 3; i++ {
+		cp0.DecrementRandom()
+	}
+	registers := cp0.Registers()
+
+	restored := CP0{}
+	restored.SetRegisters(registers)
+	assert.Equal(t, cp0, restored)
+	assert.Equal(t, uint32(28), restored.Read(CP0Random), "should Random restored without side effects")
+}
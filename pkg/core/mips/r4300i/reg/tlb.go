@@ -0,0 +1,129 @@
+/*
+
+Translation Lookaside Buffer(TLB)
+
+Entry format:
+	PageMask :  | 0 (39 bits) | MASK [24:13] | 0 (13 bits) |
+	EntryHi  :  | R [63:62] | FILL [61:40] | VPN2 [39:13] | 0 [12:8] | ASID [7:0] |
+	EntryLo  :  | 0 [63:26] | PFN [25:6] | C [5:3] | D [2] | V [1] | G [0] |
+
+The G bit of an entry is the logical AND of the G bits of EntryLo0 and EntryLo1.
+
+Reference:
+	- VR4300 User's Manual, Chapter 5 Memory Management System
+*/
+
+package reg
+
+import "n64emu/pkg/types"
+
+const (
+	NumOfTLBEntries = 32
+)
+
+const (
+	tlbPageMaskMask = 0x0000_0000_01ff_e000
+	tlbEntryHiMask  = 0xc000_00ff_ffff_e0ff
+	tlbEntryLoMask  = 0x0000_0000_03ff_fffe
+	tlbVPN2Mask     = 0xc000_00ff_ffff_e000
+	tlbASIDMask     = 0x0000_0000_0000_00ff
+	tlbGlobalBit    = 0x1
+	tlbValidBit     = 0x2
+	tlbDirtyBit     = 0x4
+	tlbPFNMask      = 0x03ff_ffc0
+	tlbPFNShift     = 6
+	tlbMinPageMask  = 0x1fff
+)
+
+// TLBEntry is an entry of TLB
+type TLBEntry struct {
+	PageMask types.DoubleWord
+	EntryHi  types.DoubleWord
+	EntryLo0 types.DoubleWord
+	EntryLo1 types.DoubleWord
+}
+
+// Global returns the G bit of the entry.
+func (e *TLBEntry) Global() bool {
+	return (e.EntryLo0 & e.EntryLo1 & tlbGlobalBit) != 0
+}
+
+// TLB is Translation Lookaside Buffer
+type TLB struct {
+	entries [NumOfTLBEntries]TLBEntry
+}
+
+// Read entry of the TLB.
+func (tlb *TLB) Read(index int) TLBEntry {
+	return tlb.entries[index]
+}
+
+// Write entry in TLB
+// Unused bits are cleared, and the G bit is set in both EntryLo only if it is set in both of them.
+func (tlb *TLB) Write(index int, entry TLBEntry) {
+	global := entry.Global()
+	entry.PageMask &= tlbPageMaskMask
+	entry.EntryHi &= tlbEntryHiMask &^ entry.PageMask
+	entry.EntryLo0 &= tlbEntryLoMask
+	entry.EntryLo1 &= tlbEntryLoMask
+	if global {
+		entry.EntryLo0 |= tlbGlobalBit
+		entry.EntryLo1 |= tlbGlobalBit
+	}
+	tlb.entries[index] = entry
+}
+
+// Probe searches the TLB for an entry that matches VPN2 and ASID of entryHi.
+// Returns index of the matched entry, or false if no entry matches.
+func (tlb *TLB) Probe(entryHi types.DoubleWord) (int, bool) {
+	for i := range tlb.entries {
+		e := &tlb.entries[i]
+		vpnMask := types.DoubleWord(tlbVPN2Mask) &^ e.PageMask
+		if (e.EntryHi & vpnMask) != (entryHi & vpnMask) {
+			continue
+		}
+		if !e.Global() && (e.EntryHi&tlbASIDMask) != (entryHi&tlbASIDMask) {
+			continue
+		}
+		return i, true
+	}
+	return 0, false
+}
+
+// TLBLookupResult is a result of TLB lookup
+type TLBLookupResult struct {
+	// physical address
+	PAddr types.DoubleWord
+	// V bit of the entry
+	Valid bool
+	// D bit of the entry
+	Dirty bool
+}
+
+// Lookup translates virtual address to physical address.
+// Returns false if no entry matches vaddr and asid (TLB miss).
+func (tlb *TLB) Lookup(vaddr types.DoubleWord, asid types.Byte) (TLBLookupResult, bool) {
+	for i := range tlb.entries {
+		e := &tlb.entries[i]
+		vpnMask := types.DoubleWord(tlbVPN2Mask) &^ e.PageMask
+		if (e.EntryHi & vpnMask) != (vaddr & vpnMask) {
+			continue
+		}
+		if !e.Global() && types.Byte(e.EntryHi&tlbASIDMask) != asid {
+			continue
+		}
+		// The bit right above the page offset selects the even or odd page.
+		offsetMask := (e.PageMask | tlbMinPageMask) >> 1
+		entryLo := e.EntryLo0
+		if (vaddr & (offsetMask + 1)) != 0 {
+			entryLo = e.EntryLo1
+		}
+		pfn := ((entryLo & tlbPFNMask) >> tlbPFNShift) << 12
+		return TLBLookupResult{
+			PAddr: (pfn &^ offsetMask) | (vaddr & offsetMask),
+			Valid: (entryLo & tlbValidBit) != 0,
+			Dirty: (entryLo & tlbDirtyBit) != 0,
+		}, true
+	}
+	return TLBLookupResult{}, false
+}
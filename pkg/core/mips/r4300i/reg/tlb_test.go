@@ -0,0 +1,98 @@
+package reg
+
+import (
+	"n64emu/pkg/types"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTLB_WriteRead(t *testing.T) {
+	tlb := TLB{}
+	tlb.Write(3, TLBEntry{
+		PageMask: 0xffff_ffff_ffff_ffff,
+		EntryHi:  0x0000_0000_8000_20ff,
+		EntryLo0: 0xffff_ffff_ffff_ffff,
+		EntryLo1: 0xffff_ffff_ffff_fffe,
+	})
+	got := tlb.Read(3)
+
+	assert.Equal(t, types.DoubleWord(0x01ff_e000), got.PageMask)
+	// VPN2 bits masked by PageMask are cleared
+	assert.Equal(t, types.DoubleWord(0x8000_00ff), got.EntryHi)
+	// G bit is cleared, because EntryLo1 is not global
+	assert.Equal(t, types.DoubleWord(0x03ff_fffe), got.EntryLo0)
+	assert.Equal(t, types.DoubleWord(0x03ff_fffe), got.EntryLo1)
+	assert.False(t, got.Global())
+}
+
+func TestTLB_Probe(t *testing.T) {
+	tlb := TLB{}
+	// ASID=0x01
+	tlb.Write(1, TLBEntry{EntryHi: 0x0040_0001})
+	// global
+	tlb.Write(2, TLBEntry{EntryHi: 0x0080_0001, EntryLo0: 0x1, EntryLo1: 0x1})
+
+	tests := []struct {
+		name      string
+		entryHi   types.DoubleWord
+		wantIndex int
+		wantOk    bool
+	}{
+		{name: "Match", entryHi: 0x0040_0001, wantIndex: 1, wantOk: true},
+		{name: "MatchOddPage", entryHi: 0x0040_1001, wantIndex: 1, wantOk: true},
+		{name: "ASIDMismatch", entryHi: 0x0040_0002, wantOk: false},
+		{name: "Global", entryHi: 0x0080_00ff, wantIndex: 2, wantOk: true},
+		{name: "VPN2Mismatch", entryHi: 0x00c0_0001, wantOk: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			index, ok := tlb.Probe(tt.entryHi)
+			assert.Equal(t, tt.wantOk, ok)
+			if tt.wantOk {
+				assert.Equal(t, tt.wantIndex, index)
+			}
+		})
+	}
+}
+
+func TestTLB_Lookup(t *testing.T) {
+	tlb := TLB{}
+	// 4KB pages, ASID=0x01, 0x00400000 -> 0x00100000(valid, dirty), 0x00401000 -> 0x00200000(valid)
+	tlb.Write(0, TLBEntry{
+		EntryHi:  0x0040_0001,
+		EntryLo0: (0x100 << 6) | 0x6,
+		EntryLo1: (0x200 << 6) | 0x2,
+	})
+	// 16KB pages, global, 0x01000000 -> 0x00300000(invalid), 0x01004000 -> 0x00304000(valid)
+	tlb.Write(1, TLBEntry{
+		PageMask: 0x0000_6000,
+		EntryHi:  0x0100_0000,
+		EntryLo0: (0x300 << 6) | 0x1,
+		EntryLo1: (0x304 << 6) | 0x3,
+	})
+
+	tests := []struct {
+		name  string
+		vaddr types.DoubleWord
+		asid  types.Byte
+		want  TLBLookupResult
+		found bool
+	}{
+		{name: "EvenPage", vaddr: 0x0040_0123, asid: 0x01, want: TLBLookupResult{PAddr: 0x0010_0123, Valid: true, Dirty: true}, found: true},
+		{name: "OddPage", vaddr: 0x0040_1123, asid: 0x01, want: TLBLookupResult{PAddr: 0x0020_0123, Valid: true}, found: true},
+		{name: "ASIDMismatch", vaddr: 0x0040_0123, asid: 0x02, found: false},
+		{name: "InvalidPage", vaddr: 0x0100_3ffc, asid: 0x02, want: TLBLookupResult{PAddr: 0x0030_3ffc}, found: true},
+		{name: "LargeOddPage", vaddr: 0x0100_4010, asid: 0x02, want: TLBLookupResult{PAddr: 0x0030_4010, Valid: true}, found: true},
+		{name: "Miss", vaddr: 0x0200_0000, asid: 0x01, found: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, found := tlb.Lookup(tt.vaddr, tt.asid)
+			assert.Equal(t, tt.found, found)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package trace
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"n64emu/pkg/core/mips/r4300i/cpu"
+	"n64emu/pkg/types"
+)
+
+// BinaryReader reads trace events of binary format.
+type BinaryReader struct {
+	r *bufio.Reader
+}
+
+// NewBinaryReader checks the header, and returns BinaryReader.
+func NewBinaryReader(r io.Reader) (*BinaryReader, error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, len(binaryMagic)+1)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, err
+	}
+	if string(header[:len(binaryMagic)]) != binaryMagic {
+		return nil, errors.New("not a binary trace")
+	}
+	if header[len(binaryMagic)] != binaryVersion {
+		return nil, errors.New("unsupported version of binary trace")
+	}
+	return &BinaryReader{r: br}, nil
+}
+
+// Read reads the next event. Returns io.EOF at the end of the trace.
+func (r *BinaryReader) Read() (*cpu.TraceEvent, error) {
+	head := make([]byte, 15)
+	if _, err := io.ReadFull(r.r, head); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, errors.New("truncated binary trace")
+		}
+		return nil, err
+	}
+	ev := &cpu.TraceEvent{
+		PC:        binary.LittleEndian.Uint64(head[0:8]),
+		Opcode:    binary.LittleEndian.Uint32(head[8:12]),
+		Exception: (head[12] & flagException) != 0,
+	}
+	body := make([]byte, 9*int(head[13])+22*int(head[14]))
+	if _, err := io.ReadFull(r.r, body); err != nil {
+		return nil, errors.New("truncated binary trace")
+	}
+	for i := 0; i < int(head[13]); i++ {
+		b := body[:9]
+		ev.Registers = append(ev.Registers, cpu.RegisterWrite{
+			Register: b[0],
+			Value:    binary.LittleEndian.Uint64(b[1:9]),
+		})
+		body = body[9:]
+	}
+	for i := 0; i < int(head[14]); i++ {
+		b := body[:22]
+		ev.Memory = append(ev.Memory, cpu.MemoryAccess{
+			Kind:  cpu.WatchKind(b[0]),
+			Size:  types.Word(b[1]),
+			VAddr: binary.LittleEndian.Uint64(b[2:10]),
+			PAddr: binary.LittleEndian.Uint32(b[10:14]),
+			Value: binary.LittleEndian.Uint64(b[14:22]),
+		})
+		body = body[22:]
+	}
+	return ev, nil
+}
@@ -0,0 +1,101 @@
+/*
+
+Trace Log Writer
+
+Text format (one line per instruction):
+	| pc | opcode | writes ... | accesses ... |
+
+	e.g. "80001000 27bdffe0 sp=ffffffff801fffe0"
+	     "80001004 8fa80010 t0=0000000000001234 R4@001fffe0=00001234"
+	     "80001008 00850018 lo=0000000000000006"
+	     "8000100c 0000000c exception"
+	     "80001010 00000000 <main+0x10>"
+
+	pc      : hex, 8 digits if it is sign-extended 32-bit address, 16 digits otherwise
+	opcode  : hex, 8 digits
+	writes  : <register>=<64-bit value in hex>, register is ABI name of GPR, "hi" or "lo"
+	accesses: <R|W><size>@<physical address>=<value in hex>
+	exception: the instruction raised an exception, and was canceled
+	symbol  : <function+offset> of pc, if the symbols are set
+
+Binary format (little-endian):
+	header: | "N64T" | version (1 byte) |
+	record: | pc (8) | opcode (4) | flags (1) | num of writes (1) | num of accesses (1) | writes ... | accesses ... |
+	write : | register (1) | value (8) |
+	access: | kind (1) | size (1) | vaddr (8) | paddr (4) | value (8) |
+	flags : bit 0 is exception
+*/
+
+package trace
+
+import (
+	"n64emu/pkg/core/mips/r4300i/cpu"
+	"n64emu/pkg/core/mips/r4300i/reg"
+	"n64emu/pkg/types"
+)
+
+// Filter selects instructions to be written
+type Filter struct {
+	// PC range [Start, End), no limit if both are zero
+	Start types.DoubleWord
+	End   types.DoubleWord
+	// number of instructions skipped before writing
+	Skip uint64
+	// max number of instructions written, no limit if zero
+	Count uint64
+}
+
+// state of the filter
+type filterState struct {
+	Filter
+	skipped uint64
+	written uint64
+}
+
+// accept reports whether the event is written
+func (f *filterState) accept(ev *cpu.TraceEvent) bool {
+	if (f.Start != 0 || f.End != 0) && (ev.PC < f.Start || f.End <= ev.PC) {
+		return false
+	}
+	if f.skipped < f.Skip {
+		f.skipped++
+		return false
+	}
+	if f.Count != 0 && f.written >= f.Count {
+		return false
+	}
+	f.written++
+	return true
+}
+
+// done reports whether no more events are written
+func (f *filterState) done() bool {
+	return f.Count != 0 && f.written >= f.Count
+}
+
+// RegisterName returns name of the register in trace events.
+func RegisterName(index types.Byte) string {
+	switch index {
+	case cpu.TraceRegHI:
+		return "hi"
+	case cpu.TraceRegLO:
+		return "lo"
+	}
+	return reg.GPRNames[index]
+}
+
+// RegisterIndex returns index of the register in trace events, or false if the name is unknown.
+func RegisterIndex(name string) (types.Byte, bool) {
+	switch name {
+	case "hi":
+		return cpu.TraceRegHI, true
+	case "lo":
+		return cpu.TraceRegLO, true
+	}
+	for i, n := range reg.GPRNames {
+		if n == name {
+			return types.Byte(i), true
+		}
+	}
+	return 0, false
+}
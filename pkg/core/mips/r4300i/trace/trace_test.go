@@ -0,0 +1,100 @@
+package trace
+
+import (
+	"bytes"
+	"io"
+	"n64emu/pkg/core/mips/r4300i/cpu"
+	"n64emu/pkg/symbols"
+	"n64emu/pkg/types"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var events = []cpu.TraceEvent{
+	{PC: 0xFFFFFFFF80001000, Opcode: 0x27BDFFE0, Registers: []cpu.RegisterWrite{{Register: 29, Value: 0xFFFFFFFF801FFFE0}}},
+	{
+		PC:        0xFFFFFFFF80001004,
+		Opcode:    0x8FA80010,
+		Registers: []cpu.RegisterWrite{{Register: 8, Value: 0x1234}},
+		Memory:    []cpu.MemoryAccess{{Kind: cpu.WatchRead, VAddr: 0xFFFFFFFF801FFFF0, PAddr: 0x001FFFF0, Size: 4, Value: 0x1234}},
+	},
+	{PC: 0xFFFFFFFF80001008, Opcode: 0x00850018, Registers: []cpu.RegisterWrite{{Register: cpu.TraceRegLO, Value: 6}}},
+	{PC: 0x0000000100000000, Opcode: 0x0000000C, Exception: true},
+}
+
+func writeAll(w *Writer) {
+	for i := range events {
+		w.Hook(&events[i])
+	}
+}
+
+func TestTextWriter(t *testing.T) {
+	buf := bytes.Buffer{}
+	w := NewTextWriter(&buf, Filter{})
+	writeAll(w)
+	assert.NoError(t, w.Flush())
+	assert.Equal(t, ""+
+		"80001000 27bdffe0 sp=ffffffff801fffe0\n"+
+		"80001004 8fa80010 t0=0000000000001234 R4@001ffff0=1234\n"+
+		"80001008 00850018 lo=0000000000000006\n"+
+		"0000000100000000 0000000c exception\n", buf.String())
+}
+
+func TestTextWriter_Symbols(t *testing.T) {
+	buf := bytes.Buffer{}
+	w := NewTextWriter(&buf, Filter{Count: 2})
+	w.SetSymbols(symbols.New([]symbols.Symbol{{Name: "main", Addr: 0xFFFFFFFF80001004}}))
+	writeAll(w)
+	assert.NoError(t, w.Flush())
+	assert.Equal(t, ""+
+		"80001000 27bdffe0 sp=ffffffff801fffe0\n"+
+		"80001004 8fa80010 t0=0000000000001234 R4@001ffff0=1234 <main>\n", buf.String())
+}
+
+func TestBinaryWriter(t *testing.T) {
+	buf := bytes.Buffer{}
+	w := NewBinaryWriter(&buf, Filter{})
+	writeAll(w)
+	assert.NoError(t, w.Flush())
+
+	r, err := NewBinaryReader(&buf)
+	assert.NoError(t, err)
+	for i := range events {
+		ev, err := r.Read()
+		assert.NoError(t, err)
+		assert.Equal(t, events[i], *ev)
+	}
+	_, err = r.Read()
+	assert.Equal(t, io.EOF, err)
+
+	_, err = NewBinaryReader(bytes.NewReader([]byte("N64X\x01")))
+	assert.Error(t, err, "should bad magic be rejected")
+}
+
+func TestFilter(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []types.DoubleWord
+	}{
+		{"none", Filter{}, []types.DoubleWord{0xFFFFFFFF80001000, 0xFFFFFFFF80001004, 0xFFFFFFFF80001008, 0x0000000100000000}},
+		{"range", Filter{Start: 0xFFFFFFFF80001004, End: 0xFFFFFFFF80001008}, []types.DoubleWord{0xFFFFFFFF80001004}},
+		{"skip", Filter{Skip: 3}, []types.DoubleWord{0x0000000100000000}},
+		{"count", Filter{Count: 2}, []types.DoubleWord{0xFFFFFFFF80001000, 0xFFFFFFFF80001004}},
+		{"skip and count", Filter{Skip: 1, Count: 1}, []types.DoubleWord{0xFFFFFFFF80001004}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := filterState{Filter: tt.filter}
+			got := []types.DoubleWord{}
+			for i := range events {
+				if f.accept(&events[i]) {
+					got = append(got, events[i].PC)
+				}
+			}
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.filter.Count != 0, f.done())
+		})
+	}
+}
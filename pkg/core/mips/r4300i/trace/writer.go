@@ -0,0 +1,146 @@
+package trace
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"n64emu/pkg/core/mips/r4300i/cpu"
+	"n64emu/pkg/symbols"
+	"n64emu/pkg/types"
+)
+
+const (
+	binaryMagic   = "N64T"
+	binaryVersion = 1
+	flagException = 0x1
+)
+
+// Writer writes trace events to io.Writer.
+// Pass Hook to CPU.SetTraceHook.
+type Writer struct {
+	w      *bufio.Writer
+	filter filterState
+	encode func(w *bufio.Writer, ev *cpu.TraceEvent) error
+	err    error
+	// symbols of the program, appended to text lines
+	symbols *symbols.Table
+}
+
+// NewTextWriter returns Writer of text format.
+func NewTextWriter(w io.Writer, filter Filter) *Writer {
+	writer := &Writer{
+		w:      bufio.NewWriter(w),
+		filter: filterState{Filter: filter},
+	}
+	writer.encode = writer.encodeText
+	return writer
+}
+
+// NewBinaryWriter returns Writer of binary format.
+func NewBinaryWriter(w io.Writer, filter Filter) *Writer {
+	writer := &Writer{
+		w:      bufio.NewWriter(w),
+		filter: filterState{Filter: filter},
+		encode: encodeBinary,
+	}
+	_, writer.err = writer.w.Write(append([]byte(binaryMagic), binaryVersion))
+	return writer
+}
+
+// Hook writes the event if it passes the filter.
+func (w *Writer) Hook(ev *cpu.TraceEvent) {
+	if w.err != nil || !w.filter.accept(ev) {
+		return
+	}
+	w.err = w.encode(w.w, ev)
+}
+
+// SetSymbols sets symbols of the program. Text lines end with the symbol of pc, if it is found.
+func (w *Writer) SetSymbols(t *symbols.Table) {
+	w.symbols = t
+}
+
+// Done reports whether the instruction count of the filter is reached.
+func (w *Writer) Done() bool {
+	return w.filter.done()
+}
+
+// Flush writes buffered data, and returns the first error occurred.
+func (w *Writer) Flush() error {
+	if w.err != nil {
+		return w.err
+	}
+	return w.w.Flush()
+}
+
+// format address as the disassembler does
+func formatPC(pc types.DoubleWord) string {
+	if pc == types.DoubleWord(types.SWord(pc)) {
+		return fmt.Sprintf("%08x", types.Word(pc))
+	}
+	return fmt.Sprintf("%016x", pc)
+}
+
+// FormatText returns a line of text format without newline.
+func FormatText(ev *cpu.TraceEvent) string {
+	line := formatPC(ev.PC) + fmt.Sprintf(" %08x", ev.Opcode)
+	for _, r := range ev.Registers {
+		line += fmt.Sprintf(" %s=%016x", RegisterName(r.Register), r.Value)
+	}
+	for _, m := range ev.Memory {
+		kind := "R"
+		if m.Kind == cpu.WatchWrite {
+			kind = "W"
+		}
+		line += fmt.Sprintf(" %s%d@%08x=%x", kind, m.Size, m.PAddr, m.Value)
+	}
+	if ev.Exception {
+		line += " exception"
+	}
+	return line
+}
+
+func (w *Writer) encodeText(bw *bufio.Writer, ev *cpu.TraceEvent) error {
+	line := FormatText(ev)
+	if name := w.symbols.Symbolize(ev.PC); name != "" {
+		line += " <" + name + ">"
+	}
+	_, err := bw.WriteString(line + "\n")
+	return err
+}
+
+func encodeBinary(w *bufio.Writer, ev *cpu.TraceEvent) error {
+	var flags types.Byte
+	if ev.Exception {
+		flags |= flagException
+	}
+	buf := make([]byte, 0, 15+9*len(ev.Registers)+22*len(ev.Memory))
+	buf = appendUint64(buf, ev.PC)
+	buf = appendUint32(buf, ev.Opcode)
+	buf = append(buf, flags, types.Byte(len(ev.Registers)), types.Byte(len(ev.Memory)))
+	for _, r := range ev.Registers {
+		buf = append(buf, r.Register)
+		buf = appendUint64(buf, r.Value)
+	}
+	for _, m := range ev.Memory {
+		buf = append(buf, types.Byte(m.Kind), types.Byte(m.Size))
+		buf = appendUint64(buf, m.VAddr)
+		buf = appendUint32(buf, m.PAddr)
+		buf = appendUint64(buf, m.Value)
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+func appendUint32(buf []byte, v types.Word) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, v)
+	return append(buf, b...)
+}
+
+func appendUint64(buf []byte, v types.DoubleWord) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, v)
+	return append(buf, b...)
+}
@@ -0,0 +1,216 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"n64emu/pkg/core/bus"
+	"n64emu/pkg/core/cart"
+	"n64emu/pkg/core/mips/r4300i/cpu"
+	"n64emu/pkg/core/mips/r4300i/reg"
+	"n64emu/pkg/core/pif"
+	"n64emu/pkg/core/ram"
+	"n64emu/pkg/core/sysbus"
+	"n64emu/pkg/symbols"
+	"n64emu/pkg/types"
+)
+
+const (
+	// size of the game code copied by IPL3
+	bootCopySize = 0x10_0000
+	// osMemSize, RDRAM size detected by IPL3
+	osMemSizeAddr = 0x318
+	spDMemBase    = 0x0400_0000
+)
+
+// N64 is the machine, that has CPU and memory.
+type N64 struct {
+	CPU *cpu.CPU
+	RAM *ram.RAM
+	PIF *pif.PIF
+	ROM *cart.ROM // nil if an ELF is loaded
+	ELF *cart.ELF // nil if a ROM is inserted
+	// symbols of the program, for debuggers and crash reports. nil if unknown.
+	Symbols *symbols.Table
+	bus     *sysbus.SysBus
+	watcher *bus.Watcher // nil unless WatchBus is called
+	// functions called after every cycle
+	cycleHooks []func()
+
+	cycles   types.DoubleWord // cycles run since boot
+	events   []event          // scheduled events, in order of the cycle
+	idleSkip bool             // skip idle loops of the CPU until the next event
+
+	expansionPak bool
+	unmapped     sysbus.UnmappedPolicy
+}
+
+// Option configures the machine created by NewN64 or NewN64WithELF.
+type Option func(n *N64)
+
+// WithExpansionPak sets whether Expansion Pak is inserted, that expands RDRAM from 4MB to 8MB.
+// It is inserted by default.
+func WithExpansionPak(inserted bool) Option {
+	return func(n *N64) {
+		n.expansionPak = inserted
+	}
+}
+
+// WithUnmappedPolicy sets what happens on accesses to unmapped addresses. Open bus by default.
+func WithUnmappedPolicy(p sysbus.UnmappedPolicy) Option {
+	return func(n *N64) {
+		n.unmapped = p
+	}
+}
+
+// NewN64 creates the machine with the ROM inserted, and boots it.
+func NewN64(rom *cart.ROM, opts ...Option) *N64 {
+	n := newN64(rom, opts)
+	n.boot()
+	return n
+}
+
+// create the machine connecting CPU, RAM, ROM and PIF with the system bus
+func newN64(rom *cart.ROM, opts []Option) *N64 {
+	n := &N64{
+		ROM:          rom,
+		PIF:          pif.NewPIF(),
+		idleSkip:     true,
+		expansionPak: true,
+	}
+	for _, opt := range opts {
+		opt(n)
+	}
+	n.RAM = ram.NewRAM(n.expansionPak)
+	n.bus = sysbus.New(n.RAM, rom, n.PIF)
+	n.CPU = cpu.NewCPU(n.bus.CPU())
+	n.bus.SetUnmappedPolicy(n.unmapped)
+	n.bus.SetBusErrorHandler(n.CPU.BusError)
+	n.bus.SetUnmappedHandler(n.CPU.UnmappedAccess)
+	return n
+}
+
+// NewN64WithELF creates the machine without ROM, and loads the ELF into RDRAM as IPL3 loads the game code.
+// The segments must be in kseg0 or kseg1, and fit in RDRAM.
+func NewN64WithELF(e *cart.ELF, opts ...Option) (*N64, error) {
+	n := newN64(nil, opts)
+	n.ELF = e
+	n.Symbols = e.Symbols
+	n.setOSMemSize()
+	for _, seg := range e.Segments {
+		if seg.VAddr < 0xFFFF_FFFF_8000_0000 || seg.VAddr >= 0xFFFF_FFFF_C000_0000 {
+			return nil, fmt.Errorf("segment at %016x is not in kseg0 or kseg1", seg.VAddr)
+		}
+		dst := types.DoubleWord(types.Word(seg.VAddr) & 0x1FFF_FFFF)
+		if dst+seg.MemSize > types.DoubleWord(len(n.RAM.RDRAM)) {
+			return nil, fmt.Errorf("segment at %016x does not fit in RDRAM", seg.VAddr)
+		}
+		n.bus.Load(types.Word(dst), seg.Data)
+		// rest of the segment is already zero-filled
+	}
+	n.setBootRegisters(e.Entry)
+	return n, nil
+}
+
+// Run runs the CPU for the cycles, and the events scheduled in them.
+// Idle loops of the CPU are skipped until the next event, unless functions are called on every cycle
+// or the bus is watched, since they observe every iteration.
+// A panic in the emulation is recovered, and returned as *Crash.
+func (n *N64) Run(cycles types.Word) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = n.crash(r)
+		}
+	}()
+	// memory may be written by the host since the last run
+	n.CPU.ResetIdle()
+	end := n.cycles + types.DoubleWord(cycles)
+	for n.cycles < end {
+		n.CPU.Step()
+		n.cycles++
+		for _, hook := range n.cycleHooks {
+			hook()
+		}
+		if len(n.events) > 0 && n.events[0].at <= n.cycles {
+			n.runEvents()
+		}
+		if n.idleSkip && len(n.cycleHooks) == 0 && (n.watcher == nil || !n.watcher.Watching()) {
+			n.skipIdle(end)
+		}
+	}
+	return nil
+}
+
+// OnCycle adds a function called after every cycle of Run, e.g. for profilers.
+func (n *N64) OnCycle(hook func()) {
+	n.cycleHooks = append(n.cycleHooks, hook)
+}
+
+// SetISViewer sets the output of IS-Viewer debug port. The messages are discarded if w is nil.
+func (n *N64) SetISViewer(w io.Writer) {
+	n.bus.SetISViewer(w)
+}
+
+// Bus returns the physical memory of the machine. Accesses through it are not watched,
+// and unmapped ones are not counted nor handled by the policy.
+func (n *N64) Bus() bus.Bus {
+	return n.bus
+}
+
+// WatchBus wraps the bus of the CPU with Watcher, that names the registers, and returns it.
+func (n *N64) WatchBus() *bus.Watcher {
+	if n.watcher == nil {
+		n.watcher = bus.NewWatcher(n.bus.CPU(), n.bus.RegisterName)
+		n.CPU.SetBus(n.watcher)
+	}
+	return n.watcher
+}
+
+// Unmapped returns the counts of unmapped accesses of the CPU by the region of the memory map.
+func (n *N64) Unmapped() []sysbus.UnmappedCount {
+	return n.bus.Unmapped()
+}
+
+// boot emulates the PIF ROM and IPL3 at high level, and leaves the CPU at the entry point of the game.
+// Reference: https://n64brew.dev/wiki/PIF-NUS#Console_startup
+func (n *N64) boot() {
+	// IPL3 runs on SP DMEM, with the header and the boot code
+	n.bus.Load(spDMemBase, n.ROM.Image[:cart.RomHeaderSize])
+
+	n.setOSMemSize()
+
+	// IPL3 copies 1MB of the game code to the entry point
+	entry := types.DoubleWord(types.SWord(n.ROM.ProgramCounter))
+	dst := types.Word(entry) & 0x1FFF_FFFF
+	src := n.ROM.Image[cart.RomHeaderSize:]
+	if len(src) > bootCopySize {
+		src = src[:bootCopySize]
+	}
+	if size := types.Word(len(n.RAM.RDRAM)); dst < size {
+		if len(src) > int(size-dst) {
+			src = src[:size-dst]
+		}
+		n.bus.Load(dst, src)
+	}
+
+	n.setBootRegisters(entry)
+}
+
+// IPL3 detects RDRAM size, and reports it to the game at 0x80000318
+// Reference: https://n64brew.dev/wiki/IPL3
+func (n *N64) setOSMemSize() {
+	n.bus.WriteWord(types.Big, osMemSizeAddr, types.Word(len(n.RAM.RDRAM)))
+}
+
+// set register values left by IPL3, and the entry point
+func (n *N64) setBootRegisters(entry types.DoubleWord) {
+	s := n.CPU.Save()
+	s.GPR[11] = 0xFFFF_FFFF_A400_0040 // t3
+	s.GPR[20] = 0x1                   // s4: TV type, NTSC
+	s.GPR[22] = 0x3F                  // s6: CIC seed
+	s.GPR[29] = 0xFFFF_FFFF_A400_1FF0 // sp
+	s.GPR[31] = 0xFFFF_FFFF_A400_1550 // ra
+	s.CP0[reg.CP0Status] = 0x3400_0000
+	s.CP0[reg.CP0Config] = 0x0006_E463
+	s.PC = entry
+	n.CPU.Load(&s)
+}
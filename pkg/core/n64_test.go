@@ -0,0 +1,352 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"n64emu/pkg/core/bus"
+	"n64emu/pkg/core/cart"
+	"n64emu/pkg/core/mips/r4300i/asm"
+	"n64emu/pkg/core/mips/r4300i/cpu"
+	"n64emu/pkg/core/mips/r4300i/reg"
+	"n64emu/pkg/core/sysbus"
+	"n64emu/pkg/symbols"
+	"n64emu/pkg/types"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestROM(entry types.Word, code []types.Byte) *cart.ROM {
+	image := make([]types.Byte, cart.RomHeaderSize+len(code))
+	binary.BigEndian.PutUint32(image[0:4], cart.RomHeaderBigEndian)
+	binary.BigEndian.PutUint32(image[8:12], entry)
+	binary.BigEndian.PutUint32(image[0x40:0x44], 0x12345678) // boot code
+	copy(image[cart.RomHeaderSize:], code)
+	return &cart.ROM{ProgramCounter: entry, Image: image}
+}
+
+func TestNewN64_Boot(t *testing.T) {
+	assert := assert.New(t)
+
+	code := asm.MustAssemble(0xFFFFFFFF80000400, `
+		addu v0, s4, s6
+		lw   v1, 0(t3)
+	`)
+	n := NewN64(newTestROM(0x80000400, code))
+
+	// game code and boot code are copied
+	assert.Equal(binary.BigEndian.Uint32(code), n.Bus().ReadWord(types.Big, 0x400))
+	assert.Equal(types.Word(cart.RomHeaderBigEndian), n.Bus().ReadWord(types.Big, 0x0400_0000))
+	// ROM is mapped
+	assert.Equal(binary.BigEndian.Uint32(code[4:]), n.Bus().ReadWord(types.Big, 0x1000_1004))
+	// unmapped
+	assert.Equal(types.Word(0), n.Bus().ReadWord(types.Big, 0x0480_0000))
+
+	n.CPU.RunUntil(6)
+	assert.Equal(types.DoubleWord(0x40), n.CPU.GPR(2))
+	// t3 points SP DMEM + 0x40, the boot code
+	assert.Equal(types.DoubleWord(0x12345678), n.CPU.GPR(3))
+}
+
+func TestNewN64WithELF(t *testing.T) {
+	assert := assert.New(t)
+
+	code := asm.MustAssemble(0xFFFFFFFF80001000, "addu v0, s4, s6")
+	e := &cart.ELF{
+		Entry: 0xFFFFFFFF80001000,
+		Segments: []cart.Segment{
+			{VAddr: 0xFFFFFFFF80001000, Data: code, MemSize: 0x10},
+			{VAddr: 0xFFFFFFFFA0002000, Data: []types.Byte{0x12, 0x34, 0x56, 0x78}, MemSize: 4},
+		},
+	}
+	n, err := NewN64WithELF(e)
+	assert.NoError(err)
+	assert.Equal(binary.BigEndian.Uint32(code), n.Bus().ReadWord(types.Big, 0x1000), "should segment placed at physical address")
+	assert.Equal(types.Word(0x12345678), n.Bus().ReadWord(types.Big, 0x2000), "should kseg1 segment placed")
+	assert.Nil(n.ROM)
+
+	n.CPU.RunUntil(6)
+	assert.Equal(types.DoubleWord(0x40), n.CPU.GPR(2), "should run from the entry point")
+
+	_, err = NewN64WithELF(&cart.ELF{Segments: []cart.Segment{{VAddr: 0x00400000, MemSize: 4}}})
+	assert.Error(err, "should mapped segment rejected")
+	_, err = NewN64WithELF(&cart.ELF{Segments: []cart.Segment{{VAddr: 0xFFFFFFFF807FFFF0, MemSize: 0x20}}})
+	assert.Error(err, "should segment beyond RDRAM rejected")
+}
+
+func TestWithExpansionPak(t *testing.T) {
+	assert := assert.New(t)
+
+	rom := newTestROM(0x80000400, asm.MustAssemble(0xFFFFFFFF80000400, "nop"))
+	n := NewN64(rom)
+	assert.Equal(types.Word(0x80_0000), n.Bus().ReadWord(types.Big, 0x318), "should osMemSize reported")
+	n.Bus().WriteWord(types.Big, 0x7F_FFFC, 1)
+	assert.Equal(types.Word(1), n.Bus().ReadWord(types.Big, 0x7F_FFFC))
+
+	n = NewN64(rom, WithExpansionPak(false))
+	assert.Len(n.RAM.RDRAM, 0x40_0000)
+	assert.Equal(types.Word(0x40_0000), n.Bus().ReadWord(types.Big, 0x318))
+	n.Bus().WriteWord(types.Big, 0x40_0000, 1)
+	assert.Equal(types.Word(0), n.Bus().ReadWord(types.Big, 0x40_0000), "should be open bus without Expansion Pak")
+
+	seg := []cart.Segment{{VAddr: 0xFFFFFFFF80400000, MemSize: 4}}
+	n, err := NewN64WithELF(&cart.ELF{Segments: seg})
+	assert.NoError(err)
+	assert.Equal(types.Word(0x80_0000), n.Bus().ReadWord(types.Big, 0x318))
+	_, err = NewN64WithELF(&cart.ELF{Segments: seg}, WithExpansionPak(false))
+	assert.Error(err, "should segment beyond RDRAM rejected")
+}
+
+func TestWithUnmappedPolicy(t *testing.T) {
+	assert := assert.New(t)
+
+	// load from 0xA4900040, unused
+	code := asm.MustAssemble(0xFFFFFFFF80000400, `
+		sll  t0, s4, 23
+		sll  t1, s4, 20
+		nop
+		nop
+		addu t0, t0, t1
+		nop
+		nop
+		addu t0, t3, t0
+		nop
+		nop
+		lw   v1, 0(t0)
+	`)
+	rom := newTestROM(0x80000400, code)
+	n := NewN64(rom)
+	assert.NoError(n.Run(16))
+	assert.Equal(types.DoubleWord(0), n.CPU.GPR(3), "should open bus read")
+	assert.Equal([]sysbus.UnmappedCount{
+		{Region: "Unused", Start: 0x0490_0000, End: 0x04FF_FFFF, Reads: 1, First: 0x0490_0040},
+	}, n.Unmapped())
+
+	n = NewN64(rom, WithUnmappedPolicy(sysbus.BusError))
+	assert.NoError(n.Run(16))
+	s := n.CPU.Save()
+	assert.Equal(types.DoubleWord(cpu.ExcDBE)<<2, s.CP0[reg.CP0Cause]&0x7c, "should Bus Error exception raised")
+	assert.Equal(types.DoubleWord(0xFFFFFFFF80000428), s.CP0[reg.CP0EPC])
+
+	n = NewN64(rom, WithUnmappedPolicy(sysbus.Panic))
+	err := n.Run(16)
+	if crash, ok := err.(*Crash); assert.True(ok, "should panic recovered") {
+		assert.Equal("unmapped read of 4 bytes at 04900040 in Unused", crash.Value)
+	}
+}
+
+func TestWithUnmappedPolicy_Host(t *testing.T) {
+	assert := assert.New(t)
+
+	n := NewN64(newTestROM(0x80000400, nil), WithUnmappedPolicy(sysbus.BusError))
+	assert.Equal(types.Word(0), n.Bus().ReadWord(types.Big, 0x0490_0040))
+	assert.NoError(n.Run(16))
+	s := n.CPU.Save()
+	assert.Equal(types.DoubleWord(0), s.CP0[reg.CP0Status]&0x2, "should no exception raised by the host read")
+	assert.Empty(n.Unmapped(), "should host read not counted")
+}
+
+func TestRun_Crash(t *testing.T) {
+	assert := assert.New(t)
+
+	code := asm.MustAssemble(0xFFFFFFFF80000400, `
+		addu v0, s4, s6
+		lui  t0, 0x8000
+	`)
+	n := NewN64(newTestROM(0x80000400, code))
+	assert.NoError(n.Run(3))
+
+	n.Symbols = symbols.New([]symbols.Symbol{{Name: "main", Addr: 0xFFFFFFFF80000400}})
+	err := n.Run(10)
+	crash, ok := err.(*Crash)
+	if assert.True(ok, "should panic recovered") {
+		assert.Equal("TODO: LUI", crash.Value)
+		assert.Equal(types.DoubleWord(0xFFFFFFFF80000404), crash.PC)
+		assert.Equal(types.Word(0x3c088000), crash.Opcode)
+		assert.Equal("panic at ffffffff80000404 <main+0x4>: TODO: LUI", crash.Error())
+
+		out := &bytes.Buffer{}
+		crash.Report(out)
+		assert.Contains(out.String(), "pc: ffffffff80000404 <main+0x4>\n    3c088000  lui     t0, 0x8000")
+		assert.Contains(out.String(), "  s4 0000000000000001")
+	}
+}
+
+func TestRun_OnCycle(t *testing.T) {
+	assert := assert.New(t)
+
+	code := asm.MustAssemble(0xFFFFFFFF80000400, `
+		addu v0, s4, s6
+		addu v1, s4, s4
+	`)
+	n := NewN64(newTestROM(0x80000400, code))
+	count := 0
+	n.OnCycle(func() { count++ })
+	assert.NoError(n.Run(6))
+	assert.Equal(6, count)
+	assert.Equal(types.DoubleWord(0x40), n.CPU.GPR(2), "should run as RunUntil")
+}
+
+func TestISViewer(t *testing.T) {
+	assert := assert.New(t)
+
+	n := NewN64(newTestROM(0x80000400, nil))
+	out := &bytes.Buffer{}
+	n.SetISViewer(out)
+	n.Bus().WriteWord(types.Big, 0x13FF_0020, 0x6F6B0A00) // "ok\n"
+	n.Bus().WriteWord(types.Big, 0x13FF_0014, 3)
+	assert.Equal("ok\n", out.String())
+	assert.Equal(types.Word(0x6F6B0A00), n.Bus().ReadWord(types.Big, 0x13FF_0020), "should buffer readable")
+
+	n.SetISViewer(nil)
+	n.Bus().WriteWord(types.Big, 0x13FF_0014, 3)
+	assert.Equal("ok\n", out.String(), "should output discarded")
+}
+
+func TestRun_IdleSkip(t *testing.T) {
+	assert := assert.New(t)
+
+	// poll a word in DMEM forever
+	code := asm.MustAssemble(0xFFFFFFFF80000400, `
+		lw   t0, 0(t3)
+		nop
+		lw   t1, 4(t3)
+		jr   t0
+		nop
+	`)
+	run := func(skip bool) *N64 {
+		n := NewN64(newTestROM(0x80000400, code))
+		n.SetIdleSkip(skip)
+		n.Bus().WriteWord(types.Big, 0x0400_0040, 0x80000408)
+		n.Schedule(50_000, func() {
+			n.Bus().WriteWord(types.Big, 0x0400_0044, 0xCAFE)
+		})
+		assert.NoError(n.Run(100_000))
+		assert.NoError(n.Run(12_345))
+		return n
+	}
+	skipped, stepped := run(true), run(false)
+
+	assert.Equal(stepped.CPU.Save(), skipped.CPU.Save(), "should skips invisible")
+	assert.Equal(types.DoubleWord(0xCAFE), skipped.CPU.GPR(9), "should event run")
+	assert.Equal(types.DoubleWord(112_345), skipped.Cycles())
+	assert.Equal(types.DoubleWord(112_345), stepped.Cycles())
+
+	stats := skipped.CPU.IdleStats()
+	assert.Equal(uint64(3), stats.Detected, "should detected before and after the event, and in each run")
+	assert.Equal(uint64(3), stats.Skips)
+	assert.Less(uint64(112_000), stats.SkippedCycles)
+	assert.Equal(cpu.IdleStats{Detected: 3}, stepped.CPU.IdleStats())
+}
+
+func TestRun_IdleSkip_Unmapped(t *testing.T) {
+	assert := assert.New(t)
+
+	// poll 0xA4900040, unused, forever
+	code := asm.MustAssemble(0xFFFFFFFF80000400, `
+		sll  t2, s4, 23
+		sll  t1, s4, 20
+		nop
+		nop
+		addu t2, t2, t1
+		nop
+		nop
+		addu t2, t3, t2
+		lw   t0, 0(t3)
+		lw   t1, 0(t2)
+		nop
+		jr   t0
+		nop
+	`)
+	run := func(skip bool) *N64 {
+		n := NewN64(newTestROM(0x80000400, code))
+		n.SetIdleSkip(skip)
+		n.Bus().WriteWord(types.Big, 0x0400_0040, 0x80000424)
+		assert.NoError(n.Run(100_000))
+		return n
+	}
+	skipped, stepped := run(true), run(false)
+
+	assert.Equal(stepped.Unmapped(), skipped.Unmapped(), "should skips not change the counts")
+	assert.Equal(stepped.CPU.Save(), skipped.CPU.Save())
+	if counts := skipped.Unmapped(); assert.Len(counts, 1) {
+		assert.Less(uint64(20_000), counts[0].Reads, "should every iteration counted")
+	}
+	assert.Equal(uint64(0), skipped.CPU.IdleStats().Skips, "should loop with unmapped accesses not skipped")
+}
+
+func TestSchedule(t *testing.T) {
+	assert := assert.New(t)
+
+	n := NewN64(newTestROM(0x80000400, nil))
+	var called []string
+	n.Schedule(10, func() { called = append(called, "b") })
+	n.Schedule(5, func() {
+		called = append(called, "a")
+		n.Schedule(5, func() { called = append(called, "c") })
+	})
+	n.Schedule(20, func() { called = append(called, "d") })
+
+	assert.NoError(n.Run(9))
+	assert.Equal([]string{"a"}, called)
+	assert.NoError(n.Run(1))
+	assert.Equal([]string{"a", "b", "c"}, called, "should events at the same cycle called in order scheduled")
+}
+
+func TestRun_IdleSkip_NotIdle(t *testing.T) {
+	assert := assert.New(t)
+
+	// count up forever
+	code := asm.MustAssemble(0xFFFFFFFF80000400, `
+		lw   t0, 0(t3)
+		nop
+		addu t1, t1, s4
+		jr   t0
+		nop
+	`)
+	n := NewN64(newTestROM(0x80000400, code))
+	n.Bus().WriteWord(types.Big, 0x0400_0040, 0x80000408)
+	assert.NoError(n.Run(10_000))
+	assert.Equal(cpu.IdleStats{}, n.CPU.IdleStats())
+	assert.Less(uint64(3000), n.CPU.GPR(9))
+}
+
+func TestWatchBus(t *testing.T) {
+	assert := assert.New(t)
+
+	code := asm.MustAssemble(0xFFFFFFFF80000400, "lw v1, 0(t3)")
+	n := NewN64(newTestROM(0x80000400, code))
+	w := n.WatchBus()
+	assert.Same(w, n.WatchBus())
+
+	accesses := []bus.Access{}
+	w.Watch(bus.Filter{Addr: 0x0400_0000, Size: 0x50_0000}, func(a *bus.Access) { accesses = append(accesses, *a) })
+	n.CPU.RunUntil(6)
+	w.WriteWord(types.Big, 0x0440_0004, 0x0010_0000)
+	n.Bus().ReadWord(types.Big, 0x0440_0004)
+
+	assert.Equal([]bus.Access{
+		{Kind: bus.AccessRead, Addr: 0x0400_0040, Size: 4, Value: 0x12345678},
+		{Kind: bus.AccessWrite, Addr: 0x0440_0004, Size: 4, Value: 0x0010_0000, Name: "VI_ORIGIN"},
+	}, accesses, "should CPU accesses watched, and host accesses by Bus not watched")
+}
+
+func TestWatchBus_IdleLoop(t *testing.T) {
+	assert := assert.New(t)
+
+	// poll a word in DMEM forever
+	code := asm.MustAssemble(0xFFFFFFFF80000400, `
+		lw   t0, 0(t3)
+		nop
+		lw   t1, 4(t3)
+		jr   t0
+		nop
+	`)
+	n := NewN64(newTestROM(0x80000400, code))
+	n.Bus().WriteWord(types.Big, 0x0400_0040, 0x80000408)
+	reads := 0
+	n.WatchBus().Watch(bus.Filter{Addr: 0x0400_0044, Size: 4}, func(a *bus.Access) { reads++ })
+	assert.NoError(n.Run(10_000))
+	assert.Equal(uint64(0), n.CPU.IdleStats().Skips, "should idle loop not skipped while the bus is watched")
+	assert.Less(3000, reads, "should every iteration watched")
+}
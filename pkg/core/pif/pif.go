@@ -70,6 +70,16 @@ func NewPIF() *PIF {
 	return pif
 }
 
+// ROM returns PIF Boot ROM, mapped at 0x1FC00000
+func (pif *PIF) ROM() []types.Byte {
+	return pif.rom[:]
+}
+
+// RAM returns PIF RAM, mapped at 0x1FC007C0
+func (pif *PIF) RAM() []types.Byte {
+	return pif.ram[:]
+}
+
 // Emulate PIF Boot ROM
 func (pif *PIF) EmulateBoot() {
 	util.TODO("unimplemented")
@@ -32,13 +32,20 @@ package ram
 
 import "n64emu/pkg/types"
 
+const (
+	// RDRAMSize is the size of RDRAM built in N64
+	RDRAMSize = 0x40_0000
+	// RDRAMSizeExpanded is the size of RDRAM with Expansion Pak
+	RDRAMSizeExpanded = 0x80_0000
+	// MIVersion is MI_VERSION of the retail RCP: RSP 2, RDP 2, RAC 1 and IO 2
+	MIVersion = 0x0202_0102
+)
+
 // RAM N64 memory map
 type RAM struct {
 	// 0x00000000 to 0x003FFFFF R/W RDRAM range 0 (4MB)
-	RDRAM0 [0x400000]types.Byte
-
 	// 0x00400000 to 0x007FFFFF R/W RDRAM range 1 (4MB) Red Expansion PAK
-	RDRAM1 [0x400000]types.Byte
+	RDRAM []types.Byte
 
 	// 0x00800000 to 0x03EFFFFF  * Unused
 
@@ -58,6 +65,27 @@ type RAM struct {
 	// external SysAD device(0x80000000 to 0xFFFFFFFF) is effectively mirror of lower addresses
 }
 
+// NewRAM returns RAM with 4MB RDRAM, or 8MB if Expansion Pak is inserted.
+func NewRAM(expansionPak bool) *RAM {
+	size := RDRAMSize
+	if expansionPak {
+		size = RDRAMSizeExpanded
+	}
+	return &RAM{
+		RDRAM: make([]types.Byte, size),
+		MIReg: MIReg{
+			Version: MIVersion,
+		},
+		CartDomain1: CartDomain1{
+			Address1: make([]types.Byte, 0x200_0000),
+		},
+		CartDomain2: CartDomain2{
+			Address1: make([]types.Byte, 0x100_0000),
+			Address2: make([]types.Byte, 0x800_0000),
+		},
+	}
+}
+
 // RDRAMReg RDRAM Registers 0x03F00000 to 0x03FFFFFF
 type RDRAMReg struct {
 	// 0x03F00000 to 0x03F00003 R/W RDRAM_CONFIG_REG or RDRAM_DEVICE_TYPE_REG
@@ -151,9 +179,20 @@ type DPSpanReg struct {
 
 // MIReg MIPS Interface (MI) Registers 0x04300000 to 0x043FFFFF
 type MIReg struct {
+	// 0x04300000 to 0x04300003 R/W MI_MODE, or MI_INIT_MODE
+	// (R): [6:0] init length, [7] init mode, [8] ebus test mode, [9] RDRAM reg mode
+	// (W): [6:0] init length, [7] clear init mode, [8] set init mode, [9] clear ebus test mode,
+	//      [10] set ebus test mode, [11] clear DP interrupt, [12] clear RDRAM reg mode, [13] set RDRAM reg mode
 	InitMode types.Word
-	Version  types.Word
-	Intr     types.Word
+
+	// 0x04300004 to 0x04300007 R MI_VERSION
+	// [7:0] IO version, [15:8] RAC version, [23:16] RDP version, [31:24] RSP version
+	Version types.Word
+
+	// 0x04300008 to 0x0430000B R MI_INTR
+	Intr types.Word
+
+	// 0x0430000C to 0x0430000F R/W MI_INTR_MASK
 	IntrMask types.Word
 }
 
@@ -179,6 +218,7 @@ type VIReg struct {
 type AIReg struct {
 	DramAddr types.Word
 	Len      types.Word
+	Control  types.Word
 	Status   types.Word
 	DACRate  types.Word
 	BitRate  types.Word
@@ -206,6 +246,7 @@ type RIReg struct {
 	Mode    types.Word
 	Config  types.Word
 	Current types.Word
+	Select  types.Word
 	Refresh types.Word
 	Latency types.Word
 	RdError types.Word
@@ -223,40 +264,9 @@ type SIReg struct {
 // CartDomain1 Cartridge Domain 1
 type CartDomain1 struct {
 	// 0x06000000 to 0x07FFFFFF This address seems to be where the n64ddrive would be addressed
-	Address1 [0x2000000]types.Byte
-
-	// 0x10000000 to 0x1FBFFFFF
-	Address2 struct {
-		// 0x10000000 to 0x1000003F ROM header
-		// NOTE: not define ROM header because ROM header info is in rom.ROM struct
+	Address1 []types.Byte
 
-		// 0x10000040 to 0x10000B6F
-		RAMROMBootstrapOffset [0xb30]types.Byte
-
-		// 0x10000B70 to 0x10000FEF
-		RAMROMFontDataOffset [0x480]types.Byte
-
-		// 0x10001000 to 0x10FF9FFF
-		RAMROMGameOffset [0xff9000]types.Byte
-
-		// 0x10FFA000 to 0x10FFAFFF
-		RAMROMAppReadAddr [0x1000]types.Byte
-
-		// 0x10FFB000 to 0x10FFBFFF
-		RAMROMAppWriteAddr [0x1000]types.Byte
-
-		// 0x10FFC000 to 0x10FFCFFF
-		RAMROMRmonReadAddr [0x1000]types.Byte
-
-		// 0x10FFD000 to 0x10FFDFFF
-		RAMROMRmonWriteAddr [0x1000]types.Byte
-
-		// 0x10FFE000 to 0x10FFEFFF
-		RAMROMPrintfAddr [0x1000]types.Byte
-
-		// 0x10FFF000 to 0x10FFFFFF
-		RAMROMLogAddr [0x1000]types.Byte
-	}
+	// Address2 0x10000000 to 0x1FBFFFFF is cartridge ROM, see cart.ROM
 
 	// Address3 0x1FD00000 to 0x7FFFFFFF Unknown
 }
@@ -264,8 +274,8 @@ type CartDomain1 struct {
 // CartDomain2 Cartridge Domain 2
 type CartDomain2 struct {
 	// 0x05000000 to 0x05FFFFFF
-	Address1 [0x1000000]types.Byte
+	Address1 []types.Byte
 
 	// 0x08000000 to 0x0FFFFFFF SRAM could be here
-	Address2 [0x8000000]types.Byte
+	Address2 []types.Byte
 }
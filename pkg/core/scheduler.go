@@ -0,0 +1,58 @@
+package core
+
+import (
+	"n64emu/pkg/types"
+	"sort"
+)
+
+// event is a function called at the cycle, e.g. VI interrupt or completion of DMA
+type event struct {
+	at types.DoubleWord
+	fn func()
+}
+
+// Schedule calls fn after the cycles from now, in Run.
+// Events at the same cycle are called in the order scheduled.
+func (n *N64) Schedule(cycles types.DoubleWord, fn func()) {
+	at := n.cycles + cycles
+	i := sort.Search(len(n.events), func(i int) bool { return n.events[i].at > at })
+	n.events = append(n.events, event{})
+	copy(n.events[i+1:], n.events[i:])
+	n.events[i] = event{at: at, fn: fn}
+}
+
+// Cycles returns the cycles run since boot, including the ones skipped in idle loops.
+func (n *N64) Cycles() types.DoubleWord {
+	return n.cycles
+}
+
+// SetIdleSkip enables or disables skipping idle loops, enabled by default.
+func (n *N64) SetIdleSkip(enabled bool) {
+	n.idleSkip = enabled
+}
+
+// call the events due
+func (n *N64) runEvents() {
+	for len(n.events) > 0 && n.events[0].at <= n.cycles {
+		e := n.events[0]
+		n.events = n.events[1:]
+		e.fn()
+	}
+	// the events may change memory read by the loop
+	n.CPU.ResetIdle()
+}
+
+// skip the idle loop detected in the last cycle, by whole iterations until the next event or the end
+func (n *N64) skipIdle(end types.DoubleWord) {
+	period, ok := n.CPU.IdleLoop()
+	if !ok {
+		return
+	}
+	if len(n.events) > 0 && n.events[0].at < end {
+		end = n.events[0].at
+	}
+	if skip := (end - n.cycles) / period * period; skip > 0 {
+		n.CPU.SkipIdle(skip)
+		n.cycles += skip
+	}
+}
@@ -0,0 +1,41 @@
+package sysbus
+
+import (
+	"io"
+	"n64emu/pkg/types"
+)
+
+// IS-Viewer 64 debug port, used by homebrew and test ROMs to print messages.
+// Writing the length flushes the buffer.
+const (
+	isViewerBase   = 0x13FF_0000
+	isViewerLength = 0x14 // offset of the length register
+	isViewerBuffer = 0x20 // offset of the buffer
+	isViewerSize   = 0x1_0000
+)
+
+func (b *SysBus) mapISViewer() {
+	r := b.mapBytes(isViewerBase, b.isViewer[:])
+	r.written = func(offset types.Word, size int) {
+		if offset == isViewerLength && size == 4 {
+			b.flushISViewer(types.Word(b.isViewer[offset])<<24 | types.Word(b.isViewer[offset+1])<<16 |
+				types.Word(b.isViewer[offset+2])<<8 | types.Word(b.isViewer[offset+3]))
+		}
+	}
+}
+
+// SetISViewer sets the output of IS-Viewer debug port. The messages are discarded if w is nil.
+func (b *SysBus) SetISViewer(w io.Writer) {
+	b.isViewerOutput = w
+}
+
+// write the message in IS-Viewer buffer to the output
+func (b *SysBus) flushISViewer(length types.Word) {
+	if b.isViewerOutput == nil {
+		return
+	}
+	if max := types.Word(isViewerSize - isViewerBuffer); length > max {
+		length = max
+	}
+	b.isViewerOutput.Write(b.isViewer[isViewerBuffer : isViewerBuffer+length])
+}
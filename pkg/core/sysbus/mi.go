@@ -0,0 +1,84 @@
+package sysbus
+
+import "n64emu/pkg/types"
+
+// MIPS Interface. MI_MODE is read as the modes, and written with commands to set or clear them.
+// In init mode, the next write to RDRAM is repeated for (init length + 1) bytes, then init mode ends.
+// IPL3 uses it to fill RDRAM quickly. Ebus test mode and RDRAM reg mode are only kept as the state.
+// Reference:
+// - https://web.archive.org/web/20200429103221/http://en64.shoutwiki.com/wiki/Memory_map_detailed
+// - https://n64brew.dev/wiki/MIPS_Interface
+const (
+	miInitLength = 0x7F
+
+	// MI_MODE read
+	miInitMode     = 1 << 7
+	miEbusTestMode = 1 << 8
+	miRDRAMRegMode = 1 << 9
+
+	// MI_MODE write
+	miClearInitMode     = 1 << 7
+	miSetInitMode       = 1 << 8
+	miClearEbusTestMode = 1 << 9
+	miSetEbusTestMode   = 1 << 10
+	miClearDPIntr       = 1 << 11
+	miClearRDRAMRegMode = 1 << 12
+	miSetRDRAMRegMode   = 1 << 13
+
+	// MI_INTR
+	miIntrDP = 1 << 5
+)
+
+// MI_MODE register, written with the commands
+func (b *SysBus) miMode() *Register {
+	r := reg("MI_MODE", &b.ram.MIReg.InitMode, readOnly)
+	r.Write = b.writeMIMode
+	return r
+}
+
+func (b *SysBus) writeMIMode(data, mask types.Word) {
+	data &= mask
+	mode := b.ram.MIReg.InitMode
+	mode = mode&^(miInitLength&mask) | data&miInitLength
+	mode = command(mode, data, miClearInitMode, miSetInitMode, miInitMode)
+	mode = command(mode, data, miClearEbusTestMode, miSetEbusTestMode, miEbusTestMode)
+	mode = command(mode, data, miClearRDRAMRegMode, miSetRDRAMRegMode, miRDRAMRegMode)
+	b.ram.MIReg.InitMode = mode
+	if data&miClearDPIntr != 0 {
+		b.ram.MIReg.Intr &^= miIntrDP
+	}
+	b.setInitMode(mode&miInitMode != 0)
+}
+
+// clear and set the bit of the mode by the command, set has priority
+func command(mode, data, clear, set, bit types.Word) types.Word {
+	if data&clear != 0 {
+		mode &^= bit
+	}
+	if data&set != 0 {
+		mode |= bit
+	}
+	return mode
+}
+
+// writes to RDRAM take the slow path in init mode, to be repeated
+func (b *SysBus) setInitMode(on bool) {
+	b.rdram.written = nil
+	if on {
+		b.rdram.written = b.repeatWrite
+	}
+	for addr := b.rdram.start; addr < b.rdram.end; addr += 1 << pageShift {
+		b.pages[addr>>pageShift].writable = !on
+	}
+}
+
+// repeat the bytes written to RDRAM for (init length + 1) bytes, and end init mode
+func (b *SysBus) repeatWrite(offset types.Word, size int) {
+	mem := b.rdram.mem[offset:]
+	length := int(b.ram.MIReg.InitMode&miInitLength) + 1
+	for i := size; i < length && i < len(mem); i++ {
+		mem[i] = mem[i%size]
+	}
+	b.ram.MIReg.InitMode &^= miInitMode
+	b.setInitMode(false)
+}
@@ -0,0 +1,98 @@
+package sysbus
+
+import (
+	"n64emu/pkg/types"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMI_Mode(t *testing.T) {
+	// bit layouts of MI_MODE, written with the commands and read as the modes
+	tests := []struct {
+		name  string
+		init  types.Word
+		write types.Word
+		want  types.Word
+	}{
+		{name: "init length", write: 0x7F, want: 0x7F},
+		{name: "init length replaced", init: 0x7F, write: 0x0F, want: 0x0F},
+		{name: "set init mode", write: 1 << 8, want: 1 << 7},
+		{name: "clear init mode", init: 1 << 7, write: 1 << 7, want: 0},
+		{name: "set ebus test mode", write: 1 << 10, want: 1 << 8},
+		{name: "clear ebus test mode", init: 1 << 8, write: 1 << 9, want: 0},
+		{name: "set RDRAM reg mode", write: 1 << 13, want: 1 << 9},
+		{name: "clear RDRAM reg mode", init: 1 << 9, write: 1 << 12, want: 0},
+		{name: "set has priority", write: 1<<7 | 1<<8, want: 1 << 7},
+		{name: "modes kept", init: 1<<8 | 1<<9, write: 0x10, want: 1<<8 | 1<<9 | 0x10},
+		{name: "IPL3", write: 0x10F, want: 0x8F},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, r, _ := newTestBus()
+			r.MIReg.InitMode = tt.init
+			b.WriteWord(types.Big, 0x0430_0000, tt.write)
+			assert.Equal(t, tt.want, b.ReadWord(types.Big, 0x0430_0000))
+		})
+	}
+}
+
+func TestMI_ClearDPInterrupt(t *testing.T) {
+	assert := assert.New(t)
+	b, r, _ := newTestBus()
+
+	r.MIReg.Intr = 0x3F
+	b.WriteWord(types.Big, 0x0430_0000, 1<<11)
+	assert.Equal(types.Word(0x1F), r.MIReg.Intr, "should DP interrupt cleared")
+	assert.Equal(types.Word(0), r.MIReg.InitMode)
+}
+
+func TestMI_InitMode(t *testing.T) {
+	assert := assert.New(t)
+	b, r, _ := newTestBus()
+
+	// repeated for 16 bytes
+	b.WriteWord(types.Big, 0x0430_0000, 1<<8|0x0F)
+	b.WriteWord(types.Big, 0x0000_1000, 0x1234_5678)
+	for addr := types.Word(0x1000); addr < 0x1010; addr += 4 {
+		assert.Equal(types.Word(0x1234_5678), b.ReadWord(types.Big, addr), "%08x", addr)
+	}
+	assert.Equal(types.Word(0), b.ReadWord(types.Big, 0x1010))
+	assert.Equal(types.Word(0x0F), r.MIReg.InitMode, "should init mode end after the write")
+
+	// only the next write
+	b.WriteWord(types.Big, 0x0000_2000, 0xFFFF_FFFF)
+	assert.Equal(types.Word(0), b.ReadWord(types.Big, 0x2004))
+
+	// halfword, the whole init length
+	b.WriteWord(types.Big, 0x0430_0000, 1<<8|0x7F)
+	b.WriteHalfWord(types.Big, 0x0000_3000, 0xABCD)
+	assert.Equal(types.DoubleWord(0xABCD_ABCD_ABCD_ABCD), b.ReadDoubleWord(types.Big, 0x3078))
+	assert.Equal(types.Word(0), b.ReadWord(types.Big, 0x3080))
+
+	// not past the end of RDRAM
+	b.WriteWord(types.Big, 0x0430_0000, 1<<8|0x7F)
+	b.WriteWord(types.Big, types.Word(len(r.RDRAM)-4), 0xCAFE_CAFE)
+	assert.Equal(types.Word(0xCAFE_CAFE), b.ReadWord(types.Big, types.Word(len(r.RDRAM)-4)))
+
+	// other memory is not repeated
+	b.WriteWord(types.Big, 0x0430_0000, 1<<8|0x0F)
+	b.WriteWord(types.Big, 0x0400_0000, 0x1234_5678)
+	assert.Equal(types.Word(0), b.ReadWord(types.Big, 0x0400_0004))
+	assert.Equal(types.Word(0x8F), r.MIReg.InitMode)
+	b.WriteWord(types.Big, 0x0430_0000, 1<<7)
+	b.WriteWord(types.Big, 0x0000_4000, 0x1234_5678)
+	assert.Equal(types.Word(0), b.ReadWord(types.Big, 0x4004), "should cleared by the command")
+}
+
+func TestMI_Version(t *testing.T) {
+	assert := assert.New(t)
+	b, _, _ := newTestBus()
+
+	v := b.ReadWord(types.Big, 0x0430_0004)
+	assert.Equal(types.Word(0x02), v>>24, "RSP")
+	assert.Equal(types.Word(0x02), v>>16&0xFF, "RDP")
+	assert.Equal(types.Word(0x01), v>>8&0xFF, "RAC")
+	assert.Equal(types.Word(0x02), v&0xFF, "IO")
+	assert.Equal("MI_VERSION", b.RegisterName(0x0430_0004))
+}
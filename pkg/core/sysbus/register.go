@@ -0,0 +1,74 @@
+package sysbus
+
+import "n64emu/pkg/types"
+
+// Register is a memory-mapped register of the RCP.
+// By default a read returns the value, and a write changes the bits of WriteMask, and clears the bits of ClearMask
+// written as 1. Other bits are read only.
+// Devices replace the default with Read and Write handlers, or attach DMA and interrupts to writes with OnWrite.
+type Register struct {
+	Name  string      // e.g. "PI_WR_LEN"
+	Value *types.Word // field of ram.RAM
+
+	WriteMask types.Word // bits written as is
+	ClearMask types.Word // bits cleared by writing 1
+
+	// read handler, that returns the value instead of Value
+	Read func() types.Word
+	// write handler, instead of the default. mask has the bits of the bytes written.
+	Write func(data, mask types.Word)
+
+	hooks []func(data types.Word)
+}
+
+// OnWrite adds a function called after every write to the register, with the data written.
+func (r *Register) OnWrite(hook func(data types.Word)) {
+	r.hooks = append(r.hooks, hook)
+}
+
+// Register returns the register at the physical address, or nil if no register is there.
+func (b *SysBus) Register(addr types.Word) *Register {
+	r := b.lookup(addr, 4)
+	if r == nil || r.regs == nil {
+		return nil
+	}
+	return r.regs[(addr-r.start)>>2]
+}
+
+// RegisterName returns the name of the register containing the physical address, or empty if no register is there.
+func (b *SysBus) RegisterName(addr types.Word) string {
+	if r := b.Register(addr &^ 3); r != nil {
+		return r.Name
+	}
+	return ""
+}
+
+// read the register, 0 if it is a hole
+func (r *Register) read() types.Word {
+	switch {
+	case r == nil:
+		return 0
+	case r.Read != nil:
+		return r.Read()
+	case r.Value != nil:
+		return *r.Value
+	}
+	return 0
+}
+
+// write the bytes of mask to the register
+func (r *Register) write(data, mask types.Word) {
+	switch {
+	case r == nil:
+		return
+	case r.Write != nil:
+		r.Write(data, mask)
+	case r.Value != nil:
+		w := r.WriteMask & mask
+		*r.Value = *r.Value&^w | data&w
+		*r.Value &^= data & r.ClearMask & mask
+	}
+	for _, hook := range r.hooks {
+		hook(data)
+	}
+}
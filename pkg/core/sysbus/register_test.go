@@ -0,0 +1,69 @@
+package sysbus
+
+import (
+	"n64emu/pkg/types"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegister_Masks(t *testing.T) {
+	assert := assert.New(t)
+	b, r, _ := newTestBus()
+
+	// write mask
+	b.WriteWord(types.Big, 0x0460_0000, 0xFFFF_FFFF)
+	assert.Equal(types.Word(0x00FF_FFFE), r.PIReg.DramAddr)
+	// read only
+	r.MIReg.Version = 0x0202_0102
+	b.WriteWord(types.Big, 0x0430_0004, 0)
+	assert.Equal(types.Word(0x0202_0102), r.MIReg.Version)
+	// byte writes keep the other bytes
+	b.WriteByte(types.Big, 0x0460_0003, 0x00)
+	assert.Equal(types.Word(0x00FF_FF00), r.PIReg.DramAddr)
+
+	// write 1 to clear
+	reg := b.Register(0x0460_0000)
+	reg.WriteMask, reg.ClearMask = 0xFF00, 0xFF
+	r.PIReg.DramAddr = 0xFF
+	b.WriteWord(types.Big, 0x0460_0000, 0x1234_5681)
+	assert.Equal(types.Word(0x567E), r.PIReg.DramAddr)
+	// only the bytes written
+	b.WriteByte(types.Little, 0x0460_0001, 0xFF)
+	assert.Equal(types.Word(0xFF7E), r.PIReg.DramAddr)
+}
+
+func TestRegister_Handlers(t *testing.T) {
+	assert := assert.New(t)
+	b, r, _ := newTestBus()
+
+	assert.Nil(b.Register(0x0000_0000))
+	assert.Nil(b.Register(0x0480_0008))
+	wrLen := b.Register(0x0460_000C)
+	assert.Equal("PI_WR_LEN", wrLen.Name)
+	assert.Equal("VI_ORIGIN", b.RegisterName(0x0440_0006))
+	assert.Equal("", b.RegisterName(0x0000_0004))
+
+	// hooks are called after the write
+	var lens []types.Word
+	wrLen.OnWrite(func(data types.Word) { lens = append(lens, r.PIReg.WrLen) })
+	b.WriteWord(types.Big, 0x0460_000C, 0xFF00_0FFF)
+	assert.Equal([]types.Word{0x0FFF}, lens)
+
+	// handlers replace the value
+	counter := types.Word(0)
+	vi := b.Register(0x0440_0010)
+	vi.Read = func() types.Word { counter++; return counter }
+	assert.Equal(types.Word(1), b.ReadWord(types.Big, 0x0440_0010))
+	assert.Equal(types.Word(2), b.ReadWord(types.Big, 0x0440_0010))
+
+	var data, mask types.Word
+	vi.Write = func(d, m types.Word) { data, mask = d, m }
+	b.WriteHalfWord(types.Big, 0x0440_0012, 0x1234)
+	assert.Equal(types.Word(0x1234), data)
+	assert.Equal(types.Word(0xFFFF), mask)
+	b.WriteByte(types.Little, 0x0440_0011, 0x56)
+	assert.Equal(types.Word(0x5600), data)
+	assert.Equal(types.Word(0xFF00), mask)
+	assert.Equal(types.Word(0), r.VIReg.Current)
+}
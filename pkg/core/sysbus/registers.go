@@ -0,0 +1,133 @@
+package sysbus
+
+import "n64emu/pkg/types"
+
+// Write masks of the registers. Registers written with commands (e.g. SP_STATUS, MI_INTR_MASK) or to acknowledge
+// interrupts (e.g. SI_STATUS) are read only by default, and the devices handle the writes.
+// Reference:
+// - https://web.archive.org/web/20200429103221/http://en64.shoutwiki.com/wiki/Memory_map_detailed
+// - https://n64brew.dev/wiki/Memory_map
+const (
+	readOnly  = 0
+	readWrite = 0xFFFF_FFFF
+	addr24    = 0x00FF_FFFF // RDRAM address
+)
+
+func reg(name string, value *types.Word, writeMask types.Word) *Register {
+	return &Register{Name: name, Value: value, WriteMask: writeMask}
+}
+
+func (b *SysBus) mapRegisters() {
+	r := b.ram
+	b.mapRegs(0x03F0_0000,
+		reg("RDRAM_CONFIG", &r.RDRAMReg.Config, readWrite),
+		reg("RDRAM_DEVICE_ID", &r.RDRAMReg.DeviceID, readWrite),
+		reg("RDRAM_DELAY", &r.RDRAMReg.Delay, readWrite),
+		reg("RDRAM_MODE", &r.RDRAMReg.Mode, readWrite),
+		reg("RDRAM_REF_INTERVAL", &r.RDRAMReg.RefInterval, readWrite),
+		reg("RDRAM_REF_ROW", &r.RDRAMReg.RefRow, readWrite),
+		reg("RDRAM_RAS_INTERVAL", &r.RDRAMReg.RasInterval, readWrite),
+		reg("RDRAM_MIN_INTERVAL", &r.RDRAMReg.MinInterval, readWrite),
+		reg("RDRAM_ADDR_SELECT", &r.RDRAMReg.AddrSelect, readWrite),
+		reg("RDRAM_DEVICE_MANUF", &r.RDRAMReg.DeviceManuf, readWrite),
+	)
+	b.mapRegs(0x0404_0000,
+		reg("SP_MEM_ADDR", &r.SPReg.MemAddr, 0x1FFF),
+		reg("SP_DRAM_ADDR", &r.SPReg.DramAddr, addr24),
+		reg("SP_RD_LEN", &r.SPReg.RdLen, 0xFFF8_FFFF),
+		reg("SP_WR_LEN", &r.SPReg.WrLen, 0xFFF8_FFFF),
+		reg("SP_STATUS", &r.SPReg.Status, readOnly),
+		reg("SP_DMA_FULL", &r.SPReg.DMAFull, readOnly),
+		reg("SP_DMA_BUSY", &r.SPReg.DMABusy, readOnly),
+		reg("SP_SEMAPHORE", &r.SPReg.Semaphore, 0x1),
+	)
+	b.mapRegs(0x0408_0000,
+		reg("SP_PC", &r.SPReg.PC, 0xFFC),
+		reg("SP_IBIST", &r.SPReg.IBist, 0x7),
+	)
+	b.mapRegs(0x0410_0000,
+		reg("DPC_START", &r.DPCommandReg.Start, addr24),
+		reg("DPC_END", &r.DPCommandReg.End, addr24),
+		reg("DPC_CURRENT", &r.DPCommandReg.Current, readOnly),
+		reg("DPC_STATUS", &r.DPCommandReg.Status, readOnly),
+		reg("DPC_CLOCK", &r.DPCommandReg.Clock, readOnly),
+		reg("DPC_BUFBUSY", &r.DPCommandReg.BufBusy, readOnly),
+		reg("DPC_PIPEBUSY", &r.DPCommandReg.PipeBusy, readOnly),
+		reg("DPC_TMEM", &r.DPCommandReg.TMem, readOnly),
+	)
+	b.mapRegs(0x0420_0000,
+		reg("DPS_TBIST", &r.DPSpanReg.TBist, 0x7),
+		reg("DPS_TEST_MODE", &r.DPSpanReg.TestMode, 0x1),
+		reg("DPS_BUFTEST_ADDR", &r.DPSpanReg.BufTestAddr, 0x7F),
+		reg("DPS_BUFTEST_DATA", &r.DPSpanReg.BufTestData, readWrite),
+	)
+	b.mapRegs(0x0430_0000,
+		b.miMode(),
+		reg("MI_VERSION", &r.MIReg.Version, readOnly),
+		reg("MI_INTR", &r.MIReg.Intr, readOnly),
+		reg("MI_INTR_MASK", &r.MIReg.IntrMask, readOnly),
+	)
+	b.mapRegs(0x0440_0000,
+		reg("VI_STATUS", &r.VIReg.Status, 0xFFFF),
+		reg("VI_ORIGIN", &r.VIReg.Origin, addr24),
+		reg("VI_WIDTH", &r.VIReg.Width, 0xFFF),
+		reg("VI_INTR", &r.VIReg.Intr, 0x3FF),
+		reg("VI_CURRENT", &r.VIReg.Current, readOnly),
+		reg("VI_BURST", &r.VIReg.Burst, 0x3FFF_FFFF),
+		reg("VI_V_SYNC", &r.VIReg.VSync, 0x3FF),
+		reg("VI_H_SYNC", &r.VIReg.HSync, 0x1F_FFFF),
+		reg("VI_LEAP", &r.VIReg.Leap, 0x0FFF_0FFF),
+		reg("VI_H_START", &r.VIReg.HStart, 0x03FF_03FF),
+		reg("VI_V_START", &r.VIReg.VStart, 0x03FF_03FF),
+		reg("VI_V_BURST", &r.VIReg.VBurst, 0x03FF_03FF),
+		reg("VI_X_SCALE", &r.VIReg.XScale, 0x0FFF_0FFF),
+		reg("VI_Y_SCALE", &r.VIReg.YSCale, 0x0FFF_0FFF),
+	)
+	b.mapRegs(0x0450_0000,
+		reg("AI_DRAM_ADDR", &r.AI.DramAddr, 0xFF_FFF8),
+		reg("AI_LEN", &r.AI.Len, 0x3_FFF8),
+		reg("AI_CONTROL", &r.AI.Control, 0x1),
+		reg("AI_STATUS", &r.AI.Status, readOnly),
+		reg("AI_DACRATE", &r.AI.DACRate, 0x3FFF),
+		reg("AI_BITRATE", &r.AI.BitRate, 0xF),
+	)
+	b.mapRegs(0x0460_0000,
+		reg("PI_DRAM_ADDR", &r.PIReg.DramAddr, 0xFF_FFFE),
+		reg("PI_CART_ADDR", &r.PIReg.CartAddr, 0xFFFF_FFFE),
+		reg("PI_RD_LEN", &r.PIReg.RdLen, addr24),
+		reg("PI_WR_LEN", &r.PIReg.WrLen, addr24),
+		reg("PI_STATUS", &r.PIReg.Status, readOnly),
+		reg("PI_BSD_DOM1_LAT", &r.PIReg.Dom1Lat, 0xFF),
+		reg("PI_BSD_DOM1_PWD", &r.PIReg.Dom1Pwd, 0xFF),
+		reg("PI_BSD_DOM1_PGS", &r.PIReg.Dom1Pgs, 0xF),
+		reg("PI_BSD_DOM1_RLS", &r.PIReg.Dom1Rls, 0x3),
+		reg("PI_BSD_DOM2_LAT", &r.PIReg.Dom2Lat, 0xFF),
+		reg("PI_BSD_DOM2_PWD", &r.PIReg.Dom2Pwd, 0xFF),
+		reg("PI_BSD_DOM2_PGS", &r.PIReg.Dom2Pgs, 0xF),
+		reg("PI_BSD_DOM2_RLS", &r.PIReg.Dom2Rls, 0x3),
+	)
+	b.mapRegs(0x0470_0000,
+		reg("RI_MODE", &r.RIReg.Mode, 0xF),
+		reg("RI_CONFIG", &r.RIReg.Config, 0x7F),
+		reg("RI_CURRENT_LOAD", &r.RIReg.Current, readOnly),
+		reg("RI_SELECT", &r.RIReg.Select, 0xFF),
+		reg("RI_REFRESH", &r.RIReg.Refresh, 0x7_FFFF),
+		reg("RI_LATENCY", &r.RIReg.Latency, 0xF),
+		reg("RI_RERROR", &r.RIReg.RdError, readOnly),
+		reg("RI_WERROR", &r.RIReg.WrError, readOnly),
+	)
+	// 0x08, 0x0C and 0x14 are reserved
+	b.mapRegs(0x0480_0000,
+		reg("SI_DRAM_ADDR", &r.SIReg.DramAddr, addr24),
+		reg("SI_PIF_ADDR_RD64B", &r.SIReg.PIFAddrRd64B, 0x7FC),
+		nil,
+		nil,
+		reg("SI_PIF_ADDR_WR64B", &r.SIReg.PIFAddrWr64B, 0x7FC),
+		nil,
+		reg("SI_STATUS", &r.SIReg.Status, readOnly),
+	)
+}
+
+func (b *SysBus) mapRegs(start types.Word, regs ...*Register) {
+	b.regions = append(b.regions, &region{start: start, end: start + types.Word(4*len(regs)), regs: regs})
+}
@@ -0,0 +1,398 @@
+/*
+
+System Bus
+
+SysBus decodes the physical memory map documented in ram.go, and dispatches each access to the device:
+	RDRAM, SP DMEM/IMEM and cartridge domains: byte arrays of ram.RAM
+	RCP registers (RDRAM, SP, DP, MI, VI, AI, PI, RI, SI): word registers of ram.RAM, see registers.go
+	PIF Boot ROM and PIF RAM: pif.PIF
+	Cartridge ROM: cart.ROM at 0x10000000, up to 64MB
+	IS-Viewer 64 debug port: at 0x13FF0000, that shadows the end of 64MB ROM
+	MI_MODE: init mode repeating RDRAM writes, see mi.go
+
+Memory is stored in big-endian byte order, and read in the endianness of the access.
+Registers are words, so a word access gets the value in both endiannesses, and a byte or halfword access
+gets the byte lanes of the endianness.
+Reads from unmapped addresses return open bus, and writes to them are ignored.
+Open bus is 0, or the lower 16 bits of the address in each halfword in cartridge space (PI), e.g. past the end of ROM.
+Unmapped accesses of the CPU are counted, and may raise Bus Error or panic by the policy, see unmapped.go.
+Accesses of the host through SysBus itself have no such side effects.
+RDRAM above the installed size, 4MB without Expansion Pak, is unmapped.
+*/
+
+package sysbus
+
+import (
+	"encoding/binary"
+	"io"
+	"n64emu/pkg/core/cart"
+	"n64emu/pkg/core/pif"
+	"n64emu/pkg/core/ram"
+	"n64emu/pkg/types"
+	"sort"
+)
+
+// region is a range of the physical address space, backed by memory or registers
+type region struct {
+	start types.Word
+	end   types.Word // exclusive
+	// memory, or nil for registers
+	mem      []types.Byte
+	readOnly bool
+	// no device responds on PI, and reads return the address
+	openBus bool
+	// registers by offset/4, nil for holes
+	regs []*Register
+	// called after a write to the region, with the offset from the start
+	written func(offset types.Word, size int)
+}
+
+const (
+	// cartridge domain 1 address 2
+	romBase = 0x1000_0000
+	romEnd  = 0x1FC0_0000
+
+	pageShift = 20 // 1MB
+	pageMask  = 1<<pageShift - 1
+)
+
+// page has the memory from the start of the page, or nil if it is not memory.
+// Accesses that do not fit in the memory, e.g. registers after the memory in the page, take the slow path of lookup.
+type page struct {
+	mem      []types.Byte
+	writable bool // false if writes need the slow path
+}
+
+// SysBus is the physical memory map of N64, connecting RAM, the RCP, the cartridge and PIF to the CPU.
+type SysBus struct {
+	ram     *ram.RAM
+	pif     *pif.PIF
+	regions []*region // in order of the address
+	rdram   *region
+
+	// fast path of memory by the page, see mapPages
+	pages [1 << (32 - pageShift)]page
+
+	policy          UnmappedPolicy
+	busErrorHandler func()
+	unmappedHandler func()
+	unmapped        []UnmappedCount // by the region of memoryMap, nil until an unmapped access
+
+	isViewer       [isViewerSize]types.Byte
+	isViewerOutput io.Writer
+}
+
+// New returns SysBus connecting the RAM, ROM and PIF. rom is nil if no cartridge is inserted.
+// The image of ROM is mapped as is, without copy.
+func New(r *ram.RAM, rom *cart.ROM, p *pif.PIF) *SysBus {
+	b := &SysBus{
+		ram: r,
+		pif: p,
+	}
+	b.mapMemory()
+	b.mapROM(rom)
+	b.mapRegisters()
+	sort.Slice(b.regions, func(i, j int) bool { return b.regions[i].start < b.regions[j].start })
+	b.mapPages()
+	return b
+}
+
+// mapPages makes the page table of the memory regions, that start at or before the page
+func (b *SysBus) mapPages() {
+	for _, r := range b.regions {
+		if r.mem == nil {
+			continue
+		}
+		start := (r.start + pageMask) &^ pageMask
+		for addr := start; addr-r.start < types.Word(len(r.mem)) && addr >= start; addr += 1 << pageShift {
+			mem := r.mem[addr-r.start:]
+			if len(mem) > 1<<pageShift {
+				mem = mem[:1<<pageShift]
+			}
+			b.pages[addr>>pageShift] = page{mem: mem, writable: !r.readOnly && r.written == nil}
+		}
+	}
+}
+
+func (b *SysBus) mapMemory() {
+	r := b.ram
+	// above the installed size is open bus
+	b.rdram = b.mapBytes(0x0000_0000, r.RDRAM)
+	b.mapBytes(0x0400_0000, r.SPReg.DMem[:])
+	b.mapBytes(0x0400_1000, r.SPReg.IMem[:])
+	b.mapBytes(0x0500_0000, r.CartDomain2.Address1)
+	b.mapBytes(0x0600_0000, r.CartDomain1.Address1)
+	b.mapBytes(0x0800_0000, r.CartDomain2.Address2)
+
+	b.mapISViewer()
+
+	b.regions = append(b.regions,
+		&region{start: 0x1FC0_0000, end: 0x1FC0_0000 + pif.PIFROMSize, mem: b.pif.ROM(), readOnly: true})
+	b.mapBytes(0x1FC0_07C0, b.pif.RAM())
+}
+
+// map ROM, and open bus in the rest of cartridge domain 1 address 2
+func (b *SysBus) mapROM(rom *cart.ROM) {
+	end := types.Word(romBase)
+	if rom != nil {
+		image := rom.Image
+		if len(image) > isViewerBase-romBase {
+			image = image[:isViewerBase-romBase]
+		}
+		b.regions = append(b.regions, &region{start: romBase, end: romBase + types.Word(len(image)), mem: image, readOnly: true})
+		end += types.Word(len(image))
+	}
+	b.regions = append(b.regions,
+		&region{start: end, end: isViewerBase, openBus: true},
+		&region{start: isViewerBase + isViewerSize, end: romEnd, openBus: true})
+}
+
+func (b *SysBus) mapBytes(start types.Word, mem []types.Byte) *region {
+	r := &region{start: start, end: start + types.Word(len(mem)), mem: mem}
+	b.regions = append(b.regions, r)
+	return r
+}
+
+// lookup returns the region containing [addr, addr+size), or nil if unmapped
+func (b *SysBus) lookup(addr types.Word, size int) *region {
+	i := sort.Search(len(b.regions), func(i int) bool { return b.regions[i].end > addr })
+	if i == len(b.regions) {
+		return nil
+	}
+	r := b.regions[i]
+	if addr < r.start || r.end-addr < types.Word(size) {
+		return nil
+	}
+	return r
+}
+
+func order(e types.Endianness) binary.ByteOrder {
+	if e == types.Little {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}
+
+// read copies the bytes at addr to buf, as they are seen in the endianness
+// Unmapped reads of the CPU are handled by the policy.
+func (b *SysBus) read(e types.Endianness, addr types.Word, buf []types.Byte, fromCPU bool) {
+	r := b.lookup(addr, len(buf))
+	switch {
+	case r == nil:
+		if fromCPU {
+			b.unmappedAccess(false, addr, len(buf))
+		}
+		readUnmapped(addr, buf)
+	case r.mem != nil:
+		copy(buf, r.mem[addr-r.start:])
+	case r.openBus:
+		readOpenBus(addr, buf)
+	default:
+		r.readRegs(e, addr-r.start, buf)
+	}
+}
+
+// read open bus of PI, each halfword has the lower 16 bits of the address
+// Reference: https://n64brew.dev/wiki/Peripheral_Interface
+func readOpenBus(addr types.Word, buf []types.Byte) {
+	for i := range buf {
+		a := addr + types.Word(i)
+		if a&1 == 0 {
+			buf[i] = types.Byte(a >> 8)
+		} else {
+			buf[i] = types.Byte(a &^ 1)
+		}
+	}
+}
+
+// write copies buf to the bytes at addr
+// Unmapped writes of the CPU are handled by the policy.
+func (b *SysBus) write(e types.Endianness, addr types.Word, buf []types.Byte, fromCPU bool) {
+	r := b.lookup(addr, len(buf))
+	switch {
+	case r == nil:
+		if fromCPU {
+			b.unmappedAccess(true, addr, len(buf))
+		}
+		return
+	case r.readOnly || r.openBus:
+		return
+	case r.mem != nil:
+		copy(r.mem[addr-r.start:], buf)
+	default:
+		r.writeRegs(e, addr-r.start, buf)
+	}
+	if r.written != nil {
+		r.written(addr-r.start, len(buf))
+	}
+}
+
+// read registers as bytes, each register is encoded in the endianness
+func (r *region) readRegs(e types.Endianness, offset types.Word, buf []types.Byte) {
+	var word [4]types.Byte
+	for i := 0; i < len(buf); {
+		o := offset + types.Word(i)
+		order(e).PutUint32(word[:], r.regs[o>>2].read())
+		for ; i < len(buf) && (offset+types.Word(i))>>2 == o>>2; i++ {
+			buf[i] = word[(offset+types.Word(i))&3]
+		}
+	}
+}
+
+// write registers as bytes, with the mask of the bytes written
+func (r *region) writeRegs(e types.Endianness, offset types.Word, buf []types.Byte) {
+	for i := 0; i < len(buf); {
+		o := offset + types.Word(i)
+		var word, mask [4]types.Byte
+		for ; i < len(buf) && (offset+types.Word(i))>>2 == o>>2; i++ {
+			word[(offset+types.Word(i))&3] = buf[i]
+			mask[(offset+types.Word(i))&3] = 0xFF
+		}
+		r.regs[o>>2].write(order(e).Uint32(word[:]), order(e).Uint32(mask[:]))
+	}
+}
+
+func (b *SysBus) writeByte(e types.Endianness, addr types.Word, data types.Byte, fromCPU bool) {
+	if p := &b.pages[addr>>pageShift]; p.writable {
+		if o := addr & pageMask; o < types.Word(len(p.mem)) {
+			p.mem[o] = data
+			return
+		}
+	}
+	b.write(e, addr, []types.Byte{data}, fromCPU)
+}
+
+func (b *SysBus) writeHalfWord(e types.Endianness, addr types.Word, data types.HalfWord, fromCPU bool) {
+	if p := &b.pages[addr>>pageShift]; p.writable {
+		if o := addr & pageMask; o+2 <= types.Word(len(p.mem)) {
+			order(e).PutUint16(p.mem[o:o+2], data)
+			return
+		}
+	}
+	var buf [2]types.Byte
+	order(e).PutUint16(buf[:], data)
+	b.write(e, addr, buf[:], fromCPU)
+}
+
+func (b *SysBus) writeWord(e types.Endianness, addr types.Word, data types.Word, fromCPU bool) {
+	if p := &b.pages[addr>>pageShift]; p.writable {
+		if o := addr & pageMask; o+4 <= types.Word(len(p.mem)) {
+			if e == types.Big {
+				binary.BigEndian.PutUint32(p.mem[o:o+4], data)
+			} else {
+				binary.LittleEndian.PutUint32(p.mem[o:o+4], data)
+			}
+			return
+		}
+	}
+	var buf [4]types.Byte
+	order(e).PutUint32(buf[:], data)
+	b.write(e, addr, buf[:], fromCPU)
+}
+
+func (b *SysBus) writeDoubleWord(e types.Endianness, addr types.Word, data types.DoubleWord, fromCPU bool) {
+	if p := &b.pages[addr>>pageShift]; p.writable {
+		if o := addr & pageMask; o+8 <= types.Word(len(p.mem)) {
+			if e == types.Big {
+				binary.BigEndian.PutUint64(p.mem[o:o+8], data)
+			} else {
+				binary.LittleEndian.PutUint64(p.mem[o:o+8], data)
+			}
+			return
+		}
+	}
+	var buf [8]types.Byte
+	order(e).PutUint64(buf[:], data)
+	b.write(e, addr, buf[:], fromCPU)
+}
+
+func (b *SysBus) readByte(e types.Endianness, addr types.Word, fromCPU bool) types.Byte {
+	if mem := b.pages[addr>>pageShift].mem; addr&pageMask < types.Word(len(mem)) {
+		return mem[addr&pageMask]
+	}
+	var buf [1]types.Byte
+	b.read(e, addr, buf[:], fromCPU)
+	return buf[0]
+}
+
+func (b *SysBus) readHalfWord(e types.Endianness, addr types.Word, fromCPU bool) types.HalfWord {
+	if mem := b.pages[addr>>pageShift].mem; addr&pageMask+2 <= types.Word(len(mem)) {
+		return order(e).Uint16(mem[addr&pageMask:])
+	}
+	var buf [2]types.Byte
+	b.read(e, addr, buf[:], fromCPU)
+	return order(e).Uint16(buf[:])
+}
+
+func (b *SysBus) readWord(e types.Endianness, addr types.Word, fromCPU bool) types.Word {
+	if mem := b.pages[addr>>pageShift].mem; addr&pageMask+4 <= types.Word(len(mem)) {
+		if e == types.Big {
+			return binary.BigEndian.Uint32(mem[addr&pageMask:])
+		}
+		return binary.LittleEndian.Uint32(mem[addr&pageMask:])
+	}
+	var buf [4]types.Byte
+	b.read(e, addr, buf[:], fromCPU)
+	return order(e).Uint32(buf[:])
+}
+
+func (b *SysBus) readDoubleWord(e types.Endianness, addr types.Word, fromCPU bool) types.DoubleWord {
+	if mem := b.pages[addr>>pageShift].mem; addr&pageMask+8 <= types.Word(len(mem)) {
+		if e == types.Big {
+			return binary.BigEndian.Uint64(mem[addr&pageMask:])
+		}
+		return binary.LittleEndian.Uint64(mem[addr&pageMask:])
+	}
+	var buf [8]types.Byte
+	b.read(e, addr, buf[:], fromCPU)
+	return order(e).Uint64(buf[:])
+}
+
+func (b *SysBus) WriteByte(e types.Endianness, addr types.Word, data types.Byte) {
+	b.writeByte(e, addr, data, false)
+}
+
+func (b *SysBus) WriteHalfWord(e types.Endianness, addr types.Word, data types.HalfWord) {
+	b.writeHalfWord(e, addr, data, false)
+}
+
+func (b *SysBus) WriteWord(e types.Endianness, addr types.Word, data types.Word) {
+	b.writeWord(e, addr, data, false)
+}
+
+func (b *SysBus) WriteDoubleWord(e types.Endianness, addr types.Word, data types.DoubleWord) {
+	b.writeDoubleWord(e, addr, data, false)
+}
+
+func (b *SysBus) ReadByte(e types.Endianness, addr types.Word) types.Byte {
+	return b.readByte(e, addr, false)
+}
+
+func (b *SysBus) ReadHalfWord(e types.Endianness, addr types.Word) types.HalfWord {
+	return b.readHalfWord(e, addr, false)
+}
+
+func (b *SysBus) ReadWord(e types.Endianness, addr types.Word) types.Word {
+	return b.readWord(e, addr, false)
+}
+
+func (b *SysBus) ReadDoubleWord(e types.Endianness, addr types.Word) types.DoubleWord {
+	return b.readDoubleWord(e, addr, false)
+}
+
+// Load copies the data to memory at the physical address, e.g. to load programs.
+// The data can span regions, and the part outside writable memory is dropped.
+func (b *SysBus) Load(addr types.Word, data []types.Byte) {
+	for len(data) > 0 {
+		n := 1
+		if r := b.lookup(addr, 1); r != nil && r.mem != nil {
+			if !r.readOnly {
+				n = copy(r.mem[addr-r.start:], data)
+			} else if n = int(r.end - addr); n > len(data) {
+				n = len(data)
+			}
+		}
+		addr += types.Word(n)
+		data = data[n:]
+	}
+}
@@ -0,0 +1,331 @@
+package sysbus
+
+import (
+	"bytes"
+	"n64emu/pkg/core/cart"
+	"n64emu/pkg/core/pif"
+	"n64emu/pkg/core/ram"
+	"n64emu/pkg/types"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestBus() (*SysBus, *ram.RAM, *pif.PIF) {
+	r := ram.NewRAM(true)
+	p := pif.NewPIF()
+	return New(r, newTestROM(0x10_0000), p), r, p
+}
+
+// ROM of the size, each byte has the lower bits of the offset
+func newTestROM(size int) *cart.ROM {
+	image := make([]types.Byte, size)
+	for i := range image {
+		image[i] = types.Byte(i)
+	}
+	return &cart.ROM{Image: image}
+}
+
+func TestSysBus_Memory(t *testing.T) {
+	assert := assert.New(t)
+	b, r, _ := newTestBus()
+
+	// all widths in big endian, stored in big-endian byte order
+	b.WriteDoubleWord(types.Big, 0x10, 0x0123_4567_89AB_CDEF)
+	assert.Equal(types.Byte(0x01), r.RDRAM[0x10])
+	assert.Equal(types.Byte(0xEF), r.RDRAM[0x17])
+	assert.Equal(types.DoubleWord(0x0123_4567_89AB_CDEF), b.ReadDoubleWord(types.Big, 0x10))
+	assert.Equal(types.Word(0x89AB_CDEF), b.ReadWord(types.Big, 0x14))
+	assert.Equal(types.HalfWord(0x4567), b.ReadHalfWord(types.Big, 0x12))
+	assert.Equal(types.Byte(0x23), b.ReadByte(types.Big, 0x11))
+
+	// little endian
+	assert.Equal(types.DoubleWord(0xEFCD_AB89_6745_2301), b.ReadDoubleWord(types.Little, 0x10))
+	assert.Equal(types.Word(0x6745_2301), b.ReadWord(types.Little, 0x10))
+	assert.Equal(types.HalfWord(0x2301), b.ReadHalfWord(types.Little, 0x10))
+	b.WriteWord(types.Little, 0x20, 0x1122_3344)
+	assert.Equal(types.Byte(0x44), r.RDRAM[0x20])
+	b.WriteHalfWord(types.Little, 0x24, 0x5566)
+	assert.Equal(types.Byte(0x66), r.RDRAM[0x24])
+	b.WriteByte(types.Little, 0x26, 0x77)
+	assert.Equal(types.Byte(0x77), r.RDRAM[0x26])
+
+	// the devices
+	b.WriteWord(types.Big, 0x0040_0000, 1)
+	assert.Equal(types.Byte(1), r.RDRAM[0x40_0003])
+	b.WriteWord(types.Big, 0x0400_0000, 2)
+	assert.Equal(types.Byte(2), r.SPReg.DMem[3])
+	b.WriteWord(types.Big, 0x0400_1FFC, 3)
+	assert.Equal(types.Byte(3), r.SPReg.IMem[0xFFF])
+	b.WriteWord(types.Big, 0x0500_0000, 4)
+	assert.Equal(types.Byte(4), r.CartDomain2.Address1[3])
+	b.WriteWord(types.Big, 0x0600_0000, 5)
+	assert.Equal(types.Byte(5), r.CartDomain1.Address1[3])
+	b.WriteWord(types.Big, 0x0800_0000, 6)
+	assert.Equal(types.Byte(6), r.CartDomain2.Address2[3])
+
+	// across regions
+	b.WriteWord(types.Big, 0x0400_0FFE, 0xAABB_CCDD)
+	assert.Equal(types.Word(0), b.ReadWord(types.Big, 0x0400_0FFE))
+	assert.Equal(types.Byte(0), r.SPReg.DMem[0xFFF])
+}
+
+func TestSysBus_RDRAMSize(t *testing.T) {
+	assert := assert.New(t)
+	b := New(ram.NewRAM(false), nil, pif.NewPIF())
+
+	b.WriteWord(types.Big, 0x003F_FFFC, 1)
+	assert.Equal(types.Word(1), b.ReadWord(types.Big, 0x003F_FFFC))
+	// above the installed size
+	b.WriteWord(types.Big, 0x0040_0000, 1)
+	assert.Equal(types.Word(0), b.ReadWord(types.Big, 0x0040_0000))
+	assert.Equal(types.Word(0), b.ReadWord(types.Big, 0x007F_FFFC))
+}
+
+func TestSysBus_Registers(t *testing.T) {
+	assert := assert.New(t)
+	b, r, _ := newTestBus()
+
+	b.WriteWord(types.Big, 0x0440_0004, 0x0010_0000)
+	assert.Equal(types.Word(0x0010_0000), r.VIReg.Origin)
+	// word access has the value in both endiannesses
+	b.WriteWord(types.Little, 0x03F0_0000, 0x3)
+	assert.Equal(types.Word(0x3), r.RDRAMReg.Config)
+	assert.Equal(types.Word(0x3), b.ReadWord(types.Big, 0x03F0_0000))
+	assert.Equal(types.Word(0x3), b.ReadWord(types.Little, 0x03F0_0000))
+
+	// byte lanes of the endianness
+	r.RDRAMReg.DeviceID = 0x0202_0102
+	assert.Equal(types.Byte(0x02), b.ReadByte(types.Big, 0x03F0_0007))
+	assert.Equal(types.Byte(0x01), b.ReadByte(types.Big, 0x03F0_0006))
+	assert.Equal(types.Byte(0x01), b.ReadByte(types.Little, 0x03F0_0005))
+	assert.Equal(types.HalfWord(0x0202), b.ReadHalfWord(types.Big, 0x03F0_0004))
+	assert.Equal(types.HalfWord(0x0102), b.ReadHalfWord(types.Little, 0x03F0_0004))
+	b.WriteByte(types.Big, 0x03F0_0004, 0xFF)
+	assert.Equal(types.Word(0xFF02_0102), r.RDRAMReg.DeviceID)
+	b.WriteHalfWord(types.Little, 0x03F0_0004, 0xABCD)
+	assert.Equal(types.Word(0xFF02_ABCD), r.RDRAMReg.DeviceID)
+
+	// doubleword is two registers
+	b.WriteDoubleWord(types.Big, 0x03F0_0008, 0x1111_1111_2222_2222)
+	assert.Equal(types.Word(0x1111_1111), r.RDRAMReg.Delay)
+	assert.Equal(types.Word(0x2222_2222), r.RDRAMReg.Mode)
+	assert.Equal(types.DoubleWord(0x1111_1111_2222_2222), b.ReadDoubleWord(types.Big, 0x03F0_0008))
+
+	// the blocks
+	b.WriteWord(types.Big, 0x03F0_0024, 1)
+	assert.Equal(types.Word(1), r.RDRAMReg.DeviceManuf)
+	b.WriteWord(types.Big, 0x0404_001C, 1)
+	assert.Equal(types.Word(1), r.SPReg.Semaphore)
+	b.WriteWord(types.Big, 0x0408_0000, 4)
+	assert.Equal(types.Word(4), r.SPReg.PC)
+	b.WriteWord(types.Big, 0x0410_0004, 4)
+	assert.Equal(types.Word(4), r.DPCommandReg.End)
+	b.WriteWord(types.Big, 0x0420_000C, 5)
+	assert.Equal(types.Word(5), r.DPSpanReg.BufTestData)
+	b.WriteWord(types.Big, 0x0450_0014, 6)
+	assert.Equal(types.Word(6), r.AI.BitRate)
+	b.WriteWord(types.Big, 0x0460_0030, 2)
+	assert.Equal(types.Word(2), r.PIReg.Dom2Rls)
+	b.WriteWord(types.Big, 0x0470_0014, 6)
+	assert.Equal(types.Word(6), r.RIReg.Latency)
+	b.WriteWord(types.Big, 0x0480_0010, 0x7C0)
+	assert.Equal(types.Word(0x7C0), r.SIReg.PIFAddrWr64B)
+	r.SIReg.Status = 8
+	assert.Equal(types.Word(8), b.ReadWord(types.Big, 0x0480_0018))
+
+	// reserved registers of SI
+	b.WriteWord(types.Big, 0x0480_0008, 9)
+	assert.Equal(types.Word(0), b.ReadWord(types.Big, 0x0480_0008))
+}
+
+func TestSysBus_PIF(t *testing.T) {
+	assert := assert.New(t)
+	b, _, p := newTestBus()
+
+	p.ROM()[0] = 0x3C
+	assert.Equal(types.Byte(0x3C), b.ReadByte(types.Big, 0x1FC0_0000))
+	// ROM is read only
+	b.WriteByte(types.Big, 0x1FC0_0000, 0)
+	assert.Equal(types.Byte(0x3C), p.ROM()[0])
+
+	b.WriteWord(types.Big, 0x1FC0_07FC, 0x0000_0001)
+	assert.Equal(types.Byte(1), p.RAM()[pif.PIFRAMSize-1])
+	assert.Equal(types.Word(1), b.ReadWord(types.Big, 0x1FC0_07FC))
+}
+
+func TestSysBus_Unmapped(t *testing.T) {
+	assert := assert.New(t)
+	b, _, _ := newTestBus()
+
+	for _, addr := range []types.Word{0x0080_0000, 0x03F0_0028, 0x0490_0000, 0x1FC0_0800, 0x8000_0000} {
+		b.WriteWord(types.Big, addr, 0xFFFF_FFFF)
+		assert.Equal(types.Word(0), b.ReadWord(types.Big, addr), "%08x", addr)
+		assert.Equal(types.DoubleWord(0), b.ReadDoubleWord(types.Big, addr), "%08x", addr)
+	}
+	// open bus on PI
+	assert.Equal(types.Word(0x1234_1236), b.ReadWord(types.Big, 0x1FD0_1234))
+	assert.Equal(types.HalfWord(0x0002), b.ReadHalfWord(types.Big, 0x7FFF_0002))
+}
+
+func TestSysBus_UnmappedCounts(t *testing.T) {
+	assert := assert.New(t)
+	b, _, _ := newTestBus()
+
+	cpu := b.CPU()
+	assert.Empty(b.Unmapped())
+	cpu.ReadWord(types.Big, 0x0490_0010)
+	cpu.WriteByte(types.Big, 0x0490_0000, 1)
+	cpu.ReadDoubleWord(types.Big, 0x04A0_0000)
+	cpu.ReadWord(types.Big, 0x1FC0_0800)
+	cpu.ReadWord(types.Big, 0x0000_0400) // mapped
+	b.ReadWord(types.Big, 0x0490_0010)   // host
+	b.WriteWord(types.Big, 0x0490_0010, 0)
+	assert.Equal([]UnmappedCount{
+		{Region: "Unused", Start: 0x0490_0000, End: 0x04FF_FFFF, Reads: 2, Writes: 1, First: 0x0490_0010},
+		{Region: "Reserved", Start: 0x1FC0_0800, End: 0x1FCF_FFFF, Reads: 1, First: 0x1FC0_0800},
+	}, b.Unmapped())
+	c := b.Unmapped()[0]
+	assert.Equal(types.Word(0x0490_0000), c.Start, "should host accesses not counted")
+	assert.Equal("Unused (04900000-04ffffff): 2 reads, 1 writes, first at 04900010", c.String())
+}
+
+func TestSysBus_UnmappedPolicy(t *testing.T) {
+	assert := assert.New(t)
+	b, _, _ := newTestBus()
+
+	cpu := b.CPU()
+	errors := 0
+	b.SetBusErrorHandler(func() { errors++ })
+	cpu.ReadWord(types.Big, 0x0490_0000)
+	assert.Equal(0, errors, "should open bus by default")
+
+	b.SetUnmappedPolicy(BusError)
+	cpu.ReadWord(types.Big, 0x0490_0000)
+	cpu.WriteWord(types.Big, 0x1FC0_0800, 0)
+	cpu.ReadWord(types.Big, 0x1000_0000)
+	assert.Equal(2, errors, "should handler called on unmapped accesses only")
+	b.ReadWord(types.Big, 0x0490_0000)
+	assert.Equal(2, errors, "should host accesses not handled by the policy")
+
+	b.SetUnmappedPolicy(Panic)
+	assert.PanicsWithValue("unmapped write of 2 bytes at 04900002 in Unused", func() {
+		cpu.WriteHalfWord(types.Big, 0x0490_0002, 0)
+	})
+	assert.NotPanics(func() { b.WriteHalfWord(types.Big, 0x0490_0002, 0) }, "should host accesses not panic")
+	assert.Equal(uint64(3), b.Unmapped()[0].Reads+b.Unmapped()[0].Writes, "should counted under any policy")
+
+	for _, p := range []UnmappedPolicy{OpenBus, BusError, Panic} {
+		parsed, err := ParseUnmappedPolicy(p.String())
+		assert.NoError(err)
+		assert.Equal(p, parsed)
+	}
+	_, err := ParseUnmappedPolicy("ignore")
+	assert.Error(err)
+}
+
+func TestSysBus_ROM(t *testing.T) {
+	assert := assert.New(t)
+	b, _, _ := newTestBus()
+
+	// header through data
+	assert.Equal(types.Word(0x0001_0203), b.ReadWord(types.Big, 0x1000_0000))
+	assert.Equal(types.DoubleWord(0xF8F9_FAFB_FCFD_FEFF), b.ReadDoubleWord(types.Big, 0x100F_FFF8))
+	assert.Equal(types.HalfWord(0x4544), b.ReadHalfWord(types.Little, 0x1000_1044))
+	// read only
+	b.WriteWord(types.Big, 0x1000_0000, 0)
+	assert.Equal(types.Word(0x0001_0203), b.ReadWord(types.Big, 0x1000_0000))
+
+	// open bus past the end
+	assert.Equal(types.Word(0x0000_0002), b.ReadWord(types.Big, 0x1010_0000))
+	assert.Equal(types.Word(0x1234_1236), b.ReadWord(types.Big, 0x1011_1234))
+	assert.Equal(types.HalfWord(0x1236), b.ReadHalfWord(types.Big, 0x1011_1236))
+	assert.Equal(types.Byte(0x12), b.ReadByte(types.Big, 0x1011_1236))
+	assert.Equal(types.Byte(0x36), b.ReadByte(types.Big, 0x1011_1237))
+	assert.Equal(types.DoubleWord(0xFFF8_FFFA_FFFC_FFFE), b.ReadDoubleWord(types.Big, 0x1FBF_FFF8))
+	b.WriteWord(types.Big, 0x1011_1234, 0)
+	assert.Equal(types.Word(0x1234_1236), b.ReadWord(types.Big, 0x1011_1234))
+
+	// 64MB, that IS-Viewer shadows the end
+	b = New(ram.NewRAM(true), newTestROM(0x400_0000), pif.NewPIF())
+	assert.Equal(types.Word(0xFCFD_FEFF), b.ReadWord(types.Big, 0x13FE_FFFC))
+	assert.Equal(types.Word(0), b.ReadWord(types.Big, 0x13FF_0000))
+	assert.Equal(types.Word(0x0000_0002), b.ReadWord(types.Big, 0x1400_0000))
+
+	// no cartridge
+	b = New(ram.NewRAM(true), nil, pif.NewPIF())
+	assert.Equal(types.Word(0x0040_0042), b.ReadWord(types.Big, 0x1000_0040))
+}
+
+func TestSysBus_ISViewer(t *testing.T) {
+	assert := assert.New(t)
+	b, _, _ := newTestBus()
+	var out bytes.Buffer
+	b.SetISViewer(&out)
+
+	b.Load(isViewerBase+isViewerBuffer, []types.Byte("hello\n"))
+	b.WriteWord(types.Big, isViewerBase+isViewerLength, 6)
+	assert.Equal("hello\n", out.String())
+}
+
+func TestSysBus_Load(t *testing.T) {
+	assert := assert.New(t)
+	b, r, p := newTestBus()
+
+	// the part in unmapped addresses is dropped
+	b.Load(0x03FF_FFFF, []types.Byte{1, 2})
+	assert.Equal(types.Byte(2), r.SPReg.DMem[0])
+	b.Load(0x003F_FFFE, []types.Byte{1, 2, 3, 4})
+	assert.Equal(types.Byte(2), r.RDRAM[0x3F_FFFF])
+	assert.Equal(types.Byte(3), r.RDRAM[0x40_0000])
+
+	// read-only is dropped
+	b.Load(0x1FC0_07BF, []types.Byte{1, 2})
+	assert.Equal(types.Byte(0), p.ROM()[pif.PIFROMSize-1])
+	assert.Equal(types.Byte(2), p.RAM()[0])
+}
+
+func TestSysBus_Pages(t *testing.T) {
+	assert := assert.New(t)
+	b, r, _ := newTestBus()
+
+	// memory at the start of the page
+	assert.Len(b.pages[0x000].mem, 1<<pageShift)
+	assert.True(b.pages[0x000].writable)
+	assert.Len(b.pages[0x040].mem, len(r.SPReg.DMem))
+	assert.Nil(b.pages[0x03F].mem, "registers")
+	assert.Nil(b.pages[0x13F].mem, "not at the start of the page")
+	assert.False(b.pages[0x1FC].writable, "PIF ROM")
+	assert.Len(b.pages[0x100].mem, 0x10_0000)
+	assert.False(b.pages[0x100].writable, "ROM")
+	assert.Nil(b.pages[0x101].mem, "open bus")
+}
+
+func benchmarkReadWord(bb *testing.B, addr types.Word, paged bool) {
+	b, _, _ := newTestBus()
+	if !paged {
+		b.pages = [len(b.pages)]page{}
+	}
+	bb.ResetTimer()
+	for i := 0; i < bb.N; i++ {
+		b.ReadWord(types.Big, addr+types.Word(i&0xFF)<<2)
+	}
+}
+
+func benchmarkWriteWord(bb *testing.B, addr types.Word, paged bool) {
+	b, _, _ := newTestBus()
+	if !paged {
+		b.pages = [len(b.pages)]page{}
+	}
+	bb.ResetTimer()
+	for i := 0; i < bb.N; i++ {
+		b.WriteWord(types.Big, addr+types.Word(i&0xFF)<<2, types.Word(i))
+	}
+}
+
+func BenchmarkSysBus_ReadWord_RDRAM(b *testing.B)         { benchmarkReadWord(b, 0x0010_0000, true) }
+func BenchmarkSysBus_ReadWord_RDRAM_Lookup(b *testing.B)  { benchmarkReadWord(b, 0x0010_0000, false) }
+func BenchmarkSysBus_ReadWord_ROM(b *testing.B)           { benchmarkReadWord(b, 0x1000_1000, true) }
+func BenchmarkSysBus_ReadWord_ROM_Lookup(b *testing.B)    { benchmarkReadWord(b, 0x1000_1000, false) }
+func BenchmarkSysBus_WriteWord_RDRAM(b *testing.B)        { benchmarkWriteWord(b, 0x0010_0000, true) }
+func BenchmarkSysBus_WriteWord_RDRAM_Lookup(b *testing.B) { benchmarkWriteWord(b, 0x0010_0000, false) }
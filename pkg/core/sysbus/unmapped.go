@@ -0,0 +1,199 @@
+package sysbus
+
+import (
+	"fmt"
+	"n64emu/pkg/types"
+)
+
+// UnmappedPolicy is what happens on accesses to unmapped addresses
+type UnmappedPolicy int
+
+const (
+	// reads return open bus values, and writes are ignored
+	OpenBus UnmappedPolicy = iota
+	// the handler set by SetBusErrorHandler is called, to raise Bus Error exception of the CPU
+	BusError
+	// panic, to stop at the first access
+	Panic
+)
+
+func (p UnmappedPolicy) String() string {
+	switch p {
+	case OpenBus:
+		return "open"
+	case BusError:
+		return "error"
+	case Panic:
+		return "panic"
+	}
+	return fmt.Sprintf("UnmappedPolicy(%d)", int(p))
+}
+
+// ParseUnmappedPolicy parses the name of the policy, "open", "error" or "panic".
+func ParseUnmappedPolicy(s string) (UnmappedPolicy, error) {
+	for _, p := range []UnmappedPolicy{OpenBus, BusError, Panic} {
+		if p.String() == s {
+			return p, nil
+		}
+	}
+	return OpenBus, fmt.Errorf("unknown policy '%s' of unmapped accesses", s)
+}
+
+// regions of the memory map documented in ram.go, to count unmapped accesses
+var memoryMap = []struct {
+	name       string
+	start, end types.Word // inclusive
+}{
+	{"RDRAM Memory", 0x0000_0000, 0x03EF_FFFF},
+	{"RDRAM Registers", 0x03F0_0000, 0x03FF_FFFF},
+	{"SP Registers", 0x0400_0000, 0x040F_FFFF},
+	{"DP Command Registers", 0x0410_0000, 0x041F_FFFF},
+	{"DP Span Registers", 0x0420_0000, 0x042F_FFFF},
+	{"MI Registers", 0x0430_0000, 0x043F_FFFF},
+	{"VI Registers", 0x0440_0000, 0x044F_FFFF},
+	{"AI Registers", 0x0450_0000, 0x045F_FFFF},
+	{"PI Registers", 0x0460_0000, 0x046F_FFFF},
+	{"RI Registers", 0x0470_0000, 0x047F_FFFF},
+	{"SI Registers", 0x0480_0000, 0x048F_FFFF},
+	{"Unused", 0x0490_0000, 0x04FF_FFFF},
+	{"Cartridge Domain 2 Address 1", 0x0500_0000, 0x05FF_FFFF},
+	{"Cartridge Domain 1 Address 1", 0x0600_0000, 0x07FF_FFFF},
+	{"Cartridge Domain 2 Address 2", 0x0800_0000, 0x0FFF_FFFF},
+	{"Cartridge Domain 1 Address 2", 0x1000_0000, 0x1FBF_FFFF},
+	{"PIF Boot ROM", 0x1FC0_0000, 0x1FC0_07BF},
+	{"PIF RAM", 0x1FC0_07C0, 0x1FC0_07FF},
+	{"Reserved", 0x1FC0_0800, 0x1FCF_FFFF},
+	{"Cartridge Domain 1 Address 3", 0x1FD0_0000, 0x7FFF_FFFF},
+	{"External SysAD Device", 0x8000_0000, 0xFFFF_FFFF},
+}
+
+// UnmappedCount is the number of unmapped accesses in a region of the memory map
+type UnmappedCount struct {
+	Region     string
+	Start, End types.Word // inclusive
+	Reads      uint64
+	Writes     uint64
+	// address of the first access
+	First types.Word
+}
+
+func (c *UnmappedCount) String() string {
+	return fmt.Sprintf("%s (%08x-%08x): %d reads, %d writes, first at %08x", c.Region, c.Start, c.End, c.Reads, c.Writes, c.First)
+}
+
+// CPUView is the bus as seen by the CPU. Unmapped accesses through it are counted, and handled by the policy.
+type CPUView struct {
+	b *SysBus
+}
+
+// CPU returns the view of the bus for the CPU. Accesses through SysBus itself, e.g. by the host, are not counted.
+func (b *SysBus) CPU() *CPUView {
+	return &CPUView{b: b}
+}
+
+func (v *CPUView) WriteByte(e types.Endianness, addr types.Word, data types.Byte) {
+	v.b.writeByte(e, addr, data, true)
+}
+
+func (v *CPUView) WriteHalfWord(e types.Endianness, addr types.Word, data types.HalfWord) {
+	v.b.writeHalfWord(e, addr, data, true)
+}
+
+func (v *CPUView) WriteWord(e types.Endianness, addr types.Word, data types.Word) {
+	v.b.writeWord(e, addr, data, true)
+}
+
+func (v *CPUView) WriteDoubleWord(e types.Endianness, addr types.Word, data types.DoubleWord) {
+	v.b.writeDoubleWord(e, addr, data, true)
+}
+
+func (v *CPUView) ReadByte(e types.Endianness, addr types.Word) types.Byte {
+	return v.b.readByte(e, addr, true)
+}
+
+func (v *CPUView) ReadHalfWord(e types.Endianness, addr types.Word) types.HalfWord {
+	return v.b.readHalfWord(e, addr, true)
+}
+
+func (v *CPUView) ReadWord(e types.Endianness, addr types.Word) types.Word {
+	return v.b.readWord(e, addr, true)
+}
+
+func (v *CPUView) ReadDoubleWord(e types.Endianness, addr types.Word) types.DoubleWord {
+	return v.b.readDoubleWord(e, addr, true)
+}
+
+// SetUnmappedPolicy sets what happens on unmapped accesses of the CPU. OpenBus by default.
+func (b *SysBus) SetUnmappedPolicy(p UnmappedPolicy) {
+	b.policy = p
+}
+
+// SetBusErrorHandler sets the function called on unmapped accesses with BusError policy.
+// It is called during the access, so the CPU can raise the exception for the instruction accessing.
+func (b *SysBus) SetBusErrorHandler(handler func()) {
+	b.busErrorHandler = handler
+}
+
+// SetUnmappedHandler sets the function called on every unmapped access of the CPU, before the policy is applied.
+// The CPU uses it to know the access changed the counts, e.g. to not skip the loop making it as idle.
+func (b *SysBus) SetUnmappedHandler(handler func()) {
+	b.unmappedHandler = handler
+}
+
+// Unmapped returns the counts of unmapped accesses of the CPU by the region, in order of the address.
+// Regions without unmapped accesses are omitted.
+func (b *SysBus) Unmapped() []UnmappedCount {
+	counts := []UnmappedCount{}
+	for i, c := range b.unmapped {
+		if c.Reads+c.Writes > 0 {
+			c.Region, c.Start, c.End = memoryMap[i].name, memoryMap[i].start, memoryMap[i].end
+			counts = append(counts, c)
+		}
+	}
+	return counts
+}
+
+// count the unmapped access, and apply the policy
+func (b *SysBus) unmappedAccess(write bool, addr types.Word, size int) {
+	if b.unmapped == nil {
+		b.unmapped = make([]UnmappedCount, len(memoryMap))
+	}
+	i := 0
+	for addr > memoryMap[i].end {
+		i++
+	}
+	c := &b.unmapped[i]
+	if c.Reads+c.Writes == 0 {
+		c.First = addr
+	}
+	kind := "read"
+	if write {
+		c.Writes++
+		kind = "write"
+	} else {
+		c.Reads++
+	}
+	if b.unmappedHandler != nil {
+		b.unmappedHandler()
+	}
+
+	switch b.policy {
+	case BusError:
+		if b.busErrorHandler != nil {
+			b.busErrorHandler()
+		}
+	case Panic:
+		panic(fmt.Sprintf("unmapped %s of %d bytes at %08x in %s", kind, size, addr, memoryMap[i].name))
+	}
+}
+
+// read open bus of unmapped addresses, that is the address on PI, and 0 on the others
+func readUnmapped(addr types.Word, buf []types.Byte) {
+	if (0x0500_0000 <= addr && addr < 0x1FC0_0000) || (0x1FD0_0000 <= addr && addr < 0x8000_0000) {
+		readOpenBus(addr, buf)
+		return
+	}
+	for i := range buf {
+		buf[i] = 0
+	}
+}
@@ -0,0 +1,316 @@
+/*
+
+Interactive Debugger
+
+The debugger runs the CPU by instructions, on top of CPU.Step.
+An instruction is regarded as executed when the next one reaches RF stage, so the current PC is the
+address of the instruction to be executed next.
+Registers show the values written back, so the results of the instructions still in the pipeline are not visible yet.
+Memory is read through bus.Bus with physical addresses, or virtual addresses translated by the CPU.
+*/
+
+package debugger
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"n64emu/pkg/core/bus"
+	"n64emu/pkg/core/mips/r4300i/cpu"
+	"n64emu/pkg/symbols"
+	"n64emu/pkg/types"
+	"sync/atomic"
+)
+
+const (
+	// max cycles to fetch the next instruction, the CPU is regarded as stalled beyond this
+	maxStallCycles = 1000
+)
+
+// StopReason is why the execution stopped
+type StopReason int
+
+const (
+	StopStep StopReason = iota
+	StopBreakpoint
+	StopWatchpoint
+	StopInterrupt
+	StopError
+	StopBusAccess
+)
+
+// Stop is the result of an execution
+type Stop struct {
+	Reason StopReason
+	PC     types.DoubleWord
+	// ID of the breakpoint, watchpoint or bus watch
+	ID int
+	// memory access, for StopWatchpoint
+	Hit cpu.WatchHit
+	// bus access, for StopBusAccess
+	Access bus.Access
+	// panic of the CPU, for StopError
+	Err error
+}
+
+func (s *Stop) String() string {
+	return s.Format(nil)
+}
+
+// Format returns description of the stop, with addresses symbolized by the table.
+func (s *Stop) Format(t *symbols.Table) string {
+	switch s.Reason {
+	case StopBreakpoint:
+		return fmt.Sprintf("breakpoint %d at %s", s.ID, t.Format(s.PC))
+	case StopWatchpoint:
+		return fmt.Sprintf("watchpoint %d: %s %d bytes at %08x by %s", s.ID, kindName(s.Hit.Kind), s.Hit.Size, s.Hit.Addr, t.Format(s.Hit.PC))
+	case StopBusAccess:
+		return fmt.Sprintf("bus watch %d: %s at %s", s.ID, s.Access.String(), t.Format(s.PC))
+	case StopInterrupt:
+		return fmt.Sprintf("interrupted at %s", t.Format(s.PC))
+	case StopError:
+		return fmt.Sprintf("error at %s: %s", t.Format(s.PC), s.Err)
+	}
+	return fmt.Sprintf("stopped at %s", t.Format(s.PC))
+}
+
+// busWatch breaks on or logs bus accesses
+type busWatch struct {
+	filter bus.Filter
+	log    bool
+}
+
+type busHit struct {
+	id     int
+	access bus.Access
+}
+
+// Debugger controls the CPU
+type Debugger struct {
+	cpu *cpu.CPU
+	bus bus.Bus
+
+	breakpoints    map[int]types.DoubleWord
+	nextBreakpoint int
+	watchpoints    map[int]cpu.Watchpoint
+	watchHits      []cpu.WatchHit
+	watcher        *bus.Watcher
+	busWatches     map[int]busWatch
+	busHits        []busHit
+	interrupted    int32
+	symbols        *symbols.Table
+}
+
+// New returns Debugger of the CPU and the bus connected to it.
+func New(c *cpu.CPU, b bus.Bus) *Debugger {
+	d := &Debugger{
+		cpu:         c,
+		bus:         b,
+		breakpoints: map[int]types.DoubleWord{},
+		watchpoints: map[int]cpu.Watchpoint{},
+		busWatches:  map[int]busWatch{},
+	}
+	c.SetWatchpointHandler(func(hit cpu.WatchHit) {
+		d.watchHits = append(d.watchHits, hit)
+	})
+	return d
+}
+
+// PC returns address of the instruction to be executed next. It does not change the CPU state.
+func (d *Debugger) PC() types.DoubleWord {
+	return d.cpu.NextPC()
+}
+
+// run cycles until an instruction is fetched in RF stage
+func (d *Debugger) fill() {
+	for i := 0; i < maxStallCycles; i++ {
+		if _, _, ok := d.cpu.NextInstruction(); ok {
+			return
+		}
+		d.cpu.Step()
+	}
+}
+
+// execute an instruction, recovering from panics of unimplemented features
+func (d *Debugger) stepInstruction() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	d.fill()
+	d.cpu.Step()
+	d.fill()
+	return nil
+}
+
+// step executes an instruction, and returns the stop if the execution must stop
+func (d *Debugger) step() *Stop {
+	pc := d.PC()
+	if err := d.stepInstruction(); err != nil {
+		return &Stop{Reason: StopError, PC: pc, Err: err}
+	}
+	if len(d.watchHits) > 0 {
+		hit := d.watchHits[0]
+		d.watchHits = nil
+		return &Stop{Reason: StopWatchpoint, PC: d.PC(), ID: hit.ID, Hit: hit}
+	}
+	if len(d.busHits) > 0 {
+		hit := d.busHits[0]
+		d.busHits = nil
+		return &Stop{Reason: StopBusAccess, PC: d.PC(), ID: hit.id, Access: hit.access}
+	}
+	return nil
+}
+
+// Step executes n instructions. It stops early at watchpoints and errors.
+func (d *Debugger) Step(n int) *Stop {
+	for i := 0; i < n; i++ {
+		if s := d.step(); s != nil {
+			return s
+		}
+	}
+	return &Stop{Reason: StopStep, PC: d.PC()}
+}
+
+// Continue executes instructions until a breakpoint, watchpoint, error or Interrupt.
+func (d *Debugger) Continue() *Stop {
+	return d.runUntil(func(types.DoubleWord) bool { return false })
+}
+
+// RunUntil executes instructions until the PC reaches addr, or stops like Continue.
+func (d *Debugger) RunUntil(addr types.DoubleWord) *Stop {
+	return d.runUntil(func(pc types.DoubleWord) bool { return pc == addr })
+}
+
+func (d *Debugger) runUntil(done func(pc types.DoubleWord) bool) *Stop {
+	// the first instruction is executed even if it is on a breakpoint
+	if s := d.step(); s != nil {
+		return s
+	}
+	for {
+		pc := d.PC()
+		if done(pc) {
+			return &Stop{Reason: StopStep, PC: pc}
+		}
+		if id, ok := d.breakpointAt(pc); ok {
+			return &Stop{Reason: StopBreakpoint, PC: pc, ID: id}
+		}
+		if atomic.SwapInt32(&d.interrupted, 0) != 0 {
+			return &Stop{Reason: StopInterrupt, PC: pc}
+		}
+		if s := d.step(); s != nil {
+			return s
+		}
+	}
+}
+
+// Interrupt stops Continue and RunUntil. It can be called from another goroutine, e.g. on SIGINT.
+// If they are not running, the next one stops after an instruction.
+func (d *Debugger) Interrupt() {
+	atomic.StoreInt32(&d.interrupted, 1)
+}
+
+// AddBreakpoint adds a breakpoint on PC, and returns its ID.
+func (d *Debugger) AddBreakpoint(pc types.DoubleWord) int {
+	id := d.nextBreakpoint
+	d.nextBreakpoint++
+	d.breakpoints[id] = pc
+	return id
+}
+
+// RemoveBreakpoint removes a breakpoint. Returns false if it does not exist.
+func (d *Debugger) RemoveBreakpoint(id int) bool {
+	if _, ok := d.breakpoints[id]; !ok {
+		return false
+	}
+	delete(d.breakpoints, id)
+	return true
+}
+
+// breakpointAt returns the breakpoint on PC with the lowest ID, since several breakpoints can share a PC.
+func (d *Debugger) breakpointAt(pc types.DoubleWord) (int, bool) {
+	found, ok := 0, false
+	for id, addr := range d.breakpoints {
+		if addr == pc && (!ok || id < found) {
+			found, ok = id, true
+		}
+	}
+	return found, ok
+}
+
+// AddWatchpoint adds a watchpoint on physical memory, and returns its ID.
+func (d *Debugger) AddWatchpoint(wp cpu.Watchpoint) int {
+	id := d.cpu.AddWatchpoint(wp)
+	d.watchpoints[id] = wp
+	return id
+}
+
+// RemoveWatchpoint removes a watchpoint. Returns false if it does not exist.
+func (d *Debugger) RemoveWatchpoint(id int) bool {
+	if _, ok := d.watchpoints[id]; !ok {
+		return false
+	}
+	d.cpu.RemoveWatchpoint(id)
+	delete(d.watchpoints, id)
+	return true
+}
+
+// SetBusWatcher sets the bus of the CPU wrapped with Watcher, to watch bus accesses e.g. to MMIO registers.
+func (d *Debugger) SetBusWatcher(w *bus.Watcher) {
+	d.watcher = w
+}
+
+// AddBusWatch adds a watch on bus accesses that pass the filter, and returns its ID.
+// The execution stops after the access, or the accesses are written to out if it is not nil.
+// Loads and stores access the bus in DC stage, so the PC of the stop is past the instruction that accessed.
+func (d *Debugger) AddBusWatch(f bus.Filter, out io.Writer) (int, error) {
+	if d.watcher == nil {
+		return 0, errors.New("bus is not watched")
+	}
+	var id int
+	if out != nil {
+		id = d.watcher.Watch(f, bus.Log(out))
+	} else {
+		id = d.watcher.Watch(f, func(a *bus.Access) {
+			d.busHits = append(d.busHits, busHit{id: id, access: *a})
+		})
+	}
+	d.busWatches[id] = busWatch{filter: f, log: out != nil}
+	return id, nil
+}
+
+// RemoveBusWatch removes a watch on bus accesses. Returns false if it does not exist.
+func (d *Debugger) RemoveBusWatch(id int) bool {
+	if _, ok := d.busWatches[id]; !ok {
+		return false
+	}
+	d.watcher.Unwatch(id)
+	delete(d.busWatches, id)
+	return true
+}
+
+// ReadVirtual reads a word at the virtual address. Returns false if it is not mapped.
+func (d *Debugger) ReadVirtual(vaddr types.DoubleWord) (types.Word, bool) {
+	paddr, ok := d.cpu.Translate(vaddr)
+	if !ok {
+		return 0, false
+	}
+	return d.bus.ReadWord(types.Big, paddr), true
+}
+
+// SetSymbols sets symbols of the program, e.g. from ELF.
+// They are used to resolve addresses in commands, and to show locations.
+func (d *Debugger) SetSymbols(t *symbols.Table) {
+	d.symbols = t
+}
+
+func kindName(kind cpu.WatchKind) string {
+	switch kind {
+	case cpu.WatchRead:
+		return "read"
+	case cpu.WatchWrite:
+		return "write"
+	}
+	return "access"
+}
@@ -0,0 +1,205 @@
+package debugger
+
+import (
+	"bytes"
+	"encoding/binary"
+	"n64emu/pkg/core"
+	"n64emu/pkg/core/bus"
+	"n64emu/pkg/core/cart"
+	"n64emu/pkg/core/mips/r4300i/asm"
+	"n64emu/pkg/core/mips/r4300i/cpu"
+	"n64emu/pkg/symbols"
+	"n64emu/pkg/types"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const entry = types.DoubleWord(0xFFFFFFFF80000400)
+
+func setupDebugger(src string) *Debugger {
+	code := asm.MustAssemble(entry, src)
+	image := make([]types.Byte, cart.RomHeaderSize+len(code))
+	binary.BigEndian.PutUint32(image[0:4], cart.RomHeaderBigEndian)
+	binary.BigEndian.PutUint32(image[0x40:0x44], 0x12345678) // boot code
+	copy(image[cart.RomHeaderSize:], code)
+	n := core.NewN64(&cart.ROM{ProgramCounter: 0x80000400, Image: image})
+	d := New(n.CPU, n.Bus())
+	d.SetBusWatcher(n.WatchBus())
+	return d
+}
+
+const program = `
+	addu v0, s4, s6
+	lw   v1, 0(t3)
+	subu a0, s6, s4
+	nop
+	nop
+`
+
+func TestDebugger_Step(t *testing.T) {
+	assert := assert.New(t)
+	d := setupDebugger(program)
+	assert.Equal(entry, d.PC())
+
+	s := d.Step(1)
+	assert.Equal(StopStep, s.Reason)
+	assert.Equal(entry+4, s.PC)
+	s = d.Step(2)
+	assert.Equal(entry+12, s.PC)
+	d.Step(1)
+	assert.Equal(types.DoubleWord(0x40), d.cpu.GPR(2), "should executed instructions written back")
+	assert.Equal(types.DoubleWord(0x12345678), d.cpu.GPR(3))
+}
+
+func TestDebugger_PC(t *testing.T) {
+	assert := assert.New(t)
+	d := setupDebugger(program)
+	before := d.cpu.Save()
+	assert.Equal(entry, d.PC())
+	assert.Equal(before, d.cpu.Save(), "should inspection not change the CPU state")
+
+	d.Step(1)
+	before = d.cpu.Save()
+	assert.Equal(entry+4, d.PC())
+	assert.Equal(before, d.cpu.Save(), "should inspection not change the CPU state")
+}
+
+func TestDebugger_Breakpoint(t *testing.T) {
+	assert := assert.New(t)
+	d := setupDebugger(program)
+	id := d.AddBreakpoint(entry + 8)
+	s := d.Continue()
+	assert.Equal(StopBreakpoint, s.Reason)
+	assert.Equal(id, s.ID)
+	assert.Equal(entry+8, s.PC)
+
+	// continue from the breakpoint
+	assert.True(d.RemoveBreakpoint(id))
+	assert.False(d.RemoveBreakpoint(id))
+	s = d.RunUntil(entry + 16)
+	assert.Equal(StopStep, s.Reason)
+	assert.Equal(entry+16, s.PC)
+}
+
+func TestDebugger_Breakpoint_SamePC(t *testing.T) {
+	assert := assert.New(t)
+	d := setupDebugger(program)
+	ids := []int{}
+	for i := 0; i < 4; i++ {
+		ids = append(ids, d.AddBreakpoint(entry+8))
+	}
+	s := d.Continue()
+	assert.Equal(StopBreakpoint, s.Reason)
+	assert.Equal(ids[0], s.ID, "should the lowest ID reported")
+
+	// removed one by one as GDB z0 does
+	for _, want := range ids {
+		id, ok := d.breakpointAt(entry + 8)
+		if assert.True(ok) {
+			assert.Equal(want, id, "should the lowest ID found")
+			d.RemoveBreakpoint(id)
+		}
+	}
+	_, ok := d.breakpointAt(entry + 8)
+	assert.False(ok)
+}
+
+func TestDebugger_Watchpoint(t *testing.T) {
+	assert := assert.New(t)
+	d := setupDebugger(program)
+	id := d.AddWatchpoint(cpu.Watchpoint{Addr: 0x0400_0040, Size: 4, Kind: cpu.WatchRead})
+	s := d.Continue()
+	assert.Equal(StopWatchpoint, s.Reason)
+	assert.Equal(id, s.ID)
+	assert.Equal(entry+4, s.Hit.PC, "should the load instruction reported")
+	assert.Equal(types.Word(0x0400_0040), s.Hit.Addr)
+	assert.True(d.RemoveWatchpoint(id))
+}
+
+func TestDebugger_BusWatch(t *testing.T) {
+	assert := assert.New(t)
+	d := setupDebugger(program)
+	id, err := d.AddBusWatch(bus.Filter{Addr: 0x0400_0040, Size: 4, Kind: bus.AccessRead}, nil)
+	assert.NoError(err)
+	s := d.Continue()
+	assert.Equal(StopBusAccess, s.Reason)
+	assert.Equal(id, s.ID)
+	assert.Equal(bus.Access{Kind: bus.AccessRead, Addr: 0x0400_0040, Size: 4, Value: 0x12345678}, s.Access)
+	assert.Equal(entry+12, s.PC, "should stop when the load instruction is in DC stage")
+	assert.Equal("bus watch 0: R4 04000040=12345678 at ffffffff8000040c", s.String())
+	assert.True(d.RemoveBusWatch(id))
+	assert.False(d.RemoveBusWatch(id))
+
+	d = New(d.cpu, d.bus)
+	_, err = d.AddBusWatch(bus.Filter{}, nil)
+	assert.Error(err, "should bus watch need the watcher")
+}
+
+func TestDebugger_Error(t *testing.T) {
+	d := setupDebugger("lui t0, 0x8000")
+	s := d.Step(1)
+	assert.Equal(t, StopError, s.Reason)
+	assert.Equal(t, entry, s.PC)
+	assert.Contains(t, s.Err.Error(), "TODO: LUI")
+}
+
+func TestDebugger_Interrupt(t *testing.T) {
+	d := setupDebugger(program)
+	d.cpu.SetTraceHook(func(*cpu.TraceEvent) { d.Interrupt() })
+	s := d.Continue()
+	assert.Equal(t, StopInterrupt, s.Reason)
+}
+
+func TestDebugger_Run(t *testing.T) {
+	assert := assert.New(t)
+	d := setupDebugger(program)
+	in := strings.Join([]string{
+		"step",
+		"", // repeat
+		"b 8000040c",
+		"c",
+		"regs",
+		"x 04000040 8",
+		"x t3 4",
+		"bl 04000040 4 r 4",
+		"bw 04400000 8 w 1,2",
+		"bw",
+		"l pc 1",
+		"foo",
+		"quit",
+		"step", // not executed
+	}, "\n")
+	out := &bytes.Buffer{}
+	assert.NoError(d.Run(strings.NewReader(in), out))
+	got := out.String()
+	assert.Contains(got, "ffffffff80000400: 02961021  addu    v0, s4, s6")
+	assert.Contains(got, "ffffffff80000408: 02d42023  subu    a0, s6, s4")
+	assert.Contains(got, "breakpoint 0 at ffffffff8000040c")
+	assert.Contains(got, "  v0 0000000000000040")
+	assert.Contains(got, "04000040: 12 34 56 78 00 00 00 00")
+	assert.Contains(got, "*=> ffffffff8000040c: 00000000  nop")
+	assert.Contains(got, "   ffffffff80000410: 00000000  nop")
+	assert.Contains(got, "bus watch 0 at 04000040")
+	assert.Contains(got, "  1  04400000-04400007 w stop")
+	assert.Contains(got, "error: unknown command 'foo'")
+	assert.Equal(entry+12, d.PC())
+}
+
+func TestDebugger_Symbols(t *testing.T) {
+	assert := assert.New(t)
+	d := setupDebugger(program)
+	d.SetSymbols(symbols.New([]symbols.Symbol{
+		{Name: "_start", Addr: entry, Size: 8},
+		{Name: "loop", Addr: entry + 8},
+	}))
+
+	out := &bytes.Buffer{}
+	assert.NoError(d.Run(strings.NewReader("b loop\nc\nl _start 1\nq"), out))
+	got := out.String()
+	assert.Contains(got, "ffffffff80000400 <_start>: 02961021")
+	assert.Contains(got, "breakpoint 0 at ffffffff80000408")
+	assert.Contains(got, "ffffffff80000408 <loop>: 02d42023")
+	assert.Contains(got, "_start:\n    ffffffff80000400")
+}
@@ -0,0 +1,541 @@
+/*
+
+GDB Remote Serial Protocol stub
+
+Supported packets:
+	?                         last stop reason
+	g, G                      read/write all the registers
+	p n, P n=v                read/write a register
+	m addr,len, M addr,len:xx read/write memory at virtual addresses
+	Z0/z0 addr,kind           insert/remove a breakpoint
+	Z2/z2, Z3/z3, Z4/z4       insert/remove a write/read/access watchpoint
+	c [addr], s [addr]        continue/step
+	qSupported, qXfer:features:read, QStartNoAckMode, qAttached, H, k, D
+	0x03                      interrupt
+
+The target is described as mips:4000 with 64-bit registers, numbered as GDB does:
+	| 0-31 | 32     | 33 | 34 | 35       | 36    | 37 | 38-69  | 70   | 71  |
+	| GPR  | status | lo | hi | badvaddr | cause | pc | f0-f31 | fcsr | fir |
+
+Register values are sent in big-endian, the byte order of the target.
+
+Write and access watchpoints are accepted, but writes do not hit them until stores are implemented.
+
+Reference: https://sourceware.org/gdb/onlinedocs/gdb/Remote-Protocol.html
+*/
+
+package debugger
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"n64emu/pkg/core/mips/r4300i/cpu"
+	"n64emu/pkg/core/mips/r4300i/reg"
+	"n64emu/pkg/types"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	gdbRegStatus   = 32
+	gdbRegLO       = 33
+	gdbRegHI       = 34
+	gdbRegBadVAddr = 35
+	gdbRegCause    = 36
+	gdbRegPC       = 37
+	gdbRegF0       = 38
+	gdbRegFCSR     = 70
+	gdbRegFIR      = 71
+	gdbNumOfRegs   = 72
+
+	gdbPacketSize = 0x4000
+)
+
+// signals in stop replies
+const (
+	sigINT  = 0x02
+	sigILL  = 0x04
+	sigTRAP = 0x05
+)
+
+// GDBServer serves GDB remote serial protocol for the debugger
+type GDBServer struct {
+	d     *Debugger
+	conn  io.ReadWriter
+	mu    sync.Mutex // guards writes to conn
+	noAck bool
+	stop  *Stop
+	// watchpoints by "type,addr,kind" of Z packets, and their virtual addresses
+	watchIDs   map[string]int
+	watchAddrs map[int]types.DoubleWord
+}
+
+// NewGDBServer returns GDBServer of the debugger.
+func NewGDBServer(d *Debugger) *GDBServer {
+	return &GDBServer{
+		d:          d,
+		stop:       &Stop{Reason: StopStep},
+		watchIDs:   map[string]int{},
+		watchAddrs: map[int]types.DoubleWord{},
+	}
+}
+
+// ListenAndServe listens on the TCP address, and serves GDB connections one by one until an error.
+func (s *GDBServer) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+	return s.Serve(ln)
+}
+
+// Serve accepts GDB connections on the listener, and serves them one by one until an error.
+func (s *GDBServer) Serve(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		s.ServeConn(conn)
+		conn.Close()
+	}
+}
+
+// ServeConn serves a GDB connection until it is detached or closed.
+func (s *GDBServer) ServeConn(conn io.ReadWriter) {
+	s.conn = conn
+	s.noAck = false
+	packets := make(chan string)
+	done := make(chan struct{})
+	defer close(done)
+	go s.receive(conn, packets, done)
+	for p := range packets {
+		reply, detached := s.handle(p)
+		s.send(reply)
+		if detached {
+			return
+		}
+	}
+}
+
+// receive packets, and interrupts the execution on 0x03
+func (s *GDBServer) receive(r io.Reader, packets chan<- string, done <-chan struct{}) {
+	defer close(packets)
+	br := bufio.NewReader(r)
+	for {
+		c, err := br.ReadByte()
+		if err != nil {
+			return
+		}
+		switch c {
+		case 0x03:
+			s.d.Interrupt()
+		case '$':
+			data, err := br.ReadString('#')
+			if err != nil {
+				return
+			}
+			data = data[:len(data)-1]
+			sum := make([]byte, 2)
+			if _, err := io.ReadFull(br, sum); err != nil {
+				return
+			}
+			if !s.noAck {
+				if want, err := strconv.ParseUint(string(sum), 16, 8); err != nil || byte(want) != checksum(data) {
+					s.write("-")
+					continue
+				}
+				s.write("+")
+			}
+			select {
+			case packets <- data:
+			case <-done:
+				return
+			}
+		}
+		// acks from GDB are ignored, packets are not retransmitted
+	}
+}
+
+func checksum(data string) byte {
+	var sum byte
+	for i := 0; i < len(data); i++ {
+		sum += data[i]
+	}
+	return sum
+}
+
+func (s *GDBServer) write(data string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	io.WriteString(s.conn, data)
+}
+
+func (s *GDBServer) send(data string) {
+	s.write(fmt.Sprintf("$%s#%02x", data, checksum(data)))
+}
+
+// handle a packet, and returns the reply and whether the connection is closed
+func (s *GDBServer) handle(p string) (string, bool) {
+	if p == "" {
+		return "", false
+	}
+	args := p[1:]
+	switch p[0] {
+	case '?':
+		return s.stopReply(), false
+	case 'g':
+		return s.readRegisters(), false
+	case 'G':
+		return s.writeRegisters(args), false
+	case 'p':
+		n, err := strconv.ParseUint(args, 16, 8)
+		if err != nil || n >= gdbNumOfRegs {
+			return "E01", false
+		}
+		return fmt.Sprintf("%016x", s.register(s.d.cpu.Save(), int(n))), false
+	case 'P':
+		return s.writeRegister(args), false
+	case 'm':
+		return s.readMemory(args), false
+	case 'M':
+		return s.writeMemory(args), false
+	case 'Z', 'z':
+		return s.point(p[0] == 'Z', args), false
+	case 'c', 's':
+		if args != "" {
+			addr, err := strconv.ParseUint(args, 16, 64)
+			if err != nil {
+				return "E01", false
+			}
+			s.d.cpu.SetPC(signExtend(addr))
+		}
+		if p[0] == 's' {
+			s.stop = s.d.Step(1)
+		} else {
+			s.stop = s.d.Continue()
+		}
+		return s.stopReply(), false
+	case 'H':
+		return "OK", false
+	case 'k':
+		return "", true
+	case 'D':
+		return "OK", true
+	case 'q', 'Q':
+		return s.query(p), false
+	}
+	return "", false
+}
+
+func (s *GDBServer) query(p string) string {
+	switch {
+	case strings.HasPrefix(p, "qSupported"):
+		return fmt.Sprintf("PacketSize=%x;qXfer:features:read+;QStartNoAckMode+", gdbPacketSize)
+	case p == "QStartNoAckMode":
+		s.noAck = true
+		return "OK"
+	case p == "qAttached":
+		return "1"
+	case strings.HasPrefix(p, "qXfer:features:read:target.xml:"):
+		var offset, length int
+		if _, err := fmt.Sscanf(strings.TrimPrefix(p, "qXfer:features:read:target.xml:"), "%x,%x", &offset, &length); err != nil {
+			return "E01"
+		}
+		xml := targetXML()
+		if offset >= len(xml) {
+			return "l"
+		}
+		if offset+length >= len(xml) {
+			return "l" + xml[offset:]
+		}
+		return "m" + xml[offset:offset+length]
+	}
+	return ""
+}
+
+func (s *GDBServer) stopReply() string {
+	switch s.stop.Reason {
+	case StopWatchpoint:
+		kind := "awatch"
+		switch s.stop.Hit.Kind {
+		case cpu.WatchRead:
+			kind = "rwatch"
+		case cpu.WatchWrite:
+			kind = "watch"
+		}
+		return fmt.Sprintf("T%02x%s:%x;", sigTRAP, kind, s.watchAddrs[s.stop.ID])
+	case StopInterrupt:
+		return fmt.Sprintf("S%02x", sigINT)
+	case StopError:
+		return fmt.Sprintf("S%02x", sigILL)
+	}
+	return fmt.Sprintf("S%02x", sigTRAP)
+}
+
+// value of the register numbered by GDB
+func (s *GDBServer) register(st cpu.State, n int) types.DoubleWord {
+	switch {
+	case n < gdbRegStatus:
+		return st.GPR[n]
+	case n == gdbRegStatus:
+		return st.CP0[reg.CP0Status]
+	case n == gdbRegLO:
+		return st.LO
+	case n == gdbRegHI:
+		return st.HI
+	case n == gdbRegBadVAddr:
+		return st.CP0[reg.CP0BadVAddr]
+	case n == gdbRegCause:
+		return st.CP0[reg.CP0Cause]
+	case n == gdbRegPC:
+		return s.d.PC()
+	case n < gdbRegFCSR:
+		return st.FPR[n-gdbRegF0]
+	case n == gdbRegFCSR:
+		return types.DoubleWord(st.FCR31)
+	}
+	return types.DoubleWord(st.FCR0)
+}
+
+// set the register numbered by GDB. pc is ignored, it is set by CPU.SetPC to redirect the pipeline.
+func setRegister(st *cpu.State, n int, v types.DoubleWord) {
+	switch {
+	case n < gdbRegStatus:
+		st.GPR[n] = v
+	case n == gdbRegStatus:
+		st.CP0[reg.CP0Status] = v
+	case n == gdbRegLO:
+		st.LO = v
+	case n == gdbRegHI:
+		st.HI = v
+	case n == gdbRegBadVAddr:
+		st.CP0[reg.CP0BadVAddr] = v
+	case n == gdbRegCause:
+		st.CP0[reg.CP0Cause] = v
+	case n == gdbRegPC:
+	case n < gdbRegFCSR:
+		st.FPR[n-gdbRegF0] = v
+	case n == gdbRegFCSR:
+		st.FCR31 = types.Word(v)
+	default:
+		// FIR is read-only
+	}
+}
+
+func (s *GDBServer) readRegisters() string {
+	st := s.d.cpu.Save()
+	var b strings.Builder
+	for n := 0; n < gdbNumOfRegs; n++ {
+		fmt.Fprintf(&b, "%016x", s.register(st, n))
+	}
+	return b.String()
+}
+
+func (s *GDBServer) writeRegisters(args string) string {
+	if len(args) != gdbNumOfRegs*16 {
+		return "E01"
+	}
+	values := make([]types.DoubleWord, gdbNumOfRegs)
+	for n := range values {
+		v, err := strconv.ParseUint(args[n*16:(n+1)*16], 16, 64)
+		if err != nil {
+			return "E01"
+		}
+		values[n] = v
+	}
+	st := s.d.cpu.Save()
+	for n, v := range values {
+		setRegister(&st, n, v)
+	}
+	s.d.cpu.Load(&st)
+	if pc := values[gdbRegPC]; pc != s.d.PC() {
+		s.d.cpu.SetPC(pc)
+	}
+	return "OK"
+}
+
+func (s *GDBServer) writeRegister(args string) string {
+	fields := strings.SplitN(args, "=", 2)
+	if len(fields) != 2 {
+		return "E01"
+	}
+	n, err := strconv.ParseUint(fields[0], 16, 8)
+	if err != nil || n >= gdbNumOfRegs {
+		return "E01"
+	}
+	v, err := strconv.ParseUint(fields[1], 16, 64)
+	if err != nil {
+		return "E01"
+	}
+	if n == gdbRegPC {
+		s.d.cpu.SetPC(v)
+		return "OK"
+	}
+	st := s.d.cpu.Save()
+	setRegister(&st, int(n), v)
+	s.d.cpu.Load(&st)
+	return "OK"
+}
+
+// 32-bit addresses are sign-extended, for GDB with 32-bit pointers
+func signExtend(addr types.DoubleWord) types.DoubleWord {
+	if addr <= 0xFFFF_FFFF {
+		return types.DoubleWord(types.SWord(addr))
+	}
+	return addr
+}
+
+// parse "addr,len"
+func parseAddrLen(args string) (types.DoubleWord, int, bool) {
+	fields := strings.SplitN(args, ",", 2)
+	if len(fields) != 2 {
+		return 0, 0, false
+	}
+	addr, err := strconv.ParseUint(fields[0], 16, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	length, err := strconv.ParseUint(fields[1], 16, 32)
+	if err != nil || length > gdbPacketSize/2 {
+		return 0, 0, false
+	}
+	return signExtend(addr), int(length), true
+}
+
+func (s *GDBServer) readMemory(args string) string {
+	addr, length, ok := parseAddrLen(args)
+	if !ok {
+		return "E01"
+	}
+	data := make([]byte, length)
+	for i := range data {
+		paddr, ok := s.d.cpu.Translate(addr + types.DoubleWord(i))
+		if !ok {
+			return "E14" // EFAULT
+		}
+		data[i] = s.d.bus.ReadByte(types.Big, paddr)
+	}
+	return hex.EncodeToString(data)
+}
+
+func (s *GDBServer) writeMemory(args string) string {
+	fields := strings.SplitN(args, ":", 2)
+	if len(fields) != 2 {
+		return "E01"
+	}
+	addr, length, ok := parseAddrLen(fields[0])
+	if !ok {
+		return "E01"
+	}
+	data, err := hex.DecodeString(fields[1])
+	if err != nil || len(data) != length {
+		return "E01"
+	}
+	for i, b := range data {
+		paddr, ok := s.d.cpu.Translate(addr + types.DoubleWord(i))
+		if !ok {
+			return "E14" // EFAULT
+		}
+		s.d.bus.WriteByte(types.Big, paddr, b)
+	}
+	return "OK"
+}
+
+// insert or remove a breakpoint or watchpoint
+func (s *GDBServer) point(insert bool, args string) string {
+	fields := strings.Split(args, ",")
+	if len(fields) < 3 {
+		return "E01"
+	}
+	addr, err := strconv.ParseUint(fields[1], 16, 64)
+	if err != nil {
+		return "E01"
+	}
+	addr = signExtend(addr)
+	size, err := strconv.ParseUint(fields[2], 16, 32)
+	if err != nil {
+		return "E01"
+	}
+
+	var kind cpu.WatchKind
+	switch fields[0] {
+	case "0":
+		if insert {
+			s.d.AddBreakpoint(addr)
+			return "OK"
+		}
+		if id, ok := s.d.breakpointAt(addr); ok {
+			s.d.RemoveBreakpoint(id)
+		}
+		return "OK"
+	case "2":
+		kind = cpu.WatchWrite
+	case "3":
+		kind = cpu.WatchRead
+	case "4":
+		kind = cpu.WatchRead | cpu.WatchWrite
+	default:
+		// hardware breakpoints are not supported
+		return ""
+	}
+
+	key := strings.Join(fields[:3], ",")
+	if !insert {
+		if id, ok := s.watchIDs[key]; ok {
+			s.d.RemoveWatchpoint(id)
+			delete(s.watchIDs, key)
+			delete(s.watchAddrs, id)
+		}
+		return "OK"
+	}
+	paddr, ok := s.d.cpu.Translate(addr)
+	if !ok {
+		return "E14" // EFAULT
+	}
+	id := s.d.AddWatchpoint(cpu.Watchpoint{Addr: paddr, Size: types.Word(size), Kind: kind})
+	s.watchIDs[key] = id
+	s.watchAddrs[id] = addr
+	return "OK"
+}
+
+// target description
+func targetXML() string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0"?><!DOCTYPE target SYSTEM "gdb-target.dtd"><target version="1.0">`)
+	b.WriteString(`<architecture>mips:4000</architecture>`)
+	regXML := func(name string, n int, typ string) {
+		fmt.Fprintf(&b, `<reg name="%s" bitsize="64" regnum="%d" type="%s"/>`, name, n, typ)
+	}
+
+	b.WriteString(`<feature name="org.gnu.gdb.mips.cpu">`)
+	for n := 0; n < reg.NumOfRegsInGpr; n++ {
+		regXML(fmt.Sprintf("r%d", n), n, "int")
+	}
+	regXML("lo", gdbRegLO, "int")
+	regXML("hi", gdbRegHI, "int")
+	regXML("pc", gdbRegPC, "code_ptr")
+	b.WriteString(`</feature>`)
+
+	b.WriteString(`<feature name="org.gnu.gdb.mips.cp0">`)
+	regXML("status", gdbRegStatus, "int")
+	regXML("badvaddr", gdbRegBadVAddr, "data_ptr")
+	regXML("cause", gdbRegCause, "int")
+	b.WriteString(`</feature>`)
+
+	b.WriteString(`<feature name="org.gnu.gdb.mips.fpu">`)
+	for n := 0; n < reg.NumOfRegsInFpr; n++ {
+		regXML(fmt.Sprintf("f%d", n), gdbRegF0+n, "ieee_double")
+	}
+	regXML("fcsr", gdbRegFCSR, "int")
+	regXML("fir", gdbRegFIR, "int")
+	b.WriteString(`</feature>`)
+
+	b.WriteString(`</target>`)
+	return b.String()
+}
@@ -0,0 +1,175 @@
+package debugger
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"n64emu/pkg/core/mips/r4300i/cpu"
+	"n64emu/pkg/types"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// scripted GDB client
+type gdbClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func setupGDB(t *testing.T, src string) (*Debugger, *gdbClient) {
+	d := setupDebugger(src)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go NewGDBServer(d).Serve(ln)
+	t.Cleanup(func() { ln.Close() })
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return d, &gdbClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+// send a packet, and returns the reply
+func (c *gdbClient) request(p string) string {
+	fmt.Fprintf(c.conn, "$%s#%02x", p, checksum(p))
+	ack, err := c.r.ReadByte()
+	assert.NoError(c.t, err)
+	assert.Equal(c.t, byte('+'), ack, "should packet acknowledged")
+	return c.reply()
+}
+
+func (c *gdbClient) reply() string {
+	if _, err := c.r.ReadString('$'); err != nil {
+		c.t.Fatal(err)
+	}
+	data, err := c.r.ReadString('#')
+	assert.NoError(c.t, err)
+	sum := make([]byte, 2)
+	io.ReadFull(c.r, sum)
+	data = data[:len(data)-1]
+	assert.Equal(c.t, fmt.Sprintf("%02x", checksum(data)), string(sum))
+	c.conn.Write([]byte("+"))
+	return data
+}
+
+// watchpoints set in the debugger
+func watchpoints(d *Debugger) []cpu.Watchpoint {
+	wps := []cpu.Watchpoint{}
+	for _, wp := range d.watchpoints {
+		wps = append(wps, wp)
+	}
+	return wps
+}
+
+func TestGDB_Registers(t *testing.T) {
+	assert := assert.New(t)
+	d, c := setupGDB(t, program)
+
+	assert.Contains(c.request("qSupported:multiprocess+"), "qXfer:features:read+")
+	assert.Equal("S05", c.request("?"))
+
+	regs := c.request("g")
+	assert.Equal(gdbNumOfRegs*16, len(regs))
+	assert.Equal("ffffffffa4001ff0", regs[29*16:30*16], "should sp sent")
+	assert.Equal("0000000034000000", regs[gdbRegStatus*16:(gdbRegStatus+1)*16], "should status sent")
+	assert.Equal("ffffffff80000400", regs[gdbRegPC*16:(gdbRegPC+1)*16], "should pc sent")
+
+	assert.Equal("000000000000003f", c.request("p16"), "should s6 sent")
+	assert.Equal("OK", c.request("P4=0000000000001234"))
+	assert.Equal(types.DoubleWord(0x1234), d.cpu.GPR(4))
+	assert.Equal("OK", c.request("P21=0000000000005678"))
+	assert.Equal(types.DoubleWord(0x5678), d.cpu.Save().LO)
+
+	// write all the registers back, with a0 changed
+	regs = regs[:4*16] + "0000000000000042" + regs[5*16:]
+	assert.Equal("OK", c.request("G"+regs))
+	assert.Equal(types.DoubleWord(0x42), d.cpu.GPR(4))
+	assert.Equal(entry, d.PC(), "should pc not changed")
+
+	assert.Equal("E01", c.request("p99"))
+}
+
+func TestGDB_Memory(t *testing.T) {
+	assert := assert.New(t)
+	_, c := setupGDB(t, program)
+
+	assert.Equal("02961021", c.request("mffffffff80000400,4"))
+	assert.Equal("12345678", c.request("ma4000040,4"), "should 32-bit address sign-extended")
+	assert.Equal("OK", c.request("Mffffffff80100000,2:abcd"))
+	assert.Equal("abcd00", c.request("mffffffff80100000,3"))
+	assert.Equal("E14", c.request("m00000000,4"), "should unmapped address fail")
+}
+
+func TestGDB_Run(t *testing.T) {
+	assert := assert.New(t)
+	d, c := setupGDB(t, program)
+
+	assert.Equal("S05", c.request("s"))
+	assert.Equal(entry+4, d.PC())
+
+	assert.Equal("OK", c.request("Z0,ffffffff8000040c,4"))
+	assert.Equal("S05", c.request("c"))
+	assert.Equal(entry+12, d.PC(), "should stopped at breakpoint")
+	assert.Equal("OK", c.request("z0,ffffffff8000040c,4"))
+
+	// set pc, and hit read watchpoint
+	assert.Equal("OK", c.request("Z3,ffffffffa4000040,4"))
+	assert.Equal("T05rwatch:ffffffffa4000040;", c.request("cffffffff80000400"))
+	assert.Equal("OK", c.request("z3,ffffffffa4000040,4"))
+
+	// write and access watchpoints
+	assert.Equal("OK", c.request("Z2,ffffffffa4000040,4"))
+	assert.Equal("OK", c.request("Z4,ffffffffa4000044,4"))
+	assert.ElementsMatch([]cpu.Watchpoint{
+		{Addr: 0x0400_0040, Size: 4, Kind: cpu.WatchWrite},
+		{Addr: 0x0400_0044, Size: 4, Kind: cpu.WatchRead | cpu.WatchWrite},
+	}, watchpoints(d), "should write and access watchpoints accepted")
+	assert.Equal("OK", c.request("z2,ffffffffa4000040,4"))
+	assert.Equal("OK", c.request("z4,ffffffffa4000044,4"))
+	assert.Empty(watchpoints(d))
+
+	assert.Equal("", c.request("Z1,ffffffff80000400,4"), "should hardware breakpoints unsupported")
+	assert.Equal("", c.request("vMustReplyEmpty"))
+	assert.Equal("OK", c.request("D"))
+}
+
+func TestGDB_TargetXML(t *testing.T) {
+	assert := assert.New(t)
+	_, c := setupGDB(t, program)
+
+	xml := ""
+	for {
+		r := c.request(fmt.Sprintf("qXfer:features:read:target.xml:%x,%x", len(xml), 0x100))
+		xml += r[1:]
+		if r[0] == 'l' {
+			break
+		}
+		assert.Equal(byte('m'), r[0])
+	}
+	assert.Equal(targetXML(), xml)
+	assert.Contains(xml, "<architecture>mips:4000</architecture>")
+	assert.Contains(xml, `<reg name="pc" bitsize="64" regnum="37" type="code_ptr"/>`)
+	assert.Equal(gdbNumOfRegs, strings.Count(xml, "<reg "))
+}
+
+func TestGDB_Interrupt(t *testing.T) {
+	// infinite loop
+	_, c := setupGDB(t, `
+	loop:
+		jr a0
+		nop
+	`)
+	assert.Equal(t, "OK", c.request(fmt.Sprintf("P4=%016x", entry)))
+	fmt.Fprintf(c.conn, "$c#%02x", checksum("c"))
+	c.r.ReadByte() // ack
+	c.conn.Write([]byte{0x03})
+	assert.Equal(t, "S02", c.reply())
+}
@@ -0,0 +1,474 @@
+package debugger
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"n64emu/pkg/core/bus"
+	"n64emu/pkg/core/mips/r4300i/cpu"
+	"n64emu/pkg/core/mips/r4300i/disasm"
+	"n64emu/pkg/core/mips/r4300i/reg"
+	"n64emu/pkg/types"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const prompt = "(n64dbg) "
+
+const help = `commands:
+  s, step [n]               execute n instructions (default 1)
+  c, continue               run until a breakpoint or watchpoint
+  u, until <addr>           run until pc reaches addr
+  b, break [addr]           set a breakpoint on pc, or list breakpoints
+  w, watch <paddr> [size] [r|w|rw]
+                            set a watchpoint on physical memory (default 4 bytes, rw)
+                            (w is never hit, since stores are not implemented yet)
+  d, delete <id>            delete a breakpoint
+  unwatch <id>              delete a watchpoint
+  bw, buswatch [<paddr> [size] [r|w|rw] [widths]]
+                            stop on bus accesses e.g. to MMIO registers, or list bus watches
+                            (default 4 bytes, rw, widths of all e.g. 1,2,4,8)
+  bl, buslog <paddr> [size] [r|w|rw] [widths]
+                            print bus accesses without stopping
+  unbw <id>                 delete a bus watch
+  r, regs                   print GPR, HI and LO
+  fpr                       print FPR
+  cp0                       print CP0 registers
+  x <paddr> [len]           hexdump physical memory (default 64 bytes)
+  l, dis [addr] [n]         disassemble n instructions around addr (default pc, 5)
+  h, help                   print this help
+  q, quit                   quit the debugger
+addresses and numbers are hex, and may be register names e.g. sp, $a0, pc, or symbols.
+8-digit addresses are sign-extended, and virtual addresses are translated for paddr.
+an empty line repeats the last command.`
+
+// errQuit is returned by the quit command
+var errQuit = errors.New("quit")
+
+// Run reads commands from in, and writes results to out until quit or EOF.
+func (d *Debugger) Run(in io.Reader, out io.Writer) error {
+	s := bufio.NewScanner(in)
+	last := ""
+	d.printLocation(out)
+	for {
+		fmt.Fprint(out, prompt)
+		if !s.Scan() {
+			fmt.Fprintln(out)
+			return s.Err()
+		}
+		line := strings.TrimSpace(s.Text())
+		if line == "" {
+			line = last
+		}
+		if line == "" {
+			continue
+		}
+		last = line
+		if err := d.Exec(out, line); err != nil {
+			if err == errQuit {
+				return nil
+			}
+			fmt.Fprintf(out, "error: %s\n", err)
+		}
+	}
+}
+
+// Exec executes a command line.
+func (d *Debugger) Exec(out io.Writer, line string) error {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil
+	}
+	args := fields[1:]
+	switch fields[0] {
+	case "s", "step":
+		n := 1
+		if len(args) > 0 {
+			v, err := strconv.Atoi(args[0])
+			if err != nil || v <= 0 {
+				return fmt.Errorf("invalid count '%s'", args[0])
+			}
+			n = v
+		}
+		d.printStop(out, d.Step(n))
+	case "c", "continue":
+		d.printStop(out, d.Continue())
+	case "u", "until":
+		if len(args) != 1 {
+			return errors.New("address is required")
+		}
+		addr, err := d.parseAddress(args[0])
+		if err != nil {
+			return err
+		}
+		d.printStop(out, d.RunUntil(addr))
+	case "b", "break":
+		if len(args) == 0 {
+			d.printBreakpoints(out)
+			return nil
+		}
+		addr, err := d.parseAddress(args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "breakpoint %d at %016x\n", d.AddBreakpoint(addr), addr)
+	case "w", "watch":
+		return d.watch(out, args)
+	case "bw", "buswatch", "bl", "buslog":
+		if len(args) == 0 {
+			d.printBusWatches(out)
+			return nil
+		}
+		f, err := d.parseBusFilter(args)
+		if err != nil {
+			return err
+		}
+		var log io.Writer
+		if fields[0] == "bl" || fields[0] == "buslog" {
+			log = out
+		}
+		id, err := d.AddBusWatch(f, log)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "bus watch %d at %08x\n", id, f.Addr)
+	case "d", "delete", "unwatch", "unbw":
+		if len(args) != 1 {
+			return errors.New("id is required")
+		}
+		id, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid id '%s'", args[0])
+		}
+		var ok bool
+		switch fields[0] {
+		case "unwatch":
+			ok = d.RemoveWatchpoint(id)
+		case "unbw":
+			ok = d.RemoveBusWatch(id)
+		default:
+			ok = d.RemoveBreakpoint(id)
+		}
+		if !ok {
+			return fmt.Errorf("no such id %d", id)
+		}
+	case "r", "regs":
+		d.printGPR(out)
+	case "fpr":
+		d.printFPR(out)
+	case "cp0":
+		d.printCP0(out)
+	case "x":
+		return d.hexdump(out, args)
+	case "l", "dis":
+		return d.disassemble(out, args)
+	case "h", "help":
+		fmt.Fprintln(out, help)
+	case "q", "quit":
+		return errQuit
+	default:
+		return fmt.Errorf("unknown command '%s', try 'help'", fields[0])
+	}
+	return nil
+}
+
+// parse hex number or register name
+func (d *Debugger) parseAddress(s string) (types.DoubleWord, error) {
+	name := strings.ToLower(strings.TrimPrefix(s, "$"))
+	if name == "pc" {
+		return d.PC(), nil
+	}
+	for i, n := range reg.GPRNames {
+		if n == name {
+			return d.cpu.GPR(types.Byte(i)), nil
+		}
+	}
+	if addr, ok := d.symbols.Find(s); ok {
+		return addr, nil
+	}
+	digits := strings.TrimPrefix(name, "0x")
+	v, err := strconv.ParseUint(digits, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid address '%s'", s)
+	}
+	// 32-bit addresses are sign-extended
+	if len(digits) <= 8 {
+		v = types.DoubleWord(types.SWord(v))
+	}
+	return v, nil
+}
+
+// parse physical address.
+// Addresses beyond 32-bit, including sign-extended kseg0 and kseg1 addresses, are translated as virtual addresses.
+func (d *Debugger) parsePhysical(s string) (types.Word, error) {
+	v, err := d.parseAddress(s)
+	if err != nil {
+		return 0, err
+	}
+	if v <= 0xFFFF_FFFF {
+		return types.Word(v), nil
+	}
+	paddr, ok := d.cpu.Translate(v)
+	if !ok {
+		return 0, fmt.Errorf("address %016x is not mapped", v)
+	}
+	return paddr, nil
+}
+
+func (d *Debugger) watch(out io.Writer, args []string) error {
+	if len(args) == 0 {
+		d.printWatchpoints(out)
+		return nil
+	}
+	addr, size, kind, err := d.parseWatchRange(args)
+	if err != nil {
+		return err
+	}
+	wp := cpu.Watchpoint{Addr: addr, Size: size, Kind: cpu.WatchRead | cpu.WatchWrite}
+	switch kind {
+	case "r":
+		wp.Kind = cpu.WatchRead
+	case "w":
+		wp.Kind = cpu.WatchWrite
+	}
+	fmt.Fprintf(out, "watchpoint %d at %08x\n", d.AddWatchpoint(wp), wp.Addr)
+	return nil
+}
+
+// parse <paddr> [size] [r|w|rw] of watch commands
+func (d *Debugger) parseWatchRange(args []string) (addr types.Word, size types.Word, kind string, err error) {
+	if addr, err = d.parsePhysical(args[0]); err != nil {
+		return 0, 0, "", err
+	}
+	size, kind = 4, "rw"
+	if len(args) > 1 {
+		v, err := strconv.ParseUint(strings.TrimPrefix(args[1], "0x"), 16, 32)
+		if err != nil || v == 0 {
+			return 0, 0, "", fmt.Errorf("invalid size '%s'", args[1])
+		}
+		size = types.Word(v)
+	}
+	if len(args) > 2 {
+		switch args[2] {
+		case "r", "w", "rw":
+			kind = args[2]
+		default:
+			return 0, 0, "", fmt.Errorf("invalid kind '%s'", args[2])
+		}
+	}
+	return addr, size, kind, nil
+}
+
+// parse <paddr> [size] [r|w|rw] [widths] of bus watch commands, widths are comma separated e.g. 1,2
+func (d *Debugger) parseBusFilter(args []string) (bus.Filter, error) {
+	addr, size, kind, err := d.parseWatchRange(args)
+	if err != nil {
+		return bus.Filter{}, err
+	}
+	f := bus.Filter{Addr: addr, Size: size}
+	switch kind {
+	case "r":
+		f.Kind = bus.AccessRead
+	case "w":
+		f.Kind = bus.AccessWrite
+	}
+	if len(args) > 3 {
+		for _, w := range strings.Split(args[3], ",") {
+			switch w {
+			case "1", "2", "4", "8":
+				f.Widths |= types.Byte(w[0] - '0')
+			default:
+				return bus.Filter{}, fmt.Errorf("invalid width '%s'", w)
+			}
+		}
+	}
+	return f, nil
+}
+
+func (d *Debugger) printStop(out io.Writer, s *Stop) {
+	if s.Reason != StopStep {
+		fmt.Fprintln(out, s.Format(d.symbols))
+	}
+	d.printLocation(out)
+}
+
+// print the instruction to be executed next
+func (d *Debugger) printLocation(out io.Writer) {
+	pc := d.PC()
+	opcode, ok := d.ReadVirtual(pc)
+	if !ok {
+		fmt.Fprintf(out, "%s: <unmapped>\n", d.formatAddress(pc))
+		return
+	}
+	fmt.Fprintf(out, "%s: %08x  %s\n", d.formatAddress(pc), opcode, disasm.Disassemble(pc, opcode))
+}
+
+// address with symbol e.g. "ffffffff80000404 <main+0x4>"
+func (d *Debugger) formatAddress(addr types.DoubleWord) string {
+	return d.symbols.Format(addr)
+}
+
+func (d *Debugger) printBreakpoints(out io.Writer) {
+	ids := []int{}
+	for id := range d.breakpoints {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		fmt.Fprintf(out, "%3d  %s\n", id, d.formatAddress(d.breakpoints[id]))
+	}
+}
+
+func (d *Debugger) printWatchpoints(out io.Writer) {
+	ids := []int{}
+	for id := range d.watchpoints {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		wp := d.watchpoints[id]
+		fmt.Fprintf(out, "%3d  %08x-%08x %s\n", id, wp.Addr, wp.Addr+wp.Size-1, kindName(wp.Kind))
+	}
+}
+
+func (d *Debugger) printBusWatches(out io.Writer) {
+	ids := []int{}
+	for id := range d.busWatches {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		w := d.busWatches[id]
+		kind := "rw"
+		switch w.filter.Kind {
+		case bus.AccessRead:
+			kind = "r"
+		case bus.AccessWrite:
+			kind = "w"
+		}
+		action := "stop"
+		if w.log {
+			action = "log"
+		}
+		fmt.Fprintf(out, "%3d  %08x-%08x %s %s\n", id, w.filter.Addr, w.filter.Addr+w.filter.Size-1, kind, action)
+	}
+}
+
+func (d *Debugger) printGPR(out io.Writer) {
+	s := d.cpu.Save()
+	for i := 0; i < reg.NumOfRegsInGpr; i += 4 {
+		row := []string{}
+		for j := i; j < i+4; j++ {
+			row = append(row, fmt.Sprintf("%4s %016x", reg.GPRNames[j], s.GPR[j]))
+		}
+		fmt.Fprintln(out, strings.Join(row, "  "))
+	}
+	fmt.Fprintf(out, "%4s %016x  %4s %016x  %4s %016x\n", "pc", d.PC(), "hi", s.HI, "lo", s.LO)
+}
+
+func (d *Debugger) printFPR(out io.Writer) {
+	s := d.cpu.Save()
+	for i, bits := range s.FPR {
+		fmt.Fprintf(out, "%4s %016x  %g\n", fmt.Sprintf("f%d", i), bits, math.Float64frombits(bits))
+	}
+	fmt.Fprintf(out, "%4s %08x  %4s %08x\n", "fcr0", s.FCR0, "fcr31", s.FCR31)
+}
+
+func (d *Debugger) printCP0(out io.Writer) {
+	s := d.cpu.Save()
+	for i := 0; i < reg.NumOfRegsInCp0; i += 4 {
+		row := []string{}
+		for j := i; j < i+4; j++ {
+			row = append(row, fmt.Sprintf("%8s %016x", reg.CP0Names[j], s.CP0[j]))
+		}
+		fmt.Fprintln(out, strings.Join(row, "  "))
+	}
+}
+
+func (d *Debugger) hexdump(out io.Writer, args []string) error {
+	if len(args) == 0 {
+		return errors.New("address is required")
+	}
+	addr, err := d.parsePhysical(args[0])
+	if err != nil {
+		return err
+	}
+	length := types.Word(64)
+	if len(args) > 1 {
+		v, err := strconv.ParseUint(strings.TrimPrefix(args[1], "0x"), 16, 32)
+		if err != nil || v == 0 {
+			return fmt.Errorf("invalid length '%s'", args[1])
+		}
+		length = types.Word(v)
+	}
+
+	start := addr &^ 0xf
+	for line := start; line < addr+length; line += 16 {
+		text := make([]byte, 16)
+		fmt.Fprintf(out, "%08x: ", line)
+		for i := types.Word(0); i < 16; i++ {
+			a := line + i
+			if a < addr || a >= addr+length {
+				fmt.Fprint(out, "   ")
+				text[i] = ' '
+			} else {
+				b := d.bus.ReadByte(types.Big, a)
+				fmt.Fprintf(out, "%02x ", b)
+				text[i] = '.'
+				if 0x20 <= b && b < 0x7f {
+					text[i] = b
+				}
+			}
+			if i == 7 {
+				fmt.Fprint(out, " ")
+			}
+		}
+		fmt.Fprintf(out, " |%s|\n", text)
+	}
+	return nil
+}
+
+func (d *Debugger) disassemble(out io.Writer, args []string) error {
+	pc := d.PC()
+	addr := pc
+	if len(args) > 0 {
+		v, err := d.parseAddress(args[0])
+		if err != nil {
+			return err
+		}
+		addr = v &^ 0x3
+	}
+	n := 5
+	if len(args) > 1 {
+		v, err := strconv.Atoi(args[1])
+		if err != nil || v <= 0 {
+			return fmt.Errorf("invalid count '%s'", args[1])
+		}
+		n = v
+	}
+
+	// n instructions before and after addr
+	for i := -n; i <= n; i++ {
+		a := addr + types.DoubleWord(i*4)
+		mark := "   "
+		if a == pc {
+			mark = " =>"
+		}
+		if _, ok := d.breakpointAt(a); ok {
+			mark = "*" + mark[1:]
+		}
+		if sym, ok := d.symbols.At(a); ok {
+			fmt.Fprintf(out, "%s:\n", sym.Name)
+		}
+		opcode, ok := d.ReadVirtual(a)
+		if !ok {
+			fmt.Fprintf(out, "%s %016x: <unmapped>\n", mark, a)
+			continue
+		}
+		fmt.Fprintf(out, "%s %016x: %08x  %s\n", mark, a, opcode, disasm.Disassemble(a, opcode))
+	}
+	return nil
+}
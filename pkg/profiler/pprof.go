@@ -0,0 +1,216 @@
+/*
+
+pprof Output
+
+The profile is a gzip-compressed protocol buffer of profile.proto, encoded by hand to avoid dependencies.
+	sample types: samples/count, cycles/count
+	locations   : an address of the emulated program each, with the function of the symbol containing it
+	functions   : symbols, or the address itself if no symbol contains it
+
+Reference: https://github.com/google/pprof/blob/master/proto/profile.proto
+*/
+
+package profiler
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"n64emu/pkg/symbols"
+	"n64emu/pkg/types"
+)
+
+const (
+	// VR4300 clock of N64, for the duration of the profile
+	cpuClock = 93_750_000
+)
+
+// field numbers of profile.proto
+const (
+	profileSampleType    = 1
+	profileSample        = 2
+	profileMapping       = 3
+	profileLocation      = 4
+	profileFunction      = 5
+	profileStringTable   = 6
+	profileDurationNanos = 10
+	profilePeriodType    = 11
+	profilePeriod        = 12
+
+	valueTypeType = 1
+	valueTypeUnit = 2
+
+	sampleLocationID = 1
+	sampleValue      = 2
+
+	mappingID           = 1
+	mappingMemoryStart  = 2
+	mappingMemoryLimit  = 3
+	mappingFilename     = 5
+	mappingHasFunctions = 7
+
+	locationID        = 1
+	locationMappingID = 2
+	locationAddress   = 3
+	locationLine      = 4
+
+	lineFunctionID = 1
+
+	functionID         = 1
+	functionName       = 2
+	functionSystemName = 3
+	functionStartLine  = 5
+)
+
+// protocol buffer encoder
+type protoBuffer struct {
+	data []byte
+}
+
+func (b *protoBuffer) varint(x uint64) {
+	for x >= 0x80 {
+		b.data = append(b.data, byte(x)|0x80)
+		x >>= 7
+	}
+	b.data = append(b.data, byte(x))
+}
+
+// varint field
+func (b *protoBuffer) uint64(field int, x uint64) {
+	if x == 0 {
+		return
+	}
+	b.varint(uint64(field)<<3 | 0)
+	b.varint(x)
+}
+
+// length-delimited field
+func (b *protoBuffer) bytes(field int, data []byte) {
+	b.varint(uint64(field)<<3 | 2)
+	b.varint(uint64(len(data)))
+	b.data = append(b.data, data...)
+}
+
+// packed repeated varint field
+func (b *protoBuffer) packed(field int, xs []uint64) {
+	packed := &protoBuffer{}
+	for _, x := range xs {
+		packed.varint(x)
+	}
+	b.bytes(field, packed.data)
+}
+
+func (b *protoBuffer) message(field int, encode func(m *protoBuffer)) {
+	m := &protoBuffer{}
+	encode(m)
+	b.bytes(field, m.data)
+}
+
+// profile under construction
+type pprofBuilder struct {
+	strings   []string
+	stringIDs map[string]uint64
+	functions map[string]uint64 // by name
+	locations map[types.DoubleWord]uint64
+	buf       protoBuffer
+	symbols   *symbols.Table
+}
+
+func (p *pprofBuilder) str(s string) uint64 {
+	if id, ok := p.stringIDs[s]; ok {
+		return id
+	}
+	id := uint64(len(p.strings))
+	p.strings = append(p.strings, s)
+	p.stringIDs[s] = id
+	return id
+}
+
+func (p *pprofBuilder) function(pc types.DoubleWord) uint64 {
+	name := fmt.Sprintf("%016x", pc)
+	if s, ok := p.symbols.Lookup(pc); ok {
+		name = s.Name
+	}
+	if id, ok := p.functions[name]; ok {
+		return id
+	}
+	id := uint64(len(p.functions) + 1)
+	p.functions[name] = id
+	p.buf.message(profileFunction, func(m *protoBuffer) {
+		m.uint64(functionID, id)
+		m.uint64(functionName, p.str(name))
+		m.uint64(functionSystemName, p.str(name))
+		m.uint64(functionStartLine, 0)
+	})
+	return id
+}
+
+func (p *pprofBuilder) location(pc types.DoubleWord) uint64 {
+	if id, ok := p.locations[pc]; ok {
+		return id
+	}
+	id := uint64(len(p.locations) + 1)
+	p.locations[pc] = id
+	fn := p.function(pc)
+	p.buf.message(profileLocation, func(m *protoBuffer) {
+		m.uint64(locationID, id)
+		m.uint64(locationMappingID, 1)
+		m.uint64(locationAddress, pc)
+		m.message(locationLine, func(l *protoBuffer) {
+			l.uint64(lineFunctionID, fn)
+		})
+	})
+	return id
+}
+
+func (p *pprofBuilder) valueType(field int, typ, unit string) {
+	p.buf.message(field, func(m *protoBuffer) {
+		m.uint64(valueTypeType, p.str(typ))
+		m.uint64(valueTypeUnit, p.str(unit))
+	})
+}
+
+// WritePprof writes the profile in pprof format. Functions are named by the symbols, which may be nil.
+func (p *Profiler) WritePprof(w io.Writer, t *symbols.Table) error {
+	b := &pprofBuilder{
+		strings:   []string{""},
+		stringIDs: map[string]uint64{"": 0},
+		functions: map[string]uint64{},
+		locations: map[types.DoubleWord]uint64{},
+		symbols:   t,
+	}
+
+	b.valueType(profileSampleType, "samples", "count")
+	b.valueType(profileSampleType, "cycles", "count")
+	for _, s := range p.sortedSamples() {
+		ids := make([]uint64, len(s.stack))
+		for i, pc := range s.stack {
+			ids[i] = b.location(pc)
+		}
+		count := s.count
+		b.buf.message(profileSample, func(m *protoBuffer) {
+			m.packed(sampleLocationID, ids)
+			m.packed(sampleValue, []uint64{count, count * p.interval})
+		})
+	}
+	b.buf.message(profileMapping, func(m *protoBuffer) {
+		m.uint64(mappingID, 1)
+		m.uint64(mappingMemoryStart, 0)
+		m.uint64(mappingMemoryLimit, ^uint64(0))
+		m.uint64(mappingFilename, b.str("n64"))
+		m.uint64(mappingHasFunctions, 1)
+	})
+	b.buf.uint64(profileDurationNanos, p.cycles*1_000_000_000/cpuClock)
+	b.valueType(profilePeriodType, "cycles", "count")
+	b.buf.uint64(profilePeriod, p.interval)
+	// string table must be the last, all the strings are registered
+	for _, s := range b.strings {
+		b.buf.bytes(profileStringTable, []byte(s))
+	}
+
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(b.buf.data); err != nil {
+		return err
+	}
+	return gz.Close()
+}
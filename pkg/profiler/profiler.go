@@ -0,0 +1,189 @@
+/*
+
+Sampling Profiler for emulated code
+
+The profiler samples the PC of the last retired instruction every N cycles, with the call stack
+of the emulated program. The call stack is tracked from the retired instructions:
+	call  : JAL, JALR, BLTZAL, BGEZAL, BLTZALL, BGEZALL push the call site
+	return: JR ra pops the innermost call site
+The delay slot belongs to the function of the jump, so the stack changes after it.
+
+The profile is written in pprof format, so that `go tool pprof` shows the hotspots of the emulated program.
+*/
+
+package profiler
+
+import (
+	"n64emu/pkg/core/mips/r4300i/cpu"
+	"n64emu/pkg/types"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// max depth of the call stack, deeper calls are not recorded (e.g. runaway recursion)
+	maxStackDepth = 128
+)
+
+// Entry is a bucket of the PC histogram
+type Entry struct {
+	PC    types.DoubleWord
+	Count uint64
+}
+
+// a sampled call stack, leaf first
+type sample struct {
+	stack []types.DoubleWord
+	count uint64
+}
+
+// Profiler samples PC of the CPU
+type Profiler struct {
+	interval uint64 // sampling interval in cycles
+	cycles   uint64 // cycles since the start
+	// PC of the last retired instruction
+	pc      types.DoubleWord
+	retired bool
+	// call sites of the current call stack, outermost first
+	calls []types.DoubleWord
+	// call or return applied after the delay slot
+	pending     func()
+	pendingWait int
+
+	histogram map[types.DoubleWord]uint64
+	samples   map[string]*sample
+}
+
+// New returns Profiler sampling every interval cycles.
+// The profiler sets the trace hook of the CPU, and Tick must be called after every cycle.
+func New(c *cpu.CPU, interval uint64) *Profiler {
+	if interval == 0 {
+		interval = 1
+	}
+	p := &Profiler{
+		interval:  interval,
+		histogram: map[types.DoubleWord]uint64{},
+		samples:   map[string]*sample{},
+	}
+	c.SetTraceHook(p.hook)
+	return p
+}
+
+func (p *Profiler) hook(ev *cpu.TraceEvent) {
+	if ev.Exception {
+		return
+	}
+	if p.pendingWait > 0 {
+		p.pendingWait--
+		if p.pendingWait == 0 {
+			p.pending()
+		}
+	}
+	p.pc = ev.PC
+	p.retired = true
+
+	switch {
+	case isCall(ev.Opcode):
+		site := ev.PC
+		p.delay(func() {
+			if len(p.calls) < maxStackDepth {
+				p.calls = append(p.calls, site)
+			}
+		})
+	case isReturn(ev.Opcode):
+		p.delay(func() {
+			if len(p.calls) > 0 {
+				p.calls = p.calls[:len(p.calls)-1]
+			}
+		})
+	}
+}
+
+// apply the change of the call stack after the delay slot
+func (p *Profiler) delay(f func()) {
+	p.pending = f
+	p.pendingWait = 2
+}
+
+func isCall(opcode types.Word) bool {
+	switch opcode >> 26 {
+	case 0x03: // JAL
+		return true
+	case 0x00: // JALR
+		return (opcode & 0x3f) == 0x09
+	case 0x01: // BLTZAL, BGEZAL, BLTZALL, BGEZALL
+		rt := (opcode >> 16) & 0x1f
+		return 0x10 <= rt && rt <= 0x13
+	}
+	return false
+}
+
+// JR ra
+func isReturn(opcode types.Word) bool {
+	return (opcode>>26) == 0x00 && (opcode&0x3f) == 0x08 && ((opcode>>21)&0x1f) == 31
+}
+
+// Tick counts a cycle, and takes a sample every interval cycles.
+func (p *Profiler) Tick() {
+	p.cycles++
+	if p.cycles%p.interval != 0 || !p.retired {
+		return
+	}
+	p.histogram[p.pc]++
+
+	stack := make([]types.DoubleWord, 0, len(p.calls)+1)
+	stack = append(stack, p.pc)
+	for i := len(p.calls) - 1; i >= 0; i-- {
+		stack = append(stack, p.calls[i])
+	}
+	key := stackKey(stack)
+	if s, ok := p.samples[key]; ok {
+		s.count++
+		return
+	}
+	p.samples[key] = &sample{stack: stack, count: 1}
+}
+
+func stackKey(stack []types.DoubleWord) string {
+	var b strings.Builder
+	for _, pc := range stack {
+		b.WriteString(strconv.FormatUint(pc, 16))
+		b.WriteByte(',')
+	}
+	return b.String()
+}
+
+// Cycles returns the number of cycles counted.
+func (p *Profiler) Cycles() uint64 {
+	return p.cycles
+}
+
+// Histogram returns sample counts by PC, in descending order of the count.
+func (p *Profiler) Histogram() []Entry {
+	entries := make([]Entry, 0, len(p.histogram))
+	for pc, count := range p.histogram {
+		entries = append(entries, Entry{PC: pc, Count: count})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Count != entries[j].Count {
+			return entries[i].Count > entries[j].Count
+		}
+		return entries[i].PC < entries[j].PC
+	})
+	return entries
+}
+
+// sorted samples, for stable output
+func (p *Profiler) sortedSamples() []*sample {
+	keys := make([]string, 0, len(p.samples))
+	for k := range p.samples {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	samples := make([]*sample, len(keys))
+	for i, k := range keys {
+		samples[i] = p.samples[k]
+	}
+	return samples
+}
@@ -0,0 +1,158 @@
+package profiler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"n64emu/pkg/core/mips/r4300i/cpu"
+	"n64emu/pkg/symbols"
+	"n64emu/pkg/types"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	opJAL  = 0x0C000100 // jal 0x400
+	opJALR = 0x0320F809 // jalr t9
+	opJR   = 0x03E00008 // jr ra
+	opNOP  = 0x00000000
+)
+
+func newTestProfiler(interval uint64) *Profiler {
+	return New(cpu.NewCPU(nil), interval)
+}
+
+// retire an instruction and count a cycle
+func (p *Profiler) retire(pc types.DoubleWord, opcode types.Word) {
+	p.hook(&cpu.TraceEvent{PC: pc, Opcode: opcode})
+	p.Tick()
+}
+
+func TestProfiler_Histogram(t *testing.T) {
+	assert := assert.New(t)
+
+	p := newTestProfiler(2)
+	p.Tick() // no instruction is retired yet
+	for i := 0; i < 3; i++ {
+		p.retire(0xFFFFFFFF80000400, opNOP)
+		p.retire(0xFFFFFFFF80000404, opNOP)
+	}
+	p.retire(0xFFFFFFFF80000408, opNOP)
+	p.retire(0xFFFFFFFF80000408, opNOP)
+
+	assert.Equal(uint64(9), p.Cycles())
+	assert.Equal([]Entry{
+		{PC: 0xFFFFFFFF80000400, Count: 3},
+		{PC: 0xFFFFFFFF80000408, Count: 1},
+	}, p.Histogram())
+}
+
+func TestProfiler_CallStack(t *testing.T) {
+	assert := assert.New(t)
+
+	p := newTestProfiler(1)
+	p.retire(0x80000400, opJAL)
+	p.retire(0x80000404, opNOP) // delay slot
+	p.retire(0x80001000, opJALR)
+	p.retire(0x80001004, opNOP) // delay slot
+	p.retire(0x80002000, opJR)
+	p.retire(0x80002004, opNOP)                                            // delay slot
+	p.hook(&cpu.TraceEvent{PC: 0x80001010, Opcode: opJR, Exception: true}) // canceled
+	p.retire(0x80001008, opJR)
+	p.retire(0x8000100c, opNOP) // delay slot
+	p.retire(0x80000408, opNOP)
+
+	stacks := map[string]uint64{}
+	for _, s := range p.sortedSamples() {
+		stacks[stackKey(s.stack)] = s.count
+	}
+	assert.Equal(map[string]uint64{
+		"80000400,":                   1,
+		"80000404,":                   1,
+		"80001000,80000400,":          1,
+		"80001004,80000400,":          1,
+		"80002000,80001000,80000400,": 1,
+		"80002004,80001000,80000400,": 1,
+		"80001008,80000400,":          1,
+		"8000100c,80000400,":          1,
+		"80000408,":                   1,
+	}, stacks)
+}
+
+func TestProfiler_CallStackDepth(t *testing.T) {
+	p := newTestProfiler(1)
+	for i := 0; i < maxStackDepth+10; i++ {
+		p.retire(0x80000400, opJAL)
+		p.retire(0x80000404, opNOP)
+	}
+	assert.Len(t, p.calls, maxStackDepth)
+}
+
+// minimal decoder of profile.proto, returns varint and length-delimited fields of the top-level message
+func decodeFields(t *testing.T, data []byte) (map[int][]uint64, map[int][][]byte) {
+	values := map[int][]uint64{}
+	fields := map[int][][]byte{}
+	varint := func() uint64 {
+		var x uint64
+		for shift := uint(0); ; shift += 7 {
+			b := data[0]
+			data = data[1:]
+			x |= uint64(b&0x7f) << shift
+			if b < 0x80 {
+				return x
+			}
+		}
+	}
+	for len(data) > 0 {
+		key := varint()
+		switch key & 7 {
+		case 0:
+			values[int(key>>3)] = append(values[int(key>>3)], varint())
+		case 2:
+			n := varint()
+			fields[int(key>>3)] = append(fields[int(key>>3)], data[:n])
+			data = data[n:]
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+	}
+	return values, fields
+}
+
+func TestProfiler_WritePprof(t *testing.T) {
+	assert := assert.New(t)
+
+	p := newTestProfiler(1000)
+	p.retire(0xFFFFFFFF80000400, opJAL)
+	p.retire(0xFFFFFFFF80000404, opNOP)
+	for i := 0; i < 1998; i++ {
+		p.retire(0xFFFFFFFF80001004, opNOP)
+	}
+	table := symbols.New([]symbols.Symbol{
+		{Name: "main", Addr: 0xFFFFFFFF80000400, Size: 0x100},
+		{Name: "loop", Addr: 0xFFFFFFFF80001000, Size: 0x10},
+	})
+
+	var buf bytes.Buffer
+	assert.NoError(p.WritePprof(&buf, table))
+	gz, err := gzip.NewReader(&buf)
+	assert.NoError(err)
+	data, err := ioutil.ReadAll(gz)
+	assert.NoError(err)
+
+	values, fields := decodeFields(t, data)
+	assert.Len(fields[profileSampleType], 2)
+	assert.Len(fields[profileSample], 1)
+	assert.Len(fields[profileLocation], 2)
+	assert.Len(fields[profileFunction], 2)
+	assert.Equal([]uint64{1000}, values[profilePeriod])
+	var strs []string
+	for _, s := range fields[profileStringTable] {
+		strs = append(strs, string(s))
+	}
+	assert.Equal("", strs[0], "should string table start with empty string")
+	assert.Contains(strs, "loop")
+	assert.Contains(strs, "main")
+	assert.Contains(strs, "cycles")
+}
@@ -0,0 +1,139 @@
+package symbols
+
+import (
+	"bufio"
+	"bytes"
+	"debug/elf"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"n64emu/pkg/types"
+	"strconv"
+	"strings"
+)
+
+// Load reads symbols from ELF, GNU ld map or text file, detected by the contents.
+func Load(path string) (*Table, error) {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case bytes.HasPrefix(src, []byte(elf.ELFMAG)):
+		f, err := elf.NewFile(bytes.NewReader(src))
+		if err != nil {
+			return nil, err
+		}
+		return FromELF(f)
+	case bytes.Contains(src, []byte("Linker script and memory map")):
+		return ParseMap(bytes.NewReader(src))
+	}
+	return ParseText(bytes.NewReader(src))
+}
+
+// FromELF reads function, object and untyped symbols in .symtab.
+// An executable without .symtab has no symbols.
+func FromELF(f *elf.File) (*Table, error) {
+	syms, err := f.Symbols()
+	if err == elf.ErrNoSymbols {
+		return New(nil), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	symbols := []Symbol{}
+	for _, s := range syms {
+		typ := elf.ST_TYPE(s.Info)
+		if s.Name == "" || s.Section == elf.SHN_UNDEF || (typ != elf.STT_FUNC && typ != elf.STT_OBJECT && typ != elf.STT_NOTYPE) {
+			continue
+		}
+		addr := s.Value
+		if f.Class == elf.ELFCLASS32 {
+			addr = signExtend(addr)
+		}
+		symbols = append(symbols, Symbol{Name: s.Name, Addr: addr, Size: s.Size})
+	}
+	return New(symbols), nil
+}
+
+// ParseMap reads symbols in the memory map of GNU ld map file e.g.
+//
+//	.text          0x0000000080000400      0x120 build/main.o
+//	               0x0000000080000400                main
+//
+// Symbol assignments in the linker script (e.g. "__bss_end = .") are skipped.
+func ParseMap(r io.Reader) (*Table, error) {
+	s := bufio.NewScanner(r)
+	inMap := false
+	symbols := []Symbol{}
+	for s.Scan() {
+		line := s.Text()
+		if strings.HasPrefix(line, "Linker script and memory map") {
+			inMap = true
+			continue
+		}
+		fields := strings.Fields(line)
+		if !inMap || len(fields) != 2 || !strings.HasPrefix(fields[0], "0x") || !isSymbolName(fields[1]) {
+			continue
+		}
+		addr, err := strconv.ParseUint(fields[0][2:], 16, 64)
+		if err != nil {
+			continue
+		}
+		symbols = append(symbols, Symbol{Name: fields[1], Addr: signExtend(addr)})
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return New(symbols), nil
+}
+
+func isSymbolName(s string) bool {
+	if s == "" || strings.HasPrefix(s, ".") {
+		return false
+	}
+	for _, c := range s {
+		if !(c == '_' || c == '$' || c == '.' || ('0' <= c && c <= '9') || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')) {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseText reads "<addr> <name> [<size>]" per line.
+func ParseText(r io.Reader) (*Table, error) {
+	s := bufio.NewScanner(r)
+	symbols := []Symbol{}
+	for n := 1; s.Scan(); n++ {
+		line := s.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("line %d: <addr> <name> [<size>] is required", n)
+		}
+		addr, err := parseHex(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid address '%s'", n, fields[0])
+		}
+		sym := Symbol{Name: fields[1], Addr: signExtend(addr)}
+		if len(fields) == 3 {
+			if sym.Size, err = parseHex(fields[2]); err != nil {
+				return nil, fmt.Errorf("line %d: invalid size '%s'", n, fields[2])
+			}
+		}
+		symbols = append(symbols, sym)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return New(symbols), nil
+}
+
+func parseHex(s string) (types.DoubleWord, error) {
+	return strconv.ParseUint(strings.TrimPrefix(strings.ToLower(s), "0x"), 16, 64)
+}
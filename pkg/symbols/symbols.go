@@ -0,0 +1,122 @@
+/*
+
+Symbol Table
+
+Symbols are loaded from:
+	ELF       .symtab of the executable
+	GNU ld    .map file written by -Map, symbols in "Linker script and memory map"
+	text      "<addr> <name> [<size>]" per line, '#' starts a comment
+
+Addresses are hex, and 32-bit addresses are sign-extended e.g. 0x80000400 -> 0xFFFFFFFF80000400.
+Symbols without size contain addresses up to the next symbol.
+*/
+
+package symbols
+
+import (
+	"fmt"
+	"n64emu/pkg/types"
+	"sort"
+)
+
+// Symbol is a named address range
+type Symbol struct {
+	Name string
+	Addr types.DoubleWord
+	Size types.DoubleWord // 0 if unknown
+}
+
+// Table is a symbol table, sorted by address
+type Table struct {
+	symbols []Symbol
+}
+
+// New returns Table of the symbols.
+func New(symbols []Symbol) *Table {
+	t := &Table{symbols: append([]Symbol{}, symbols...)}
+	sort.SliceStable(t.symbols, func(i, j int) bool { return t.symbols[i].Addr < t.symbols[j].Addr })
+	return t
+}
+
+// Symbols returns the symbols sorted by address.
+func (t *Table) Symbols() []Symbol {
+	if t == nil {
+		return nil
+	}
+	return t.symbols
+}
+
+// Len returns the number of symbols.
+func (t *Table) Len() int {
+	if t == nil {
+		return 0
+	}
+	return len(t.symbols)
+}
+
+// Lookup returns the symbol containing addr.
+// A nil table has no symbols, so that callers need not check it.
+func (t *Table) Lookup(addr types.DoubleWord) (Symbol, bool) {
+	if t == nil {
+		return Symbol{}, false
+	}
+	i := sort.Search(len(t.symbols), func(i int) bool { return t.symbols[i].Addr > addr }) - 1
+	if i < 0 {
+		return Symbol{}, false
+	}
+	s := t.symbols[i]
+	if s.Size != 0 && addr >= s.Addr+s.Size {
+		return Symbol{}, false
+	}
+	return s, true
+}
+
+// At returns the symbol starting at addr.
+func (t *Table) At(addr types.DoubleWord) (Symbol, bool) {
+	if t == nil {
+		return Symbol{}, false
+	}
+	i := sort.Search(len(t.symbols), func(i int) bool { return t.symbols[i].Addr >= addr })
+	if i < len(t.symbols) && t.symbols[i].Addr == addr {
+		return t.symbols[i], true
+	}
+	return Symbol{}, false
+}
+
+// Find returns the address of the symbol named name.
+func (t *Table) Find(name string) (types.DoubleWord, bool) {
+	for _, s := range t.Symbols() {
+		if s.Name == name {
+			return s.Addr, true
+		}
+	}
+	return 0, false
+}
+
+// Symbolize returns "function+0xoffset" of addr, or "" if no symbol contains it.
+func (t *Table) Symbolize(addr types.DoubleWord) string {
+	s, ok := t.Lookup(addr)
+	if !ok {
+		return ""
+	}
+	if addr == s.Addr {
+		return s.Name
+	}
+	return fmt.Sprintf("%s+0x%x", s.Name, addr-s.Addr)
+}
+
+// Format returns the address with the symbol e.g. "ffffffff80000404 <main+0x4>".
+func (t *Table) Format(addr types.DoubleWord) string {
+	if name := t.Symbolize(addr); name != "" {
+		return fmt.Sprintf("%016x <%s>", addr, name)
+	}
+	return fmt.Sprintf("%016x", addr)
+}
+
+// sign-extend 32-bit address
+func signExtend(addr types.DoubleWord) types.DoubleWord {
+	if addr <= 0xFFFF_FFFF {
+		return types.DoubleWord(types.SWord(addr))
+	}
+	return addr
+}
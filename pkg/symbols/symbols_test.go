@@ -0,0 +1,116 @@
+package symbols
+
+import (
+	"io/ioutil"
+	"n64emu/pkg/types"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTable(t *testing.T) {
+	assert := assert.New(t)
+	table := New([]Symbol{
+		{Name: "loop", Addr: 0xFFFFFFFF80000408},
+		{Name: "_start", Addr: 0xFFFFFFFF80000400, Size: 8},
+		{Name: "data", Addr: 0xFFFFFFFF80000500, Size: 4},
+	})
+	tests := []struct {
+		addr types.DoubleWord
+		want string
+	}{
+		{0xFFFFFFFF80000400, "_start"},
+		{0xFFFFFFFF80000404, "_start+0x4"},
+		{0xFFFFFFFF80000410, "loop+0x8"}, // without size, up to the next symbol
+		{0xFFFFFFFF80000500, "data"},
+		{0xFFFFFFFF80000504, ""}, // out of size
+		{0xFFFFFFFF800003FC, ""},
+	}
+	for _, tt := range tests {
+		assert.Equal(tt.want, table.Symbolize(tt.addr), "%016x", tt.addr)
+	}
+	assert.Equal("ffffffff80000404 <_start+0x4>", table.Format(0xFFFFFFFF80000404))
+	assert.Equal("ffffffff800003fc", table.Format(0xFFFFFFFF800003FC))
+
+	addr, ok := table.Find("loop")
+	assert.True(ok)
+	assert.Equal(types.DoubleWord(0xFFFFFFFF80000408), addr)
+	_, ok = table.At(0xFFFFFFFF80000404)
+	assert.False(ok)
+	assert.Equal("_start", table.Symbols()[0].Name, "should sorted by address")
+
+	// nil table has no symbols
+	var empty *Table
+	assert.Equal("", empty.Symbolize(0xFFFFFFFF80000400))
+	assert.Equal("ffffffff80000400", empty.Format(0xFFFFFFFF80000400))
+	assert.Equal(0, empty.Len())
+}
+
+func TestParseMap(t *testing.T) {
+	src := `Memory Configuration
+
+Name             Origin             Length             Attributes
+*default*        0x0000000000000000 0xffffffffffffffff
+
+Linker script and memory map
+
+LOAD build/main.o
+                0x0000000080000400                __libdragon_text_start = .
+ .text          0x0000000080000400       0x40 build/main.o
+                0x0000000080000400                main
+                0x0000000080000420                helper
+ .text.foo
+                0x0000000080000440       0x10 build/foo.o
+                0x0000000080000440                foo
+                0x0000000080000450                . = ALIGN (0x10)
+ *(.bss)
+                0x80001000                counter
+`
+	table, err := ParseMap(strings.NewReader(src))
+	assert.NoError(t, err)
+	assert.Equal(t, []Symbol{
+		{Name: "main", Addr: 0xFFFFFFFF80000400},
+		{Name: "helper", Addr: 0xFFFFFFFF80000420},
+		{Name: "foo", Addr: 0xFFFFFFFF80000440},
+		{Name: "counter", Addr: 0xFFFFFFFF80001000},
+	}, table.Symbols())
+}
+
+func TestParseText(t *testing.T) {
+	src := `
+# addr name [size]
+80000400 main 20
+0xFFFFFFFF80000420 helper
+a4000040 ipl3   # comment
+`
+	table, err := ParseText(strings.NewReader(src))
+	assert.NoError(t, err)
+	assert.Equal(t, []Symbol{
+		{Name: "main", Addr: 0xFFFFFFFF80000400, Size: 0x20},
+		{Name: "helper", Addr: 0xFFFFFFFF80000420},
+		{Name: "ipl3", Addr: 0xFFFFFFFFA4000040},
+	}, table.Symbols())
+
+	for _, src := range []string{"main", "zzzz main", "80000400 main zz", "1 2 3 4"} {
+		_, err := ParseText(strings.NewReader(src))
+		assert.Error(t, err, src)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	mapPath := filepath.Join(dir, "test.map")
+	ioutil.WriteFile(mapPath, []byte("Linker script and memory map\n                0x80000400                main\n"), 0644)
+	textPath := filepath.Join(dir, "test.sym")
+	ioutil.WriteFile(textPath, []byte("80000400 main\n"), 0644)
+
+	for _, path := range []string{mapPath, textPath} {
+		table, err := Load(path)
+		assert.NoError(t, err)
+		assert.Equal(t, "main", table.Symbolize(0xFFFFFFFF80000400), path)
+	}
+	_, err := Load(filepath.Join(dir, "not found"))
+	assert.Error(t, err)
+}
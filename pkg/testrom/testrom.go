@@ -0,0 +1,302 @@
+/*
+
+Headless Test ROM Runner
+
+The runner boots a test ROM without video and input, and runs it for a budget of cycles or frames.
+The result is detected from RDRAM signatures or IS-Viewer output:
+	pass/fail signature: a word in RDRAM has the value, e.g. a result code written by the ROM
+	pass/fail output   : IS-Viewer output contains the text, e.g. "PASS" printed by the ROM
+Fail conditions are checked before pass conditions. The test times out if no condition is met within the budget.
+Accesses to unmapped addresses are counted by the region, and reported after the results.
+
+Specs are read from a JSON manifest. ROM paths are relative to the manifest.
+	[
+	  {
+	    "name": "cpu-add",
+	    "rom": "krom/CPUADD.N64",
+	    "frames": 120,
+	    "pass_signature": {"addr": "0x100000", "value": "0x1"},
+	    "fail_output": "FAILED",
+	    "unmapped": "error"
+	  }
+	]
+*/
+
+package testrom
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"n64emu/pkg/core"
+	"n64emu/pkg/core/cart"
+	"n64emu/pkg/core/mips/r4300i/cpu"
+	"n64emu/pkg/core/sysbus"
+	"n64emu/pkg/types"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+const (
+	// cycles per frame of NTSC, 93.75MHz / 60Hz
+	CyclesPerFrame = 93_750_000 / 60
+	// cycles run between checks of the conditions
+	checkInterval = 10_000
+	// budget if neither cycles nor frames are specified, 10 seconds
+	defaultFrames = 600
+)
+
+// Hex is a word written as a hex string in JSON, e.g. "0x80000000"
+type Hex types.Word
+
+// UnmarshalJSON parses hex string, or a number.
+func (h *Hex) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		s = string(data)
+	}
+	v, err := strconv.ParseUint(s, 0, 32)
+	if err != nil {
+		return fmt.Errorf("invalid word %s: %s", data, err)
+	}
+	*h = Hex(v)
+	return nil
+}
+
+// Signature is a word in RDRAM
+type Signature struct {
+	// physical address in RDRAM
+	Addr  Hex `json:"addr"`
+	Value Hex `json:"value"`
+}
+
+// Spec describes a test ROM and how to detect the result
+type Spec struct {
+	Name string `json:"name"`
+	ROM  string `json:"rom"`
+	// budget, Cycles has priority over Frames
+	Cycles uint64 `json:"cycles,omitempty"`
+	Frames uint64 `json:"frames,omitempty"`
+	// run without Expansion Pak, for titles that behave differently with it
+	NoExpansionPak bool `json:"no_expansion_pak,omitempty"`
+	// policy of unmapped accesses, "open" (default), "error" or "panic"
+	Unmapped string `json:"unmapped,omitempty"`
+
+	PassSignature *Signature `json:"pass_signature,omitempty"`
+	FailSignature *Signature `json:"fail_signature,omitempty"`
+	PassOutput    string     `json:"pass_output,omitempty"`
+	FailOutput    string     `json:"fail_output,omitempty"`
+}
+
+// Budget returns max cycles to run the ROM.
+func (s *Spec) Budget() uint64 {
+	switch {
+	case s.Cycles != 0:
+		return s.Cycles
+	case s.Frames != 0:
+		return s.Frames * CyclesPerFrame
+	}
+	return defaultFrames * CyclesPerFrame
+}
+
+func (s *Spec) unmappedPolicy() (sysbus.UnmappedPolicy, error) {
+	if s.Unmapped == "" {
+		return sysbus.OpenBus, nil
+	}
+	return sysbus.ParseUnmappedPolicy(s.Unmapped)
+}
+
+// LoadSpecs reads specs from the JSON manifest. ROM paths are resolved from the manifest directory.
+func LoadSpecs(path string) ([]Spec, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var specs []Spec
+	if err := json.Unmarshal(data, &specs); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	dir := filepath.Dir(path)
+	for i := range specs {
+		s := &specs[i]
+		if s.ROM == "" {
+			return nil, fmt.Errorf("%s: spec %d has no ROM", path, i)
+		}
+		if !filepath.IsAbs(s.ROM) {
+			s.ROM = filepath.Join(dir, s.ROM)
+		}
+		if s.Name == "" {
+			s.Name = strings.TrimSuffix(filepath.Base(s.ROM), filepath.Ext(s.ROM))
+		}
+		if s.PassSignature == nil && s.PassOutput == "" {
+			return nil, fmt.Errorf("%s: %s has no pass condition", path, s.Name)
+		}
+		if _, err := s.unmappedPolicy(); err != nil {
+			return nil, fmt.Errorf("%s: %s: %s", path, s.Name, err)
+		}
+	}
+	return specs, nil
+}
+
+// Status is the result of a test ROM
+type Status int
+
+const (
+	Pass Status = iota
+	Fail
+	Timeout
+	Error // the ROM could not be loaded, or the emulator crashed
+)
+
+func (s Status) String() string {
+	switch s {
+	case Pass:
+		return "PASS"
+	case Fail:
+		return "FAIL"
+	case Timeout:
+		return "TIMEOUT"
+	}
+	return "ERROR"
+}
+
+// Result of a test ROM
+type Result struct {
+	Name   string
+	Status Status
+	// cycles run until the result is detected
+	Cycles uint64
+	// IS-Viewer output
+	Output string
+	// idle loops skipped in the cycles
+	Idle cpu.IdleStats
+	// unmapped regions the ROM accessed
+	Unmapped []sysbus.UnmappedCount
+	// why it failed, or nil if passed
+	Err error
+}
+
+// Load loads the ROM or ELF executable, and boots the machine.
+func Load(path string, opts ...core.Option) (*core.N64, error) {
+	if cart.IsELF(path) {
+		e, err := cart.NewELF(path)
+		if err != nil {
+			return nil, err
+		}
+		return core.NewN64WithELF(e, opts...)
+	}
+	rom, err := cart.NewRom(path)
+	if err != nil {
+		return nil, err
+	}
+	return core.NewN64(rom, opts...), nil
+}
+
+// RunFile loads the ROM of the spec, and runs it.
+func RunFile(s *Spec) *Result {
+	policy, err := s.unmappedPolicy()
+	if err != nil {
+		return &Result{Name: s.Name, Status: Error, Err: err}
+	}
+	n, err := Load(s.ROM, core.WithExpansionPak(!s.NoExpansionPak), core.WithUnmappedPolicy(policy))
+	if err != nil {
+		return &Result{Name: s.Name, Status: Error, Err: err}
+	}
+	return Run(n, s)
+}
+
+// Run runs the booted machine until a condition of the spec is met, or the budget is exhausted.
+func Run(n *core.N64, s *Spec) *Result {
+	output := &bytes.Buffer{}
+	n.SetISViewer(output)
+	defer n.SetISViewer(nil)
+
+	r := &Result{Name: s.Name}
+	budget := s.Budget()
+	for {
+		if r.check(n, s, output.String()) {
+			break
+		}
+		if r.Cycles >= budget {
+			r.Status = Timeout
+			r.Err = fmt.Errorf("no result in %d cycles", budget)
+			break
+		}
+		cycles := budget - r.Cycles
+		if cycles > checkInterval {
+			cycles = checkInterval
+		}
+		// count the cycles actually run, which are less than requested on crashes
+		start := n.Cycles()
+		err := n.Run(types.Word(cycles))
+		r.Cycles += n.Cycles() - start
+		if err != nil {
+			r.Status, r.Err = Error, err
+			break
+		}
+	}
+	r.Output = output.String()
+	r.Idle = n.CPU.IdleStats()
+	r.Unmapped = n.Unmapped()
+	return r
+}
+
+// check the conditions, fail first. Returns true if the result is detected.
+func (r *Result) check(n *core.N64, s *Spec, output string) bool {
+	if sig := s.FailSignature; sig != nil && matchSignature(n, sig) {
+		r.Status, r.Err = Fail, fmt.Errorf("fail signature %08x=%08x", sig.Addr, sig.Value)
+		return true
+	}
+	if s.FailOutput != "" && strings.Contains(output, s.FailOutput) {
+		r.Status, r.Err = Fail, fmt.Errorf("fail output %q", s.FailOutput)
+		return true
+	}
+	if s.PassSignature == nil && s.PassOutput == "" {
+		return false
+	}
+	if sig := s.PassSignature; sig != nil && !matchSignature(n, sig) {
+		return false
+	}
+	if s.PassOutput != "" && !strings.Contains(output, s.PassOutput) {
+		return false
+	}
+	r.Status = Pass
+	return true
+}
+
+func matchSignature(n *core.N64, sig *Signature) bool {
+	return n.Bus().ReadWord(types.Big, types.Word(sig.Addr)) == types.Word(sig.Value)
+}
+
+// WriteTable writes the results as a table, and returns the number of results not passed.
+// IDLE column is the ratio of cycles skipped in idle loops.
+// Unmapped regions accessed by the ROMs are listed after the table.
+func WriteTable(w io.Writer, results []*Result) int {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSTATUS\tCYCLES\tIDLE\tDETAIL")
+	failed := 0
+	for _, r := range results {
+		detail := ""
+		if r.Err != nil {
+			detail = r.Err.Error()
+			failed++
+		}
+		idle := 0.0
+		if r.Cycles > 0 {
+			idle = float64(r.Idle.SkippedCycles) / float64(r.Cycles) * 100
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%.1f%%\t%s\n", r.Name, r.Status, r.Cycles, idle, detail)
+	}
+	tw.Flush()
+	for _, r := range results {
+		for i := range r.Unmapped {
+			fmt.Fprintf(w, "%s: unmapped %s\n", r.Name, &r.Unmapped[i])
+		}
+	}
+	fmt.Fprintf(w, "%d passed, %d failed\n", len(results)-failed, failed)
+	return failed
+}
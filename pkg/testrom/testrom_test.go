@@ -0,0 +1,230 @@
+package testrom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"n64emu/pkg/core"
+	"n64emu/pkg/core/cart"
+	"n64emu/pkg/core/mips/r4300i/asm"
+	"n64emu/pkg/core/sysbus"
+	"n64emu/pkg/types"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	entry = 0x80000400
+	// the first word of the game code, copied to RDRAM at boot
+	codeAddr = 0x400
+)
+
+func newTestImage(source string) []types.Byte {
+	code := asm.MustAssemble(0xFFFFFFFF80000400, source)
+	image := make([]types.Byte, cart.RomHeaderSize+len(code))
+	binary.BigEndian.PutUint32(image[0:4], cart.RomHeaderBigEndian)
+	binary.BigEndian.PutUint32(image[8:12], entry)
+	copy(image[cart.RomHeaderSize:], code)
+	return image
+}
+
+func newTestN64(source string) *core.N64 {
+	return core.NewN64(&cart.ROM{ProgramCounter: entry, Image: newTestImage(source)})
+}
+
+// print the message to IS-Viewer after the cycles, as a test ROM does
+func printAfter(n *core.N64, cycles int, msg string) {
+	n.OnCycle(func() {
+		if cycles--; cycles != 0 {
+			return
+		}
+		for i := 0; i < len(msg); i++ {
+			n.Bus().WriteByte(types.Big, 0x13FF_0020+types.Word(i), msg[i])
+		}
+		n.Bus().WriteWord(types.Big, 0x13FF_0014, types.Word(len(msg)))
+	})
+}
+
+func TestRun(t *testing.T) {
+	const loop = "addu v0, s4, s6\njr ra\naddu v0, s4, s6\n"
+	opcode := binary.BigEndian.Uint32(newTestImage(loop)[cart.RomHeaderSize:])
+
+	tests := []struct {
+		name   string
+		spec   Spec
+		output string // printed after 50000 cycles
+		status Status
+		cycles uint64
+	}{
+		{
+			name:   "pass signature",
+			spec:   Spec{PassSignature: &Signature{Addr: codeAddr, Value: Hex(opcode)}},
+			status: Pass,
+		},
+		{
+			name:   "fail signature",
+			spec:   Spec{PassOutput: "PASS", FailSignature: &Signature{Addr: codeAddr, Value: Hex(opcode)}},
+			status: Fail,
+		},
+		{
+			name:   "pass output",
+			spec:   Spec{PassOutput: "PASS"},
+			output: "test 1 PASS\n",
+			status: Pass,
+			cycles: 50_000,
+		},
+		{
+			name:   "fail output",
+			spec:   Spec{PassOutput: "PASS", FailOutput: "FAIL"},
+			output: "test 1 FAIL\n",
+			status: Fail,
+			cycles: 50_000,
+		},
+		{
+			name:   "all pass conditions",
+			spec:   Spec{PassOutput: "PASS", PassSignature: &Signature{Addr: codeAddr, Value: 0}},
+			output: "PASS",
+			status: Timeout,
+			cycles: 100_000,
+		},
+		{
+			name:   "timeout",
+			spec:   Spec{Cycles: 12_345, PassOutput: "PASS"},
+			status: Timeout,
+			cycles: 12_345,
+		},
+		{
+			name:   "frames",
+			spec:   Spec{Frames: 1, PassOutput: "PASS"},
+			status: Timeout,
+			cycles: CyclesPerFrame,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			n := newTestN64(loop)
+			if tt.output != "" {
+				printAfter(n, 50_000, tt.output)
+			}
+			if tt.spec.Cycles == 0 && tt.spec.Frames == 0 {
+				tt.spec.Cycles = 100_000
+			}
+			r := Run(n, &tt.spec)
+			assert.Equal(tt.status, r.Status, "%v", r.Err)
+			assert.Equal(tt.cycles, r.Cycles)
+			assert.Equal(tt.output, r.Output)
+			assert.Equal(tt.status == Pass, r.Err == nil)
+		})
+	}
+}
+
+func TestRun_Crash(t *testing.T) {
+	assert := assert.New(t)
+
+	n := newTestN64("lui t0, 0x8000")
+	r := Run(n, &Spec{Name: "crash", PassOutput: "PASS"})
+	assert.Equal(Error, r.Status)
+	assert.Equal(uint64(2), r.Cycles, "should cycles before the crash in EX stage counted")
+	_, ok := r.Err.(*core.Crash)
+	assert.True(ok, "should crash reported")
+}
+
+func TestRun_Unmapped(t *testing.T) {
+	assert := assert.New(t)
+
+	// load from 0xA4900040, unused
+	const load = "sll t0, s4, 23\nsll t1, s4, 20\nnop\nnop\naddu t0, t0, t1\nnop\nnop\naddu t0, t3, t0\nnop\nnop\nlw v1, 0(t0)\n"
+	n := newTestN64(load)
+	r := Run(n, &Spec{Name: "unmapped", Cycles: 100, PassOutput: "PASS"})
+	assert.Equal([]sysbus.UnmappedCount{
+		{Region: "Unused", Start: 0x0490_0000, End: 0x04FF_FFFF, Reads: 1, First: 0x0490_0040},
+	}, r.Unmapped)
+
+	out := &bytes.Buffer{}
+	WriteTable(out, []*Result{r})
+	assert.Contains(out.String(), "\nunmapped: unmapped Unused (04900000-04ffffff): 1 reads, 0 writes, first at 04900040\n0 passed, 1 failed\n")
+}
+
+func TestLoadSpecs(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "testrom")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	rom := make([]types.Byte, cart.RomHeaderSize+0x100)
+	copy(rom, newTestImage("addu v0, s4, s6"))
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "test.z64"), rom, 0644))
+	manifest := filepath.Join(dir, "testroms.json")
+	assert.NoError(ioutil.WriteFile(manifest, []byte(`[
+		{"rom": "test.z64", "cycles": 100, "pass_signature": {"addr": "0x400", "value": "0x02961021"}},
+		{"name": "missing", "rom": "missing.z64", "frames": 2, "pass_output": "PASS"}
+	]`), 0644))
+
+	specs, err := LoadSpecs(manifest)
+	if assert.NoError(err) && assert.Len(specs, 2) {
+		assert.Equal("test", specs[0].Name)
+		assert.Equal(filepath.Join(dir, "test.z64"), specs[0].ROM)
+		assert.Equal(&Signature{Addr: 0x400, Value: 0x02961021}, specs[0].PassSignature)
+		assert.Equal(uint64(100), specs[0].Budget())
+		assert.Equal(uint64(2*CyclesPerFrame), specs[1].Budget())
+
+		results := []*Result{RunFile(&specs[0]), RunFile(&specs[1])}
+		assert.Equal(Pass, results[0].Status, "%v", results[0].Err)
+		assert.Equal(Error, results[1].Status)
+
+		out := &bytes.Buffer{}
+		assert.Equal(1, WriteTable(out, results))
+		assert.Contains(out.String(), "NAME     STATUS  CYCLES  IDLE  DETAIL\ntest     PASS    0       0.0%  \nmissing  ERROR   0       0.0%  ")
+		assert.Contains(out.String(), "1 passed, 1 failed\n")
+
+		// osMemSize without Expansion Pak
+		s := specs[0]
+		s.NoExpansionPak = true
+		s.PassSignature = &Signature{Addr: 0x318, Value: 0x40_0000}
+		assert.Equal(Pass, RunFile(&s).Status)
+
+		s.Unmapped = "panic"
+		assert.Equal(Pass, RunFile(&s).Status)
+		s.Unmapped = "ignore"
+		assert.Equal(Error, RunFile(&s).Status)
+	}
+
+	assert.NoError(ioutil.WriteFile(manifest, []byte(`[{"rom": "test.z64"}]`), 0644))
+	_, err = LoadSpecs(manifest)
+	assert.Error(err, "should spec without pass condition rejected")
+
+	assert.NoError(ioutil.WriteFile(manifest, []byte(`[{"rom": "test.z64", "pass_output": "PASS", "unmapped": "ignore"}]`), 0644))
+	_, err = LoadSpecs(manifest)
+	assert.Error(err, "should unknown policy rejected")
+}
+
+// TestROMs runs the test ROMs in the manifest of N64_TESTROMS, e.g.
+//
+//	N64_TESTROMS=~/n64-tests/testroms.json go test ./pkg/testrom -run TestROMs -v
+func TestROMs(t *testing.T) {
+	manifest := os.Getenv("N64_TESTROMS")
+	if manifest == "" {
+		t.Skip("N64_TESTROMS is not set")
+	}
+	specs, err := LoadSpecs(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range specs {
+		s := &specs[i]
+		t.Run(s.Name, func(t *testing.T) {
+			r := RunFile(s)
+			if r.Output != "" {
+				t.Log(r.Output)
+			}
+			if r.Status != Pass {
+				t.Errorf("%s after %d cycles: %v", r.Status, r.Cycles, r.Err)
+			}
+		})
+	}
+}